@@ -2,20 +2,25 @@ package main
 
 import (
 	"flag"
-	"log"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var logger = logging.For("get_chat_id")
+
 func main() {
 	var botToken string
 
 	flag.StringVar(&botToken, "telegram_token", "", "Telegram Bot Token")
 	flag.Parse()
 
+	logging.AddSecrets(botToken)
+
 	bot, err := tgbotapi.NewBotAPI(botToken)
 	if err != nil {
-		log.Fatalf("Could not connect to the Telegram API: %v", err)
+		logging.Fatal(logger, "Could not connect to the Telegram API", "error", err)
 	}
 
 	u := tgbotapi.NewUpdate(0)
@@ -25,7 +30,9 @@ func main() {
 
 	for update := range updates {
 		if update.Message != nil {
-			log.Printf("Chat ID: %d, text: %s", update.Message.Chat.ID, update.Message.Text)
+			logger.Info("Received message",
+				"chat_id", update.Message.Chat.ID, logging.Text("text", update.Message.Text),
+			)
 		}
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,13 +22,14 @@ func (f *fakeTelegramRequester) Request(request tgbotapi.Chattable) (*tgbotapi.A
 
 func TestRegisterBotCommands(t *testing.T) {
 	bot := &fakeTelegramRequester{}
+	commands := requestprocessor.NewRequestProcessor(nil, "", nil).BotCommands()
 
-	err := registerBotCommands(bot)
+	err := registerBotCommands(bot, commands)
 	require.NoError(t, err)
 
 	config, ok := bot.request.(tgbotapi.SetMyCommandsConfig)
 	require.True(t, ok)
-	assert.Equal(t, botCommands(), config.Commands)
+	assert.Equal(t, commands, config.Commands)
 	assert.Contains(t, config.Commands, tgbotapi.BotCommand{
 		Command: "cancel", Description: "Cancel the current action",
 	})
@@ -37,7 +39,7 @@ func TestRegisterBotCommandsReturnsTelegramError(t *testing.T) {
 	telegramErr := errors.New("telegram unavailable")
 	bot := &fakeTelegramRequester{err: telegramErr}
 
-	err := registerBotCommands(bot)
+	err := registerBotCommands(bot, nil)
 
 	require.Error(t, err)
 	assert.ErrorIs(t, err, telegramErr)
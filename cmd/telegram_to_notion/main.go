@@ -1,115 +1,249 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/config"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notifier"
 	"github.com/gibsn/telegram_to_notion/internal/notion"
 	"github.com/gibsn/telegram_to_notion/internal/pinger"
+	"github.com/gibsn/telegram_to_notion/internal/ratelimit"
 	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
+	"github.com/gibsn/telegram_to_notion/internal/store"
+	"github.com/gibsn/telegram_to_notion/internal/supervisor"
 	"github.com/gibsn/telegram_to_notion/internal/taskscache"
 	"github.com/gibsn/telegram_to_notion/internal/trackscache"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+	"github.com/gibsn/telegram_to_notion/internal/webhook"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var logger = logging.For("main")
+
 func main() {
-	var (
-		debug                                            bool
-		botToken                                         string
-		notionToken                                      string
-		tasksDBID, tweaksDBID, tweaksMixDBID, tracksDBID string
-		pingThreshold                                    time.Duration
-		pingStartingTime, pingEndTime                    string
-		pingPeriod                                       time.Duration
-		pingChatID                                       int64
-		pingText                                         string
-		tasksCachePeriod                                 time.Duration
-		tracksCachePeriod                                time.Duration
-	)
+	var configPath string
 
-	flag.BoolVar(&debug, "debug", false, "Enable debug mode")
-	flag.StringVar(&botToken, "telegram_token", "", "Telegram Bot Token")
-	flag.StringVar(&notionToken, "notion_token", "", "Notion Integration Token")
-	flag.StringVar(&tasksDBID, "tasks_db", "", "Notion Tasks Database ID")
-	flag.StringVar(&tweaksDBID, "tweaks_db", "", "Notion Tweaks Database ID")
-	flag.StringVar(&tweaksMixDBID, "tweaks_mix_db", "", "Notion Tweaks Mix Database ID")
-	flag.StringVar(&tracksDBID, "tracks_db", "", "Notion Tracks Database ID")
-	flag.DurationVar(
-		&pingThreshold, "ping_threshold", 72*time.Hour, "Days till deadline when to start pinging",
-	)
-	flag.StringVar(&pingStartingTime, "ping_st_time", "09:00", "Time to start pinging")
-	flag.StringVar(&pingEndTime, "ping_end_time", "23:00", "Time to finish pinging")
-	flag.DurationVar(&pingPeriod, "ping_period_time", 6*time.Hour, "Pinging period")
-	flag.Int64Var(&pingChatID, "ping_chat_id", 0, "Pinger chat ID")
-	flag.StringVar(&pingText, "ping_text", "Hi, what's the estimate?", "Text for ping message")
-	flag.DurationVar(
-		&tasksCachePeriod, "tasks_cache_period", 1*time.Minute, "Tasks cache refresh period",
-	)
-	flag.DurationVar(
-		&tracksCachePeriod, "tracks_cache_period", 1*time.Minute, "Tracks cache refresh period",
+	flag.StringVar(
+		&configPath, "config", "config.yaml", "YAML config file, see scripts/config.example.yaml",
 	)
 	flag.Parse()
 
-	if botToken == "" || notionToken == "" || tasksDBID == "" ||
-		tweaksDBID == "" || tweaksMixDBID == "" || tracksDBID == "" {
-		log.Fatal("Required: telegram_token, notion_token, tasks_db, tweaks_db, tweaks_mix_db, tracks_db")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logging.Fatal(logger, "Could not load config", "error", err)
 	}
 
-	log.Printf("Will connect to Telgram")
+	logging.Apply(cfg.Logging)
+	logging.AddSecrets(cfg.Telegram.Token, cfg.Notion.Token, cfg.Telegram.Webhook.SecretToken)
+	// the Telegram library logs the failures it retries by itself
+	telegramLogger := logging.StdLogger(logging.For("telegram"), slog.LevelWarn)
+	if err := tgbotapi.SetLogger(telegramLogger); err != nil {
+		logging.Fatal(logger, "Could not set up the Telegram logger", "error", err)
+	}
 
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	logger.Info("Will connect to Telegram")
+
+	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.Token)
 	if err != nil {
-		log.Fatalf("Could not connect to the Telegram API: %v", err)
+		logging.Fatal(logger, "Could not connect to the Telegram API", "error", err)
 	}
 
-	log.Printf("Successfully connected to Telegram")
-	if err := registerBotCommands(bot); err != nil {
-		log.Fatalf("Could not register Telegram bot commands: %v", err)
-	}
-	log.Printf("Successfully registered Telegram bot commands")
+	logger.Info("Successfully connected to Telegram")
 
-	notion := notion.NewNotion(notionToken)
-	cache := taskscache.NewTasksCache(notion, tasksDBID, tasksCachePeriod)
-	tracksCache := trackscache.NewTracksCache(notion, tracksDBID, tracksCachePeriod)
+	notion := newNotion(cfg)
+	cache := taskscache.NewTasksCache(notion, cfg.Notion.Databases.Tasks, cfg.Caches.TasksPeriod)
+	cache.SetResyncPeriod(cfg.Caches.TasksResyncPeriod)
+	tracksCache := trackscache.NewTracksCache(
+		notion, cfg.Notion.Databases.Tracks, cfg.Caches.TracksPeriod,
+	)
 
-	notion.SetTweaksDBIDs(tweaksDBID, tweaksMixDBID)
+	state := store.NewMemoryStore()
+	if cfg.StateFile != "" {
+		if state, err = store.Open(cfg.StateFile); err != nil {
+			logging.Fatal(logger, "Could not load state", "error", err)
+		}
+	}
 
-	processor := requestprocessor.NewRequestProcessor(notion, tasksDBID, bot)
+	resolver := users.NewUserResolver()
+	resolver.SetDeclaredLinks(userLinks(cfg.UserLinks))
+	if err := resolver.SetStore(state); err != nil {
+		logging.Fatal(logger, "Could not load user links", "error", err)
+	}
+
+	processor := requestprocessor.NewRequestProcessor(notion, cfg.Notion.Databases.Tasks, bot)
+	processor.SetUserResolver(resolver)
+	processor.SetStore(state)
+	var auditLog *audit.Log
+	if cfg.Audit.File != "" {
+		auditLog, err = audit.Open(
+			cfg.Audit.File, int64(cfg.Audit.MaxSizeMB)<<20, cfg.Audit.MaxFiles,
+		)
+		if err != nil {
+			logging.Fatal(logger, "Could not open the audit log", "error", err)
+		}
+		processor.SetAuditLog(auditLog)
+		processor.SetUndoWindow(cfg.Audit.UndoWindow)
+	}
 	processor.SetTasksCache(cache)
 	processor.SetTracksCache(tracksCache)
-	processor.SetTracksDBID(tracksDBID)
+	processor.SetTracksDBID(cfg.Notion.Databases.Tracks)
+	processor.SetRequestTimeout(cfg.Updates.RequestTimeout)
+	processor.SetWorkers(cfg.Updates.Workers)
+	processor.SetQueueSize(cfg.Updates.QueueSize)
+	if cfg.WriteQueue.Enabled {
+		processor.SetWriteQueue(requestprocessor.WriteQueueConfig{
+			BaseDelay: cfg.WriteQueue.BaseDelay,
+			MaxDelay:  cfg.WriteQueue.MaxDelay,
+			MaxAge:    cfg.WriteQueue.MaxAge,
+		})
+	}
+	if err := processor.SetAccessConfig(&cfg.Access); err != nil {
+		logging.Fatal(logger, "Invalid access config", "error", err)
+	}
+
+	if err := registerBotCommands(bot, processor.BotCommands()); err != nil {
+		logging.Fatal(logger, "Could not register Telegram bot commands", "error", err)
+	}
+	logger.Info("Successfully registered Telegram bot commands")
 
-	pinger, err := pinger.NewPinger(cache, bot, pingChatID)
+	pinger, err := pinger.NewPinger(cache, bot, cfg.Pinger.ChatID)
 	if err != nil {
-		log.Fatalf("Could not initialise pinger: %v", err)
+		logging.Fatal(logger, "Could not initialise pinger", "error", err)
 	}
-	if err := pinger.SetStartingTime(pingStartingTime); err != nil {
-		log.Fatalf("Could not set up starting time for pinger: %v", err)
+	if err := applyPingerConfig(pinger, cfg.Pinger); err != nil {
+		logging.Fatal(logger, "Could not set up pinger", "error", err)
 	}
-	if err := pinger.SetEndTime(pingEndTime); err != nil {
-		log.Fatalf("Could not set up finish time for pinger: %v", err)
+	pinger.SetUserResolver(resolver)
+
+	var notifications *notifier.Notifier
+	if len(cfg.Notifications.Events) > 0 {
+		notifications = newNotifier(bot, resolver, cfg)
+		cache.SetEventHandler(notifications.Notify)
 	}
 
-	pinger.SetThreshold(pingThreshold)
-	pinger.SetPeriod(pingPeriod)
-	pinger.SetPingText(pingText)
+	reloader := newReloader(configPath, cfg, processor, pinger, resolver)
+	processor.SetReloader(reloader)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	if debug {
-		notion.SetDebug(debug)
-		processor.SetDebug(debug)
-		cache.SetDebug(debug)
-		tracksCache.SetDebug(debug)
-		pinger.SetDebug(debug)
+	loops := supervisor.NewSupervisor()
+	// TODO should start pinger only after tasks have been loaded
+	loops.Go(ctx, "tasks cache", cache.RefreshPeriodically)
+	loops.Go(ctx, "tracks cache", tracksCache.RefreshPeriodically)
+	loops.Go(ctx, "pinger", pinger.PingPeriodically)
+	loops.Go(ctx, "config reloader", reloader.ReloadOnSignal)
+	if notifications != nil {
+		loops.Go(ctx, "notifier", notifications.Run)
+	}
+	if cfg.WriteQueue.Enabled {
+		loops.Go(ctx, "write queue", processor.ReplayQueuedWrites)
+	}
+	if cfg.Monitoring.Listen != "" {
+		handler := newMonitoringHandler([]readinessCheck{
+			{name: "tasks cache", ready: cache.Ready},
+			{name: "tracks cache", ready: tracksCache.Ready},
+		})
+		loops.Go(ctx, "monitoring server", serveMonitoring(cfg.Monitoring.Listen, handler))
 	}
 
-	go processor.ProcessRequests()
-	go cache.RefreshPeriodically() // TODO should start pinger only after tasks have been loaded
-	go tracksCache.RefreshPeriodically()
-	go pinger.PingPeriodically()
+	err = receiveUpdates(ctx, cfg.Telegram.Mode, webhookConfig(cfg.Telegram.Webhook), bot, processor)
+	if err != nil {
+		logger.Error("Could not receive Telegram updates", "error", err)
+	} else {
+		logger.Info("Shutting down")
+	}
+
+	// a second signal kills the bot without waiting
+	stop()
+	loops.Wait()
+
+	// closed explicitly since os.Exit skips deferred calls
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			logger.Error("Could not close the audit log", "error", err)
+		}
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+	logger.Info("Stopped")
+}
+
+// newNotifier creates the notifier of the task changes, it posts to the
+// pinger chat unless another one is configured.
+func newNotifier(
+	bot *tgbotapi.BotAPI, resolver *users.UserResolver, cfg *config.Config,
+) *notifier.Notifier {
+	chatID := cfg.Notifications.ChatID
+	if chatID == 0 {
+		chatID = cfg.Pinger.ChatID
+	}
+
+	n := notifier.NewNotifier(bot, resolver, chatID)
+	n.SetEvents(cfg.Notifications.Events)
+	n.SetDirectMessages(cfg.Notifications.Target == config.NotifyDM)
+
+	return n
+}
+
+// newNotion creates the Notion client and checks the databases against the
+// schema unless disabled.
+func newNotion(cfg *config.Config) *notion.Notion {
+	schema := notion.DefaultSchema()
+	if cfg.Notion.Schema != "" {
+		var err error
+		if schema, err = notion.LoadSchema(cfg.Notion.Schema); err != nil {
+			logging.Fatal(logger, "Could not load Notion schema", "error", err)
+		}
+	}
+
+	dbs := cfg.Notion.Databases
+
+	client := notion.NewNotion(cfg.Notion.Token)
+	client.SetSchema(schema)
+	client.SetQueryPageSize(cfg.Notion.PageSize)
+	client.SetQueryMaxPages(cfg.Notion.MaxPages)
+	// a single limiter is shared by the caches, the pinger and command handlers
+	client.SetRateLimiter(ratelimit.NewLimiter(cfg.Notion.RPS, cfg.Notion.Burst))
+	client.SetIdempotencyKeyProperty(cfg.Notion.IdempotencyProperty)
+	client.SetTweaksDBIDs(dbs.TweaksDemo, dbs.TweaksMix)
+
+	if cfg.Notion.ValidateSchema {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		report := client.ValidateSchemaContext(ctx, notion.DatabaseIDs{
+			Tasks:      dbs.Tasks,
+			TweaksDemo: dbs.TweaksDemo,
+			TweaksMix:  dbs.TweaksMix,
+			Tracks:     dbs.Tracks,
+		})
+		cancel()
+
+		if report.HasErrors() {
+			logging.Fatal(logger, "Notion databases do not match the schema", "report", report.String())
+		}
+		logger.Info("Checked Notion schema", "report", report.String())
+	}
+
+	return client
+}
 
-	for {
-		time.Sleep(time.Second)
+func webhookConfig(c config.Webhook) webhook.Config {
+	return webhook.Config{
+		URL:               c.URL,
+		ListenAddr:        c.Listen,
+		SecretToken:       c.SecretToken,
+		CertFile:          c.CertFile,
+		KeyFile:           c.KeyFile,
+		UploadCertificate: c.UploadCertificate,
 	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
+)
+
+const monitoringReadHeaderTimeout = 10 * time.Second
+
+// readinessCheck tells whether a part of the bot is ready, e.g. a cache has
+// been loaded.
+type readinessCheck struct {
+	name  string
+	ready func() bool
+}
+
+// newMonitoringHandler serves the metrics, /healthz answering while the
+// process is alive and /readyz answering once all checks pass.
+func newMonitoringHandler(checks []readinessCheck) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		var notReady []string
+		for _, check := range checks {
+			if !check.ready() {
+				notReady = append(notReady, check.name)
+			}
+		}
+
+		if len(notReady) > 0 {
+			http.Error(
+				w, "not ready: "+strings.Join(notReady, ", "), http.StatusServiceUnavailable,
+			)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
+
+// serveMonitoring returns a loop serving the handler on addr until ctx is
+// cancelled.
+func serveMonitoring(addr string, handler http.Handler) func(context.Context) {
+	return func(ctx context.Context) {
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: monitoringReadHeaderTimeout,
+		}
+
+		go func() {
+			<-ctx.Done()
+			// the handlers answer at once, there is nothing to wait for
+			if err := server.Close(); err != nil {
+				logger.Error("Could not close monitoring server", "error", err)
+			}
+		}()
+
+		logger.Info("Serving metrics and health checks", "listen", addr)
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Could not serve metrics and health checks", "error", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitoringHandler(t *testing.T) {
+	tasksReady := false
+	handler := newMonitoringHandler([]readinessCheck{
+		{name: "tasks cache", ready: func() bool { return tasksReady }},
+		{name: "tracks cache", ready: func() bool { return true }},
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+
+	rec := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "not ready: tasks cache\n", rec.Body.String())
+
+	tasksReady = true
+	assert.Equal(t, http.StatusOK, get("/readyz").Code)
+
+	rec = get("/metrics")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "# TYPE commands_total counter")
+	assert.Contains(t, rec.Body.String(), "# TYPE notion_requests_total counter")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gibsn/telegram_to_notion/internal/config"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/pinger"
+	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+)
+
+// reloader applies the sections of the config file that can be changed
+// without a restart, on SIGHUP or /reload. It implements
+// requestprocessor.Reloader.
+type reloader struct {
+	mu   sync.Mutex
+	path string
+	// current is the config the bot runs with, the sections needing a restart
+	// keep their values from the start.
+	current *config.Config
+
+	processor *requestprocessor.RequestProcessor
+	pinger    *pinger.Pinger
+	users     *users.UserResolver
+}
+
+func newReloader(
+	path string, current *config.Config,
+	processor *requestprocessor.RequestProcessor,
+	pinger *pinger.Pinger,
+	resolver *users.UserResolver,
+) *reloader {
+	return &reloader{
+		path:      path,
+		current:   current,
+		processor: processor,
+		pinger:    pinger,
+		users:     resolver,
+	}
+}
+
+// Reload reads the config file and applies it if it is valid, the current
+// config is kept otherwise.
+func (r *reloader) Reload() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updated, err := config.Load(r.path)
+	if err == nil {
+		err = r.processor.CheckAccessConfig(&updated.Access)
+	}
+	if err != nil {
+		logger.Error("Could not reload config, keeping the current one", "error", err)
+		return "", err
+	}
+
+	reloaded, needRestart := config.Diff(r.current, updated)
+
+	applied := *r.current
+	for _, section := range reloaded {
+		switch section {
+		case "logging":
+			applied.Logging = updated.Logging
+			logging.Apply(applied.Logging)
+		case "access":
+			applied.Access = updated.Access
+			err = r.processor.SetAccessConfig(&applied.Access)
+		case "user_links":
+			applied.UserLinks = updated.UserLinks
+			r.users.SetDeclaredLinks(userLinks(applied.UserLinks))
+		case "pinger":
+			applied.Pinger = updated.Pinger
+			err = applyPingerConfig(r.pinger, applied.Pinger)
+		}
+		if err != nil {
+			// the config has been validated, so this is a bug
+			return "", fmt.Errorf("could not apply %s: %w", section, err)
+		}
+	}
+	r.current = &applied
+
+	summary := "Config reloaded, nothing has changed."
+	if len(reloaded) > 0 {
+		summary = "Config reloaded: " + strings.Join(reloaded, ", ") + "."
+	}
+	if len(needRestart) > 0 {
+		summary += "\nRestart the bot to apply: " + strings.Join(needRestart, ", ") + "."
+	}
+	logger.Info("Config reloaded", "reloaded", reloaded, "need_restart", needRestart)
+
+	return summary, nil
+}
+
+// ReloadOnSignal reloads the config on every SIGHUP until ctx is cancelled.
+func (r *reloader) ReloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			// the outcome is logged by Reload
+			_, _ = r.Reload()
+		}
+	}
+}
+
+func applyPingerConfig(p *pinger.Pinger, c config.Pinger) error {
+	if err := p.SetStartingTime(c.Start); err != nil {
+		return err
+	}
+	if err := p.SetEndTime(c.End); err != nil {
+		return err
+	}
+	p.SetChatID(c.ChatID)
+	p.SetThreshold(c.Threshold)
+	p.SetPeriod(c.Period)
+	p.SetPingText(c.Text)
+
+	return nil
+}
+
+func userLinks(links []config.UserLink) []users.UserLink {
+	converted := make([]users.UserLink, 0, len(links))
+	for _, l := range links {
+		converted = append(converted, users.UserLink{
+			TelegramName: l.TelegramName,
+			NotionID:     l.NotionID,
+		})
+	}
+
+	return converted
+}
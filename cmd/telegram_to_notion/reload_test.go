@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/config"
+	"github.com/gibsn/telegram_to_notion/internal/pinger"
+	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+telegram:
+  token: tg-token
+notion:
+  token: notion-token
+  databases:
+    tasks: tasks-db
+    tweaks_demo: demo-db
+    tweaks_mix: mix-db
+    tracks: tracks-db
+`
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(extra string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(path, []byte(testConfig+extra), 0o600))
+	}
+
+	write("access:\n  users:\n    gibsn: admin\n")
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	processor := requestprocessor.NewRequestProcessor(nil, "", nil)
+	require.NoError(t, processor.SetAccessConfig(&cfg.Access))
+	p, err := pinger.NewPinger(nil, nil, 0)
+	require.NoError(t, err)
+	resolver := users.NewUserResolver()
+	r := newReloader(path, cfg, processor, p, resolver)
+
+	summary, err := r.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, "Config reloaded, nothing has changed.", summary)
+
+	write(`access:
+  users:
+    gibsn: admin
+    vomadan: member
+user_links:
+  - telegram_name: vomadan
+    notion_id: notion-2
+pinger:
+  chat_id: 10
+caches:
+  tasks_period: 5m
+`)
+	summary, err = r.Reload()
+	require.NoError(t, err)
+	assert.Equal(t,
+		"Config reloaded: pinger, access, user_links.\nRestart the bot to apply: caches.", summary,
+	)
+	assert.Equal(t, "notion-2", resolver.TgToNotion("@vomadan"))
+
+	// an invalid config is not applied
+	write("access:\n  users:\n    gibsn: admin\n  commands:\n    publish: admin\n")
+	_, err = r.Reload()
+	assert.ErrorContains(t, err, "publish")
+	write("access:\n  users:\n    gibsn: owner\n")
+	_, err = r.Reload()
+	assert.ErrorContains(t, err, "users.gibsn")
+	assert.Equal(t, "notion-2", resolver.TgToNotion("@vomadan"))
+
+	// the sections needing a restart are reported until the restart
+	write("access:\n  users:\n    gibsn: admin\ncaches:\n  tasks_period: 5m\n")
+	summary, err = r.Reload()
+	require.NoError(t, err)
+	assert.Equal(t,
+		"Config reloaded: pinger, access, user_links.\nRestart the bot to apply: caches.", summary,
+	)
+	assert.Empty(t, resolver.TgToNotion("@vomadan"))
+	assert.Equal(t, access.Config{
+		Users: map[string]access.Role{"gibsn": access.Admin}, Commands: map[string]access.Role{},
+	}, r.current.Access)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gibsn/telegram_to_notion/internal/config"
+	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
+	"github.com/gibsn/telegram_to_notion/internal/webhook"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// receiveUpdates passes Telegram updates to the processor in the given mode
+// until ctx is cancelled and the update in flight has been handled.
+func receiveUpdates(
+	ctx context.Context,
+	mode string,
+	webhookConfig webhook.Config,
+	bot *tgbotapi.BotAPI,
+	processor *requestprocessor.RequestProcessor,
+) error {
+	if mode == config.ModePolling {
+		// Telegram refuses getUpdates while a webhook is set, which happens if
+		// the bot was killed in webhook mode before deleting it
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			return fmt.Errorf("delete Telegram webhook: %w", err)
+		}
+
+		processor.ProcessRequests(ctx)
+		return nil
+	}
+
+	receiver, err := webhook.NewReceiver(bot, webhookConfig)
+	if err != nil {
+		return err
+	}
+
+	// the processor stops as well if the webhook fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		processor.ProcessUpdates(ctx, receiver.Updates())
+	}()
+
+	err = receiver.Run(ctx)
+	cancel()
+	<-processed
+
+	return err
+}
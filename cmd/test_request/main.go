@@ -3,14 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"strings"
 
+	"github.com/gibsn/telegram_to_notion/internal/logging"
 	notionapi "github.com/gibsn/telegram_to_notion/internal/notion"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var logger = logging.For("test_request")
+
 func main() {
 	var botToken, notionToken, notionDBID string
 	var debug bool
@@ -18,21 +20,29 @@ func main() {
 	flag.StringVar(&botToken, "telegram_token", "", "Telegram Bot Token")
 	flag.StringVar(&notionToken, "notion_token", "", "Notion Integration Token")
 	flag.StringVar(&notionDBID, "notion_db", "", "Notion Database ID")
-	flag.BoolVar(&debug, "debug", false, "Debug mode")
+	flag.BoolVar(&debug, "debug", false, "Log Notion payloads")
 	flag.Parse()
 
 	if botToken == "" || notionToken == "" || notionDBID == "" {
-		log.Fatal("All parameters (telegram_token, notion_token, notion_db) are required")
+		logging.Fatal(logger, "All parameters (telegram_token, notion_token, notion_db) are required")
+	}
+
+	logging.AddSecrets(botToken, notionToken)
+	if debug {
+		c := logging.DefaultConfig()
+		c.Level = "debug"
+		c.RedactMessages = false
+		logging.Apply(c)
 	}
 
-	log.Printf("Will connect to Telgram")
+	logger.Info("Will connect to Telegram")
 
 	bot, err := tgbotapi.NewBotAPI(botToken)
 	if err != nil {
-		log.Fatalf("Could not connect to the Telegram API: %v", err)
+		logging.Fatal(logger, "Could not connect to the Telegram API", "error", err)
 	}
 
-	log.Printf("Successfully connected to Telegram")
+	logger.Info("Successfully connected to Telegram")
 
 	notion := notionapi.NewNotion(notionToken)
 
@@ -43,11 +53,10 @@ func main() {
 	req.TaskName = "test_task_2"
 	req.Description = "test_description_2"
 	req.Assignees = []string{"7439e2ca-75f8-4024-b170-620ef7ed08b1"}
-	req.Debug = debug
 
 	url, err := notion.CreateNotionTask(req)
 	if err != nil {
-		log.Printf("error: %s", err)
+		logger.Error("Could not create task", "error", err)
 		reply = err.Error()
 	} else {
 		reply = fmt.Sprintf(
@@ -59,6 +68,6 @@ func main() {
 
 	msg := tgbotapi.NewMessage(51451990, reply)
 	if _, err := bot.Send(msg); err != nil {
-		log.Printf("Could not send message to Telegram: %v", err)
+		logger.Error("Could not send message to Telegram", "error", err)
 	}
 }
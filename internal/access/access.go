@@ -0,0 +1,240 @@
+// Package access decides who may use which bot commands. Telegram users are
+// given roles, and every command requires a minimum role.
+package access
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/store"
+)
+
+var logger = logging.For("access")
+
+// Role is a role of a user, every role includes the ones below it.
+type Role string
+
+const (
+	// Anyone is required by commands everybody may use, it is never given to
+	// users.
+	Anyone Role = "anyone"
+	Viewer Role = "viewer"
+	Member Role = "member"
+	Admin  Role = "admin"
+	// None takes the role away, it is only used in grants.
+	None Role = "none"
+)
+
+var ranks = map[Role]int{
+	Anyone: 0,
+	Viewer: 1,
+	Member: 2,
+	Admin:  3,
+}
+
+// ParseRole parses a role given to a user.
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	switch role {
+	case Viewer, Member, Admin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, expected viewer, member or admin", s)
+	}
+}
+
+// Includes tells whether the role is enough for a command requiring the
+// other one. Unknown roles include nothing and are included by nothing.
+func (r Role) Includes(required Role) bool {
+	rank, ok := ranks[r]
+	requiredRank, requiredOK := ranks[required]
+
+	return ok && requiredOK && rank >= requiredRank
+}
+
+// Config lists the roles of users and overrides the roles required by
+// commands.
+type Config struct {
+	// Users maps Telegram usernames to roles.
+	Users map[string]Role `yaml:"users"`
+	// Commands maps commands to the minimum role they require, e.g. "agenda"
+	// or "tweak towork" for a subcommand. Commands missing here require the
+	// role they are declared with.
+	Commands map[string]Role `yaml:"commands,omitempty"`
+}
+
+// DefaultConfig returns the team the bot was written for, everyone being an
+// admin.
+func DefaultConfig() *Config {
+	c := &Config{Users: map[string]Role{}}
+	for _, user := range []string{
+		"alexander_zh", "vomadan", "fenyakolles", "nikitacmc", "homesick94", "gibsn",
+	} {
+		c.Users[user] = Admin
+	}
+
+	return c
+}
+
+// Validate checks the roles and brings usernames and commands to the form
+// they are looked up in.
+func (c *Config) Validate() error {
+	users := make(map[string]Role, len(c.Users))
+	for user, role := range c.Users {
+		parsed, err := ParseRole(string(role))
+		if err != nil {
+			return fmt.Errorf("users.%s: %w", user, err)
+		}
+		users[NormalizeUser(user)] = parsed
+	}
+	c.Users = users
+
+	commands := make(map[string]Role, len(c.Commands))
+	for command, role := range c.Commands {
+		required := Role(strings.ToLower(string(role)))
+		if _, ok := ranks[required]; !ok {
+			return fmt.Errorf(
+				"commands.%s: unknown role %q, expected anyone, viewer, member or admin",
+				command, role,
+			)
+		}
+		commands[normalizeCommand(command)] = required
+	}
+	c.Commands = commands
+
+	return nil
+}
+
+// OverriddenCommands returns the commands the config overrides the role of.
+func (c *Config) OverriddenCommands() []string {
+	commands := make([]string, 0, len(c.Commands))
+	for command := range c.Commands {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	return commands
+}
+
+// NormalizeUser brings a username to the form it is stored in, usernames are
+// case insensitive and may be written with a leading @.
+func NormalizeUser(user string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(user), "@"))
+}
+
+func normalizeCommand(command string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.TrimPrefix(command, "/")), " "))
+}
+
+// grantsBucket is the bucket of the store holding the roles granted from chat.
+const grantsBucket = "access_grants"
+
+// Control answers access questions and keeps the roles granted from chat. The
+// granted roles take precedence over the config, None in a grant takes the
+// role given by the config away. It is safe for concurrent use.
+type Control struct {
+	mu     sync.RWMutex
+	config *Config
+	grants *store.Bucket[Role]
+}
+
+func NewControl(config *Config) *Control {
+	return &Control{
+		config: config,
+		grants: store.NewBucket[Role](store.NewMemoryStore(), grantsBucket),
+	}
+}
+
+// SetStore makes the roles granted from chat survive a restart.
+func (c *Control) SetStore(s *store.Store) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.grants = store.NewBucket[Role](s, grantsBucket)
+}
+
+// SetConfig replaces the config, e.g. on reload. The roles granted from chat
+// are kept.
+func (c *Control) SetConfig(config *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+}
+
+// Role returns the role of the user, empty if the user has none.
+func (c *Control) Role(user string) Role {
+	user = NormalizeUser(user)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	granted, ok, err := c.grants.Get(user)
+	if err != nil {
+		logger.Error("Could not read the granted role", "user", user, "error", err)
+	}
+	switch {
+	case ok && granted == None:
+		return ""
+	case ok:
+		return granted
+	default:
+		return c.config.Users[user]
+	}
+}
+
+// CommandRole returns the role required by the command, declared is used
+// unless the config overrides it.
+func (c *Control) CommandRole(command string, declared Role) Role {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if role, ok := c.config.Commands[normalizeCommand(command)]; ok {
+		return role
+	}
+
+	return declared
+}
+
+// Allows tells whether the user may run a command requiring the role.
+func (c *Control) Allows(user string, required Role) bool {
+	if required == Anyone {
+		return true
+	}
+
+	return c.Role(user).Includes(required)
+}
+
+// Grant gives the role to the user, None takes the role away. A grant equal
+// to the role given by the config is forgotten, so that later changes of the
+// config apply to the user.
+func (c *Control) Grant(user string, role Role) error {
+	user = NormalizeUser(user)
+	if user == "" {
+		return errors.New("username is empty")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	configured, ok := c.config.Users[user]
+	if !ok {
+		configured = None
+	}
+
+	var err error
+	if role == configured {
+		err = c.grants.Delete(user)
+	} else {
+		err = c.grants.Put(user, role)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the role: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package access
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleIncludes(t *testing.T) {
+	assert.True(t, Admin.Includes(Member))
+	assert.True(t, Member.Includes(Member))
+	assert.True(t, Viewer.Includes(Anyone))
+	assert.False(t, Viewer.Includes(Member))
+	assert.False(t, Role("").Includes(Anyone))
+	assert.False(t, Admin.Includes(None))
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := &Config{
+		Users:    map[string]Role{"@GibsN": Admin, "vomadan": "Viewer"},
+		Commands: map[string]Role{"/Tweak   towork": Member, "help": Anyone},
+	}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, map[string]Role{"gibsn": Admin, "vomadan": Viewer}, c.Users)
+	assert.Equal(t, map[string]Role{"tweak towork": Member, "help": Anyone}, c.Commands)
+	assert.Equal(t, []string{"help", "tweak towork"}, c.OverriddenCommands())
+
+	c = &Config{Users: map[string]Role{"gibsn": Anyone}}
+	assert.ErrorContains(t, c.Validate(), "users.gibsn")
+
+	c = &Config{Commands: map[string]Role{"tasks": "lead"}}
+	assert.ErrorContains(t, c.Validate(), "commands.tasks")
+}
+
+func TestControl(t *testing.T) {
+	c := NewControl(&Config{
+		Users:    map[string]Role{"gibsn": Admin, "vomadan": Viewer},
+		Commands: map[string]Role{"tasks": Member},
+	})
+
+	assert.True(t, c.Allows("@GIBSN", Admin))
+	assert.False(t, c.Allows("vomadan", Member))
+	assert.False(t, c.Allows("stranger", Viewer))
+	assert.True(t, c.Allows("stranger", Anyone))
+
+	assert.Equal(t, Member, c.CommandRole("/tasks", Viewer))
+	assert.Equal(t, Viewer, c.CommandRole("tracks", Viewer))
+}
+
+func TestGrant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := store.Open(path)
+	require.NoError(t, err)
+
+	c := NewControl(&Config{Users: map[string]Role{"gibsn": Admin, "homesick94": Viewer}})
+	c.SetStore(s)
+
+	require.NoError(t, c.Grant("@vomadan", Member))
+	require.NoError(t, c.Grant("gibsn", None))
+	require.NoError(t, c.Grant("homesick94", Member))
+
+	// the grants survive a restart and take precedence over the config
+	s, err = store.Open(path)
+	require.NoError(t, err)
+	c = NewControl(&Config{Users: map[string]Role{"gibsn": Admin, "homesick94": Viewer}})
+	c.SetStore(s)
+	assert.Equal(t, Member, c.Role("vomadan"))
+	assert.Empty(t, c.Role("gibsn"))
+	assert.Equal(t, Member, c.Role("homesick94"))
+
+	// a grant equal to the config is dropped, so the config applies again
+	require.NoError(t, c.Grant("homesick94", Viewer))
+	c.SetConfig(&Config{Users: map[string]Role{"homesick94": Admin}})
+	assert.Equal(t, Admin, c.Role("homesick94"))
+	assert.Equal(t, Member, c.Role("vomadan"))
+}
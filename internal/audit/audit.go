@@ -0,0 +1,336 @@
+// Package audit keeps an append-only log of the changes the bot makes in
+// Notion, one JSON object per line. The file is rotated once it grows over a
+// size, a number of rotated files is kept and searched as well.
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+var logger = logging.For("audit")
+
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+	// OutcomeQueued is the outcome of a command that failed while Notion was
+	// unavailable and is to be applied later, another entry records the
+	// result.
+	OutcomeQueued = "queued"
+)
+
+const (
+	// FieldPage is the field of a change creating a page, New is its link.
+	FieldPage     = "page"
+	FieldStatus   = "status"
+	FieldDeadline = "deadline"
+)
+
+// Change is a change of a page. For a failed command it is the change that
+// was attempted.
+type Change struct {
+	PageID string `json:"page_id,omitempty"`
+	Field  string `json:"field"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+	// OldKnown tells whether Old is the value before the change, it is not if
+	// the value could not be loaded. A change with an unknown old value cannot
+	// be reverted.
+	OldKnown bool `json:"old_known,omitempty"`
+	// OldDate is the deadline before a change of FieldDeadline as stored in
+	// Notion, nil if it was not set. Old only describes it.
+	OldDate *property.Date `json:"old_date,omitempty"`
+}
+
+// Entry is a command changing Notion and its outcome.
+type Entry struct {
+	// ID is unique for the entries of a log, it is set by Append.
+	ID        string    `json:"id,omitempty"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name,omitempty"`
+	ChatID    int64     `json:"chat_id"`
+	Command   string    `json:"command"`
+	Changes   []Change  `json:"changes,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	// Undoes is the ID of the entry whose changes the entry reverts.
+	Undoes string `json:"undoes,omitempty"`
+}
+
+// maxLineSize limits the entries read back, an entry is far smaller.
+const maxLineSize = 1 << 20
+
+// Log appends entries to a file. It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	// maxSize is the size a file is rotated at, maxFiles is the number of
+	// rotated files kept.
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+
+	now func() time.Time
+}
+
+// Open opens the log at path for appending, creating it if needed.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxSize <= 0 || maxFiles <= 0 {
+		return nil, errors.New("max size and max files must be positive")
+	}
+
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles, now: time.Now}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+
+	l.file, l.size = file, info.Size()
+
+	// a line cut by a crash is ended, so that it does not swallow the next one
+	if l.size > 0 && !endsWithNewline(l.path, l.size) {
+		n, err := l.file.Write([]byte{'\n'})
+		l.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("could not open audit log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func endsWithNewline(path string, size int64) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return true
+	}
+
+	return last[0] == '\n'
+}
+
+// Append writes the entry, setting its ID and its time unless set.
+func (l *Log) Append(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("could not write audit entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("could not write audit entry: %w", err)
+	}
+
+	return nil
+}
+
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b[:])
+}
+
+// rotatedPath returns the path of the i-th rotated file, 1 is the newest.
+func (l *Log) rotatedPath(i int) string {
+	return l.path + "." + strconv.Itoa(i)
+}
+
+// rotate renames the current file to the first rotated one, shifting the
+// others and dropping the oldest, and starts a new file. It must be called
+// with mu locked.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+
+	err := os.Remove(l.rotatedPath(l.maxFiles))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(l.path, l.rotatedPath(1)); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+
+	return l.open()
+}
+
+// Query returns up to limit latest entries matching, the newest first. The
+// rotated files are searched as well, newest first, until limit entries are
+// found. The log is only locked to open the files, appends and rotations
+// going on during the search are not seen by it.
+func (l *Log) Query(match func(Entry) bool, limit int) ([]Entry, error) {
+	files, err := l.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles(files)
+
+	var found []Entry
+	for _, f := range files {
+		if len(found) >= limit {
+			break
+		}
+
+		var matched []Entry
+		err := readEntries(f.path, io.NewSectionReader(f.file, 0, f.size), func(e Entry) {
+			if match(e) {
+				matched = append(matched, e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(matched) - 1; i >= 0 && len(found) < limit; i-- {
+			found = append(found, matched[i])
+		}
+	}
+
+	return found, nil
+}
+
+// openedFile is a file of the log opened for reading, size is the part of it
+// written when it was opened.
+type openedFile struct {
+	path string
+	file *os.File
+	size int64
+}
+
+// openFiles opens the current file and the rotated ones, the newest first,
+// missing files are skipped. The files opened stay readable when rotated.
+func (l *Log) openFiles() ([]openedFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	files := make([]openedFile, 0, l.maxFiles+1)
+	for i := 0; i <= l.maxFiles; i++ {
+		path := l.path
+		if i > 0 {
+			path = l.rotatedPath(i)
+		}
+
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("could not read audit log: %w", err)
+		}
+
+		size := l.size
+		if i > 0 {
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				closeFiles(files)
+				return nil, fmt.Errorf("could not read audit log: %w", err)
+			}
+			size = info.Size()
+		}
+
+		files = append(files, openedFile{path: path, file: file, size: size})
+	}
+
+	return files, nil
+}
+
+func closeFiles(files []openedFile) {
+	for _, f := range files {
+		f.file.Close()
+	}
+}
+
+// readEntries passes the entries read from the file at path in order. Lines
+// that cannot be parsed, e.g. cut by a crash, are skipped.
+func readEntries(path string, r io.Reader, read func(Entry)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logger.Warn("Skipping invalid audit entry", "file", path, "line", line, "error", err)
+			continue
+		}
+		read(e)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the file, the log may not be used afterwards.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func all(Entry) bool { return true }
+
+func TestAppendAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 1<<20, 3)
+	require.NoError(t, err)
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	require.NoError(t, l.Append(Entry{UserName: "gibsn", Command: "/done", Outcome: OutcomeOK,
+		Changes: []Change{{PageID: "page-1", Field: FieldStatus, Old: "New", New: "Done"}}}))
+	require.NoError(t, l.Append(Entry{UserName: "vomadan", Command: "/task", Outcome: OutcomeError,
+		Error: "notion is down"}))
+	require.NoError(t, l.Close())
+
+	// entries survive reopening and are appended to
+	l, err = Open(path, 1<<20, 3)
+	require.NoError(t, err)
+	require.NoError(t, l.Append(Entry{UserName: "gibsn", Command: "/agenda", Outcome: OutcomeOK}))
+
+	entries, err := l.Query(all, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "/agenda", entries[0].Command)
+	assert.Equal(t, "/done", entries[2].Command)
+	assert.Equal(t, now, entries[2].Time)
+	assert.Equal(t, "Done", entries[2].Changes[0].New)
+	assert.NotEmpty(t, entries[0].ID)
+	assert.NotEqual(t, entries[0].ID, entries[1].ID)
+
+	entries, err = l.Query(func(e Entry) bool { return e.UserName == "gibsn" }, 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "/agenda", entries[0].Command)
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 200, 2)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, l.Append(Entry{ChatID: int64(i), Command: "/done", Outcome: OutcomeOK}))
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(200))
+	}
+	assert.NoFileExists(t, path+".3")
+
+	// the oldest entries are gone, the rest is returned in order
+	entries, err := l.Query(all, 100)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Less(t, len(entries), 20)
+	assert.Equal(t, int64(19), entries[0].ChatID)
+	for i := 1; i < len(entries); i++ {
+		assert.Equal(t, entries[i-1].ChatID-1, entries[i].ChatID)
+	}
+}
+
+func TestQuerySkipsInvalidLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"command\":\"/done\"}\n{\"comm\n"), 0o600))
+
+	l, err := Open(path, 1<<20, 1)
+	require.NoError(t, err)
+
+	// the cut line does not swallow the next entry
+	require.NoError(t, l.Append(Entry{Command: "/task"}))
+
+	entries, err := l.Query(all, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/task", entries[0].Command)
+	assert.Equal(t, "/done", entries[1].Command)
+}
+
+func TestQueryStopsAtLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 200, 2)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, l.Append(Entry{ChatID: int64(i), Command: "/done", Outcome: OutcomeOK}))
+	}
+
+	// the oldest file cannot be read, it is not needed for the latest entries
+	require.NoError(t, os.Remove(path+".2"))
+	require.NoError(t, os.Mkdir(path+".2", 0o700))
+
+	entries, err := l.Query(all, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, int64(19), entries[0].ChatID)
+	assert.Equal(t, int64(18), entries[1].ChatID)
+
+	_, err = l.Query(all, 100)
+	assert.Error(t, err)
+}
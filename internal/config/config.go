@@ -0,0 +1,401 @@
+// Package config loads the YAML config of the bot. Secrets may be given
+// inline or as paths to files holding them, so that they do not appear on the
+// command line. Some sections may be reloaded without a restart, see Diff.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/taskscache"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Targets of the notifications.
+const (
+	NotifyChat = "chat"
+	NotifyDM   = "dm"
+)
+
+type Config struct {
+	// Logging sets the level, the format and the redaction of the logs.
+	Logging logging.Config `yaml:"logging"`
+	// StateFile keeps the state surviving restarts, e.g. dialogs waiting for
+	// a reply, roles granted and accounts linked from chat. Empty keeps the
+	// state in memory.
+	StateFile string `yaml:"state_file"`
+	// Audit records the changes made in Notion for /history.
+	Audit    Audit    `yaml:"audit"`
+	Telegram Telegram `yaml:"telegram"`
+	Notion   Notion   `yaml:"notion"`
+	Pinger   Pinger   `yaml:"pinger"`
+	Caches   Caches   `yaml:"caches"`
+	Updates  Updates  `yaml:"updates"`
+	// WriteQueue queues the changes failing while Notion is unavailable, they
+	// are kept in StateFile.
+	WriteQueue WriteQueue `yaml:"write_queue"`
+	// Monitoring serves /metrics, /healthz and /readyz.
+	Monitoring Monitoring `yaml:"monitoring"`
+	// Notifications announce the changes of the tasks noticed by the tasks
+	// cache, the ones made in the Notion UI included.
+	Notifications Notifications `yaml:"notifications"`
+	// Access lists the roles of users, roles granted from chat with /grant
+	// take precedence.
+	Access access.Config `yaml:"access"`
+	// UserLinks links Telegram users to Notion users in addition to the links
+	// made with /link. A link is skipped if either of the users is linked
+	// already.
+	UserLinks []UserLink `yaml:"user_links"`
+}
+
+type Telegram struct {
+	Token     string  `yaml:"token"`
+	TokenFile string  `yaml:"token_file"`
+	Mode      string  `yaml:"mode"`
+	Webhook   Webhook `yaml:"webhook"`
+}
+
+// Webhook configures the webhook mode, see webhook.Config.
+type Webhook struct {
+	URL               string `yaml:"url"`
+	Listen            string `yaml:"listen"`
+	SecretToken       string `yaml:"secret_token"`
+	SecretTokenFile   string `yaml:"secret_token_file"`
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	UploadCertificate bool   `yaml:"upload_certificate"`
+}
+
+type Notion struct {
+	Token     string    `yaml:"token"`
+	TokenFile string    `yaml:"token_file"`
+	Databases Databases `yaml:"databases"`
+	// Schema is a YAML file mapping bot fields to Notion properties, see
+	// notion.LoadSchema.
+	Schema         string  `yaml:"schema"`
+	ValidateSchema bool    `yaml:"validate_schema"`
+	PageSize       int     `yaml:"page_size"`
+	MaxPages       int     `yaml:"max_pages"`
+	RPS            float64 `yaml:"rps"`
+	Burst          int     `yaml:"burst"`
+	// IdempotencyProperty is a rich text property storing idempotency keys of
+	// created pages, empty disables the check. The property has to be added
+	// to the tasks and tweaks databases before it is set.
+	IdempotencyProperty string `yaml:"idempotency_property"`
+}
+
+type Databases struct {
+	Tasks      string `yaml:"tasks"`
+	TweaksDemo string `yaml:"tweaks_demo"`
+	TweaksMix  string `yaml:"tweaks_mix"`
+	Tracks     string `yaml:"tracks"`
+}
+
+type Pinger struct {
+	ChatID int64 `yaml:"chat_id"`
+	// Threshold is the time till the deadline when pinging starts.
+	Threshold time.Duration `yaml:"threshold"`
+	// Start and End bound the pings of a day, e.g. 09:00.
+	Start  string        `yaml:"start"`
+	End    string        `yaml:"end"`
+	Period time.Duration `yaml:"period"`
+	Text   string        `yaml:"text"`
+}
+
+type Caches struct {
+	TasksPeriod time.Duration `yaml:"tasks_period"`
+	// TasksResyncPeriod is how often all active tasks are loaded, the other
+	// refreshes load only the tasks edited since the previous one.
+	TasksResyncPeriod time.Duration `yaml:"tasks_resync_period"`
+	TracksPeriod      time.Duration `yaml:"tracks_period"`
+}
+
+type Updates struct {
+	// RequestTimeout is the deadline for handling a single update.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	Workers        int           `yaml:"workers"`
+	// QueueSize is the max number of received updates waiting or being
+	// handled.
+	QueueSize int `yaml:"queue_size"`
+}
+
+// WriteQueue configures the replays of queued changes, see
+// requestprocessor.WriteQueueConfig.
+type WriteQueue struct {
+	Enabled   bool          `yaml:"enabled"`
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	MaxAge    time.Duration `yaml:"max_age"`
+}
+
+type Monitoring struct {
+	// Listen is the address of the HTTP server, empty disables it.
+	Listen string `yaml:"listen"`
+}
+
+type Notifications struct {
+	// Events are the types of the events announced, see
+	// taskscache.EventTypes. Empty disables the notifications.
+	Events []taskscache.EventType `yaml:"events"`
+	// Target is chat to post to ChatID or dm to message the assignees
+	// affected.
+	Target string `yaml:"target"`
+	// ChatID defaults to pinger.chat_id.
+	ChatID int64 `yaml:"chat_id"`
+}
+
+type Audit struct {
+	// File is the path of the audit log, empty disables it.
+	File string `yaml:"file"`
+	// MaxSizeMB is the size the file is rotated at, MaxFiles is the number of
+	// rotated files kept.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	MaxFiles  int `yaml:"max_files"`
+	// UndoWindow is how long after a change /undo may revert it.
+	UndoWindow time.Duration `yaml:"undo_window"`
+}
+
+type UserLink struct {
+	TelegramName string `yaml:"telegram_name"`
+	NotionID     string `yaml:"notion_id"`
+}
+
+// Default returns the config used for the settings missing in the file.
+func Default() *Config {
+	return &Config{
+		Logging: logging.DefaultConfig(),
+		Telegram: Telegram{
+			Mode:    ModePolling,
+			Webhook: Webhook{Listen: ":8443"},
+		},
+		Notion: Notion{
+			ValidateSchema: true,
+			PageSize:       100,
+			MaxPages:       50,
+			RPS:            3,
+			Burst:          3,
+		},
+		Pinger: Pinger{
+			Threshold: 72 * time.Hour,
+			Start:     "09:00",
+			End:       "23:00",
+			Period:    6 * time.Hour,
+			Text:      "Hi, what's the estimate?",
+		},
+		Caches: Caches{
+			TasksPeriod:       time.Minute,
+			TasksResyncPeriod: 10 * time.Minute,
+			TracksPeriod:      time.Minute,
+		},
+		Updates: Updates{
+			RequestTimeout: time.Minute,
+			Workers:        8,
+			QueueSize:      100,
+		},
+		WriteQueue: WriteQueue{
+			Enabled:   true,
+			BaseDelay: 30 * time.Second,
+			MaxDelay:  10 * time.Minute,
+			MaxAge:    24 * time.Hour,
+		},
+		Monitoring:    Monitoring{Listen: "127.0.0.1:9090"},
+		Notifications: Notifications{Target: NotifyChat},
+		Audit:         Audit{MaxSizeMB: 10, MaxFiles: 5, UndoWindow: 15 * time.Minute},
+	}
+}
+
+// Load reads and validates the config file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Parse parses the YAML config on top of Default, reads the secret files and
+// validates the result. Unknown fields are rejected.
+func Parse(data []byte) (*Config, error) {
+	c := Default()
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := c.readSecrets(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) readSecrets() error {
+	secrets := []struct {
+		field       string
+		value, file *string
+	}{
+		{"telegram.token", &c.Telegram.Token, &c.Telegram.TokenFile},
+		{
+			"telegram.webhook.secret_token",
+			&c.Telegram.Webhook.SecretToken, &c.Telegram.Webhook.SecretTokenFile,
+		},
+		{"notion.token", &c.Notion.Token, &c.Notion.TokenFile},
+	}
+
+	for _, s := range secrets {
+		if *s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("%s: both the value and the file are given", s.field)
+		}
+
+		data, err := os.ReadFile(*s.file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.field, err)
+		}
+		*s.value = strings.TrimSpace(string(data))
+	}
+
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Telegram.Token != "", "telegram.token is required")
+	check(c.Notion.Token != "", "notion.token is required")
+	for field, id := range map[string]string{
+		"tasks":       c.Notion.Databases.Tasks,
+		"tweaks_demo": c.Notion.Databases.TweaksDemo,
+		"tweaks_mix":  c.Notion.Databases.TweaksMix,
+		"tracks":      c.Notion.Databases.Tracks,
+	} {
+		check(id != "", "notion.databases.%s is required", field)
+	}
+
+	switch c.Telegram.Mode {
+	case ModePolling:
+	case ModeWebhook:
+		check(c.Telegram.Webhook.URL != "", "telegram.webhook.url is required in webhook mode")
+	default:
+		check(false, "telegram.mode: unknown mode %q, expected %s or %s",
+			c.Telegram.Mode, ModePolling, ModeWebhook)
+	}
+
+	check(c.Notion.PageSize > 0 && c.Notion.PageSize <= 100, "notion.page_size must be 1..100")
+	check(c.Notion.MaxPages > 0, "notion.max_pages must be positive")
+	check(c.Notion.RPS > 0, "notion.rps must be positive")
+	check(c.Notion.Burst > 0, "notion.burst must be positive")
+
+	for field, value := range map[string]string{"start": c.Pinger.Start, "end": c.Pinger.End} {
+		_, err := time.Parse("15:04", value)
+		check(err == nil, "pinger.%s: expected time as 15:04, got %q", field, value)
+	}
+	check(c.Pinger.Period > 0, "pinger.period must be positive")
+	check(c.Caches.TasksPeriod > 0, "caches.tasks_period must be positive")
+	check(c.Caches.TasksResyncPeriod > 0, "caches.tasks_resync_period must be positive")
+	check(c.Caches.TracksPeriod > 0, "caches.tracks_period must be positive")
+	check(c.Updates.RequestTimeout > 0, "updates.request_timeout must be positive")
+	check(c.Updates.Workers > 0, "updates.workers must be positive")
+	check(c.Updates.QueueSize > 0, "updates.queue_size must be positive")
+	if c.WriteQueue.Enabled {
+		check(c.WriteQueue.BaseDelay > 0, "write_queue.base_delay must be positive")
+		check(c.WriteQueue.MaxDelay >= c.WriteQueue.BaseDelay,
+			"write_queue.max_delay must not be less than base_delay")
+		check(c.WriteQueue.MaxAge > 0, "write_queue.max_age must be positive")
+	}
+	if c.Audit.File != "" {
+		check(c.Audit.MaxSizeMB > 0, "audit.max_size_mb must be positive")
+		check(c.Audit.MaxFiles > 0, "audit.max_files must be positive")
+		check(c.Audit.UndoWindow > 0, "audit.undo_window must be positive")
+	}
+	for _, event := range c.Notifications.Events {
+		check(slices.Contains(taskscache.EventTypes, event),
+			"notifications.events: unknown event %q", event)
+	}
+	switch c.Notifications.Target {
+	case NotifyChat:
+		check(len(c.Notifications.Events) == 0 || c.Notifications.ChatID != 0 || c.Pinger.ChatID != 0,
+			"notifications.chat_id is required to post notifications to a chat")
+	case NotifyDM:
+	default:
+		check(false, "notifications.target: unknown target %q, expected %s or %s",
+			c.Notifications.Target, NotifyChat, NotifyDM)
+	}
+
+	if err := c.Logging.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("logging: %w", err))
+	}
+	check(len(c.Access.Users) > 0, "access.users is required, nobody could use the bot otherwise")
+	if err := c.Access.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("access: %w", err))
+	}
+	for i, link := range c.UserLinks {
+		check(link.TelegramName != "" && link.NotionID != "",
+			"user_links[%d]: telegram_name and notion_id are required", i)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reloadable lists the sections applied by a reload, the others need a
+// restart.
+var reloadable = map[string]bool{
+	"logging":    true,
+	"access":     true,
+	"user_links": true,
+	"pinger":     true,
+}
+
+// Diff returns the sections of the config that differ between old and updated,
+// split into the ones applied by a reload and the ones needing a restart.
+func Diff(old, updated *Config) (reloaded, needRestart []string) {
+	oldValue, updatedValue := reflect.ValueOf(*old), reflect.ValueOf(*updated)
+
+	for i := 0; i < oldValue.NumField(); i++ {
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), updatedValue.Field(i).Interface()) {
+			continue
+		}
+
+		section := strings.Split(oldValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if reloadable[section] {
+			reloaded = append(reloaded, section)
+		} else {
+			needRestart = append(needRestart, section)
+		}
+	}
+
+	return reloaded, needRestart
+}
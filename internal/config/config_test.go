@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// minimalConfig is a valid config once the telegram section is added.
+const minimalConfig = `
+notion:
+  token: notion-token
+  databases:
+    tasks: tasks-db
+    tweaks_demo: demo-db
+    tweaks_mix: mix-db
+    tracks: tracks-db
+access:
+  users:
+    "@Gibsn": admin
+`
+
+const telegramSection = "telegram:\n  token: tg-token\n"
+
+func TestParseDefaults(t *testing.T) {
+	c, err := Parse([]byte(telegramSection + minimalConfig + "pinger:\n  period: 2h\n"))
+	require.NoError(t, err)
+
+	expected := Default()
+	expected.Telegram.Token = "tg-token"
+	expected.Notion.Token = "notion-token"
+	expected.Notion.Databases = Databases{
+		Tasks: "tasks-db", TweaksDemo: "demo-db", TweaksMix: "mix-db", Tracks: "tracks-db",
+	}
+	expected.Pinger.Period = 2 * time.Hour
+	expected.Access = access.Config{
+		Users: map[string]access.Role{"gibsn": access.Admin}, Commands: map[string]access.Role{},
+	}
+	assert.Equal(t, expected, c)
+}
+
+func TestParseRejectsInvalidConfig(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config   string
+		expected string
+	}{
+		"unknown field": {telegramSection + minimalConfig + "pinger:\n  chat: 1\n", "chat not found"},
+		"bad role":      {telegramSection + minimalConfig + "    vomadan: owner\n", "users.vomadan"},
+		"bad time":      {telegramSection + minimalConfig + "pinger:\n  start: 9am\n", "pinger.start"},
+		"bad log level": {
+			telegramSection + minimalConfig + "logging:\n  level: verbose\n", "logging: unknown level",
+		},
+		"bad audit size": {
+			telegramSection + minimalConfig + "audit:\n  file: a.jsonl\n  max_size_mb: 0\n",
+			"audit.max_size_mb",
+		},
+		"unknown notification event": {
+			telegramSection + minimalConfig + "notifications:\n  events: [renamed]\n",
+			"notifications.events",
+		},
+		"notifications without chat": {
+			telegramSection + minimalConfig + "notifications:\n  events: [done]\n",
+			"notifications.chat_id",
+		},
+		"bad write queue delay": {
+			telegramSection + minimalConfig + "write_queue:\n  max_delay: 1s\n",
+			"write_queue.max_delay",
+		},
+		"webhook": {
+			telegramSection + "  mode: webhook\n" + minimalConfig, "telegram.webhook.url",
+		},
+		"missing": {"telegram:\n  token: t\n", "notion.databases.tasks is required"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.config))
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestParseReadsSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+
+	c, err := Parse([]byte("telegram:\n  token_file: " + tokenFile + "\n" + minimalConfig))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", c.Telegram.Token)
+
+	_, err = Parse([]byte(telegramSection + "  token_file: " + tokenFile + "\n" + minimalConfig))
+	assert.ErrorContains(t, err, "both the value and the file")
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("telegram:\n  token_file: missing\n"), 0o600))
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "telegram.token")
+}
+
+func TestDiff(t *testing.T) {
+	old, err := Parse([]byte(telegramSection + minimalConfig))
+	require.NoError(t, err)
+
+	updated, err := Parse([]byte(telegramSection + minimalConfig + `    vomadan: member
+logging:
+  level: debug
+pinger:
+  chat_id: 10
+caches:
+  tasks_period: 5m
+`))
+	require.NoError(t, err)
+
+	reloaded, needRestart := Diff(old, updated)
+	assert.Equal(t, []string{"logging", "pinger", "access"}, reloaded)
+	assert.Equal(t, []string{"caches"}, needRestart)
+
+	reloaded, needRestart = Diff(old, old)
+	assert.Empty(t, reloaded)
+	assert.Empty(t, needRestart)
+}
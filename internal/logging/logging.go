@@ -0,0 +1,268 @@
+// Package logging sets up log/slog for the bot. Every package logs through a
+// logger of its component, see For, and the lines logged while handling an
+// update or a background job carry its request ID, see WithRequestID.
+//
+// Tokens and message contents are redacted unless configured otherwise:
+// registered secrets are masked wherever they appear, and values marked with
+// Text are replaced with their length.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config configures logging, it may be changed while the bot runs, see Apply.
+type Config struct {
+	// Level is one of debug, info, warn and error. Debug adds the Notion
+	// payloads and the texts of messages.
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	// RedactTokens masks the Telegram and Notion tokens and other registered
+	// secrets, see AddSecrets.
+	RedactTokens bool `yaml:"redact_tokens"`
+	// RedactMessages replaces message texts and Notion payloads with their
+	// length, see Text.
+	RedactMessages bool `yaml:"redact_messages"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Level:          "info",
+		Format:         FormatText,
+		RedactTokens:   true,
+		RedactMessages: true,
+	}
+}
+
+// Validate checks the level and the format.
+func (c Config) Validate() error {
+	if _, err := parseLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("unknown format %q, expected %s or %s", c.Format, FormatText, FormatJSON)
+	}
+
+	return nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown level %q, expected debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+// settings are shared by all loggers, so that Apply affects the loggers made
+// before it.
+var settings = struct {
+	level          slog.LevelVar
+	redactTokens   atomic.Bool
+	redactMessages atomic.Bool
+	// output is the handler lines are written with, it depends on the format.
+	output atomic.Pointer[slog.Handler]
+
+	mu      sync.Mutex
+	secrets atomic.Pointer[[]string]
+}{}
+
+// out is where the lines are written, it is only changed by tests.
+var out io.Writer = os.Stderr
+
+func init() {
+	Apply(DefaultConfig())
+	// lines logged with the log package, e.g. by dependencies, go through
+	// slog as well
+	slog.SetDefault(slog.New(rootHandler{}))
+}
+
+// Apply changes the level, the format and the redaction of all loggers. The
+// config must be valid.
+func Apply(c Config) {
+	level, err := parseLevel(c.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	settings.level.Set(level)
+	settings.redactTokens.Store(c.RedactTokens)
+	settings.redactMessages.Store(c.RedactMessages)
+
+	options := &slog.HandlerOptions{Level: &settings.level, ReplaceAttr: replaceAttr}
+	var output slog.Handler
+	if c.Format == FormatJSON {
+		output = slog.NewJSONHandler(out, options)
+	} else {
+		output = slog.NewTextHandler(out, options)
+	}
+	settings.output.Store(&output)
+}
+
+// AddSecrets registers the values to mask in all lines, e.g. tokens. Empty
+// values are ignored.
+func AddSecrets(secrets ...string) {
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+
+	var updated []string
+	if current := settings.secrets.Load(); current != nil {
+		updated = append(updated, *current...)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			updated = append(updated, secret)
+		}
+	}
+	settings.secrets.Store(&updated)
+}
+
+// tokenRe matches Telegram bot tokens and Notion integration tokens, so that
+// they are masked even if they were not registered.
+var tokenRe = regexp.MustCompile(`\d{5,}:[\w-]{30,}|\b(?:secret|ntn)_\w{20,}`)
+
+const redacted = "[REDACTED]"
+
+func redactTokens(s string) string {
+	if current := settings.secrets.Load(); current != nil {
+		for _, secret := range *current {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+
+	return tokenRe.ReplaceAllString(s, redacted)
+}
+
+// text is user content, see Text.
+type text string
+
+// Text marks a value holding user content, e.g. a message text or a Notion
+// payload. It is replaced with its length unless message redaction is off.
+func Text(key, value string) slog.Attr {
+	return slog.Any(key, text(value))
+}
+
+func replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if t, ok := a.Value.Any().(text); ok && a.Value.Kind() == slog.KindAny {
+		if settings.redactMessages.Load() {
+			return slog.String(a.Key, fmt.Sprintf("[%d bytes]", len(t)))
+		}
+		a.Value = slog.StringValue(string(t))
+	}
+
+	if settings.redactTokens.Load() {
+		switch a.Value.Kind() {
+		case slog.KindString:
+			a.Value = slog.StringValue(redactTokens(a.Value.String()))
+		case slog.KindAny:
+			if err, ok := a.Value.Any().(error); ok {
+				a.Value = slog.StringValue(redactTokens(err.Error()))
+			}
+		}
+	}
+
+	return a
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose log lines carry the ID, e.g. of the
+// update being handled.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID set by WithRequestID, empty if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random ID for a job not started by an update, e.g. a
+// cache refresh.
+func NewRequestID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b[:])
+}
+
+// For returns the logger of the component, e.g. notion or processor.
+func For(component string) *slog.Logger {
+	return slog.New(rootHandler{}).With("component", component)
+}
+
+// Fatal logs the error and exits, it replaces log.Fatalf for the lines
+// logged before the bot starts.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// rootHandler passes the records to the current output, adding the request
+// ID from the context. The attributes and groups added with With are
+// replayed on the output, since it may be replaced by Apply.
+type rootHandler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h rootHandler) current() slog.Handler {
+	output := *settings.output.Load()
+	for _, op := range h.ops {
+		output = op(output)
+	}
+
+	return output
+}
+
+func (h rootHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= settings.level.Level()
+}
+
+func (h rootHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.current().Handle(ctx, r)
+}
+
+func (h rootHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(output slog.Handler) slog.Handler { return output.WithAttrs(attrs) })
+}
+
+func (h rootHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(output slog.Handler) slog.Handler { return output.WithGroup(name) })
+}
+
+func (h rootHandler) with(op func(slog.Handler) slog.Handler) rootHandler {
+	ops := make([]func(slog.Handler) slog.Handler, 0, len(h.ops)+1)
+	ops = append(ops, h.ops...)
+
+	return rootHandler{ops: append(ops, op)}
+}
+
+// StdLogger returns a log.Logger writing to the component logger at the
+// level, for the dependencies taking one.
+func StdLogger(logger *slog.Logger, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(logger.Handler(), level)
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capture makes the loggers write to the returned buffer with the config.
+func capture(t *testing.T, c Config) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	out = buf
+	Apply(c)
+	t.Cleanup(func() {
+		out = os.Stderr
+		Apply(DefaultConfig())
+	})
+
+	return buf
+}
+
+func TestRedaction(t *testing.T) {
+	c := DefaultConfig()
+	c.Format = FormatJSON
+	buf := capture(t, c)
+	AddSecrets("webhook-secret-value")
+
+	logger := For("test")
+	logger.Info("Sending",
+		"url", "https://api.telegram.org/bot123456789:AAH-abcdefghijklmnopqrstuvwxyz_0123/sendMessage",
+		"error", errors.New("bad token secret_abcdefghijklmnopqrstuvwxyz"),
+		"header", "webhook-secret-value",
+		Text("text", "private message"),
+	)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "https://api.telegram.org/bot[REDACTED]/sendMessage", line["url"])
+	assert.Equal(t, "bad token [REDACTED]", line["error"])
+	assert.Equal(t, "[REDACTED]", line["header"])
+	assert.Equal(t, "[15 bytes]", line["text"])
+	assert.Equal(t, "test", line["component"])
+
+	buf.Reset()
+	c.RedactTokens, c.RedactMessages = false, false
+	Apply(c)
+	logger.Info("Sending", "header", "webhook-secret-value", Text("text", "private message"))
+
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "webhook-secret-value", line["header"])
+	assert.Equal(t, "private message", line["text"])
+}
+
+func TestRequestID(t *testing.T) {
+	buf := capture(t, DefaultConfig())
+
+	ctx := WithRequestID(context.Background(), "u42")
+	assert.Equal(t, "u42", RequestID(ctx))
+	assert.Empty(t, RequestID(context.Background()))
+
+	For("processor").With("cache", "tasks").InfoContext(ctx, "Handled")
+	assert.Contains(t, buf.String(), "component=processor cache=tasks request_id=u42")
+
+	assert.NotEqual(t, NewRequestID(), NewRequestID())
+}
+
+func TestApplyChangesExistingLoggers(t *testing.T) {
+	buf := capture(t, DefaultConfig())
+	logger := For("test")
+
+	logger.Debug("hidden")
+	assert.Empty(t, buf.String())
+
+	c := DefaultConfig()
+	c.Level = "debug"
+	c.Format = FormatJSON
+	Apply(c)
+
+	logger.Debug("shown")
+	assert.Contains(t, buf.String(), `"msg":"shown"`)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultConfig().Validate())
+
+	c := DefaultConfig()
+	c.Level = "verbose"
+	assert.ErrorContains(t, c.Validate(), "unknown level")
+
+	c = DefaultConfig()
+	c.Format = "xml"
+	assert.ErrorContains(t, c.Validate(), "unknown format")
+}
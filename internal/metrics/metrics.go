@@ -0,0 +1,355 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text format. It covers the little the bot needs, so that the
+// Prometheus client with its dependencies is not vendored.
+//
+// Metrics are declared as package variables of the packages they describe
+// and registered in Default, see NewCounterVec.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds, suitable for the
+// latency of network requests.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry holds metric families by name. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default is the registry metrics are declared in and served from.
+var Default = NewRegistry()
+
+// register returns the family with the name, creating it if needed. Packages
+// describing the same thing, e.g. both caches, may declare the same family,
+// but the declarations have to match.
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.families[f.name]; ok {
+		if existing.typ != f.typ || !equal(existing.labels, f.labels) {
+			panic(fmt.Sprintf("metric %s is declared twice with different types or labels", f.name))
+		}
+		return existing
+	}
+	r.families[f.name] = f
+
+	return f
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WriteText writes all metrics in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	buf := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		// the client is gone if writing fails, there is nobody to report to
+		_ = r.WriteText(w)
+	})
+}
+
+type family struct {
+	name, help string
+	typ        metricType
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+
+	value float64
+	// fn computes the value of a gauge when the metrics are written.
+	fn func() float64
+
+	// bucketCounts are the non-cumulative counts of a histogram.
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+// get returns the series with the label values, creating it if needed. It
+// must be called with mu locked.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf(
+			"metric %s has %d labels, got %d values", f.name, len(f.labels), len(labelValues),
+		))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.typ == typeHistogram {
+			s.bucketCounts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func (f *family) update(labelValues []string, change func(*series)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	change(f.get(labelValues))
+}
+
+// read passes the series with the label values to read, a series never
+// updated is not created and read is not called then.
+func (f *family) read(labelValues []string, read func(*series)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+		read(s)
+	}
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != typeHistogram {
+			value := s.value
+			if s.fn != nil {
+				value = s.fn()
+			}
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(s, "", ""), formatFloat(value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n",
+				f.name, f.labelPairs(s, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelPairs(s, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelPairs(s, "", ""), s.count)
+	}
+}
+
+// labelPairs formats the labels of the series adding the extra one if given.
+func (f *family) labelPairs(s *series, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(f.labels)+1)
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(s.labelValues[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+// NewCounterVec declares a counter in Default.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(newFamily(name, help, typeCounter, labels, nil))}
+}
+
+// Inc adds one to the counter with the label values, given in the order of
+// the labels.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter, v must not be negative.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("counter cannot decrease")
+	}
+	c.f.update(labelValues, func(s *series) { s.value += v })
+}
+
+// Value returns the counter with the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	var value float64
+	c.f.read(labelValues, func(s *series) { value = s.value })
+
+	return value
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// NewGaugeVec declares a gauge in Default.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(newFamily(name, help, typeGauge, labels, nil))}
+}
+
+// Set sets the gauge with the label values.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value, s.fn = v, nil })
+}
+
+// SetFunc makes the gauge computed by fn whenever the metrics are written,
+// e.g. for the age of something.
+func (g *GaugeVec) SetFunc(fn func() float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.fn = fn })
+}
+
+// Value returns the gauge with the label values.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	var fn func() float64
+	var value float64
+	g.f.read(labelValues, func(s *series) { value, fn = s.value, s.fn })
+	if fn != nil {
+		return fn()
+	}
+
+	return value
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// NewHistogramVec declares a histogram in Default, buckets are the upper
+// bounds in increasing order.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewHistogramVec(
+	name, help string, buckets []float64, labels ...string,
+) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of metric %s are not sorted", name))
+	}
+
+	return &HistogramVec{f: r.register(newFamily(name, help, typeHistogram, labels, buckets))}
+}
+
+// Observe adds the value to the histogram with the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	bucket := sort.SearchFloat64s(h.f.buckets, v)
+	h.f.update(labelValues, func(s *series) {
+		s.bucketCounts[bucket]++
+		s.sum += v
+		s.count++
+	})
+}
+
+// Count returns the number of values observed by the histogram with the label
+// values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	var count uint64
+	h.f.read(labelValues, func(s *series) { count = s.count })
+
+	return count
+}
+
+func newFamily(name, help string, typ metricType, labels []string, buckets []float64) *family {
+	return &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounterVec("requests_total", "Requests by status.", "op", "status")
+	requests.Inc("query", "200")
+	requests.Add(2, "query", "200")
+	requests.Inc("update", `"quoted"`)
+
+	age := r.NewGaugeVec("age_seconds", "Age.\nIn seconds.", "cache")
+	age.Set(5, "tasks")
+	age.SetFunc(func() float64 { return 7.5 }, "tracks")
+
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.1)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	var text strings.Builder
+	require.NoError(t, r.WriteText(&text))
+	assert.Equal(t, `# HELP age_seconds Age.\nIn seconds.
+# TYPE age_seconds gauge
+age_seconds{cache="tasks"} 5
+age_seconds{cache="tracks"} 7.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.6
+latency_seconds_count 3
+# HELP requests_total Requests by status.
+# TYPE requests_total counter
+requests_total{op="query",status="200"} 3
+requests_total{op="update",status="\"quoted\""} 1
+`, text.String())
+
+	assert.Equal(t, 3.0, requests.Value("query", "200"))
+	assert.Zero(t, requests.Value("query", "500"))
+	assert.Equal(t, 7.5, age.Value("tracks"))
+	assert.Equal(t, uint64(3), latency.Count())
+}
+
+func TestRegisterSameFamily(t *testing.T) {
+	r := NewRegistry()
+
+	a := r.NewCounterVec("refreshes_total", "Refreshes.", "cache")
+	b := r.NewCounterVec("refreshes_total", "Refreshes.", "cache")
+	a.Inc("tasks")
+	assert.Equal(t, 1.0, b.Value("tasks"))
+
+	assert.Panics(t, func() { r.NewGaugeVec("refreshes_total", "Refreshes.", "cache") })
+	assert.Panics(t, func() { a.Inc() })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("pings_total", "Pings.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, rec.Body.String(), "pings_total 1\n")
+}
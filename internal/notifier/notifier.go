@@ -0,0 +1,209 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/taskscache"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var logger = logging.For("notifier")
+
+var (
+	notificationsTotal = metrics.NewCounterVec(
+		"notifications_total",
+		"Notifications about changes of tasks by event type and result, sent, error or dropped.",
+		"event", "result",
+	)
+	telegramSendErrors = metrics.NewCounterVec(
+		"telegram_send_errors_total",
+		"Failed requests sending messages to Telegram by the component sending them.",
+		"component",
+	)
+)
+
+// queueSize is the max number of batches of events waiting to be sent, the
+// batches coming when the queue is full are dropped.
+const queueSize = 100
+
+type telegramBot interface {
+	Send(tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// Notifier announces the changes of the tasks noticed by the tasks cache,
+// either in a chat or in direct messages to the assignees of the task.
+type Notifier struct {
+	tg    telegramBot
+	users *users.UserResolver
+
+	chatID         int64
+	directMessages bool
+	events         map[taskscache.EventType]bool
+
+	queue chan []taskscache.Event
+}
+
+// NewNotifier returns a notifier posting to the chat. No event is announced
+// until enabled by SetEvents.
+func NewNotifier(
+	tg *tgbotapi.BotAPI, resolver *users.UserResolver, chatID int64,
+) *Notifier {
+	return &Notifier{
+		tg:     tg,
+		users:  resolver,
+		chatID: chatID,
+		events: make(map[taskscache.EventType]bool),
+		queue:  make(chan []taskscache.Event, queueSize),
+	}
+}
+
+// SetEvents sets the types of the events announced.
+func (n *Notifier) SetEvents(events []taskscache.EventType) {
+	n.events = make(map[taskscache.EventType]bool, len(events))
+	for _, e := range events {
+		n.events[e] = true
+	}
+}
+
+// SetDirectMessages makes the events sent to the affected assignees instead
+// of the chat. An assignee is messaged only once they have written to the
+// bot, before that their Telegram chat is unknown.
+func (n *Notifier) SetDirectMessages(enabled bool) {
+	n.directMessages = enabled
+}
+
+// Notify queues the enabled events to be sent by Run, it does not block. It
+// is a taskscache.EventHandler.
+func (n *Notifier) Notify(ctx context.Context, events []taskscache.Event) {
+	enabled := make([]taskscache.Event, 0, len(events))
+	for _, e := range events {
+		if n.events[e.Type] {
+			enabled = append(enabled, e)
+		}
+	}
+	if len(enabled) == 0 {
+		return
+	}
+
+	select {
+	case n.queue <- enabled:
+	default:
+		logger.WarnContext(ctx, "Notification queue is full, dropping events", "count", len(enabled))
+		for _, e := range enabled {
+			notificationsTotal.Inc(string(e.Type), "dropped")
+		}
+	}
+}
+
+// Run sends the queued events until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-n.queue:
+			for _, e := range events {
+				n.send(ctx, e)
+			}
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, e taskscache.Event) {
+	text := formatEvent(e)
+	if !n.directMessages {
+		if mention := n.mention(affected(e)); mention != "" {
+			text += "\n" + mention
+		}
+		n.sendMessage(ctx, e, n.chatID, text)
+		return
+	}
+
+	for _, a := range affected(e) {
+		link, ok := n.users.LinkedTo(a.ID)
+		if !ok || link.TelegramID == 0 {
+			logger.DebugContext(ctx, "Telegram chat of the assignee is unknown, not notifying them",
+				"notion_user_id", a.ID, "task", e.Task.Link,
+			)
+			continue
+		}
+		n.sendMessage(ctx, e, link.TelegramID, text)
+	}
+}
+
+func (n *Notifier) sendMessage(ctx context.Context, e taskscache.Event, chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+
+	if _, err := n.tg.Send(msg); err != nil {
+		logger.ErrorContext(ctx, "Could not send notification",
+			"event", e.Type, "task", e.Task.Link, "error", err,
+		)
+		telegramSendErrors.Inc("notifier")
+		notificationsTotal.Inc(string(e.Type), "error")
+		return
+	}
+
+	notificationsTotal.Inc(string(e.Type), "sent")
+}
+
+// mention returns the Telegram usernames of the assignees linked to one.
+func (n *Notifier) mention(assignees []notion.Assignee) string {
+	names := make([]string, 0, len(assignees))
+	for _, a := range assignees {
+		if name := n.users.NotionToTg(a.ID); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// affected returns the assignees concerned by the event, the added ones for
+// EventAssigneeAdded and all of them otherwise.
+func affected(e taskscache.Event) []notion.Assignee {
+	if e.Type == taskscache.EventAssigneeAdded {
+		return e.Assignees
+	}
+
+	return e.Task.Assignees
+}
+
+func formatEvent(e taskscache.Event) string {
+	task := fmt.Sprintf("<a href=\"%s\">%s</a>", e.Task.Link, html.EscapeString(e.Task.Title))
+
+	switch e.Type {
+	case taskscache.EventCreated:
+		return "New task: " + task
+	case taskscache.EventAssigneeAdded:
+		names := make([]string, 0, len(e.Assignees))
+		for _, a := range e.Assignees {
+			names = append(names, html.EscapeString(a.Name))
+		}
+		return fmt.Sprintf("%s assigned to %s", strings.Join(names, ", "), task)
+	case taskscache.EventStatusChanged:
+		return fmt.Sprintf("%s: status %s → %s", task,
+			html.EscapeString(e.OldStatus), html.EscapeString(e.Task.Status),
+		)
+	case taskscache.EventDeadline:
+		deadline := e.Task.Deadline.Format("2006-01-02")
+		if e.OldDeadline.IsZero() {
+			return fmt.Sprintf("%s: deadline set to %s", task, deadline)
+		}
+		return fmt.Sprintf("%s: deadline moved from %s to %s",
+			task, e.OldDeadline.Format("2006-01-02"), deadline,
+		)
+	case taskscache.EventDone:
+		return task + " is done"
+	default:
+		return fmt.Sprintf("%s: %s", task, e.Type)
+	}
+}
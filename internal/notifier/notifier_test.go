@@ -0,0 +1,101 @@
+package notifier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/taskscache"
+	"github.com/gibsn/telegram_to_notion/internal/telegramtest"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const chatID = -100
+
+var (
+	alice = notion.Assignee{Name: "Alice", ID: "user-1"}
+	bob   = notion.Assignee{Name: "Bob", ID: "user-2"}
+)
+
+func newTestNotifier(t *testing.T) (*Notifier, *telegramtest.Server) {
+	t.Helper()
+
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+	bot, err := tg.NewBotAPI()
+	require.NoError(t, err)
+
+	resolver := users.NewUserResolver()
+	resolver.SetDeclaredLinks([]users.UserLink{
+		{TelegramName: "alice", NotionID: alice.ID},
+		{TelegramName: "bob", NotionID: bob.ID},
+	})
+	require.NoError(t, resolver.Seen(1, "alice"))
+
+	// the chats are known to the server once someone has written there
+	from := &tgbotapi.User{ID: 1, UserName: "alice"}
+	for _, chat := range []*tgbotapi.Chat{{ID: chatID, Type: "group"}, {ID: 1, Type: "private"}} {
+		_, err := tg.SendMessage(tgbotapi.Message{From: from, Chat: chat, Text: "hi"})
+		require.NoError(t, err)
+	}
+
+	return NewNotifier(bot, resolver, chatID), tg
+}
+
+func testTask() notion.Task {
+	return notion.Task{
+		Title:     "Fix <the> mixer",
+		Link:      "https://www.notion.so/task",
+		Assignees: []notion.Assignee{alice, bob},
+		Status:    "In work",
+		Deadline:  time.Date(2030, 1, 3, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotifyChat(t *testing.T) {
+	n, tg := newTestNotifier(t)
+	n.SetEvents([]taskscache.EventType{taskscache.EventAssigneeAdded, taskscache.EventDeadline})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go n.Run(ctx)
+
+	task := testTask()
+	n.Notify(ctx, []taskscache.Event{
+		{Type: taskscache.EventStatusChanged, Task: task, OldStatus: "New"},
+		{Type: taskscache.EventAssigneeAdded, Task: task, Assignees: []notion.Assignee{bob}},
+		{
+			Type: taskscache.EventDeadline, Task: task,
+			OldDeadline: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+	})
+
+	sent, err := tg.WaitSent(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+
+	link := `<a href="https://www.notion.so/task">Fix &lt;the&gt; mixer</a>`
+	assert.Equal(t, int64(chatID), sent[0].Chat.ID)
+	assert.Equal(t, "Bob assigned to "+link+"\n@bob", sent[0].Text)
+	assert.Equal(t, link+": deadline moved from 2030-01-02 to 2030-01-03\n@alice, @bob",
+		sent[1].Text)
+}
+
+func TestNotifyDirectMessages(t *testing.T) {
+	n, tg := newTestNotifier(t)
+	n.SetDirectMessages(true)
+
+	// only Alice has written to the bot, Bob's chat is unknown
+	n.send(context.Background(), taskscache.Event{Type: taskscache.EventDone, Task: testTask()})
+
+	sent := tg.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, int64(1), sent[0].Chat.ID)
+	assert.Equal(t, `<a href="https://www.notion.so/task">Fix &lt;the&gt; mixer</a> is done`,
+		sent[0].Text)
+}
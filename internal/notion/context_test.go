@@ -0,0 +1,57 @@
+package notion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSetStatusContext_CancelWhileWaitingForRetry(t *testing.T) {
+	var calls int
+	server := newStatusSequenceServer([]int{http.StatusInternalServerError}, nil, &calls)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err := n.SetStatusContext(ctx, &SetStatusRequest{
+		TaskLink: "https://www.notion.so/Task-1234567890abcdef1234567890abcdef",
+		Status:   StatusDone,
+	})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected the retry wait to be aborted, took %s", elapsed)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 request, got %d", calls)
+	}
+}
+
+func TestLoadTasksContext_CancelledBeforeStart(t *testing.T) {
+	var calls int
+	server := newStatusSequenceServer(nil, nil, &calls)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := n.LoadTasksContext(ctx, "db"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancelled error, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no requests, got %d", calls)
+	}
+}
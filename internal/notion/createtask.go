@@ -1,80 +1,57 @@
 package notion
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-	"strings"
+	"context"
 	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 type createPayload struct {
 	Parent struct {
 		DatabaseID string `json:"database_id"`
 	} `json:"parent"`
-	Properties map[string]interface{}   `json:"properties"`
-	Children   []map[string]interface{} `json:"children,omitempty"`
+	Properties property.Properties `json:"properties"`
+	Children   []block             `json:"children,omitempty"`
+}
+
+type block struct {
+	Object    string     `json:"object"`
+	Type      string     `json:"type"`
+	Paragraph *paragraph `json:"paragraph,omitempty"`
+}
+
+type paragraph struct {
+	RichText []property.RichText `json:"rich_text"`
 }
 
-type createResult struct {
-	ID string `json:"id"`
+func newParagraph(text string) block {
+	return block{
+		Object:    "block",
+		Type:      "paragraph",
+		Paragraph: &paragraph{RichText: property.RichTextOf(text)},
+	}
 }
 
-func newCreatePayload(createRequest *CreateTaskRequest) *createPayload {
+func newCreatePayload(s *TasksSchema, createRequest *CreateTaskRequest) *createPayload {
 	payload := &createPayload{}
 	payload.Parent.DatabaseID = createRequest.NotionDBID
 
-	payload.Properties = map[string]interface{}{
-		"Задача": map[string]interface{}{
-			"title": []map[string]interface{}{
-				{"text": map[string]string{"content": createRequest.TaskName}},
-			},
-		},
-		"_timeWhenMovedToWork": map[string]interface{}{
-			"date": map[string]string{
-				"start": time.Now().Format(time.RFC3339),
-			},
-		},
-		"Статус": map[string]interface{}{
-			"select": map[string]string{
-				"name": StatusNew,
-			},
-		},
+	props := s.Properties
+	payload.Properties = property.Properties{
+		props.Title.Name:       property.NewTitle(createRequest.TaskName),
+		props.MovedToWork.Name: property.NewDateTime(time.Now()),
+		props.Status.Name: property.NewOption(
+			property.Type(props.Status.Type), s.Statuses.New,
+		),
 	}
 
 	if len(createRequest.Assignees) > 0 {
-		assigneesPayload := make([]map[string]string, len(createRequest.Assignees))
-		for i, id := range createRequest.Assignees {
-			assigneesPayload[i] = map[string]string{
-				"object": "user",
-				"id":     id,
-			}
-		}
-
-		payload.Properties["Исполнитель"] = map[string]interface{}{
-			"people": assigneesPayload,
-		}
+		payload.Properties[props.Assignees.Name] = property.NewPeople(createRequest.Assignees...)
 	}
 
 	if createRequest.Description != "" {
-		children := []map[string]interface{}{
-			{
-				"object": "block",
-				"type":   "paragraph",
-				"paragraph": map[string]interface{}{
-					"rich_text": []map[string]interface{}{
-						{
-							"type": "text",
-							"text": map[string]string{
-								"content": createRequest.Description,
-							},
-						},
-					},
-				},
-			},
-		}
-		payload.Children = children
+		payload.Children = []block{newParagraph(createRequest.Description)}
 	}
 
 	return payload
@@ -87,7 +64,9 @@ type CreateTaskRequest struct {
 	Assignees   []string
 	Description string
 
-	Debug bool
+	// IdempotencyKey is set when the task is created, a request sent again
+	// with the key creates at most one task.
+	IdempotencyKey string
 }
 
 func NewCreateTaskRequest() *CreateTaskRequest {
@@ -95,38 +74,13 @@ func NewCreateTaskRequest() *CreateTaskRequest {
 }
 
 func (n *Notion) CreateNotionTask(r *CreateTaskRequest) (string, error) {
-	payload := newCreatePayload(r)
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", fmt.Errorf("could not marshal request: %w", err)
-	}
-
-	if r.Debug {
-		prettyPayload, _ := json.MarshalIndent(payload, "", "  ") //nolint:errcheck
-		log.Println(string(prettyPayload))
-		log.Println(n.apiBaseURL + "pages")
-	}
-
-	req, err := http.NewRequest("POST", n.apiBaseURL+"pages", nil)
-	if err != nil {
-		return "", fmt.Errorf("could not create a request: %w", err)
-	}
-
-	resp, err := n.doWithRetries(req, body)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var result createResult
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	cleanID := strings.ReplaceAll(result.ID, "-", "")
-	url := notionURL + cleanID
+	return n.CreateNotionTaskContext(context.Background(), r)
+}
 
-	return url, nil
+// CreateNotionTaskContext creates a task and returns a link to it. The context
+// bounds the whole operation including retries.
+func (n *Notion) CreateNotionTaskContext(
+	ctx context.Context, r *CreateTaskRequest,
+) (string, error) {
+	return n.createPage(ctx, newCreatePayload(&n.schema.Tasks, r), &r.IdempotencyKey)
 }
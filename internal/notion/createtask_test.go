@@ -8,6 +8,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 const (
@@ -65,60 +67,32 @@ func TestCreateNotionTask(t *testing.T) {
 					t.Errorf("expected database ID db-id-123, got %s", payload.Parent.DatabaseID)
 				}
 
-				taskNameField, ok := payload.Properties["Задача"].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected Задача property to be map")
-				}
-				titleArray, ok := taskNameField["title"].([]interface{})
-				if !ok || len(titleArray) == 0 {
-					t.Fatalf("expected title array with at least one element")
-				}
-				titleObj, ok := titleArray[0].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected title object to be map")
-				}
-				textObj, ok := titleObj["text"].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected text object to be map")
-				}
-				if textObj["content"] != "Test Task" {
-					t.Errorf("expected task name 'Test Task', got %v", textObj["content"])
+				if title := payload.Properties.PlainText("Задача"); title != "Test Task" {
+					t.Errorf("expected task name 'Test Task', got %v", title)
 				}
 
-				statusField, ok := payload.Properties["Статус"].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected Статус property to be map")
-				}
-				selectField, ok := statusField["select"].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected select field to be map")
+				statusField := payload.Properties["Статус"]
+				if statusField.Type != property.TypeSelect {
+					t.Fatalf("expected Статус to be select, got %q", statusField.Type)
 				}
-				if selectField["name"] != StatusNew {
-					t.Errorf("expected status '%s', got %v", StatusNew, selectField["name"])
+				if statusField.OptionName() != StatusNew {
+					t.Errorf("expected status '%s', got %v", StatusNew, statusField.OptionName())
 				}
 
-				assigneeField, ok := payload.Properties["Исполнитель"].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected Исполнитель property to be map")
-				}
-				peopleArray, ok := assigneeField["people"].([]interface{})
-				if !ok || len(peopleArray) != 1 {
-					t.Fatalf("expected people array with 1 element, got %d", len(peopleArray))
-				}
-				personObj, ok := peopleArray[0].(map[string]interface{})
-				if !ok {
-					t.Fatalf("expected person object to be map")
+				people := payload.Properties["Исполнитель"].People
+				if len(people) != 1 {
+					t.Fatalf("expected people array with 1 element, got %d", len(people))
 				}
-				if personObj["id"] != "user-id-1" {
-					t.Errorf("expected assignee ID 'user-id-1', got %v", personObj["id"])
+				if people[0].ID != "user-id-1" {
+					t.Errorf("expected assignee ID 'user-id-1', got %v", people[0].ID)
 				}
 
 				if len(payload.Children) != 1 {
 					t.Fatalf("expected 1 child, got %d", len(payload.Children))
 				}
 				child := payload.Children[0]
-				if child["type"] != "paragraph" {
-					t.Errorf("expected child type 'paragraph', got %v", child["type"])
+				if child.Type != "paragraph" {
+					t.Errorf("expected child type 'paragraph', got %v", child.Type)
 				}
 			},
 		},
@@ -1,50 +1,20 @@
 package notion
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"net/http"
-	"path"
 	"strings"
 	"time"
-)
 
-type loadPayload struct {
-	Filter map[string]interface{} `json:"filter"`
-}
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
 
 type Assignee struct {
 	Name string `json:"name"`
 	ID   string `json:"id"`
 }
 
-type loadResultProperty map[string]struct {
-	Title []struct {
-		PlainText string `json:"plain_text"`
-	} `json:"title,omitempty"`
-	People []Assignee `json:"People,omitempty"`
-	Date   struct {
-		Start string `json:"start"`
-	} `json:"Date,omitempty"`
-	Select *struct {
-		Name string `json:"name"`
-	} `json:"select,omitempty"`
-	Status *struct {
-		Name string `json:"name"`
-	} `json:"status,omitempty"`
-}
-
-type loadResultEntry struct {
-	ID         string             `json:"id"`
-	Properties loadResultProperty `json:"properties"`
-}
-
-type loadResult struct {
-	Results []loadResultEntry `json:"results"`
-}
-
 type Task struct {
 	Title     string
 	Assignees []Assignee
@@ -53,19 +23,50 @@ type Task struct {
 	Link      string
 }
 
-func createTasksFilter() []map[string]interface{} {
-	andFilters := make([]map[string]interface{}, 0, 3)
-	for _, status := range []string{StatusBacklog, StatusDone, StatusArchived} {
+// TaskState holds the properties of a task the bot changes, so that they can
+// be recorded before a change.
+type TaskState struct {
+	Status string
+	// Deadline is the date as stored in Notion, nil if it is not set.
+	Deadline *property.Date
+}
+
+// LoadTaskStateContext loads the status and the deadline of the task.
+func (n *Notion) LoadTaskStateContext(ctx context.Context, taskLink string) (TaskState, error) {
+	pageID := PageIDFromLink(taskLink)
+	if pageID == "" {
+		return TaskState{}, fmt.Errorf("invalid task link %s", taskLink)
+	}
+
+	result, err := n.getPage(ctx, pageID)
+	if err != nil {
+		return TaskState{}, fmt.Errorf("could not load task %s: %w", pageID, err)
+	}
+
+	names := n.schema.Tasks.Properties
+
+	return TaskState{
+		Status:   result.Properties[names.Status.Name].OptionName(),
+		Deadline: result.Properties[names.Deadline.Name].Date,
+	}, nil
+}
+
+func createTasksFilter(s *TasksSchema) []map[string]interface{} {
+	statusProp := s.Properties.Status
+	inactive := []string{s.Statuses.Backlog, s.Statuses.Done, s.Statuses.Archived}
+
+	andFilters := make([]map[string]interface{}, 0, len(inactive)+1)
+	for _, status := range inactive {
 		andFilters = append(andFilters, map[string]interface{}{
-			"property": "Статус",
-			"select": map[string]string{
+			"property": statusProp.Name,
+			statusProp.Type: map[string]string{
 				"does_not_equal": status,
 			},
 		})
 	}
 	andFilters = append(andFilters, map[string]interface{}{
-		"property": "Статус",
-		"select": map[string]bool{
+		"property": statusProp.Name,
+		statusProp.Type: map[string]bool{
 			"is_not_empty": true,
 		},
 	})
@@ -73,45 +74,32 @@ func createTasksFilter() []map[string]interface{} {
 	return andFilters
 }
 
-func parseTask(result loadResultEntry) (Task, error) {
-	titleField, ok := result.Properties["Задача"]
-	if !ok || len(titleField.Title) == 0 {
+func parseTask(s *TasksSchema, result page) (Task, error) {
+	taskName := result.Properties.PlainText(s.Properties.Title.Name)
+	if taskName == "" {
 		return Task{}, fmt.Errorf("missing title")
 	}
 
-	taskName := titleField.Title[0].PlainText
-
-	assigneeField, ok := result.Properties["Исполнитель"]
-	if !ok || len(assigneeField.People) == 0 {
+	people := result.Properties[s.Properties.Assignees.Name].People
+	if len(people) == 0 {
 		return Task{}, fmt.Errorf("missing assignees")
 	}
 
-	assignees := assigneeField.People
-
-	loc := time.Now().Location()
-
-	var (
-		deadline time.Time
-		err      error
-	)
-
-	if dateField, ok := result.Properties["Дедлайн"]; ok && len(dateField.Date.Start) > 0 {
-		deadline, err = time.ParseInLocation("2006-01-02", dateField.Date.Start, loc)
-		if err != nil {
-			log.Printf("Invalid deadline '%s': %v", deadline, err)
-		}
+	assignees := make([]Assignee, 0, len(people))
+	for _, person := range people {
+		assignees = append(assignees, Assignee{Name: person.Name, ID: person.ID})
 	}
 
-	var status string
-	if statusField, ok := result.Properties["Статус"]; ok {
-		switch {
-		case statusField.Select != nil:
-			status = statusField.Select.Name
-		case statusField.Status != nil:
-			status = statusField.Status.Name
+	var deadline time.Time
+	if date := result.Properties[s.Properties.Deadline.Name].Date; date != nil {
+		var err error
+		if deadline, err = date.StartTime(); err != nil {
+			logger.Warn("Invalid deadline", "page_id", result.ID, "deadline", date.Start, "error", err)
 		}
 	}
 
+	status := result.Properties[s.Properties.Status.Name].OptionName()
+
 	taskURL := notionURL + strings.ReplaceAll(result.ID, "-", "")
 
 	return Task{
@@ -124,69 +112,98 @@ func parseTask(result loadResultEntry) (Task, error) {
 }
 
 func (n *Notion) LoadTasks(dbID string) ([]Task, error) {
-	var payload loadPayload
-	payload.Filter = map[string]interface{}{
-		"and": createTasksFilter(),
-	}
+	return n.LoadTasksContext(context.Background(), dbID)
+}
 
-	body, err := json.Marshal(payload)
+// LoadTasksContext loads all active tasks from the database.
+func (n *Notion) LoadTasksContext(ctx context.Context, dbID string) ([]Task, error) {
+	results, err := queryAll[page](ctx, n, dbID, map[string]interface{}{
+		"and": createTasksFilter(&n.schema.Tasks),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal json payload: %w", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequest(
-		"POST", n.apiBaseURL+path.Join("databases", dbID, "query"),
-		bytes.NewBuffer(body),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not create a request: %w", err)
-	}
+	tasks := make([]Task, 0, len(results))
 
-	req.Header.Set("Authorization", "Bearer "+n.token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Notion-Version", "2022-06-28")
+	for _, task := range results {
+		taskParsed, err := parseTask(&n.schema.Tasks, task)
+		if err != nil {
+			logger.WarnContext(ctx, "Skipping invalid task", "page_id", task.ID, "error", err)
+			continue
+		}
+		logger.DebugContext(ctx, "Loaded task",
+			logging.Text("title", taskParsed.Title),
+			"assignees", len(taskParsed.Assignees),
+			"status", taskParsed.Status,
+			"deadline", taskParsed.Deadline,
+			"url", taskParsed.Link,
+		)
 
-	resp, err := n.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request to Notion API failed: %w", err)
+		tasks = append(tasks, taskParsed)
 	}
 
-	defer resp.Body.Close()
+	return tasks, nil
+}
+
+// TaskChanges are the tasks edited since some time.
+type TaskChanges struct {
+	// Active are the edited tasks that are active.
+	Active []Task
+	// Inactive are the links of the edited tasks that are not active anymore
+	// or not valid.
+	Inactive []string
+	// Done are the links of the inactive tasks that are done.
+	Done []string
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("status code is %d", resp.StatusCode)
+// isActiveTask tells whether the status makes a task active, see
+// createTasksFilter.
+func isActiveTask(s *TasksSchema, status string) bool {
+	switch status {
+	case "", s.Statuses.Backlog, s.Statuses.Done, s.Statuses.Archived:
+		return false
+	default:
+		return true
 	}
+}
 
-	var result loadResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+// LoadTasksEditedSinceContext loads the tasks edited on or after since,
+// whatever their status. Archived pages are not returned by Notion, so a
+// task removed since is only noticed by a full load.
+func (n *Notion) LoadTasksEditedSinceContext(
+	ctx context.Context, dbID string, since time.Time,
+) (TaskChanges, error) {
+	results, err := queryAll[page](ctx, n, dbID, map[string]interface{}{
+		"timestamp": "last_edited_time",
+		"last_edited_time": map[string]string{
+			"on_or_after": since.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return TaskChanges{}, err
 	}
 
-	tasks := make([]Task, 0, len(result.Results))
+	s := &n.schema.Tasks
 
-	for _, task := range result.Results {
-		taskParsed, err := parseTask(task)
+	var changes TaskChanges
+	for _, result := range results {
+		taskParsed, err := parseTask(s, result)
 		if err != nil {
-			log.Printf(
-				"Could not load task (id: %s): invalid response fron Notion API: %v",
-				task.ID, err,
-			)
+			logger.DebugContext(ctx, "Edited task is not valid", "page_id", result.ID, "error", err)
+			changes.Inactive = append(changes.Inactive, notionURL+strings.ReplaceAll(result.ID, "-", ""))
 			continue
 		}
-		if n.debug {
-			fmt.Printf(
-				"Task: %s\nAssignees: %s\nStatus: %s\nDeadline: %s\nURL: %s\n---",
-				taskParsed.Title,
-				taskParsed.Assignees,
-				taskParsed.Status,
-				taskParsed.Deadline,
-				taskParsed.Link,
-			)
+		if !isActiveTask(s, taskParsed.Status) {
+			changes.Inactive = append(changes.Inactive, taskParsed.Link)
+			if taskParsed.Status == s.Statuses.Done {
+				changes.Done = append(changes.Done, taskParsed.Link)
+			}
+			continue
 		}
 
-		tasks = append(tasks, taskParsed)
+		changes.Active = append(changes.Active, taskParsed)
 	}
 
-	return tasks, nil
+	return changes, nil
 }
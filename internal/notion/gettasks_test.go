@@ -1,6 +1,7 @@
 package notion
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 const (
@@ -43,12 +46,12 @@ func TestLoadTasks(t *testing.T) {
 								},
 							},
 							"Исполнитель": map[string]interface{}{
-								"People": []map[string]interface{}{
+								"people": []map[string]interface{}{
 									{"name": "Alice", "id": "user-1"},
 								},
 							},
 							"Дедлайн": map[string]interface{}{
-								"Date": map[string]interface{}{
+								"date": map[string]interface{}{
 									"start": "2025-12-31",
 								},
 							},
@@ -81,7 +84,7 @@ func TestLoadTasks(t *testing.T) {
 					t.Errorf("expected Notion-Version header, got %s", req.Header.Get("Notion-Version"))
 				}
 
-				var payload loadPayload
+				var payload queryPayload
 				bodyBytes, _ := io.ReadAll(req.Body) //nolint:errcheck
 				if err := json.Unmarshal(bodyBytes, &payload); err != nil {
 					t.Fatalf("failed to decode request body: %v", err)
@@ -109,7 +112,7 @@ func TestLoadTasks(t *testing.T) {
 								},
 							},
 							"Исполнитель": map[string]interface{}{
-								"People": []map[string]interface{}{
+								"people": []map[string]interface{}{
 									{"name": "Alice", "id": "user-1"},
 								},
 							},
@@ -124,7 +127,7 @@ func TestLoadTasks(t *testing.T) {
 								},
 							},
 							"Исполнитель": map[string]interface{}{
-								"People": []map[string]interface{}{
+								"people": []map[string]interface{}{
 									{"name": "Bob", "id": "user-2"},
 								},
 							},
@@ -160,7 +163,7 @@ func TestLoadTasks(t *testing.T) {
 								},
 							},
 							"Исполнитель": map[string]interface{}{
-								"People": []map[string]interface{}{
+								"people": []map[string]interface{}{
 									{"name": "Alice", "id": "user-1"},
 								},
 							},
@@ -271,12 +274,12 @@ func TestParseTask_DeadlineParsing(t *testing.T) {
 								},
 							},
 							"Исполнитель": map[string]interface{}{
-								"People": []map[string]interface{}{
+								"people": []map[string]interface{}{
 									{"name": "Alice", "id": "user-1"},
 								},
 							},
 							"Дедлайн": map[string]interface{}{
-								"Date": map[string]interface{}{
+								"date": map[string]interface{}{
 									"start": tt.deadlineStart,
 								},
 							},
@@ -314,36 +317,30 @@ func TestParseTask_DeadlineParsing(t *testing.T) {
 }
 
 func TestParseTask(t *testing.T) {
+	people := func(users ...property.User) property.Value {
+		return property.Value{Type: property.TypePeople, People: users}
+	}
+	date := func(start string) property.Value {
+		return property.Value{Type: property.TypeDate, Date: &property.Date{Start: start}}
+	}
+	alice := property.User{Name: "Alice", ID: "uuid-alice"}
+
 	tests := []struct {
 		name       string
-		input      loadResultEntry
+		input      page
 		expected   Task
 		wantErr    bool
 		errMessage string
 	}{
 		{
 			name: "valid task with one assignee",
-			input: loadResultEntry{
+			input: page{
 				ID: "abc-def",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Single Assignee Task"}},
-					},
-					"Исполнитель": {
-						People: []Assignee{{Name: "Alice", ID: "uuid-alice"}},
-					},
-					"Дедлайн": {
-						Date: struct {
-							Start string `json:"start"`
-						}{Start: "2025-12-31"},
-					},
-					"Статус": {
-						Select: &struct {
-							Name string `json:"name"`
-						}{Name: StatusNew},
-					},
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Single Assignee Task"),
+					"Исполнитель": people(alice),
+					"Дедлайн":     date("2025-12-31"),
+					"Статус":      property.NewSelect(StatusNew),
 				},
 			},
 			expected: Task{
@@ -356,22 +353,12 @@ func TestParseTask(t *testing.T) {
 		},
 		{
 			name: "valid task with status property",
-			input: loadResultEntry{
+			input: page{
 				ID: "status-id",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Status Task"}},
-					},
-					"Исполнитель": {
-						People: []Assignee{{Name: "Alice", ID: "uuid-alice"}},
-					},
-					"Статус": {
-						Status: &struct {
-							Name string `json:"name"`
-						}{Name: "В работе"},
-					},
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Status Task"),
+					"Исполнитель": people(alice),
+					"Статус":      property.NewStatus("В работе"),
 				},
 			},
 			expected: Task{
@@ -383,25 +370,12 @@ func TestParseTask(t *testing.T) {
 		},
 		{
 			name: "valid task with multiple assignees",
-			input: loadResultEntry{
+			input: page{
 				ID: "abc-xyz",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Multiple Assignees Task"}},
-					},
-					"Исполнитель": {
-						People: []Assignee{
-							{Name: "Alice", ID: "uuid-alice"},
-							{Name: "Bob", ID: "uuid-bob"},
-						},
-					},
-					"Дедлайн": {
-						Date: struct {
-							Start string `json:"start"`
-						}{Start: "2025-11-30"},
-					},
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Multiple Assignees Task"),
+					"Исполнитель": people(alice, property.User{Name: "Bob", ID: "uuid-bob"}),
+					"Дедлайн":     date("2025-11-30"),
 				},
 			},
 			expected: Task{
@@ -414,22 +388,35 @@ func TestParseTask(t *testing.T) {
 				Link:     "https://www.notion.so/abcxyz",
 			},
 		},
+		{
+			name: "deadline with time",
+			input: page{
+				ID: "id321",
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Timed task"),
+					"Исполнитель": people(alice),
+					"Дедлайн":     date("2025-11-30T18:00:00.000+03:00"),
+				},
+			},
+			expected: Task{
+				Title:     "Timed task",
+				Assignees: []Assignee{{Name: "Alice", ID: "uuid-alice"}},
+				Deadline:  time.Date(2025, 11, 30, 15, 0, 0, 0, time.UTC),
+				Link:      "https://www.notion.so/id321",
+			},
+		},
 		{
 			name:       "missing title field",
-			input:      loadResultEntry{ID: "id123", Properties: loadResultProperty{}},
+			input:      page{ID: "id123", Properties: property.Properties{}},
 			wantErr:    true,
 			errMessage: "missing title",
 		},
 		{
 			name: "missing assignee field",
-			input: loadResultEntry{
+			input: page{
 				ID: "id999",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Task Without Assignee"}},
-					},
+				Properties: property.Properties{
+					"Задача": property.NewTitle("Task Without Assignee"),
 				},
 			},
 			wantErr:    true,
@@ -437,22 +424,12 @@ func TestParseTask(t *testing.T) {
 		},
 		{
 			name: "invalid date format",
-			input: loadResultEntry{
+			input: page{
 				ID: "id456",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Some task"}},
-					},
-					"Исполнитель": {
-						People: []Assignee{{Name: "Alice", ID: "uuid-alice"}},
-					},
-					"Дедлайн": {
-						Date: struct {
-							Start string `json:"start"`
-						}{Start: "not-a-date"},
-					},
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Some task"),
+					"Исполнитель": people(alice),
+					"Дедлайн":     date("not-a-date"),
 				},
 			},
 			expected: Task{
@@ -464,22 +441,12 @@ func TestParseTask(t *testing.T) {
 		},
 		{
 			name: "incorrectly formatted date MM-DD-YYYY",
-			input: loadResultEntry{
+			input: page{
 				ID: "id789",
-				Properties: loadResultProperty{
-					"Задача": {
-						Title: []struct {
-							PlainText string `json:"plain_text"`
-						}{{PlainText: "Another task"}},
-					},
-					"Исполнитель": {
-						People: []Assignee{{Name: "Alice", ID: "uuid-alice"}},
-					},
-					"Дедлайн": {
-						Date: struct {
-							Start string `json:"start"`
-						}{Start: "01-12-2025"},
-					},
+				Properties: property.Properties{
+					"Задача":      property.NewTitle("Another task"),
+					"Исполнитель": people(alice),
+					"Дедлайн":     date("01-12-2025"),
 				},
 			},
 			expected: Task{
@@ -493,7 +460,7 @@ func TestParseTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			task, err := parseTask(tt.input)
+			task, err := parseTask(&DefaultSchema().Tasks, tt.input)
 
 			if tt.wantErr {
 				if err == nil {
@@ -534,3 +501,118 @@ func mustParse(s string) time.Time {
 
 	return tm
 }
+
+func TestLoadTaskState(t *testing.T) {
+	names := DefaultSchema().Tasks.Properties
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/pages/12345678-9012-3456-7890-123456789012" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "12345678-9012-3456-7890-123456789012",
+			"properties": map[string]interface{}{
+				names.Status.Name: map[string]interface{}{
+					"type": "status", "status": map[string]string{"name": StatusNew},
+				},
+				names.Deadline.Name: map[string]interface{}{
+					"type": "date", "date": map[string]string{
+						"start": "2030-01-02T10:00:00", "time_zone": "Europe/Moscow",
+					},
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	state, err := n.LoadTaskStateContext(
+		context.Background(), "https://www.notion.so/12345678901234567890123456789012",
+	)
+	if err != nil {
+		t.Fatalf("LoadTaskStateContext returned error: %v", err)
+	}
+	timeZone := "Europe/Moscow"
+	want := TaskState{
+		Status:   StatusNew,
+		Deadline: &property.Date{Start: "2030-01-02T10:00:00", TimeZone: &timeZone},
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	if _, err := n.LoadTaskStateContext(context.Background(), "not a link"); err == nil {
+		t.Error("expected an error for an invalid link")
+	}
+}
+
+func TestLoadTasksEditedSince(t *testing.T) {
+	task := func(id, status string, assignees ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id": id,
+			"properties": map[string]interface{}{
+				"Задача": map[string]interface{}{
+					"title": []map[string]interface{}{{"plain_text": "Task " + id}},
+				},
+				"Исполнитель": map[string]interface{}{"people": assignees},
+				"Статус": map[string]interface{}{
+					"select": map[string]interface{}{"name": status},
+				},
+			},
+		}
+	}
+	alice := map[string]interface{}{"name": "Alice", "id": "user-1"}
+
+	var filter string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Filter json.RawMessage `json:"filter"`
+		}
+		//nolint:errcheck
+		json.NewDecoder(r.Body).Decode(&body)
+		filter = string(body.Filter)
+
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []map[string]interface{}{
+				task("11111111-1111-1111-1111-111111111111", StatusNew, alice),
+				task("22222222-2222-2222-2222-222222222222", StatusDone, alice),
+				task("33333333-3333-3333-3333-333333333333", StatusNew),
+			},
+		})
+	}))
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	since := time.Date(2030, 1, 2, 3, 4, 0, 0, time.FixedZone("", 3*60*60))
+	changes, err := n.LoadTasksEditedSinceContext(context.Background(), "db", since)
+	if err != nil {
+		t.Fatalf("LoadTasksEditedSinceContext returned error: %v", err)
+	}
+
+	wantFilter := `{"last_edited_time":{"on_or_after":"2030-01-02T00:04:00Z"},` +
+		`"timestamp":"last_edited_time"}`
+	if filter != wantFilter {
+		t.Errorf("expected filter %s, got %s", wantFilter, filter)
+	}
+
+	if len(changes.Active) != 1 ||
+		changes.Active[0].Link != "https://www.notion.so/11111111111111111111111111111111" {
+		t.Errorf("expected the first task to be active, got %+v", changes.Active)
+	}
+	wantInactive := []string{
+		"https://www.notion.so/22222222222222222222222222222222",
+		"https://www.notion.so/33333333333333333333333333333333",
+	}
+	if !reflect.DeepEqual(changes.Inactive, wantInactive) {
+		t.Errorf("expected inactive %v, got %v", wantInactive, changes.Inactive)
+	}
+	if !reflect.DeepEqual(changes.Done, wantInactive[:1]) {
+		t.Errorf("expected done %v, got %v", wantInactive[:1], changes.Done)
+	}
+}
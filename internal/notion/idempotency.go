@@ -0,0 +1,145 @@
+package notion
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+// SetIdempotencyKeyProperty sets the name of a hidden rich_text property that
+// stores a key generated by the bot for every page it creates. It lets the bot
+// find out whether a create request that failed on the client side has in
+// fact succeeded on Notion side before sending it again. The property must be
+// added to the tasks and tweaks databases first, Notion rejects pages with
+// unknown properties. An empty name, the default, disables the mechanism.
+func (n *Notion) SetIdempotencyKeyProperty(name string) {
+	n.idempotencyKeyProperty = name
+}
+
+// idempotencyKeyPropertyOf returns the idempotency key property of the
+// database, empty if the database has none.
+func (n *Notion) idempotencyKeyPropertyOf(dbID string) string {
+	if n.noIdempotencyKey[dbID] {
+		return ""
+	}
+
+	return n.idempotencyKeyProperty
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// createPage creates a page and returns a link to it. When the idempotency
+// key property is configured, a retry is only sent after a query confirms
+// that the previous attempt has not created the page. Otherwise a failure
+// that may have created the page is not retried, a retry could duplicate it.
+//
+// key is the idempotency key of the page. An empty key is generated and set,
+// a key set by the caller means the request is sent again, e.g. after Notion
+// has been unavailable, so it is only sent if no page has the key yet.
+func (n *Notion) createPage(
+	ctx context.Context, payload *createPayload, key *string,
+) (string, error) {
+	dbID := payload.Parent.DatabaseID
+	keyProperty := n.idempotencyKeyPropertyOf(dbID)
+
+	if keyProperty != "" {
+		if *key != "" {
+			existingID, err := n.findPageByIdempotencyKey(ctx, dbID, *key)
+			if err != nil {
+				return "", err
+			}
+			if existingID != "" {
+				logger.InfoContext(ctx, "Page has been created already", "page_id", existingID)
+				return pageLink(existingID), nil
+			}
+		} else {
+			generated, err := newIdempotencyKey()
+			if err != nil {
+				return "", fmt.Errorf("could not generate idempotency key: %w", err)
+			}
+			*key = generated
+		}
+
+		payload.Properties[keyProperty] = property.NewRichText(*key)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal request: %w", err)
+	}
+
+	logger.DebugContext(ctx, "Creating page",
+		"database_id", dbID, logging.Text("payload", string(body)),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.apiBaseURL+"pages", nil)
+	if err != nil {
+		return "", fmt.Errorf("could not create a request: %w", err)
+	}
+
+	var (
+		existingID string
+		check      retryCheck
+	)
+	if keyProperty != "" {
+		check = func() (bool, error) {
+			existingID, err = n.findPageByIdempotencyKey(ctx, dbID, *key)
+			return existingID != "", err
+		}
+	}
+
+	resp, err := n.doWithRetriesCheck(req, body, keyProperty != "", check)
+	if errors.Is(err, errAlreadyApplied) {
+		return pageLink(existingID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result page
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return pageLink(result.ID), nil
+}
+
+func (n *Notion) findPageByIdempotencyKey(
+	ctx context.Context, dbID, key string,
+) (string, error) {
+	results, err := queryAll[page](ctx, n, dbID, map[string]interface{}{
+		"property": n.idempotencyKeyProperty,
+		"rich_text": map[string]string{
+			"equals": key,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not look up page by idempotency key: %w", err)
+	}
+
+	if len(results) == 0 {
+		return "", nil
+	}
+
+	return results[0].ID, nil
+}
+
+func pageLink(pageID string) string {
+	return notionURL + strings.ReplaceAll(pageID, "-", "")
+}
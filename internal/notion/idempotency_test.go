@@ -0,0 +1,268 @@
+package notion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testIdempotencyKeyProperty = "_idempotencyKey"
+
+// fakePagesServer stores created pages by their idempotency key and answers
+// queries filtering by that key. Creation requests listed in slowCreates are
+// applied but answered only after the client has given up waiting, and those
+// listed in failedCreates are rejected with 500 without being applied.
+type fakePagesServer struct {
+	t *testing.T
+
+	mu            sync.Mutex
+	creates       int
+	queries       int
+	keys          []string
+	pagesByKey    map[string]string
+	slowCreates   map[int]bool
+	failedCreates map[int]bool
+	release       chan struct{}
+}
+
+func newFakePagesServer(t *testing.T) *fakePagesServer {
+	return &fakePagesServer{
+		t:             t,
+		pagesByKey:    map[string]string{},
+		slowCreates:   map[int]bool{},
+		failedCreates: map[int]bool{},
+		release:       make(chan struct{}),
+	}
+}
+
+func (s *fakePagesServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var payload map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		s.t.Errorf("failed to decode request body: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/pages"):
+		s.handleCreate(w, payload)
+	case strings.HasSuffix(req.URL.Path, "/query"):
+		s.handleQuery(w, payload)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *fakePagesServer) handleCreate(w http.ResponseWriter, payload map[string]interface{}) {
+	s.mu.Lock()
+	s.creates++
+	attempt := s.creates
+
+	props, _ := payload["properties"].(map[string]interface{})
+	keyProp, _ := props[testIdempotencyKeyProperty].(map[string]interface{})
+	key := ""
+	if richText, ok := keyProp["rich_text"].([]interface{}); ok && len(richText) > 0 {
+		text := richText[0].(map[string]interface{})["text"].(map[string]interface{})
+		key = text["content"].(string)
+	}
+	s.keys = append(s.keys, key)
+
+	if s.failedCreates[attempt] {
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pageID := "11111111-2222-3333-4444-55555555555" + string(rune('0'+attempt))
+	s.pagesByKey[key] = pageID
+	s.mu.Unlock()
+
+	if s.slowCreates[attempt] {
+		<-s.release
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"id": pageID}) //nolint:errcheck
+}
+
+func (s *fakePagesServer) handleQuery(w http.ResponseWriter, payload map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries++
+
+	filter := payload["filter"].(map[string]interface{})
+	if filter["property"] != testIdempotencyKeyProperty {
+		s.t.Errorf("unexpected query filter: %v", filter)
+	}
+	key := filter["rich_text"].(map[string]interface{})["equals"].(string)
+
+	results := []map[string]string{}
+	if pageID, ok := s.pagesByKey[key]; ok {
+		results = append(results, map[string]string{"id": pageID})
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": results}) //nolint:errcheck
+}
+
+func newIdempotencyTestNotion(serverURL string) *Notion {
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(serverURL + "/")
+	n.SetTweaksDBIDs("demo-db-id", "mix-db-id")
+	n.SetIdempotencyKeyProperty(testIdempotencyKeyProperty)
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	n.client.Timeout = 100 * time.Millisecond
+
+	return n
+}
+
+func TestCreatePage_ServerSucceededClientTimedOut(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(*Notion) (string, error)
+	}{
+		{
+			name: "task",
+			create: func(n *Notion) (string, error) {
+				return n.CreateNotionTask(&CreateTaskRequest{NotionDBID: "tasks-db", TaskName: "Task"})
+			},
+		},
+		{
+			name: "tweak",
+			create: func(n *Notion) (string, error) {
+				return n.CreateTweakMix(&CreateTweakRequest{Title: "Tweak"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakePagesServer(t)
+			fake.slowCreates[1] = true
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			defer close(fake.release)
+
+			n := newIdempotencyTestNotion(server.URL)
+
+			url, err := tt.create(n)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+
+			if fake.creates != 1 {
+				t.Fatalf("expected exactly one create request, got %d", fake.creates)
+			}
+			if fake.queries != 1 {
+				t.Fatalf("expected one lookup query, got %d", fake.queries)
+			}
+			if want := notionURL + "11111111222233334444555555555551"; url != want {
+				t.Fatalf("expected link to the page created by the first attempt %s, got %s", want, url)
+			}
+		})
+	}
+}
+
+func TestCreatePage_RetriesWithSameKeyWhenPageWasNotCreated(t *testing.T) {
+	fake := newFakePagesServer(t)
+	fake.failedCreates[1] = true
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newIdempotencyTestNotion(server.URL)
+
+	url, err := n.CreateNotionTask(&CreateTaskRequest{NotionDBID: "tasks-db", TaskName: "Task"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fake.creates != 2 || fake.queries != 1 {
+		t.Fatalf("expected 2 creates and 1 query, got %d and %d", fake.creates, fake.queries)
+	}
+	if fake.keys[0] == "" || fake.keys[0] != fake.keys[1] {
+		t.Fatalf("expected both attempts to carry the same key, got %q", fake.keys)
+	}
+	if want := notionURL + "11111111222233334444555555555552"; url != want {
+		t.Fatalf("expected %s, got %s", want, url)
+	}
+}
+
+func TestCreatePage_KeysDifferBetweenPages(t *testing.T) {
+	fake := newFakePagesServer(t)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newIdempotencyTestNotion(server.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := n.CreateNotionTask(&CreateTaskRequest{NotionDBID: "db", TaskName: "T"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if fake.queries != 0 {
+		t.Fatalf("expected no lookups for successful creates, got %d", fake.queries)
+	}
+	if len(fake.keys) != 2 || fake.keys[0] == fake.keys[1] {
+		t.Fatalf("expected two distinct keys, got %q", fake.keys)
+	}
+}
+
+func TestCreatePage_IdempotencyCanBeDisabled(t *testing.T) {
+	fake := newFakePagesServer(t)
+	fake.failedCreates[1] = true
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newIdempotencyTestNotion(server.URL)
+	n.SetIdempotencyKeyProperty("")
+
+	// without a key it is unknown whether the failed create has been applied,
+	// so it is not retried
+	_, err := n.CreateNotionTask(&CreateTaskRequest{NotionDBID: "db", TaskName: "T"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if fake.creates != 1 || fake.queries != 0 {
+		t.Fatalf("expected 1 create and no queries, got %d and %d", fake.creates, fake.queries)
+	}
+	if fake.keys[0] != "" {
+		t.Fatalf("expected no idempotency key, got %q", fake.keys[0])
+	}
+}
+
+func TestCreatePage_ResentRequestCreatesOnePage(t *testing.T) {
+	fake := newFakePagesServer(t)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	n := newIdempotencyTestNotion(server.URL)
+
+	req := &CreateTaskRequest{NotionDBID: "db", TaskName: "T"}
+	first, err := n.CreateNotionTask(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.IdempotencyKey == "" || req.IdempotencyKey != fake.keys[0] {
+		t.Fatalf("expected the key sent to be set in the request, got %q", req.IdempotencyKey)
+	}
+
+	second, err := n.CreateNotionTask(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fake.creates != 1 || fake.queries != 1 {
+		t.Fatalf("expected 1 create and 1 query, got %d and %d", fake.creates, fake.queries)
+	}
+	if first != second {
+		t.Fatalf("expected the link to the same page, got %s and %s", first, second)
+	}
+}
@@ -0,0 +1,64 @@
+package notion
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec(
+		"notion_requests_total",
+		"Attempts of requests to Notion API by operation and HTTP status, "+
+			"the status is error if no response was received.",
+		"operation", "status",
+	)
+	requestDuration = metrics.NewHistogramVec(
+		"notion_request_duration_seconds",
+		"Latency of attempts of requests to Notion API by operation and HTTP status.",
+		metrics.DefBuckets,
+		"operation", "status",
+	)
+	retriesTotal = metrics.NewCounterVec(
+		"notion_retries_total", "Retries of requests to Notion API by operation.", "operation",
+	)
+)
+
+func observeRequest(operation string, resp *http.Response, latency time.Duration) {
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	requestsTotal.Inc(operation, status)
+	requestDuration.Observe(latency.Seconds(), operation, status)
+}
+
+// operation names the request for metrics, e.g. query_database or
+// update_page, so that the IDs in the path do not end up in the labels.
+func (n *Notion) operation(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.String(), n.apiBaseURL)
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	resource := strings.TrimSuffix(parts[0], "s")
+	switch {
+	case resource == "":
+		return "other"
+	case parts[len(parts)-1] == "query":
+		return "query_" + resource
+	case req.Method == http.MethodGet && len(parts) == 1:
+		return "list_" + parts[0]
+	case req.Method == http.MethodGet:
+		return "get_" + resource
+	case req.Method == http.MethodPost:
+		return "create_" + resource
+	case req.Method == http.MethodPatch:
+		return "update_" + resource
+	default:
+		return "other"
+	}
+}
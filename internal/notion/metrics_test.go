@@ -0,0 +1,63 @@
+package notion
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	var (
+		calls  int
+		sleeps []time.Duration
+	)
+	server := newStatusSequenceServer([]int{http.StatusBadGateway}, nil, &calls)
+	defer server.Close()
+
+	n := newRetryTestNotion(server.URL, &sleeps)
+
+	failed := requestsTotal.Value("create_page", "502")
+	succeeded := requestsTotal.Value("create_page", "200")
+	retries := retriesTotal.Value("create_page")
+
+	if err := doTestRequest(t, n); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	if got := requestsTotal.Value("create_page", "502") - failed; got != 1 {
+		t.Errorf("expected 1 failed attempt, got %v", got)
+	}
+	if got := requestsTotal.Value("create_page", "200") - succeeded; got != 1 {
+		t.Errorf("expected 1 successful attempt, got %v", got)
+	}
+	if got := retriesTotal.Value("create_page") - retries; got != 1 {
+		t.Errorf("expected 1 retry, got %v", got)
+	}
+	if requestDuration.Count("create_page", "200") == 0 {
+		t.Errorf("expected the latency to be observed")
+	}
+}
+
+func TestOperation(t *testing.T) {
+	n := NewNotion("test-token")
+
+	for _, tt := range []struct {
+		method, path, expected string
+	}{
+		{http.MethodPost, "databases/db-id/query", "query_database"},
+		{http.MethodGet, "databases/db-id", "get_database"},
+		{http.MethodPost, "pages", "create_page"},
+		{http.MethodPatch, "pages/page-id", "update_page"},
+		{http.MethodGet, "users?page_size=100", "list_users"},
+		{http.MethodDelete, "blocks/block-id", "other"},
+	} {
+		req, err := http.NewRequest(tt.method, notionAPI+tt.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+
+		if got := n.operation(req); got != tt.expected {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.path, tt.expected, got)
+		}
+	}
+}
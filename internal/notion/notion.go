@@ -2,16 +2,19 @@ package notion
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
 )
 
-type Notion struct {
-	debug bool
+var logger = logging.For("notion")
 
+type Notion struct {
 	token string
 
 	client *http.Client
@@ -20,11 +23,30 @@ type Notion struct {
 
 	tweaksDemoDBID string
 	tweaksMixDBID  string
+
+	queryPageSize int
+	queryMaxPages int
+
+	idempotencyKeyProperty string
+	// noIdempotencyKey holds the databases lacking idempotencyKeyProperty,
+	// see ValidateSchemaContext.
+	noIdempotencyKey map[string]bool
+
+	schema *Schema
+
+	retryPolicy RetryPolicy
+	limiter     rateLimiter
+	now         func() time.Time
+	sleep       func(context.Context, time.Duration) error
+}
+
+// rateLimiter throttles outgoing requests, see ratelimit.Limiter.
+type rateLimiter interface {
+	Wait(ctx context.Context) error
 }
 
 const (
 	timeoutNotionAPI = 10 * time.Second
-	numberOfRetries  = 2
 )
 
 const (
@@ -32,6 +54,7 @@ const (
 	notionAPI = "https://api.notion.com/v1/"
 )
 
+// Task statuses used by DefaultSchema.
 const (
 	StatusNew      = "новая"
 	StatusBacklog  = "бэклог"
@@ -46,6 +69,14 @@ func NewNotion(token string) *Notion {
 		client: &http.Client{
 			Timeout: timeoutNotionAPI,
 		},
+		queryPageSize: defaultQueryPageSize,
+		queryMaxPages: defaultQueryMaxPages,
+
+		schema: DefaultSchema(),
+
+		retryPolicy: DefaultRetryPolicy(),
+		now:         time.Now,
+		sleep:       sleepContext,
 	}
 
 	return n
@@ -56,63 +87,192 @@ func (n *Notion) SetAPIBaseURL(url string) {
 	n.apiBaseURL = url
 }
 
+// SetHTTPTimeout sets the timeout of a single attempt of a request.
+func (n *Notion) SetHTTPTimeout(timeout time.Duration) {
+	n.client.Timeout = timeout
+}
+
+// SetRetryPolicy replaces the default retry policy.
+func (n *Notion) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	n.retryPolicy = policy
+}
+
+// SetRateLimiter makes every attempt of every request wait for the limiter.
+// The same limiter should be shared by everything that uses the integration
+// token, so that together they stay under the Notion rate limit.
+func (n *Notion) SetRateLimiter(limiter rateLimiter) {
+	n.limiter = limiter
+}
+
+// doWithRetries sends the request according to the retry policy. Network
+// errors and retryable statuses are retried with a jittered exponential
+// backoff, for 429 and 503 the delay requested by Retry-After is honoured.
 func (n *Notion) doWithRetries(req *http.Request, body []byte) (*http.Response, error) {
-	var (
-		resp *http.Response
-		err  error
-	)
+	return n.doWithRetriesCheck(req, body, true, nil)
+}
+
+// retryCheck is called before a request is retried after a failure that
+// leaves it unknown whether Notion has applied the request, an ambiguous one. It reports
+// whether the request turned out to be applied, in which case it is not
+// retried and errAlreadyApplied is returned instead.
+type retryCheck func() (applied bool, err error)
+
+var errAlreadyApplied = errors.New("request has already been applied")
+
+// ErrUnavailable is matched by the errors of requests that failed because
+// Notion did not answer or answered with a retryable status until the retries
+// ran out, as opposed to rejecting the request. Such a request may succeed if
+// sent again later.
+var ErrUnavailable = errors.New("notion is unavailable")
+
+type unavailableError struct {
+	err error
+}
+
+func (e unavailableError) Error() string        { return e.err.Error() }
+func (e unavailableError) Unwrap() error        { return e.err }
+func (e unavailableError) Is(target error) bool { return target == ErrUnavailable }
 
+// doWithRetriesCheck is doWithRetries for the requests that must not be
+// applied twice. Ambiguous failures are retried only if retryAmbiguous is set,
+// after check if it is not nil.
+func (n *Notion) doWithRetriesCheck(
+	req *http.Request, body []byte, retryAmbiguous bool, check retryCheck,
+) (*http.Response, error) {
 	req.Header.Set("Authorization", "Bearer "+n.token)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Notion-Version", "2022-06-28")
 
-	for i := 1; i <= numberOfRetries; i++ {
-		req.Body = io.NopCloser(bytes.NewReader(body))
-		req.ContentLength = int64(len(body))
+	ctx := req.Context()
+	policy := n.retryPolicy
+	started := n.now()
+	operation := n.operation(req)
 
-		resp, err = n.client.Do(req)
+	var (
+		lastErr     error
+		unavailable bool
+	)
 
-		if err != nil || resp.StatusCode >= 300 {
-			if err != nil {
-				log.Printf("request to Notion API failed: %s", err)
-			}
-			if resp != nil {
-				if resp.StatusCode == 400 {
-					bodyBytes, err := io.ReadAll(resp.Body)
-					if err != nil {
-						log.Printf(
-							"request to Notion API failed: failed to read response body: %s", err,
-						)
-					}
-
-					log.Printf(
-						"request to Notion API failed with status code 400: %s", string(bodyBytes),
-					)
-				}
-				resp.Body.Close()
+	for attempt := 1; ; attempt++ {
+		if n.limiter != nil {
+			if err := n.limiter.Wait(ctx); err != nil {
+				return nil, fmt.Errorf("request to Notion API failed: %w", err)
 			}
+		}
 
-			if i < numberOfRetries {
-				log.Printf("retrying request to Notion API")
-				continue
-			}
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
 
-			if err == nil {
-				err = fmt.Errorf("status code is %d", resp.StatusCode)
+		attemptStarted := time.Now()
+		resp, err := n.client.Do(req)
+		observeRequest(operation, resp, time.Since(attemptStarted))
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var (
+			retryable bool
+			ambiguous bool
+			delay     time.Duration
+		)
+
+		if err != nil {
+			logger.WarnContext(ctx, "Request to Notion API failed",
+				"operation", operation, "attempt", attempt, "error", err,
+			)
+			lastErr = err
+			retryable = ctx.Err() == nil
+			ambiguous = true
+			// a request out of its own time is not a sign of Notion being
+			// down
+			unavailable = ctx.Err() == nil
+		} else {
+			logFailedResponse(ctx, operation, attempt, resp)
+			lastErr = fmt.Errorf("status code is %d", resp.StatusCode)
+			retryable = isRetryableStatus(resp.StatusCode)
+			ambiguous = resp.StatusCode >= http.StatusInternalServerError
+			unavailable = retryable
+
+			if resp.StatusCode == http.StatusTooManyRequests ||
+				resp.StatusCode == http.StatusServiceUnavailable {
+				delay, _ = retryAfter(resp, n.now())
 			}
+			resp.Body.Close()
+		}
 
-			return nil, fmt.Errorf("request to Notion API failed: %w", err)
+		if !retryable || attempt >= policy.MaxAttempts {
+			break
+		}
+		if ambiguous && !retryAmbiguous {
+			logger.WarnContext(ctx, "Request to Notion API may have been applied, not retrying",
+				"operation", operation,
+			)
+			break
 		}
 
-		break
+		if delay == 0 {
+			delay = policy.backoff(attempt)
+		}
+		if policy.Budget > 0 && n.now().Sub(started)+delay > policy.Budget {
+			logger.WarnContext(ctx, "Retry budget of request to Notion API is exhausted",
+				"operation", operation, "budget", policy.Budget,
+			)
+			break
+		}
+
+		logger.InfoContext(ctx, "Retrying request to Notion API",
+			"operation", operation, "delay", delay,
+			"attempt", attempt+1, "max_attempts", policy.MaxAttempts,
+		)
+		retriesTotal.Inc(operation)
+		if err := n.sleep(ctx, delay); err != nil {
+			lastErr, unavailable = err, false
+			break
+		}
+
+		if check != nil && ambiguous {
+			applied, err := check()
+			if err != nil {
+				return nil, fmt.Errorf(
+					"request to Notion API failed: %w, could not check if it was applied: %w",
+					lastErr, err,
+				)
+			}
+			if applied {
+				logger.InfoContext(ctx,
+					"Request to Notion API has been applied despite the error, not retrying",
+					"operation", operation,
+				)
+				return nil, errAlreadyApplied
+			}
+		}
+	}
+
+	err := fmt.Errorf("request to Notion API failed: %w", lastErr)
+	if unavailable {
+		return nil, unavailableError{err}
 	}
 
-	return resp, nil
+	return nil, err
 }
 
-func (n *Notion) SetDebug(debug bool) {
-	n.debug = debug
+func logFailedResponse(ctx context.Context, operation string, attempt int, resp *http.Response) {
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.WarnContext(ctx, "Could not read response body of Notion API", "error", err)
+	}
+
+	// the body is an error object, it holds no page content
+	logger.WarnContext(ctx, "Request to Notion API failed",
+		"operation", operation, "attempt", attempt,
+		"status", resp.StatusCode, "body", string(bodyBytes),
+	)
 }
 
 func (n *Notion) SetTweaksDBIDs(demoDBID, mixDBID string) {
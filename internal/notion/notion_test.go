@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -112,9 +113,9 @@ func TestDoWithRetries_ReplaysIdenticalRequest(t *testing.T) { //nolint:gocyclo
 				// Fail first N calls, succeed after
 				if callN <= tt.failFirstN {
 					w.Header().Set("Content-Type", testContentTypeRetries)
-					w.WriteHeader(http.StatusBadRequest)
+					w.WriteHeader(http.StatusServiceUnavailable)
 					_ = json.NewEncoder(w).Encode( //nolint:errcheck
-						map[string]any{"object": "error", "code": "bad_request"},
+						map[string]any{"object": "error", "code": "service_unavailable"},
 					) //nolint:errcheck
 					return
 				}
@@ -128,6 +129,9 @@ func TestDoWithRetries_ReplaysIdenticalRequest(t *testing.T) { //nolint:gocyclo
 			// Client under test
 			notion := NewNotion("test-token")
 			notion.SetAPIBaseURL(server.URL + "/")
+			notion.SetRetryPolicy(RetryPolicy{
+				MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond,
+			})
 
 			req, err := http.NewRequest(
 				tt.method, notion.apiBaseURL+strings.TrimPrefix(tt.path, "/"), nil,
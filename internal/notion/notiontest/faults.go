@@ -0,0 +1,139 @@
+package notiontest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault makes the server fail matching requests.
+type Fault struct {
+	// Method and Path select the requests, empty values match any request.
+	// Path is a prefix of the path relative to the base URL, e.g. "pages" or
+	// "databases/tasks/query".
+	Method string
+	Path   string
+
+	// Times is the number of matching requests the fault affects, zero means
+	// all of them.
+	Times int
+
+	// Status is the status code of the response. When it is zero, the request
+	// is handled normally after Delay.
+	Status int
+	// RetryAfter is sent in the Retry-After header if it is positive.
+	RetryAfter time.Duration
+	// Delay holds the response back, which makes clients with a shorter
+	// timeout give up.
+	Delay time.Duration
+	// Apply makes the server handle the request before failing, as if the
+	// response got lost on its way to the client.
+	Apply bool
+}
+
+type activeFault struct {
+	Fault
+	left int
+}
+
+// InjectFault adds a fault. Faults are checked in the order they were added,
+// a request is affected by the first matching one.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &activeFault{Fault: f, left: f.Times})
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+func (f *Fault) matches(req Request) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, req.Method)) &&
+		strings.HasPrefix(req.Path, f.Path)
+}
+
+func (s *Server) takeFault(req Request) *Fault {
+	for i, f := range s.faults {
+		if !f.matches(req) {
+			continue
+		}
+
+		if f.Times > 0 {
+			f.left--
+			if f.left == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+
+		return &f.Fault
+	}
+
+	return nil
+}
+
+func faultError(status int) *apiError {
+	switch status {
+	case http.StatusTooManyRequests:
+		return &apiError{
+			status, "rate_limited",
+			"You have been rate limited. Please try again in a few minutes.",
+		}
+	case http.StatusConflict:
+		return &apiError{
+			status, "conflict_error", "Conflict occurred while saving. Please try again.",
+		}
+	case http.StatusInternalServerError:
+		return &apiError{status, "internal_server_error", "Unexpected error occurred."}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &apiError{status, "service_unavailable", "Notion is unavailable, try again later."}
+	default:
+		return &apiError{status, "injected_fault", http.StatusText(status)}
+	}
+}
+
+func (s *Server) serveFault(w http.ResponseWriter, r *http.Request, f *Fault) {
+	var applied *httptest.ResponseRecorder
+	if f.Apply {
+		applied = httptest.NewRecorder()
+		s.mux.ServeHTTP(applied, r)
+	}
+
+	if f.Delay > 0 {
+		timer := time.NewTimer(f.Delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		}
+	}
+
+	switch {
+	case f.Status != 0:
+		if f.RetryAfter > 0 {
+			w.Header().Set(
+				"Retry-After", strconv.FormatFloat(f.RetryAfter.Seconds(), 'f', -1, 64),
+			)
+		}
+		writeError(w, faultError(f.Status))
+	case applied != nil:
+		for key, values := range applied.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(applied.Code)
+		w.Write(applied.Body.Bytes()) //nolint:errcheck,gosec // the client is gone
+	default:
+		s.mux.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,255 @@
+package notiontest
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+type predicate func(*Page) bool
+
+// compileFilter turns the filter of a query into a predicate. Only compound
+// and/or filters, property filters and timestamp filters are supported. The
+// conditions of property filters are equals, does_not_equal, contains,
+// does_not_contain, is_empty and is_not_empty, the ones of timestamp filters
+// are after, before, on_or_after and on_or_before.
+func compileFilter(db *database, filter map[string]interface{}) (predicate, *apiError) {
+	if len(filter) == 0 {
+		return func(*Page) bool { return true }, nil
+	}
+
+	for _, op := range []string{"and", "or"} {
+		if raw, ok := filter[op]; ok {
+			return compileCompound(db, op, raw)
+		}
+	}
+
+	if _, ok := filter["timestamp"]; ok {
+		return compileTimestampFilter(filter)
+	}
+
+	return compilePropertyFilter(db, filter)
+}
+
+func compileTimestampFilter(filter map[string]interface{}) (predicate, *apiError) {
+	timestamp, _ := filter["timestamp"].(string)
+
+	var field func(*Page) time.Time
+	switch timestamp {
+	case "created_time":
+		field = func(p *Page) time.Time { return p.CreatedTime }
+	case "last_edited_time":
+		field = func(p *Page) time.Time { return p.LastEditedTime }
+	default:
+		return nil, validationError("body.filter.timestamp should be created_time or last_edited_time.")
+	}
+
+	condition, _ := filter[timestamp].(map[string]interface{})
+	if len(filter) != 2 || len(condition) != 1 {
+		return nil, validationError("body.filter.%s should have a single operator.", timestamp)
+	}
+
+	// condition has exactly one entry
+	var (
+		op  string
+		arg interface{}
+	)
+	for op, arg = range condition {
+	}
+
+	s, _ := arg.(string)
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if at, err = time.Parse("2006-01-02", s); err != nil {
+			return nil, validationError("body.filter.%s.%s should be a date.", timestamp, op)
+		}
+	}
+
+	switch op {
+	case "after":
+		return func(p *Page) bool { return field(p).After(at) }, nil
+	case "before":
+		return func(p *Page) bool { return field(p).Before(at) }, nil
+	case "on_or_after":
+		return func(p *Page) bool { return !field(p).Before(at) }, nil
+	case "on_or_before":
+		return func(p *Page) bool { return !field(p).After(at) }, nil
+	}
+
+	return nil, validationError("%s is not a supported condition for %s", op, timestamp)
+}
+
+func compileCompound(db *database, op string, raw interface{}) (predicate, *apiError) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, validationError("body.filter.%s should be an array.", op)
+	}
+
+	predicates := make([]predicate, 0, len(items))
+	for _, item := range items {
+		filter, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, validationError("body.filter.%s should contain objects.", op)
+		}
+
+		p, apiErr := compileFilter(db, filter)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		predicates = append(predicates, p)
+	}
+
+	if op == "and" {
+		return func(page *Page) bool {
+			for _, p := range predicates {
+				if !p(page) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+
+	return func(page *Page) bool {
+		for _, p := range predicates {
+			if p(page) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// filterTypes returns the filter types applicable to a property type.
+func filterTypes(t property.Type) []string {
+	switch t {
+	case property.TypeTitle, property.TypeURL:
+		return []string{string(t), string(property.TypeRichText)}
+	default:
+		return []string{string(t)}
+	}
+}
+
+func compilePropertyFilter(db *database, filter map[string]interface{}) (predicate, *apiError) {
+	name, _ := filter["property"].(string)
+	column, ok := db.columns[name]
+	if !ok {
+		return nil, validationError("Could not find property with name or id: %s", name)
+	}
+
+	var (
+		filterType string
+		condition  map[string]interface{}
+	)
+	for key, value := range filter {
+		if key == "property" {
+			continue
+		}
+		if filterType != "" {
+			return nil, validationError("body.filter should have a single condition.")
+		}
+		filterType = key
+		condition, _ = value.(map[string]interface{})
+	}
+
+	if !slices.Contains(filterTypes(column.Type), filterType) {
+		return nil, validationError(
+			"database property %s does not match filter %s", column.Type, filterType,
+		)
+	}
+	if len(condition) != 1 {
+		return nil, validationError("body.filter.%s should have a single operator.", filterType)
+	}
+
+	// condition has exactly one entry
+	var (
+		op  string
+		arg interface{}
+	)
+	for op, arg = range condition {
+	}
+
+	return compileCondition(name, column.Type, op, arg)
+}
+
+func compileCondition(
+	name string, t property.Type, op string, arg interface{},
+) (predicate, *apiError) {
+	value := func(p *Page) property.Value {
+		return p.Properties[name]
+	}
+
+	switch op {
+	case "is_empty", "is_not_empty":
+		if arg != true {
+			return nil, validationError("%s should be true.", op)
+		}
+		want := op == "is_empty"
+		return func(p *Page) bool { return isEmpty(value(p)) == want }, nil
+
+	case "equals", "does_not_equal":
+		want := op == "equals"
+		return func(p *Page) bool { return equals(value(p), arg) == want }, nil
+
+	case "contains", "does_not_contain":
+		s, ok := arg.(string)
+		if !ok {
+			return nil, validationError("%s should be a string.", op)
+		}
+		want := op == "contains"
+		return func(p *Page) bool { return contains(value(p), s) == want }, nil
+	}
+
+	return nil, validationError("%s is not a supported condition for %s", op, t)
+}
+
+func isEmpty(v property.Value) bool {
+	switch v.Type {
+	case property.TypeSelect:
+		return v.Select == nil
+	case property.TypeStatus:
+		return v.Status == nil
+	case property.TypePeople:
+		return len(v.People) == 0
+	case property.TypeRelation:
+		return len(v.Relation) == 0
+	case property.TypeDate:
+		return v.Date == nil
+	case property.TypeNumber:
+		return v.Number == nil
+	case property.TypeCheckbox:
+		return false
+	default:
+		return v.PlainText() == ""
+	}
+}
+
+func equals(v property.Value, arg interface{}) bool {
+	switch v.Type {
+	case property.TypeNumber:
+		n, ok := arg.(float64)
+		return ok && v.Number != nil && *v.Number == n
+	case property.TypeCheckbox:
+		b, ok := arg.(bool)
+		return ok && v.Checkbox == b
+	case property.TypeDate:
+		s, ok := arg.(string)
+		return ok && v.Date != nil && v.Date.Start == s
+	default:
+		s, ok := arg.(string)
+		return ok && v.PlainText() == s
+	}
+}
+
+func contains(v property.Value, s string) bool {
+	switch v.Type {
+	case property.TypePeople:
+		return slices.ContainsFunc(v.People, func(u property.User) bool { return u.ID == s })
+	case property.TypeRelation:
+		return slices.Contains(v.RelationIDs(), s)
+	default:
+		return strings.Contains(strings.ToLower(v.PlainText()), strings.ToLower(s))
+	}
+}
@@ -0,0 +1,370 @@
+package notiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+const (
+	maxPageSize = 100
+	timeLayout  = "2006-01-02T15:04:05.000Z"
+)
+
+func (s *Server) newPageID() string {
+	s.lastID++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012x", s.lastID)
+}
+
+// normalize validates a value written to a property and fills in what Notion
+// derives on its side: plain text of rich text and names of people.
+func (s *Server) normalize(db *database, name string, v property.Value) (
+	property.Value, *apiError,
+) {
+	column, ok := db.columns[name]
+	if !ok {
+		return v, validationError("%s is not a property that exists.", name)
+	}
+	if v.Type != column.Type {
+		return v, validationError("%s is expected to be %s.", name, column.Type)
+	}
+
+	switch v.Type {
+	case property.TypeTitle:
+		v.Title = normalizeRichText(v.Title)
+	case property.TypeRichText:
+		v.RichText = normalizeRichText(v.RichText)
+	case property.TypeSelect:
+		if v.Select != nil && !slices.Contains(column.Options, v.Select.Name) {
+			column.Options = append(column.Options, v.Select.Name)
+		}
+	case property.TypeStatus:
+		if v.Status != nil && !slices.Contains(column.Options, v.Status.Name) {
+			return v, validationError(
+				"Invalid status option. Status option %q does not exist for %s.",
+				v.Status.Name, name,
+			)
+		}
+	case property.TypePeople:
+		people := make([]property.User, 0, len(v.People))
+		for _, person := range v.People {
+			person.Object = "user"
+			if userName, ok := s.users[person.ID]; ok {
+				person.Name = userName
+			}
+			people = append(people, person)
+		}
+		v.People = people
+	case property.TypeUniqueID, property.TypeFormula, property.TypeRollup:
+		return v, validationError("%s is a read-only property.", name)
+	}
+
+	return v, nil
+}
+
+func normalizeRichText(parts []property.RichText) []property.RichText {
+	normalized := make([]property.RichText, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "" {
+			part.Type = "text"
+		}
+		part.PlainText = part.String()
+		normalized = append(normalized, part)
+	}
+
+	return normalized
+}
+
+func (s *Server) createPage(dbID string, props property.Properties) (*Page, *apiError) {
+	db, ok := s.databases[dbID]
+	if !ok {
+		return nil, notFound("database", dbID)
+	}
+
+	now := s.now()
+	p := &Page{
+		DatabaseID:     dbID,
+		Properties:     make(property.Properties, len(db.columns)),
+		CreatedTime:    now,
+		LastEditedTime: now,
+	}
+
+	for name, value := range props {
+		value, apiErr := s.normalize(db, name, value)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		p.Properties[name] = value
+	}
+
+	for name, column := range db.columns {
+		if _, ok := p.Properties[name]; !ok {
+			p.Properties[name] = property.Value{Type: column.Type}
+		}
+	}
+
+	p.ID = s.newPageID()
+	s.pages[p.ID] = p
+	s.order = append(s.order, p.ID)
+
+	return p, nil
+}
+
+func encodeValue(name string, v property.Value) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["id"] = name
+	fields["type"] = v.Type
+
+	return json.Marshal(fields)
+}
+
+func encodePage(p *Page) (map[string]interface{}, error) {
+	props := make(map[string]json.RawMessage, len(p.Properties))
+	for name, value := range p.Properties {
+		encoded, err := encodeValue(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode property %s: %w", name, err)
+		}
+		props[name] = encoded
+	}
+
+	return map[string]interface{}{
+		"object":           "page",
+		"id":               p.ID,
+		"created_time":     p.CreatedTime.UTC().Format(timeLayout),
+		"last_edited_time": p.LastEditedTime.UTC().Format(timeLayout),
+		"archived":         p.Archived,
+		"in_trash":         p.Archived,
+		"parent": map[string]string{
+			"type":        "database_id",
+			"database_id": p.DatabaseID,
+		},
+		"url":        "https://www.notion.so/" + strings.ReplaceAll(p.ID, "-", ""),
+		"properties": props,
+	}, nil
+}
+
+func writePage(w http.ResponseWriter, p *Page) {
+	encoded, err := encodePage(p)
+	if err != nil {
+		writeError(w, &apiError{http.StatusInternalServerError, "internal_server_error", err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, encoded)
+}
+
+func (s *Server) handleCreatePage(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Parent struct {
+			DatabaseID string `json:"database_id"`
+		} `json:"parent"`
+		Properties property.Properties `json:"properties"`
+	}
+	if apiErr := decodeBody(r, &payload); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, apiErr := s.createPage(payload.Parent.DatabaseID, payload.Properties)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	writePage(w, p)
+}
+
+func (s *Server) handleGetPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pages[r.PathValue("id")]
+	if !ok {
+		writeError(w, notFound("page", r.PathValue("id")))
+		return
+	}
+
+	writePage(w, p)
+}
+
+func (s *Server) handleUpdatePage(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Properties property.Properties `json:"properties"`
+		Archived   *bool               `json:"archived"`
+		InTrash    *bool               `json:"in_trash"`
+	}
+	if apiErr := decodeBody(r, &payload); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pages[r.PathValue("id")]
+	if !ok {
+		writeError(w, notFound("page", r.PathValue("id")))
+		return
+	}
+
+	archived := p.Archived
+	for _, flag := range []*bool{payload.Archived, payload.InTrash} {
+		if flag != nil {
+			archived = *flag
+		}
+	}
+	if p.Archived && archived {
+		writeError(w, validationError("Can't edit block that is archived."))
+		return
+	}
+
+	db := s.databases[p.DatabaseID]
+	updated := make(property.Properties, len(payload.Properties))
+	for name, value := range payload.Properties {
+		value, apiErr := s.normalize(db, name, value)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		updated[name] = value
+	}
+
+	for name, value := range updated {
+		p.Properties[name] = value
+	}
+	p.Archived = archived
+	p.LastEditedTime = s.now()
+
+	writePage(w, p)
+}
+
+func (s *Server) handleGetDatabase(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, ok := s.databases[r.PathValue("id")]
+	if !ok {
+		writeError(w, notFound("database", r.PathValue("id")))
+		return
+	}
+
+	props := make(map[string]interface{}, len(db.columns))
+	for name, column := range db.columns {
+		config := map[string]interface{}{}
+		if column.Type == property.TypeSelect || column.Type == property.TypeStatus {
+			options := make([]property.Option, 0, len(column.Options))
+			for _, option := range column.Options {
+				options = append(options, property.Option{Name: option})
+			}
+			config["options"] = options
+		}
+
+		props[name] = map[string]interface{}{
+			"id":                name,
+			"name":              name,
+			"type":              column.Type,
+			string(column.Type): config,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":     "database",
+		"id":         db.id,
+		"properties": props,
+	})
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Filter      map[string]interface{} `json:"filter"`
+		StartCursor string                 `json:"start_cursor"`
+		PageSize    int                    `json:"page_size"`
+	}
+	if apiErr := decodeBody(r, &payload); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	switch {
+	case payload.PageSize == 0:
+		payload.PageSize = maxPageSize
+	case payload.PageSize < 0 || payload.PageSize > maxPageSize:
+		writeError(w, validationError("body.page_size should be ≤ %d.", maxPageSize))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, ok := s.databases[r.PathValue("id")]
+	if !ok {
+		writeError(w, notFound("database", r.PathValue("id")))
+		return
+	}
+
+	match, apiErr := compileFilter(db, payload.Filter)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	var matched []*Page
+	for _, id := range s.order {
+		p := s.pages[id]
+		if p.DatabaseID == db.id && !p.Archived && match(p) {
+			matched = append(matched, p)
+		}
+	}
+
+	start := 0
+	if payload.StartCursor != "" {
+		start = slices.IndexFunc(matched, func(p *Page) bool {
+			return p.ID == payload.StartCursor
+		})
+		if start < 0 {
+			writeError(w, validationError("start_cursor provided is invalid."))
+			return
+		}
+	}
+
+	end := min(start+payload.PageSize, len(matched))
+	results := make([]map[string]interface{}, 0, end-start)
+	for _, p := range matched[start:end] {
+		encoded, err := encodePage(p)
+		if err != nil {
+			writeError(w, &apiError{
+				http.StatusInternalServerError, "internal_server_error", err.Error(),
+			})
+			return
+		}
+		results = append(results, encoded)
+	}
+
+	var nextCursor interface{}
+	if end < len(matched) {
+		nextCursor = matched[end].ID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":      "list",
+		"results":     results,
+		"has_more":    nextCursor != nil,
+		"next_cursor": nextCursor,
+	})
+}
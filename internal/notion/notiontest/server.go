@@ -0,0 +1,276 @@
+// Package notiontest provides an in-memory emulator of the part of Notion API
+// used by the bot, to test the Notion client and the commands built on top of
+// it without network access.
+//
+// The emulator keeps databases and pages in memory and validates requests the
+// way Notion does: unknown databases and properties, values of the wrong type
+// and unknown status options are rejected. Faults such as rate limiting,
+// server errors and timeouts can be injected into matching requests.
+package notiontest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+// Column is a property of a database.
+type Column struct {
+	Type property.Type
+	// Options are the options of a select or status property. Writing an
+	// unknown option adds it to a select property and fails for a status one.
+	Options []string
+}
+
+type database struct {
+	id      string
+	columns map[string]*Column
+}
+
+// Page is a page stored by the server.
+type Page struct {
+	ID             string
+	DatabaseID     string
+	Properties     property.Properties
+	Archived       bool
+	CreatedTime    time.Time
+	LastEditedTime time.Time
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	// Path is relative to the base URL, e.g. "pages" or "databases/db/query".
+	Path string
+	Body []byte
+}
+
+// Server is an in-memory Notion API server.
+type Server struct {
+	server *httptest.Server
+	mux    *http.ServeMux
+	closed chan struct{}
+
+	mu        sync.Mutex
+	databases map[string]*database
+	pages     map[string]*Page
+	order     []string
+	users     map[string]string
+	requests  []Request
+	faults    []*activeFault
+	lastID    int
+	now       func() time.Time
+}
+
+// NewServer starts a server with no databases. It must be closed by Close.
+func NewServer() *Server {
+	s := &Server{
+		mux:       http.NewServeMux(),
+		closed:    make(chan struct{}),
+		databases: make(map[string]*database),
+		pages:     make(map[string]*Page),
+		users:     make(map[string]string),
+		now:       time.Now,
+	}
+
+	s.mux.HandleFunc("GET /databases/{id}", s.handleGetDatabase)
+	s.mux.HandleFunc("POST /databases/{id}/query", s.handleQuery)
+	s.mux.HandleFunc("POST /pages", s.handleCreatePage)
+	s.mux.HandleFunc("GET /pages/{id}", s.handleGetPage)
+	s.mux.HandleFunc("PATCH /pages/{id}", s.handleUpdatePage)
+	s.mux.HandleFunc("GET /users", s.handleListUsers)
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// URL returns the base URL to be passed to Notion.SetAPIBaseURL.
+func (s *Server) URL() string {
+	return s.server.URL + "/"
+}
+
+// Close aborts delayed responses and shuts the server down.
+func (s *Server) Close() {
+	close(s.closed)
+	s.server.Close()
+}
+
+// SetNow replaces the clock used for created and last edited times.
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// AddDatabase creates a database with the given properties, replacing an
+// existing one with the same ID together with its pages.
+func (s *Server) AddDatabase(id string, columns map[string]Column) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := &database{id: id, columns: make(map[string]*Column, len(columns))}
+	for name, column := range columns {
+		column.Options = slices.Clone(column.Options)
+		db.columns[name] = &column
+	}
+
+	s.order = slices.DeleteFunc(s.order, func(pageID string) bool {
+		if s.pages[pageID].DatabaseID == id {
+			delete(s.pages, pageID)
+			return true
+		}
+		return false
+	})
+	s.databases[id] = db
+}
+
+// AddUser registers a user of the workspace, so that people values written
+// with the user ID are read back with the name and the user is listed by the
+// users endpoint.
+func (s *Server) AddUser(id, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[id] = name
+}
+
+// AddPage creates a page the same way a create request does and returns its
+// ID. It panics if the page is rejected since it is only meant for setting up
+// tests.
+func (s *Server) AddPage(dbID string, props property.Properties) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, apiErr := s.createPage(dbID, props)
+	if apiErr != nil {
+		panic(fmt.Sprintf("notiontest: could not add page: %s", apiErr.Message))
+	}
+
+	return p.ID
+}
+
+// Page returns a copy of the page with the given ID.
+func (s *Server) Page(id string) (Page, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pages[id]
+	if !ok {
+		return Page{}, false
+	}
+
+	return p.clone(), true
+}
+
+// Pages returns copies of the pages of the database including archived ones
+// in the order they were created.
+func (s *Server) Pages(dbID string) []Page {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pages []Page
+	for _, id := range s.order {
+		if p := s.pages[id]; p.DatabaseID == dbID {
+			pages = append(pages, p.clone())
+		}
+	}
+
+	return pages
+}
+
+// Requests returns the requests received so far, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+func (p *Page) clone() Page {
+	c := *p
+	c.Properties = make(property.Properties, len(p.Properties))
+	for name, value := range p.Properties {
+		c.Properties[name] = value
+	}
+
+	return c
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, "invalid_request", err.Error()})
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if r.Header.Get("Authorization") == "" {
+		writeError(w, &apiError{http.StatusUnauthorized, "unauthorized", "API token is invalid."})
+		return
+	}
+
+	req := Request{Method: r.Method, Path: strings.TrimPrefix(r.URL.Path, "/"), Body: body}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	fault := s.takeFault(req)
+	s.mu.Unlock()
+
+	if fault == nil {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+
+	s.serveFault(w, r, fault)
+}
+
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func validationError(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "validation_error", fmt.Sprintf(format, args...)}
+}
+
+func notFound(what, id string) *apiError {
+	return &apiError{
+		http.StatusNotFound, "object_not_found",
+		fmt.Sprintf("Could not find %s with ID: %s.", what, id),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck,gosec // the client is gone
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.Status, map[string]interface{}{
+		"object":  "error",
+		"status":  err.Status,
+		"code":    err.Code,
+		"message": err.Message,
+	})
+}
+
+func decodeBody(r *http.Request, v interface{}) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return &apiError{http.StatusBadRequest, "invalid_json", err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package notiontest_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+const (
+	tasksDB  = "tasks-db"
+	tracksDB = "tracks-db"
+)
+
+func newTestServer(t *testing.T) (*notiontest.Server, *notion.Notion) {
+	t.Helper()
+
+	s := notiontest.NewServer()
+	t.Cleanup(s.Close)
+
+	s.AddDatabase(tasksDB, map[string]notiontest.Column{
+		"Задача":      {Type: property.TypeTitle},
+		"Исполнитель": {Type: property.TypePeople},
+		"Статус": {Type: property.TypeSelect, Options: []string{
+			notion.StatusNew, notion.StatusBacklog, notion.StatusDone, notion.StatusArchived,
+		}},
+		"Дедлайн":              {Type: property.TypeDate},
+		"_timeWhenMovedToWork": {Type: property.TypeDate},
+		"_idempotencyKey":      {Type: property.TypeRichText},
+	})
+	s.AddDatabase(tracksDB, map[string]notiontest.Column{
+		"Название": {Type: property.TypeTitle},
+		"Статус": {Type: property.TypeStatus, Options: []string{
+			notion.TrackStatusDemo, notion.TrackStatusMixing, "Выпущен",
+		}},
+	})
+	s.AddUser("user-1", "Alice")
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(s.URL())
+	n.SetRetryPolicy(notion.RetryPolicy{MaxAttempts: 3})
+
+	return s, n
+}
+
+func addTrack(s *notiontest.Server, title, status string) string {
+	return s.AddPage(tracksDB, property.Properties{
+		"Название": property.NewTitle(title),
+		"Статус":   property.NewStatus(status),
+	})
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	s, n := newTestServer(t)
+
+	link, err := n.CreateNotionTask(&notion.CreateTaskRequest{
+		NotionDBID: tasksDB,
+		TaskName:   "Write tests",
+		Assignees:  []string{"user-1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tasks, err := n.LoadTasks(tasksDB)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task, got %d", len(tasks))
+	}
+	task := tasks[0]
+	if task.Title != "Write tests" || task.Status != notion.StatusNew || task.Link != link {
+		t.Errorf("unexpected task %+v", task)
+	}
+	if len(task.Assignees) != 1 || task.Assignees[0].Name != "Alice" {
+		t.Errorf("unexpected assignees %+v", task.Assignees)
+	}
+
+	deadline := time.Date(2030, 1, 2, 0, 0, 0, 0, time.Local)
+	err = n.SetDeadline(&notion.SetDeadlineRequest{TaskLink: link, Deadline: deadline})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tasks, err = n.LoadTasks(tasksDB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 1 || !tasks[0].Deadline.Equal(deadline) {
+		t.Errorf("deadline is not set: %+v", tasks)
+	}
+
+	err = n.SetStatus(&notion.SetStatusRequest{TaskLink: link, Status: notion.StatusDone})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tasks, err = n.LoadTasks(tasksDB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("done task is still loaded: %+v", tasks)
+	}
+
+	pages := s.Pages(tasksDB)
+	if len(pages) != 1 || pages[0].Properties.PlainText("Статус") != notion.StatusDone {
+		t.Errorf("unexpected pages %+v", pages)
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	s, n := newTestServer(t)
+	n.SetQueryPageSize(2)
+
+	for _, title := range []string{"E", "D", "C", "B", "A"} {
+		addTrack(s, title, notion.TrackStatusDemo)
+	}
+	addTrack(s, "Released", "Выпущен")
+
+	tracks, err := n.LoadTrackPages(tracksDB)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var titles []string
+	for _, track := range tracks {
+		titles = append(titles, track.Title)
+	}
+	if got := strings.Join(titles, ","); got != "A,B,C,D,E" {
+		t.Errorf("got tracks %s", got)
+	}
+
+	if got := len(s.Requests()); got != 3 {
+		t.Errorf("expected 3 query pages, got %d", got)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	s, n := newTestServer(t)
+	n.SetQueryPageSize(2)
+	s.AddUser("user-2", "Bob")
+	s.AddUser("user-3", "Carol")
+
+	users, err := n.ListUsersContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, u := range users {
+		names = append(names, u.ID+"="+u.Name)
+	}
+	if got := strings.Join(names, ","); got != "user-1=Alice,user-2=Bob,user-3=Carol" {
+		t.Errorf("got users %s", got)
+	}
+
+	if got := len(s.Requests()); got != 2 {
+		t.Errorf("expected 2 pages of users, got %d", got)
+	}
+}
+
+func TestRejectsInvalidRequests(t *testing.T) {
+	s, n := newTestServer(t)
+
+	// the schema expects a status property, the database has a select one
+	schema := notion.DefaultSchema()
+	schema.Tasks.Properties.Status.Type = notion.PropertyTypeStatus
+	n.SetSchema(schema)
+
+	if _, err := n.LoadTasks(tasksDB); err == nil {
+		t.Error("expected the filter of the wrong type to be rejected")
+	}
+
+	n.SetSchema(notion.DefaultSchema())
+	n.SetIdempotencyKeyProperty("Missing")
+	_, err := n.CreateNotionTask(&notion.CreateTaskRequest{NotionDBID: tasksDB, TaskName: "x"})
+	if err == nil {
+		t.Error("expected a write to an unknown property to be rejected")
+	}
+
+	trackID := addTrack(s, "Song", notion.TrackStatusDemo)
+	req, err := http.NewRequest(
+		http.MethodPatch, s.URL()+"pages/"+trackID,
+		strings.NewReader(`{"properties": {"Статус": {"status": {"name": "Unknown"}}}}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer test-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected unknown status option to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s, n := newTestServer(t)
+	addTrack(s, "Song", notion.TrackStatusDemo)
+
+	s.InjectFault(notiontest.Fault{
+		Path: "databases/", Times: 1,
+		Status: http.StatusTooManyRequests, RetryAfter: time.Millisecond,
+	})
+	s.InjectFault(notiontest.Fault{
+		Path: "databases/", Times: 1, Status: http.StatusInternalServerError,
+	})
+
+	tracks, err := n.LoadTrackPages(tracksDB)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 1 {
+		t.Errorf("expected 1 track, got %d", len(tracks))
+	}
+	if got := len(s.Requests()); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+
+	s.InjectFault(notiontest.Fault{Status: http.StatusServiceUnavailable})
+	if _, err := n.LoadTrackPages(tracksDB); err == nil {
+		t.Error("expected error when every attempt fails")
+	}
+}
+
+func TestTimeoutAfterCreateDoesNotDuplicatePage(t *testing.T) {
+	s, n := newTestServer(t)
+	n.SetIdempotencyKeyProperty("_idempotencyKey")
+	n.SetHTTPTimeout(50 * time.Millisecond)
+
+	s.InjectFault(notiontest.Fault{
+		Method: http.MethodPost, Path: "pages", Times: 1, Delay: time.Second, Apply: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	link, err := n.CreateNotionTaskContext(ctx, &notion.CreateTaskRequest{
+		NotionDBID: tasksDB,
+		TaskName:   "Once",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pages := s.Pages(tasksDB)
+	if len(pages) != 1 {
+		t.Fatalf("expected a single page, got %d", len(pages))
+	}
+	if !strings.HasSuffix(link, strings.ReplaceAll(pages[0].ID, "-", "")) {
+		t.Errorf("link %s does not point to page %s", link, pages[0].ID)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	_, n := newTestServer(t)
+
+	report := n.ValidateSchema(notion.DatabaseIDs{Tasks: tasksDB})
+	if report.HasErrors() {
+		t.Errorf("unexpected errors:\n%s", report)
+	}
+}
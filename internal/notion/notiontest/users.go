@@ -0,0 +1,60 @@
+package notiontest
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	pageSize := maxPageSize
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 || size > maxPageSize {
+			writeError(w, validationError("page_size should be ≤ %d.", maxPageSize))
+			return
+		}
+		pageSize = size
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	start := 0
+	if cursor := r.URL.Query().Get("start_cursor"); cursor != "" {
+		start = slices.Index(ids, cursor)
+		if start < 0 {
+			writeError(w, validationError("start_cursor provided is invalid."))
+			return
+		}
+	}
+
+	end := min(start+pageSize, len(ids))
+	results := make([]map[string]interface{}, 0, end-start)
+	for _, id := range ids[start:end] {
+		results = append(results, map[string]interface{}{
+			"object": "user",
+			"id":     id,
+			"type":   "person",
+			"name":   s.users[id],
+		})
+	}
+
+	var nextCursor interface{}
+	if end < len(ids) {
+		nextCursor = ids[end]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":      "list",
+		"results":     results,
+		"has_more":    nextCursor != nil,
+		"next_cursor": nextCursor,
+	})
+}
@@ -0,0 +1,78 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+// page is a page as returned by queries and page requests.
+type page struct {
+	ID         string              `json:"id"`
+	Properties property.Properties `json:"properties"`
+}
+
+type updatePayload struct {
+	Properties property.Properties `json:"properties,omitempty"`
+	Archived   bool                `json:"archived,omitempty"`
+}
+
+// getPage loads a page with its properties.
+func (n *Notion) getPage(ctx context.Context, pageID string) (*page, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, n.apiBaseURL+path.Join("pages", pageID), nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+
+	resp, err := n.doWithRetries(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result page
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// updatePage sets the given properties of a page leaving the others intact.
+func (n *Notion) updatePage(ctx context.Context, pageID string, props property.Properties) error {
+	return n.patchPage(ctx, pageID, updatePayload{Properties: props})
+}
+
+// ArchivePageContext moves the page to the trash, it can be restored from
+// there in Notion.
+func (n *Notion) ArchivePageContext(ctx context.Context, pageID string) error {
+	return n.patchPage(ctx, pageID, updatePayload{Archived: true})
+}
+
+func (n *Notion) patchPage(ctx context.Context, pageID string, payload updatePayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPatch, n.apiBaseURL+path.Join("pages", pageID), nil,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create a request: %w", err)
+	}
+
+	resp, err := n.doWithRetries(req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package property
+
+import "time"
+
+func NewTitle(text string) Value {
+	return Value{Type: TypeTitle, Title: RichTextOf(text)}
+}
+
+func NewRichText(text string) Value {
+	return Value{Type: TypeRichText, RichText: RichTextOf(text)}
+}
+
+func NewSelect(name string) Value {
+	return Value{Type: TypeSelect, Select: &Option{Name: name}}
+}
+
+func NewStatus(name string) Value {
+	return Value{Type: TypeStatus, Status: &Option{Name: name}}
+}
+
+// NewOption builds a status value if t is status and a select value
+// otherwise. It is used for properties that may be of either type.
+func NewOption(t Type, name string) Value {
+	if t == TypeStatus {
+		return NewStatus(name)
+	}
+
+	return NewSelect(name)
+}
+
+func NewPeople(userIDs ...string) Value {
+	people := make([]User, 0, len(userIDs))
+	for _, id := range userIDs {
+		people = append(people, User{Object: "user", ID: id})
+	}
+
+	return Value{Type: TypePeople, People: people}
+}
+
+// NewDate builds a date without time.
+func NewDate(day time.Time) Value {
+	return Value{Type: TypeDate, Date: &Date{Start: formatDate(day, false)}}
+}
+
+// NewDateTime builds a date with time.
+func NewDateTime(t time.Time) Value {
+	return Value{Type: TypeDate, Date: &Date{Start: formatDate(t, true)}}
+}
+
+// NewDateRange builds a date range, with or without time.
+func NewDateRange(start, end time.Time, withTime bool) Value {
+	endStr := formatDate(end, withTime)
+
+	return Value{Type: TypeDate, Date: &Date{
+		Start: formatDate(start, withTime),
+		End:   &endStr,
+	}}
+}
+
+func NewRelation(pageIDs ...string) Value {
+	relation := make([]Relation, 0, len(pageIDs))
+	for _, id := range pageIDs {
+		relation = append(relation, Relation{ID: id})
+	}
+
+	return Value{Type: TypeRelation, Relation: relation}
+}
+
+func NewNumber(n float64) Value {
+	return Value{Type: TypeNumber, Number: &n}
+}
+
+func NewCheckbox(checked bool) Value {
+	return Value{Type: TypeCheckbox, Checkbox: checked}
+}
+
+func NewURL(url string) Value {
+	return Value{Type: TypeURL, URL: &url}
+}
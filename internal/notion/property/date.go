@@ -0,0 +1,72 @@
+package property
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = time.RFC3339
+)
+
+// Date is a value of a date property. Start and End are kept as sent by Notion:
+// either a date in YYYY-MM-DD form or an ISO 8601 date with time. End is empty
+// for single dates.
+type Date struct {
+	Start    string  `json:"start"`
+	End      *string `json:"end,omitempty"`
+	TimeZone *string `json:"time_zone,omitempty"`
+}
+
+// HasTime reports whether the date has time.
+func (d *Date) HasTime() bool {
+	return d != nil && len(d.Start) > len(dateLayout)
+}
+
+// StartTime parses the start of the date. Dates without time are parsed in
+// the local time zone.
+func (d *Date) StartTime() (time.Time, error) {
+	if d == nil {
+		return time.Time{}, fmt.Errorf("date is empty")
+	}
+
+	return parseDate(d.Start)
+}
+
+// EndTime parses the end of a date range. It returns the zero time for single
+// dates.
+func (d *Date) EndTime() (time.Time, error) {
+	if d == nil || d.End == nil {
+		return time.Time{}, nil
+	}
+
+	return parseDate(*d.End)
+}
+
+func (d *Date) String() string {
+	switch {
+	case d == nil:
+		return ""
+	case d.End == nil || *d.End == "":
+		return d.Start
+	default:
+		return d.Start + " → " + *d.End
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if len(s) == len(dateLayout) {
+		return time.ParseInLocation(dateLayout, s, time.Local)
+	}
+
+	return time.Parse(dateTimeLayout, s)
+}
+
+func formatDate(t time.Time, withTime bool) string {
+	if withTime {
+		return t.Format(dateTimeLayout)
+	}
+
+	return t.Format(dateLayout)
+}
@@ -0,0 +1,376 @@
+// Package property models values of Notion page properties. Values are built
+// with the New* functions for writes and decoded from API responses for reads.
+package property
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a property value as named by the Notion API.
+type Type string
+
+const (
+	TypeTitle    Type = "title"
+	TypeRichText Type = "rich_text"
+	TypeSelect   Type = "select"
+	TypeStatus   Type = "status"
+	TypePeople   Type = "people"
+	TypeDate     Type = "date"
+	TypeRelation Type = "relation"
+	TypeNumber   Type = "number"
+	TypeCheckbox Type = "checkbox"
+	TypeURL      Type = "url"
+	TypeUniqueID Type = "unique_id"
+	TypeFormula  Type = "formula"
+	TypeRollup   Type = "rollup"
+)
+
+// types is used to detect the type of values written without the type field.
+var types = []Type{
+	TypeTitle, TypeRichText, TypeSelect, TypeStatus, TypePeople, TypeDate,
+	TypeRelation, TypeNumber, TypeCheckbox, TypeURL, TypeUniqueID, TypeFormula,
+	TypeRollup,
+}
+
+// Text is the content of a text rich text part.
+type Text struct {
+	Content string `json:"content"`
+	Link    *Link  `json:"link,omitempty"`
+}
+
+type Link struct {
+	URL string `json:"url"`
+}
+
+// RichText is a part of a title or rich_text value. Mentions and equations are
+// only read, PlainText holds their rendered text.
+type RichText struct {
+	Type      string `json:"type,omitempty"`
+	Text      *Text  `json:"text,omitempty"`
+	PlainText string `json:"plain_text,omitempty"`
+}
+
+// String returns the plain text of the part. Parts built for writes have no
+// plain text, the content is returned for them.
+func (r RichText) String() string {
+	if r.PlainText == "" && r.Text != nil {
+		return r.Text.Content
+	}
+
+	return r.PlainText
+}
+
+// RichTextOf returns rich text consisting of a single text part.
+func RichTextOf(content string) []RichText {
+	return []RichText{{Type: "text", Text: &Text{Content: content}}}
+}
+
+// Option is a value of a select or status property.
+type Option struct {
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+}
+
+// User is a Notion user. Only ID is required for writes.
+type User struct {
+	Object string `json:"object,omitempty"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+}
+
+type Relation struct {
+	ID string `json:"id"`
+}
+
+type UniqueID struct {
+	Prefix string `json:"prefix,omitempty"`
+	Number int    `json:"number"`
+}
+
+func (u UniqueID) String() string {
+	if u.Prefix == "" {
+		return strconv.Itoa(u.Number)
+	}
+
+	return u.Prefix + "-" + strconv.Itoa(u.Number)
+}
+
+// Formula is the result of a formula. Type is one of string, number, boolean
+// and date.
+type Formula struct {
+	Type    string   `json:"type"`
+	String  *string  `json:"string,omitempty"`
+	Number  *float64 `json:"number,omitempty"`
+	Boolean *bool    `json:"boolean,omitempty"`
+	Date    *Date    `json:"date,omitempty"`
+}
+
+// Rollup is the result of a rollup. Type is one of number, date and array.
+type Rollup struct {
+	Type     string   `json:"type"`
+	Function string   `json:"function,omitempty"`
+	Number   *float64 `json:"number,omitempty"`
+	Date     *Date    `json:"date,omitempty"`
+	Array    []Value  `json:"array,omitempty"`
+}
+
+// Value is a value of a page property. Only the field matching Type is used.
+//
+// Values are encoded without the type field, which is how Notion expects them
+// in create and update requests. Notion rejects writes of unique_id, formula
+// and rollup values, they are still encoded so that values read from Notion
+// can be sent back by test servers.
+type Value struct {
+	Type Type
+
+	Title    []RichText
+	RichText []RichText
+	Select   *Option
+	Status   *Option
+	People   []User
+	Date     *Date
+	Relation []Relation
+	Number   *float64
+	Checkbox bool
+	URL      *string
+	UniqueID *UniqueID
+	Formula  *Formula
+	Rollup   *Rollup
+}
+
+// Properties are the properties of a page by name.
+type Properties map[string]Value
+
+// PlainText returns the text of the property with the given name or an empty
+// string if there is no such property.
+func (p Properties) PlainText(name string) string {
+	return p[name].PlainText()
+}
+
+// field returns a pointer to the field holding the value of type t or nil if
+// the type is not supported.
+func (v *Value) field(t Type) interface{} {
+	switch t {
+	case TypeTitle:
+		return &v.Title
+	case TypeRichText:
+		return &v.RichText
+	case TypeSelect:
+		return &v.Select
+	case TypeStatus:
+		return &v.Status
+	case TypePeople:
+		return &v.People
+	case TypeDate:
+		return &v.Date
+	case TypeRelation:
+		return &v.Relation
+	case TypeNumber:
+		return &v.Number
+	case TypeCheckbox:
+		return &v.Checkbox
+	case TypeURL:
+		return &v.URL
+	case TypeUniqueID:
+		return &v.UniqueID
+	case TypeFormula:
+		return &v.Formula
+	case TypeRollup:
+		return &v.Rollup
+	default:
+		return nil
+	}
+}
+
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	return s
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	var payload interface{}
+
+	// empty lists clear a property, null is not accepted for them
+	switch v.Type {
+	case TypeTitle:
+		payload = orEmpty(v.Title)
+	case TypeRichText:
+		payload = orEmpty(v.RichText)
+	case TypePeople:
+		payload = orEmpty(v.People)
+	case TypeRelation:
+		payload = orEmpty(v.Relation)
+	default:
+		field := v.field(v.Type)
+		if field == nil {
+			return nil, fmt.Errorf("unsupported property type %q", v.Type)
+		}
+		payload = field
+	}
+
+	return json.Marshal(map[Type]interface{}{v.Type: payload})
+}
+
+// UnmarshalJSON decodes a value as returned by Notion. When the type field is
+// absent, as in request payloads, the type is detected by the keys present.
+// Values of unsupported types only get their Type set.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*v = Value{}
+
+	if raw, ok := fields["type"]; ok {
+		if err := json.Unmarshal(raw, &v.Type); err != nil {
+			return fmt.Errorf("invalid property type: %w", err)
+		}
+	} else {
+		for _, t := range types {
+			if _, ok := fields[string(t)]; ok {
+				v.Type = t
+				break
+			}
+		}
+	}
+
+	raw, ok := fields[string(v.Type)]
+	field := v.field(v.Type)
+	if !ok || field == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, field); err != nil {
+		return fmt.Errorf("invalid %s property: %w", v.Type, err)
+	}
+
+	return nil
+}
+
+// OptionName returns the name of a select or status value.
+func (v Value) OptionName() string {
+	switch {
+	case v.Type == TypeSelect && v.Select != nil:
+		return v.Select.Name
+	case v.Type == TypeStatus && v.Status != nil:
+		return v.Status.Name
+	default:
+		return ""
+	}
+}
+
+// RelationIDs returns the IDs of related pages.
+func (v Value) RelationIDs() []string {
+	ids := make([]string, 0, len(v.Relation))
+	for _, r := range v.Relation {
+		ids = append(ids, r.ID)
+	}
+
+	return ids
+}
+
+// PlainText renders the value as text. People are rendered by name, or by ID
+// when the name is unknown. Relations are rendered as an empty string since
+// only IDs of related pages are known.
+func (v Value) PlainText() string {
+	switch v.Type {
+	case TypeTitle:
+		return joinRichText(v.Title)
+	case TypeRichText:
+		return joinRichText(v.RichText)
+	case TypeSelect, TypeStatus:
+		return v.OptionName()
+	case TypePeople:
+		names := make([]string, 0, len(v.People))
+		for _, person := range v.People {
+			if person.Name != "" {
+				names = append(names, person.Name)
+			} else if person.ID != "" {
+				names = append(names, person.ID)
+			}
+		}
+		return strings.Join(names, ", ")
+	case TypeDate:
+		return v.Date.String()
+	case TypeNumber:
+		return formatNumber(v.Number)
+	case TypeCheckbox:
+		return strconv.FormatBool(v.Checkbox)
+	case TypeURL:
+		if v.URL == nil {
+			return ""
+		}
+		return *v.URL
+	case TypeUniqueID:
+		if v.UniqueID == nil {
+			return ""
+		}
+		return v.UniqueID.String()
+	case TypeFormula:
+		return v.Formula.plainText()
+	case TypeRollup:
+		return v.Rollup.plainText()
+	default:
+		return ""
+	}
+}
+
+func (f *Formula) plainText() string {
+	switch {
+	case f == nil:
+		return ""
+	case f.String != nil:
+		return *f.String
+	case f.Number != nil:
+		return formatNumber(f.Number)
+	case f.Boolean != nil:
+		return strconv.FormatBool(*f.Boolean)
+	default:
+		return f.Date.String()
+	}
+}
+
+func (r *Rollup) plainText() string {
+	switch {
+	case r == nil:
+		return ""
+	case r.Number != nil:
+		return formatNumber(r.Number)
+	case r.Date != nil:
+		return r.Date.String()
+	}
+
+	parts := make([]string, 0, len(r.Array))
+	for _, item := range r.Array {
+		if text := item.PlainText(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func joinRichText(parts []RichText) string {
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(part.String())
+	}
+
+	return b.String()
+}
+
+func formatNumber(n *float64) string {
+	if n == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(*n, 'f', -1, 64)
+}
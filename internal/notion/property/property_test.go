@@ -0,0 +1,237 @@
+package property
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testPageProperties = `{
+	"Title": {"id": "title", "type": "title", "title": [
+		{"type": "text", "text": {"content": "Hello, "}, "plain_text": "Hello, "},
+		{"type": "mention", "mention": {}, "plain_text": "@Alice"}
+	]},
+	"Notes": {"id": "a", "type": "rich_text", "rich_text": []},
+	"Kind": {"id": "b", "type": "select", "select": {"id": "x", "name": "Bug", "color": "red"}},
+	"State": {"id": "c", "type": "status", "status": {"name": "In progress"}},
+	"Empty select": {"id": "d", "type": "select", "select": null},
+	"People": {"id": "e", "type": "people", "people": [
+		{"object": "user", "id": "u1", "name": "Alice"},
+		{"object": "user", "id": "u2"}
+	]},
+	"Due": {"id": "f", "type": "date", "date": {
+		"start": "2025-06-01T10:00:00.000+03:00", "end": "2025-06-02T12:30:00.000+03:00",
+		"time_zone": null
+	}},
+	"Track": {"id": "g", "type": "relation", "relation": [{"id": "p1"}, {"id": "p2"}],
+		"has_more": false},
+	"Count": {"id": "h", "type": "number", "number": 2.5},
+	"No count": {"id": "i", "type": "number", "number": null},
+	"Done": {"id": "j", "type": "checkbox", "checkbox": true},
+	"Link": {"id": "k", "type": "url", "url": "https://example.com"},
+	"ID": {"id": "l", "type": "unique_id", "unique_id": {"prefix": "TASK", "number": 42}},
+	"Formula": {"id": "m", "type": "formula", "formula": {"type": "number", "number": 7}},
+	"Rollup": {"id": "n", "type": "rollup", "rollup": {"type": "array", "function": "show_original",
+		"array": [
+			{"type": "title", "title": [{"plain_text": "Song"}]},
+			{"type": "people", "people": [{"id": "u3", "name": "Bob"}]}
+		]
+	}},
+	"Files": {"id": "o", "type": "files", "files": [{"name": "a.pdf"}]}
+}`
+
+func TestDecodePageProperties(t *testing.T) {
+	var props Properties
+	if err := json.Unmarshal([]byte(testPageProperties), &props); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	texts := map[string]string{
+		"Title":        "Hello, @Alice",
+		"Notes":        "",
+		"Kind":         "Bug",
+		"State":        "In progress",
+		"Empty select": "",
+		"People":       "Alice, u2",
+		"Due":          "2025-06-01T10:00:00.000+03:00 → 2025-06-02T12:30:00.000+03:00",
+		"Track":        "",
+		"Count":        "2.5",
+		"No count":     "",
+		"Done":         "true",
+		"Link":         "https://example.com",
+		"ID":           "TASK-42",
+		"Formula":      "7",
+		"Rollup":       "Song, Bob",
+		"Files":        "",
+		"Missing":      "",
+	}
+	for name, want := range texts {
+		if got := props.PlainText(name); got != want {
+			t.Errorf("PlainText(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	if got := props["Kind"].OptionName(); got != "Bug" {
+		t.Errorf("OptionName() = %q, want Bug", got)
+	}
+	if got := props["Track"].RelationIDs(); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("RelationIDs() = %v", got)
+	}
+	if got := props["Files"].Type; got != "files" {
+		t.Errorf("unsupported type = %q, want files", got)
+	}
+
+	due := props["Due"].Date
+	if !due.HasTime() {
+		t.Error("expected date with time")
+	}
+	start, err := due.StartTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := time.Date(2025, 6, 1, 7, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("StartTime() = %v, want %v", start, want)
+	}
+	end, err := due.EndTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := time.Date(2025, 6, 2, 9, 30, 0, 0, time.UTC); !end.Equal(want) {
+		t.Errorf("EndTime() = %v, want %v", end, want)
+	}
+}
+
+func TestBuildersEncoding(t *testing.T) {
+	day := time.Date(2025, 3, 4, 15, 16, 17, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value Value
+		want  string
+	}{
+		{
+			name:  "title",
+			value: NewTitle("Task"),
+			want:  `{"title":[{"type":"text","text":{"content":"Task"}}]}`,
+		},
+		{
+			name:  "rich text",
+			value: NewRichText("Notes"),
+			want:  `{"rich_text":[{"type":"text","text":{"content":"Notes"}}]}`,
+		},
+		{
+			name:  "empty rich text clears the property",
+			value: Value{Type: TypeRichText},
+			want:  `{"rich_text":[]}`,
+		},
+		{
+			name:  "select",
+			value: NewOption(TypeSelect, "todo"),
+			want:  `{"select":{"name":"todo"}}`,
+		},
+		{
+			name:  "status",
+			value: NewOption(TypeStatus, "Done"),
+			want:  `{"status":{"name":"Done"}}`,
+		},
+		{
+			name:  "cleared select",
+			value: Value{Type: TypeSelect},
+			want:  `{"select":null}`,
+		},
+		{
+			name:  "people",
+			value: NewPeople("u1", "u2"),
+			want:  `{"people":[{"object":"user","id":"u1"},{"object":"user","id":"u2"}]}`,
+		},
+		{
+			name:  "date",
+			value: NewDate(day),
+			want:  `{"date":{"start":"2025-03-04"}}`,
+		},
+		{
+			name:  "date with time",
+			value: NewDateTime(day),
+			want:  `{"date":{"start":"2025-03-04T15:16:17Z"}}`,
+		},
+		{
+			name:  "date range",
+			value: NewDateRange(day, day.AddDate(0, 0, 2), false),
+			want:  `{"date":{"start":"2025-03-04","end":"2025-03-06"}}`,
+		},
+		{
+			name:  "relation",
+			value: NewRelation("p1"),
+			want:  `{"relation":[{"id":"p1"}]}`,
+		},
+		{
+			name:  "number",
+			value: NewNumber(3),
+			want:  `{"number":3}`,
+		},
+		{
+			name:  "checkbox",
+			value: NewCheckbox(false),
+			want:  `{"checkbox":false}`,
+		},
+		{
+			name:  "url",
+			value: NewURL("https://example.com"),
+			want:  `{"url":"https://example.com"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Fatalf("got %s, want %s", data, tt.want)
+			}
+
+			var decoded Value
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decoded.Type != tt.value.Type {
+				t.Errorf("decoded type = %q, want %q", decoded.Type, tt.value.Type)
+			}
+			if decoded.PlainText() != tt.value.PlainText() {
+				t.Errorf("decoded text = %q, want %q", decoded.PlainText(), tt.value.PlainText())
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupportedType(t *testing.T) {
+	if _, err := json.Marshal(Value{Type: "files"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDateWithoutTime(t *testing.T) {
+	d := &Date{Start: "2025-12-31"}
+	if d.HasTime() {
+		t.Error("expected date without time")
+	}
+
+	start, err := d.StartTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := time.Date(2025, 12, 31, 0, 0, 0, 0, time.Local); !start.Equal(want) {
+		t.Errorf("StartTime() = %v, want %v", start, want)
+	}
+
+	end, err := d.EndTime()
+	if err != nil || !end.IsZero() {
+		t.Errorf("EndTime() = %v, %v, want zero time", end, err)
+	}
+
+	if _, err := (&Date{Start: "01-12-2025"}).StartTime(); err == nil {
+		t.Error("expected error for invalid date")
+	}
+}
@@ -0,0 +1,161 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+)
+
+const (
+	// maxQueryPageSize is the largest page_size accepted by Notion.
+	maxQueryPageSize     = 100
+	defaultQueryPageSize = maxQueryPageSize
+	defaultQueryMaxPages = 50
+)
+
+type queryPayload struct {
+	Filter      map[string]interface{} `json:"filter,omitempty"`
+	StartCursor string                 `json:"start_cursor,omitempty"`
+	PageSize    int                    `json:"page_size,omitempty"`
+}
+
+type queryResponse[T any] struct {
+	Results    []T    `json:"results"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// queryIterator walks through the pages of a databases/{id}/query request by
+// following next_cursor. It stops when Notion reports there is nothing more to
+// read or when the page cap of the client is reached.
+type queryIterator[T any] struct {
+	ctx    context.Context
+	n      *Notion
+	dbID   string
+	filter map[string]interface{}
+
+	cursor  string
+	pages   int
+	done    bool
+	results []T
+	err     error
+}
+
+func newQueryIterator[T any](
+	ctx context.Context, n *Notion, dbID string, filter map[string]interface{},
+) *queryIterator[T] {
+	return &queryIterator[T]{
+		ctx:    ctx,
+		n:      n,
+		dbID:   dbID,
+		filter: filter,
+	}
+}
+
+// Next loads the next page of results. It returns false when all pages have
+// been read or an error occurred, Err must be checked afterwards.
+func (it *queryIterator[T]) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	if it.pages >= it.n.queryMaxPages {
+		logger.WarnContext(it.ctx, "Query stopped at the page limit, the rest of the results is ignored",
+			"database_id", it.dbID, "pages", it.pages,
+		)
+		it.done = true
+		return false
+	}
+
+	page, err := it.fetch()
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.pages++
+	it.results = page.Results
+	it.cursor = page.NextCursor
+	it.done = !page.HasMore || page.NextCursor == ""
+
+	return true
+}
+
+// Results returns the results of the page loaded by the last call to Next.
+func (it *queryIterator[T]) Results() []T {
+	return it.results
+}
+
+func (it *queryIterator[T]) Err() error {
+	return it.err
+}
+
+func (it *queryIterator[T]) fetch() (*queryResponse[T], error) {
+	payload := queryPayload{
+		Filter:      it.filter,
+		StartCursor: it.cursor,
+		PageSize:    it.n.queryPageSize,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal json payload: %w", err)
+	}
+
+	url := it.n.apiBaseURL + path.Join("databases", it.dbID, "query")
+	logger.DebugContext(it.ctx, "Querying database", "url", url, "cursor", it.cursor)
+
+	req, err := http.NewRequestWithContext(it.ctx, "POST", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+
+	resp, err := it.n.doWithRetries(req, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result queryResponse[T]
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// queryAll reads every page of the query and returns all results at once.
+func queryAll[T any](
+	ctx context.Context, n *Notion, dbID string, filter map[string]interface{},
+) ([]T, error) {
+	it := newQueryIterator[T](ctx, n, dbID, filter)
+
+	var results []T
+	for it.Next() {
+		results = append(results, it.Results()...)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// SetQueryPageSize sets the number of results requested per page of a
+// database query. Values outside of 1..100 fall back to 100.
+func (n *Notion) SetQueryPageSize(size int) {
+	if size <= 0 || size > maxQueryPageSize {
+		size = maxQueryPageSize
+	}
+	n.queryPageSize = size
+}
+
+// SetQueryMaxPages caps the number of pages read by a single database query.
+func (n *Notion) SetQueryMaxPages(pages int) {
+	if pages <= 0 {
+		pages = defaultQueryMaxPages
+	}
+	n.queryMaxPages = pages
+}
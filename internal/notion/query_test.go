@@ -0,0 +1,194 @@
+package notion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newPagedQueryServer serves totalResults task pages split into pages of the
+// requested page_size and records every query payload it receives.
+func newPagedQueryServer(
+	t *testing.T, totalResults int, payloads *[]queryPayload,
+) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload queryPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*payloads = append(*payloads, payload)
+
+		start := 0
+		if payload.StartCursor != "" {
+			if _, err := fmt.Sscanf(payload.StartCursor, "cursor-%d", &start); err != nil {
+				t.Errorf("unexpected cursor %q", payload.StartCursor)
+			}
+		}
+		end := min(start+payload.PageSize, totalResults)
+
+		results := make([]map[string]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			results = append(results, map[string]interface{}{
+				"id": fmt.Sprintf("page-%d", i),
+				"properties": map[string]interface{}{
+					"Задача": map[string]interface{}{
+						"title": []map[string]interface{}{
+							{"plain_text": fmt.Sprintf("Task %d", i)},
+						},
+					},
+					"Исполнитель": map[string]interface{}{
+						"people": []map[string]interface{}{
+							{"name": "Alice", "id": "user-1"},
+						},
+					},
+				},
+			})
+		}
+
+		response := map[string]interface{}{
+			"results":     results,
+			"has_more":    end < totalResults,
+			"next_cursor": nil,
+		}
+		if end < totalResults {
+			response["next_cursor"] = fmt.Sprintf("cursor-%d", end)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response) //nolint:errcheck
+	}))
+}
+
+func TestLoadTasks_ReadsAllPages(t *testing.T) {
+	var payloads []queryPayload
+	server := newPagedQueryServer(t, 7, &payloads)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	n.SetQueryPageSize(3)
+
+	tasks, err := n.LoadTasks("db-paged")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tasks) != 7 {
+		t.Fatalf("expected 7 tasks, got %d", len(tasks))
+	}
+	for i, task := range tasks {
+		if want := fmt.Sprintf("Task %d", i); task.Title != want {
+			t.Errorf("task %d: expected title %q, got %q", i, want, task.Title)
+		}
+	}
+
+	wantCursors := []string{"", "cursor-3", "cursor-6"}
+	if len(payloads) != len(wantCursors) {
+		t.Fatalf("expected %d requests, got %d", len(wantCursors), len(payloads))
+	}
+	for i, payload := range payloads {
+		if payload.StartCursor != wantCursors[i] {
+			t.Errorf("request %d: expected cursor %q, got %q", i, wantCursors[i], payload.StartCursor)
+		}
+		if payload.PageSize != 3 {
+			t.Errorf("request %d: expected page size 3, got %d", i, payload.PageSize)
+		}
+		if _, ok := payload.Filter["and"]; !ok {
+			t.Errorf("request %d: expected the tasks filter to be sent with every page", i)
+		}
+	}
+}
+
+func TestLoadTasks_StopsAtMaxPages(t *testing.T) {
+	var payloads []queryPayload
+	server := newPagedQueryServer(t, 10, &payloads)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	n.SetQueryPageSize(2)
+	n.SetQueryMaxPages(2)
+
+	tasks, err := n.LoadTasks("db-capped")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(payloads))
+	}
+	if len(tasks) != 4 {
+		t.Fatalf("expected 4 tasks, got %d", len(tasks))
+	}
+}
+
+func TestLoadAllTrackPages_ReadsAllPages(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload queryPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		calls++
+
+		title, response := "Alpha", map[string]interface{}{
+			"has_more":    true,
+			"next_cursor": "second",
+		}
+		if payload.StartCursor == "second" {
+			title, response = "Bravo", map[string]interface{}{"has_more": false}
+		}
+		response["results"] = []map[string]interface{}{
+			{
+				"id": "track-" + title,
+				"properties": map[string]interface{}{
+					"Название": map[string]interface{}{
+						"title": []map[string]interface{}{{"plain_text": title}},
+					},
+				},
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	tracks, err := n.LoadAllTrackPages("db-tracks")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected 2 requests, got %d", calls)
+	}
+	if len(tracks) != 2 || tracks[0].Title != "Alpha" || tracks[1].Title != "Bravo" {
+		t.Fatalf("unexpected tracks: %#v", tracks)
+	}
+}
+
+func TestSetQueryPageSize_Bounds(t *testing.T) {
+	n := NewNotion("test-token")
+
+	for _, size := range []int{0, -1, 101} {
+		n.SetQueryPageSize(size)
+		if n.queryPageSize != maxQueryPageSize {
+			t.Errorf("size %d: expected page size to fall back to %d, got %d",
+				size, maxQueryPageSize, n.queryPageSize)
+		}
+	}
+
+	n.SetQueryPageSize(25)
+	if n.queryPageSize != 25 {
+		t.Errorf("expected page size 25, got %d", n.queryPageSize)
+	}
+}
@@ -0,0 +1,305 @@
+package notion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStatusSequenceServer answers with the given statuses in order and with
+// 200 once they run out. Every response carries the given headers.
+func newStatusSequenceServer(
+	statuses []int, headers map[string]string, calls *int,
+) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		*calls++
+
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		if *calls <= len(statuses) {
+			w.WriteHeader(statuses[*calls-1])
+			_, _ = w.Write([]byte(`{"object":"error"}`)) //nolint:errcheck
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"id":"ok"}`)) //nolint:errcheck
+	}))
+}
+
+func newRetryTestNotion(serverURL string, sleeps *[]time.Duration) *Notion {
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(serverURL + "/")
+	n.sleep = func(_ context.Context, d time.Duration) error {
+		*sleeps = append(*sleeps, d)
+		return nil
+	}
+
+	return n
+}
+
+func doTestRequest(t *testing.T, n *Notion) error {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, n.apiBaseURL+"pages", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+
+	resp, err := n.doWithRetries(req, []byte(`{}`))
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	return err
+}
+
+func TestDoWithRetries_HonoursRetryAfter(t *testing.T) {
+	var (
+		calls  int
+		sleeps []time.Duration
+	)
+	server := newStatusSequenceServer(
+		[]int{http.StatusTooManyRequests}, map[string]string{"Retry-After": "2"}, &calls,
+	)
+	defer server.Close()
+
+	n := newRetryTestNotion(server.URL, &sleeps)
+
+	if err := doTestRequest(t, n); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+	if len(sleeps) != 1 || sleeps[0] != 2*time.Second {
+		t.Fatalf("expected one sleep of 2s, got %v", sleeps)
+	}
+}
+
+func TestDoWithRetries_DoesNotRetryClientErrors(t *testing.T) {
+	for _, status := range []int{
+		http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound,
+	} {
+		var (
+			calls  int
+			sleeps []time.Duration
+		)
+		server := newStatusSequenceServer([]int{status}, nil, &calls)
+
+		n := newRetryTestNotion(server.URL, &sleeps)
+		err := doTestRequest(t, n)
+		server.Close()
+
+		if err == nil {
+			t.Errorf("status %d: expected error", status)
+		}
+		if errors.Is(err, ErrUnavailable) {
+			t.Errorf("status %d: rejected request reported as unavailable", status)
+		}
+		if calls != 1 {
+			t.Errorf("status %d: expected 1 call, got %d", status, calls)
+		}
+	}
+}
+
+func TestDoWithRetries_StopsAfterMaxAttempts(t *testing.T) {
+	var (
+		calls  int
+		sleeps []time.Duration
+	)
+	statuses := []int{
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusInternalServerError,
+	}
+	server := newStatusSequenceServer(statuses, nil, &calls)
+	defer server.Close()
+
+	n := newRetryTestNotion(server.URL, &sleeps)
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+
+	err := doTestRequest(t, n)
+
+	if err == nil || !strings.Contains(err.Error(), "status code is 503") {
+		t.Fatalf("expected the last status in the error, got %v", err)
+	}
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected Notion to be reported as unavailable, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+	if len(sleeps) != 2 {
+		t.Fatalf("expected 2 sleeps, got %v", sleeps)
+	}
+	if sleeps[0] > time.Second || sleeps[1] > 2*time.Second {
+		t.Fatalf("backoff exceeds the exponential ceiling: %v", sleeps)
+	}
+}
+
+func TestDoWithRetries_RetriesNetworkErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("failed to hijack connection: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"ok"}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	var sleeps []time.Duration
+	n := newRetryTestNotion(server.URL, &sleeps)
+
+	if err := doTestRequest(t, n); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestDoWithRetries_RespectsBudget(t *testing.T) {
+	var (
+		calls  int
+		sleeps []time.Duration
+	)
+	server := newStatusSequenceServer(
+		[]int{http.StatusTooManyRequests}, map[string]string{"Retry-After": "60"}, &calls,
+	)
+	defer server.Close()
+
+	n := newRetryTestNotion(server.URL, &sleeps)
+
+	err := doTestRequest(t, n)
+
+	if err == nil || !strings.Contains(err.Error(), "status code is 429") {
+		t.Fatalf("expected 429 error, got %v", err)
+	}
+	if calls != 1 || len(sleeps) != 0 {
+		t.Fatalf("expected no retries over budget, got %d calls and sleeps %v", calls, sleeps)
+	}
+}
+
+type countingLimiter struct {
+	waits int
+	err   error
+}
+
+func (l *countingLimiter) Wait(context.Context) error {
+	l.waits++
+	return l.err
+}
+
+func TestDoWithRetries_WaitsForLimiterOnEveryAttempt(t *testing.T) {
+	var (
+		calls  int
+		sleeps []time.Duration
+	)
+	server := newStatusSequenceServer([]int{http.StatusInternalServerError}, nil, &calls)
+	defer server.Close()
+
+	limiter := &countingLimiter{}
+	n := newRetryTestNotion(server.URL, &sleeps)
+	n.SetRateLimiter(limiter)
+
+	if err := doTestRequest(t, n); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if limiter.waits != 2 {
+		t.Fatalf("expected 2 limiter waits, got %d", limiter.waits)
+	}
+
+	limiter.err = errors.New("limiter closed")
+	calls = 0
+	if err := doTestRequest(t, n); !errors.Is(err, limiter.err) {
+		t.Fatalf("expected limiter error, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no requests when the limiter fails, got %d", calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, time.July, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "0.5", want: 500 * time.Millisecond, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: now.Add(7 * time.Second).Format(http.TimeFormat), want: 7 * time.Second, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.value != "" {
+			resp.Header.Set("Retry-After", tt.value)
+		}
+
+		got, ok := retryAfter(resp, now)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffIsCapped(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		for i := 0; i < 20; i++ {
+			if d := policy.backoff(attempt); d < 0 || d > 3*time.Second {
+				t.Fatalf("attempt %d: backoff %s is out of bounds", attempt, d)
+			}
+		}
+	}
+}
+
+func TestDoWithRetries_TimeoutIsNotUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	var sleeps []time.Duration
+	n := newRetryTestNotion(server.URL, &sleeps)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.apiBaseURL+"pages/id", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+
+	_, err = n.doWithRetries(req, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the request running out of time not to be unavailable: %v", err)
+	}
+	if len(sleeps) != 0 {
+		t.Fatalf("expected no retries, got %d", len(sleeps))
+	}
+}
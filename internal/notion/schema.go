@@ -0,0 +1,309 @@
+package notion
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+// Property types used by the bot.
+const (
+	PropertyTypeTitle    = string(property.TypeTitle)
+	PropertyTypeRichText = string(property.TypeRichText)
+	PropertyTypeSelect   = string(property.TypeSelect)
+	PropertyTypeStatus   = string(property.TypeStatus)
+	PropertyTypePeople   = string(property.TypePeople)
+	PropertyTypeDate     = string(property.TypeDate)
+	PropertyTypeRelation = string(property.TypeRelation)
+)
+
+// Property maps a logical field to a property of a Notion database. Type may
+// be empty for properties that are only read and rendered as text.
+//
+// In the schema file it can be written either as a mapping with name and type
+// or as a plain string, which only overrides the name.
+type Property struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+}
+
+func (p *Property) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&p.Name)
+	}
+
+	type plain Property
+	return value.Decode((*plain)(p))
+}
+
+// TasksSchema describes the tasks database.
+type TasksSchema struct {
+	Properties struct {
+		Title       Property `yaml:"title"`
+		Assignees   Property `yaml:"assignees"`
+		Status      Property `yaml:"status"`
+		Deadline    Property `yaml:"deadline"`
+		MovedToWork Property `yaml:"moved_to_work"`
+	} `yaml:"properties"`
+
+	Statuses struct {
+		New      string `yaml:"new"`
+		Backlog  string `yaml:"backlog"`
+		Done     string `yaml:"done"`
+		Archived string `yaml:"archived"`
+	} `yaml:"statuses"`
+}
+
+// TweaksSchema describes a tweaks database, demo and mix ones share it.
+type TweaksSchema struct {
+	Properties struct {
+		Title       Property `yaml:"title"`
+		Status      Property `yaml:"status"`
+		Explanation Property `yaml:"explanation"`
+		Track       Property `yaml:"track"`
+		TrackPart   Property `yaml:"track_part"`
+		Start       Property `yaml:"start"`
+		End         Property `yaml:"end"`
+		Author      Property `yaml:"author"`
+		// AuthorComputed is rendered instead of Author when it is not empty.
+		AuthorComputed Property `yaml:"author_computed"`
+	} `yaml:"properties"`
+
+	Statuses struct {
+		// New is the status of created tweaks.
+		New      string `yaml:"new"`
+		Deferred string `yaml:"deferred"`
+		Ready    string `yaml:"ready"`
+		InWork   string `yaml:"in_work"`
+	} `yaml:"statuses"`
+}
+
+// TracksSchema describes the tracks database.
+type TracksSchema struct {
+	Properties struct {
+		Title  Property `yaml:"title"`
+		Status Property `yaml:"status"`
+	} `yaml:"properties"`
+
+	// ActiveStatuses are the statuses of tracks available to bot commands.
+	ActiveStatuses []string `yaml:"active_statuses"`
+}
+
+// Schema maps logical fields used by the bot to the properties and status
+// values of the Notion databases, so that renaming a column in Notion does
+// not require a code change.
+type Schema struct {
+	Tasks      TasksSchema  `yaml:"tasks"`
+	TweaksDemo TweaksSchema `yaml:"tweaks_demo"`
+	TweaksMix  TweaksSchema `yaml:"tweaks_mix"`
+	Tracks     TracksSchema `yaml:"tracks"`
+}
+
+func newTweaksSchema(statusType string) TweaksSchema {
+	var s TweaksSchema
+
+	s.Properties.Title = Property{"Кратко", PropertyTypeTitle}
+	s.Properties.Status = Property{"Статус", statusType}
+	s.Properties.Explanation = Property{"Пояснение", PropertyTypeRichText}
+	s.Properties.Track = Property{"Песня", PropertyTypeRelation}
+	s.Properties.TrackPart = Property{Name: "Дорожка"}
+	s.Properties.Start = Property{"Начало интервала", PropertyTypeRichText}
+	s.Properties.End = Property{"Конец интервала", PropertyTypeRichText}
+	s.Properties.Author = Property{"Автор (Manual)", PropertyTypePeople}
+	s.Properties.AuthorComputed = Property{Name: "Автор"}
+
+	return s
+}
+
+// DefaultSchema returns the schema of the databases the bot was written for.
+func DefaultSchema() *Schema {
+	s := &Schema{}
+
+	tasks := &s.Tasks
+	tasks.Properties.Title = Property{"Задача", PropertyTypeTitle}
+	tasks.Properties.Assignees = Property{"Исполнитель", PropertyTypePeople}
+	tasks.Properties.Status = Property{"Статус", PropertyTypeSelect}
+	tasks.Properties.Deadline = Property{"Дедлайн", PropertyTypeDate}
+	tasks.Properties.MovedToWork = Property{"_timeWhenMovedToWork", PropertyTypeDate}
+	tasks.Statuses.New = StatusNew
+	tasks.Statuses.Backlog = StatusBacklog
+	tasks.Statuses.Done = StatusDone
+	tasks.Statuses.Archived = StatusArchived
+
+	s.TweaksDemo = newTweaksSchema(PropertyTypeSelect)
+	s.TweaksDemo.Statuses.New = TweakDemoStatusTODO
+
+	s.TweaksMix = newTweaksSchema(PropertyTypeStatus)
+	s.TweaksMix.Statuses.New = TweakMixStatusAnalysis
+	s.TweaksMix.Statuses.Deferred = TweakMixStatusDeferred
+	s.TweaksMix.Statuses.Ready = TweakMixStatusReadyForWork
+	s.TweaksMix.Statuses.InWork = TweakMixStatusInWork
+
+	s.Tracks.Properties.Title = Property{"Название", PropertyTypeTitle}
+	s.Tracks.Properties.Status = Property{"Статус", PropertyTypeStatus}
+	s.Tracks.ActiveStatuses = []string{
+		TrackStatusDemo,
+		TrackStatusRecording,
+		TrackStatusMixing,
+		TrackStatusMixReady,
+	}
+
+	return s
+}
+
+// LoadSchema reads a YAML schema file. Everything missing from the file keeps
+// its value from DefaultSchema, unknown keys are rejected.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema: %w", err)
+	}
+
+	s, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// ParseSchema parses a YAML schema on top of DefaultSchema and validates it.
+func ParseSchema(data []byte) (*Schema, error) {
+	s := DefaultSchema()
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// schemaField is a property of a database the bot reads or writes.
+type schemaField struct {
+	db   string
+	name string
+	prop *Property
+	// types the property may have, empty for properties that are only read
+	// and rendered as text and therefore may be of any type or missing
+	types []string
+	// statuses the bot writes or filters by
+	statuses []string
+}
+
+func (f schemaField) path() string {
+	return f.db + "." + f.name
+}
+
+func (s *Schema) fields() []schemaField {
+	statusTypes := []string{PropertyTypeSelect, PropertyTypeStatus}
+
+	tasks := &s.Tasks.Properties
+	taskStatuses := s.Tasks.Statuses
+	fields := []schemaField{
+		{"tasks", "title", &tasks.Title, []string{PropertyTypeTitle}, nil},
+		{"tasks", "assignees", &tasks.Assignees, []string{PropertyTypePeople}, nil},
+		{"tasks", "status", &tasks.Status, statusTypes, []string{
+			taskStatuses.New, taskStatuses.Backlog, taskStatuses.Done, taskStatuses.Archived,
+		}},
+		{"tasks", "deadline", &tasks.Deadline, []string{PropertyTypeDate}, nil},
+		{"tasks", "moved_to_work", &tasks.MovedToWork, []string{PropertyTypeDate}, nil},
+	}
+
+	mixStatuses := s.TweaksMix.Statuses
+	for _, db := range []struct {
+		name     string
+		tweaks   *TweaksSchema
+		statuses []string
+	}{
+		{"tweaks_demo", &s.TweaksDemo, []string{s.TweaksDemo.Statuses.New}},
+		{"tweaks_mix", &s.TweaksMix, []string{
+			mixStatuses.New, mixStatuses.Deferred, mixStatuses.Ready, mixStatuses.InWork,
+		}},
+	} {
+		props := &db.tweaks.Properties
+		fields = append(fields,
+			schemaField{db.name, "title", &props.Title, []string{PropertyTypeTitle}, nil},
+			schemaField{db.name, "status", &props.Status, statusTypes, db.statuses},
+			schemaField{db.name, "explanation", &props.Explanation, []string{PropertyTypeRichText}, nil},
+			schemaField{db.name, "track", &props.Track, []string{PropertyTypeRelation}, nil},
+			schemaField{db.name, "track_part", &props.TrackPart, nil, nil},
+			schemaField{db.name, "start", &props.Start, []string{PropertyTypeRichText}, nil},
+			schemaField{db.name, "end", &props.End, []string{PropertyTypeRichText}, nil},
+			schemaField{db.name, "author", &props.Author, []string{PropertyTypePeople}, nil},
+			schemaField{db.name, "author_computed", &props.AuthorComputed, nil, nil},
+		)
+	}
+
+	tracks := &s.Tracks.Properties
+	fields = append(fields,
+		schemaField{"tracks", "title", &tracks.Title, []string{PropertyTypeTitle}, nil},
+		schemaField{"tracks", "status", &tracks.Status, statusTypes, s.Tracks.ActiveStatuses},
+	)
+
+	return fields
+}
+
+// Validate checks that every property has a name and a type the bot is able
+// to work with and that every status value is set.
+func (s *Schema) Validate() error {
+	var errs []error
+
+	for _, f := range s.fields() {
+		if f.prop.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: property name is empty", f.path()))
+		}
+		if len(f.types) > 0 && !slices.Contains(f.types, f.prop.Type) {
+			errs = append(errs, fmt.Errorf(
+				"%s: type must be one of %v, got %q", f.path(), f.types, f.prop.Type,
+			))
+		}
+	}
+
+	checkStatus := func(field, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s: status is empty", field))
+		}
+	}
+
+	checkStatus("tasks.statuses.new", s.Tasks.Statuses.New)
+	checkStatus("tasks.statuses.backlog", s.Tasks.Statuses.Backlog)
+	checkStatus("tasks.statuses.done", s.Tasks.Statuses.Done)
+	checkStatus("tasks.statuses.archived", s.Tasks.Statuses.Archived)
+	checkStatus("tweaks_demo.statuses.new", s.TweaksDemo.Statuses.New)
+
+	mix := s.TweaksMix.Statuses
+	checkStatus("tweaks_mix.statuses.new", mix.New)
+	checkStatus("tweaks_mix.statuses.deferred", mix.Deferred)
+	checkStatus("tweaks_mix.statuses.ready", mix.Ready)
+	checkStatus("tweaks_mix.statuses.in_work", mix.InWork)
+
+	if len(s.Tracks.ActiveStatuses) == 0 {
+		errs = append(errs, errors.New("tracks.active_statuses: no statuses"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetSchema replaces the default schema. It must be called before the client
+// is used concurrently.
+func (n *Notion) SetSchema(s *Schema) {
+	n.schema = s
+}
+
+// Schema returns the schema used by the client.
+func (n *Notion) Schema() *Schema {
+	return n.schema
+}
@@ -0,0 +1,181 @@
+package notion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSchema_OverlaysDefaults(t *testing.T) {
+	s, err := ParseSchema([]byte(`
+tasks:
+  properties:
+    title: Task
+    status: {name: State, type: status}
+  statuses:
+    done: Done
+tracks:
+  active_statuses: [Mixing]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := s.Tasks.Properties.Title; got != (Property{"Task", PropertyTypeTitle}) {
+		t.Errorf("unexpected title property: %+v", got)
+	}
+	if got := s.Tasks.Properties.Status; got != (Property{"State", PropertyTypeStatus}) {
+		t.Errorf("unexpected status property: %+v", got)
+	}
+	if s.Tasks.Statuses.Done != "Done" || s.Tasks.Statuses.New != StatusNew {
+		t.Errorf("unexpected statuses: %+v", s.Tasks.Statuses)
+	}
+	if s.Tasks.Properties.Deadline.Name != "Дедлайн" {
+		t.Errorf("expected deadline to keep the default name, got %q", s.Tasks.Properties.Deadline.Name)
+	}
+	if len(s.Tracks.ActiveStatuses) != 1 || s.Tracks.ActiveStatuses[0] != "Mixing" {
+		t.Errorf("unexpected active statuses: %v", s.Tracks.ActiveStatuses)
+	}
+}
+
+func TestParseSchema_Empty(t *testing.T) {
+	s, err := ParseSchema(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Tasks.Properties.Title.Name != "Задача" {
+		t.Errorf("expected default schema, got %+v", s.Tasks.Properties)
+	}
+}
+
+func TestParseSchema_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "unknown key",
+			input:   "tasks:\n  properties:\n    titel: Task\n",
+			wantErr: "field titel not found",
+		},
+		{
+			name:    "wrong type",
+			input:   "tasks:\n  properties:\n    status: {type: people}\n",
+			wantErr: `tasks.status: type must be one of [select status], got "people"`,
+		},
+		{
+			name:    "empty name",
+			input:   "tweaks_mix:\n  properties:\n    track: \"\"\n",
+			wantErr: "tweaks_mix.track: property name is empty",
+		},
+		{
+			name:    "empty status",
+			input:   "tweaks_mix:\n  statuses:\n    ready: \"\"\n",
+			wantErr: "tweaks_mix.statuses.ready: status is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.yaml")
+	content := []byte("tasks:\n  properties:\n    title: Task\n")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("failed to write schema: %v", err)
+	}
+
+	s, err := LoadSchema(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Tasks.Properties.Title.Name != "Task" {
+		t.Errorf("expected title Task, got %q", s.Tasks.Properties.Title.Name)
+	}
+
+	if _, err := LoadSchema(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestSchema_UsedForRequests(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		bodies = append(bodies, body)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(req.URL.Path, "/query") {
+			_, _ = w.Write([]byte(`{"results":[{"id":"task-1","properties":{` + //nolint:errcheck
+				`"Task":{"title":[{"plain_text":"Renamed"}]},` +
+				`"Owner":{"people":[{"id":"user-1"}]},` +
+				`"State":{"status":{"name":"Doing"}}}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"page-1"}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	s, err := ParseSchema([]byte(`
+tasks:
+  properties:
+    title: Task
+    assignees: Owner
+    status: {name: State, type: status}
+  statuses:
+    new: Todo
+    done: Done
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	n.SetSchema(s)
+
+	if _, err := n.CreateNotionTask(&CreateTaskRequest{
+		NotionDBID: "db", TaskName: "T", Assignees: []string{"user-1"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	props := bodies[0]["properties"].(map[string]interface{})
+	for _, name := range []string{"Task", "Owner", "State"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("expected property %q in the payload, got %v", name, props)
+		}
+	}
+	status := props["State"].(map[string]interface{})["status"].(map[string]interface{})
+	if status["name"] != "Todo" {
+		t.Errorf("expected status Todo, got %v", status["name"])
+	}
+
+	tasks, err := n.LoadTasks("db")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Title != "Renamed" || tasks[0].Status != "Doing" {
+		t.Fatalf("unexpected tasks: %+v", tasks)
+	}
+
+	filter, _ := json.Marshal(bodies[1]["filter"]) //nolint:errcheck
+	if !strings.Contains(string(filter), `{"property":"State","status":{"does_not_equal":"Done"}}`) {
+		t.Errorf("expected the filter to use the schema, got %s", filter)
+	}
+}
@@ -1,11 +1,12 @@
 package notion
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"strings"
 	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 type SetDeadlineRequest struct {
@@ -13,46 +14,42 @@ type SetDeadlineRequest struct {
 
 	TaskLink string
 	Deadline time.Time
-
-	Debug bool
 }
 
 func (n *Notion) SetDeadline(setRequest *SetDeadlineRequest) error {
-	pageID := extractPageID(setRequest.TaskLink)
+	return n.SetDeadlineContext(context.Background(), setRequest)
+}
+
+func (n *Notion) SetDeadlineContext(ctx context.Context, setRequest *SetDeadlineRequest) error {
+	pageID := PageIDFromLink(setRequest.TaskLink)
 	if pageID == "" {
 		return fmt.Errorf("invalid task link %s", setRequest.TaskLink)
 	}
 
-	payload := map[string]interface{}{
-		"properties": map[string]interface{}{
-			"Дедлайн": map[string]interface{}{
-				"date": map[string]string{
-					"start": setRequest.Deadline.Format("2006-01-02"),
-				},
-			},
-		},
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	req, err := http.NewRequest("PATCH", n.apiBaseURL+"pages/"+pageID, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+	return n.updatePage(ctx, pageID, property.Properties{
+		n.schema.Tasks.Properties.Deadline.Name: property.NewDate(setRequest.Deadline),
+	})
+}
 
-	resp, err := n.doWithRetries(req, body)
-	if err != nil {
-		return err
+// RestoreDeadlineContext sets the deadline of the task to a date as stored in
+// Notion, see TaskState. The date is written as is, a nil one clears the
+// deadline.
+func (n *Notion) RestoreDeadlineContext(
+	ctx context.Context, taskLink string, deadline *property.Date,
+) error {
+	pageID := PageIDFromLink(taskLink)
+	if pageID == "" {
+		return fmt.Errorf("invalid task link %s", taskLink)
 	}
-	defer resp.Body.Close()
 
-	return nil
+	return n.updatePage(ctx, pageID, property.Properties{
+		n.schema.Tasks.Properties.Deadline.Name: {Type: property.TypeDate, Date: deadline},
+	})
 }
 
-func extractPageID(link string) string {
+// PageIDFromLink returns the ID of the page from its link, empty if the link
+// holds no ID.
+func PageIDFromLink(link string) string {
 	parts := strings.Split(link, "/")
 	lastPart := parts[len(parts)-1]
 
@@ -2,6 +2,7 @@ package notion
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 const (
@@ -175,10 +178,61 @@ func TestExtractPageID(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractPageID(tt.link)
+			result := PageIDFromLink(tt.link)
 			if result != tt.expected {
 				t.Errorf("expected %s, got %s", tt.expected, result)
 			}
 		})
 	}
 }
+
+func TestRestoreDeadlineAndArchive(t *testing.T) {
+	var payloads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != testMethodPATCH || r.URL.Path != "/pages/12345678-9012-3456-7890-123456789012" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+		payloads = append(payloads, string(body))
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "page-123"})
+	}))
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	ctx := context.Background()
+	link := "https://www.notion.so/12345678901234567890123456789012"
+	end, timeZone := "2030-01-05", "Europe/Moscow"
+	for _, deadline := range []*property.Date{
+		{Start: "2030-01-02"},
+		{Start: "2030-01-02", End: &end},
+		{Start: "2030-01-02T10:00:00", TimeZone: &timeZone},
+		nil,
+	} {
+		if err := n.RestoreDeadlineContext(ctx, link, deadline); err != nil {
+			t.Fatalf("RestoreDeadlineContext(%v) returned error: %v", deadline, err)
+		}
+	}
+	if err := n.ArchivePageContext(ctx, "12345678-9012-3456-7890-123456789012"); err != nil {
+		t.Fatalf("ArchivePageContext returned error: %v", err)
+	}
+
+	want := []string{
+		`{"properties":{"Дедлайн":{"date":{"start":"2030-01-02"}}}}`,
+		`{"properties":{"Дедлайн":{"date":{"start":"2030-01-02","end":"2030-01-05"}}}}`,
+		`{"properties":{"Дедлайн":{"date":{"start":"2030-01-02T10:00:00",` +
+			`"time_zone":"Europe/Moscow"}}}}`,
+		`{"properties":{"Дедлайн":{"date":null}}}`,
+		`{"archived":true}`,
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("expected %d requests, got %d", len(want), len(payloads))
+	}
+	for i := range want {
+		if payloads[i] != want[i] {
+			t.Errorf("request %d: expected %s, got %s", i, want[i], payloads[i])
+		}
+	}
+}
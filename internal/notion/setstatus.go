@@ -1,49 +1,30 @@
 package notion
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 type SetStatusRequest struct {
 	TaskLink string
 	Status   string
-
-	Debug bool
 }
 
 func (n *Notion) SetStatus(setRequest *SetStatusRequest) error {
-	pageID := extractPageID(setRequest.TaskLink)
+	return n.SetStatusContext(context.Background(), setRequest)
+}
+
+func (n *Notion) SetStatusContext(ctx context.Context, setRequest *SetStatusRequest) error {
+	pageID := PageIDFromLink(setRequest.TaskLink)
 	if pageID == "" {
 		return fmt.Errorf("invalid task link %s", setRequest.TaskLink)
 	}
 
-	payload := map[string]interface{}{
-		"properties": map[string]interface{}{
-			"Статус": map[string]interface{}{
-				"select": map[string]string{
-					"name": setRequest.Status,
-				},
-			},
-		},
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-
-	req, err := http.NewRequest("PATCH", n.apiBaseURL+"pages/"+pageID, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := n.doWithRetries(req, body)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	statusProp := n.schema.Tasks.Properties.Status
 
-	return nil
+	return n.updatePage(ctx, pageID, property.Properties{
+		statusProp.Name: property.NewOption(property.Type(statusProp.Type), setRequest.Status),
+	})
 }
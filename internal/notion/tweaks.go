@@ -1,13 +1,12 @@
 package notion
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"net/http"
-	"path"
 	"sort"
 	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
 )
 
 // Tweak status constants for demo tweaks
@@ -23,7 +22,7 @@ const (
 	TweakMixStatusInWork       = "В работе"
 )
 
-// Track status constants included in the tracks cache.
+// Track status constants included in the tracks cache by default.
 const (
 	TrackStatusDemo      = "Демка"
 	TrackStatusRecording = "Запись"
@@ -32,19 +31,14 @@ const (
 )
 
 // createCachedTracksFilter creates a filter for tracks available to bot commands.
-func createCachedTracksFilter() map[string]interface{} {
-	cachedStatuses := []string{
-		TrackStatusDemo,
-		TrackStatusRecording,
-		TrackStatusMixing,
-		TrackStatusMixReady,
-	}
+func createCachedTracksFilter(s *TracksSchema) map[string]interface{} {
+	statusProp := s.Properties.Status
 
-	orFilters := make([]map[string]interface{}, 0, len(cachedStatuses))
-	for _, status := range cachedStatuses {
+	orFilters := make([]map[string]interface{}, 0, len(s.ActiveStatuses))
+	for _, status := range s.ActiveStatuses {
 		orFilters = append(orFilters, map[string]interface{}{
-			"property": "Статус",
-			"status": map[string]string{
+			"property": statusProp.Name,
+			statusProp.Type: map[string]string{
 				"equals": status,
 			},
 		})
@@ -65,26 +59,17 @@ func trackLinkFromPageID(pageID string) string {
 	return notionURL + strings.ReplaceAll(pageID, "-", "")
 }
 
-func parseTrackPages(result struct {
-	Results []struct {
-		ID         string `json:"id"`
-		Properties map[string]struct {
-			Title []struct {
-				PlainText string `json:"plain_text"`
-			} `json:"title"`
-		} `json:"properties"`
-	} `json:"results"`
-}) []TrackPage {
-	tracks := make([]TrackPage, 0, len(result.Results))
-
-	for _, r := range result.Results {
-		prop, ok := r.Properties["Название"]
-		if !ok || len(prop.Title) == 0 {
+func parseTrackPages(titleProperty string, results []page) []TrackPage {
+	tracks := make([]TrackPage, 0, len(results))
+
+	for _, r := range results {
+		title := r.Properties.PlainText(titleProperty)
+		if title == "" {
 			continue
 		}
 
 		tracks = append(tracks, TrackPage{
-			Title:  prop.Title[0].PlainText,
+			Title:  title,
 			PageID: r.ID,
 			Link:   trackLinkFromPageID(r.ID),
 		})
@@ -97,66 +82,41 @@ func parseTrackPages(result struct {
 	return tracks
 }
 
-func (n *Notion) loadTrackPages(dbID string, filter map[string]interface{}) ([]TrackPage, error) {
-	payload := map[string]interface{}{}
-	if filter != nil {
-		payload["filter"] = filter
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("could not marshal json payload: %w", err)
-	}
-
-	req, err := http.NewRequest(
-		"POST", n.apiBaseURL+path.Join("databases", dbID, "query"),
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not create a request: %w", err)
-	}
-
-	if n.debug {
-		url := n.apiBaseURL + path.Join("databases", dbID, "query")
-		log.Printf("Tracks url: %s", url)
-	}
-
-	resp, err := n.doWithRetries(req, body)
+func (n *Notion) loadTrackPages(
+	ctx context.Context, dbID string, filter map[string]interface{},
+) ([]TrackPage, error) {
+	results, err := queryAll[page](ctx, n, dbID, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Results []struct {
-			ID         string `json:"id"`
-			Properties map[string]struct {
-				Title []struct {
-					PlainText string `json:"plain_text"`
-				} `json:"title"`
-			} `json:"properties"`
-		} `json:"results"`
-	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-
-	return parseTrackPages(result), nil
+	return parseTrackPages(n.schema.Tracks.Properties.Title.Name, results), nil
 }
 
 func (n *Notion) LoadTrackPages(dbID string) ([]TrackPage, error) {
-	return n.loadTrackPages(dbID, createCachedTracksFilter())
+	return n.LoadTrackPagesContext(context.Background(), dbID)
+}
+
+func (n *Notion) LoadTrackPagesContext(ctx context.Context, dbID string) ([]TrackPage, error) {
+	return n.loadTrackPages(ctx, dbID, createCachedTracksFilter(&n.schema.Tracks))
 }
 
 func (n *Notion) LoadAllTrackPages(dbID string) ([]TrackPage, error) {
-	return n.loadTrackPages(dbID, nil)
+	return n.LoadAllTrackPagesContext(context.Background(), dbID)
 }
 
-// LoadTracks queries the tracks database and returns a list of track titles (property "Название")
+func (n *Notion) LoadAllTrackPagesContext(ctx context.Context, dbID string) ([]TrackPage, error) {
+	return n.loadTrackPages(ctx, dbID, nil)
+}
+
+// LoadTracks queries the tracks database and returns a list of track titles
 // Only returns tracks in statuses available to bot commands.
 func (n *Notion) LoadTracks(dbID string) (map[string]string, error) {
-	tracks, err := n.LoadTrackPages(dbID)
+	return n.LoadTracksContext(context.Background(), dbID)
+}
+
+func (n *Notion) LoadTracksContext(ctx context.Context, dbID string) (map[string]string, error) {
+	tracks, err := n.LoadTrackPagesContext(ctx, dbID)
 	if err != nil {
 		return nil, err
 	}
@@ -177,7 +137,11 @@ type CreateTweakRequest struct {
 	End              string
 	Explanation      string
 	AuthorNotionUser string
-	StatusType       string // "select" or "status"
+	StatusType       string // "select" or "status", taken from the schema
+
+	// IdempotencyKey is set when the tweak is created, a request sent again
+	// with the key creates at most one tweak.
+	IdempotencyKey string
 }
 
 type RenderTweak struct {
@@ -190,141 +154,89 @@ type RenderTweak struct {
 	Author      string
 }
 
-func (n *Notion) createTweak(dbID, status string, r *CreateTweakRequest) (string, error) {
+func (n *Notion) createTweak(
+	ctx context.Context, dbID string, s *TweaksSchema, r *CreateTweakRequest,
+) (string, error) {
 	if dbID == "" {
 		return "", fmt.Errorf("database ID is empty")
 	}
 
-	titleProperty := "Кратко"
+	schemaProps := s.Properties
 
-	// Status field can be either "select" or "status" type
-	var statusField map[string]interface{}
-	if r.StatusType == "status" {
-		statusField = map[string]interface{}{
-			"status": map[string]string{
-				"name": status,
-			},
-		}
-	} else {
-		statusField = map[string]interface{}{
-			"select": map[string]string{
-				"name": status,
-			},
-		}
+	payload := &createPayload{}
+	payload.Parent.DatabaseID = dbID
+	payload.Properties = property.Properties{
+		schemaProps.Title.Name: property.NewTitle(r.Title),
+		schemaProps.Status.Name: property.NewOption(
+			property.Type(r.StatusType), s.Statuses.New,
+		),
 	}
 
-	payload := map[string]interface{}{
-		"parent": map[string]string{"database_id": dbID},
-		"properties": map[string]interface{}{
-			titleProperty: map[string]interface{}{
-				"title": []map[string]interface{}{
-					{"text": map[string]string{"content": r.Title}},
-				},
-			},
-			"Статус": statusField,
-		},
-	}
+	// Optional properties
+	props := payload.Properties
 
 	if r.Explanation != "" {
-		payload["properties"].(map[string]interface{})["Пояснение"] = map[string]interface{}{
-			"rich_text": []map[string]interface{}{
-				{"text": map[string]string{"content": r.Explanation}},
-			},
-		}
+		props[schemaProps.Explanation.Name] = property.NewRichText(r.Explanation)
 	}
-
 	if r.TrackPageID != "" {
-		payload["properties"].(map[string]interface{})["Песня"] = map[string]interface{}{
-			"relation": []map[string]string{{"id": r.TrackPageID}},
-		}
+		props[schemaProps.Track.Name] = property.NewRelation(r.TrackPageID)
 	}
-
-	// Optional properties
-	props := payload["properties"].(map[string]interface{})
-
 	if r.Start != "" {
-		props["Начало интервала"] = map[string]interface{}{
-			"rich_text": []map[string]interface{}{
-				{"text": map[string]string{"content": r.Start}},
-			},
-		}
+		props[schemaProps.Start.Name] = property.NewRichText(r.Start)
 	}
 	if r.End != "" {
-		props["Конец интервала"] = map[string]interface{}{
-			"rich_text": []map[string]interface{}{
-				{"text": map[string]string{"content": r.End}},
-			},
-		}
+		props[schemaProps.End.Name] = property.NewRichText(r.End)
 	}
 	if r.AuthorNotionUser != "" {
-		props["Автор (Manual)"] = map[string]interface{}{
-			"people": []map[string]string{
-				{
-					"object": "user",
-					"id":     r.AuthorNotionUser,
-				},
-			},
-		}
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", fmt.Errorf("could not marshal request: %w", err)
+		props[schemaProps.Author.Name] = property.NewPeople(r.AuthorNotionUser)
 	}
 
-	if n.debug {
-		prettyPayload, _ := json.MarshalIndent(payload, "", "  ") //nolint:errcheck
-		log.Printf("Tweaks payload: %s", string(prettyPayload))
-	}
-
-	req, err := http.NewRequest("POST", n.apiBaseURL+"pages", nil)
-	if err != nil {
-		return "", fmt.Errorf("could not create a request: %w", err)
-	}
-
-	resp, err := n.doWithRetries(req, body)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		ID string `json:"id"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	cleanID := strings.ReplaceAll(result.ID, "-", "")
-	url := notionURL + cleanID
-	return url, nil
+	return n.createPage(ctx, payload, &r.IdempotencyKey)
 }
 
 func (n *Notion) CreateTweakDemo(r *CreateTweakRequest) (string, error) {
+	return n.CreateTweakDemoContext(context.Background(), r)
+}
+
+func (n *Notion) CreateTweakDemoContext(
+	ctx context.Context, r *CreateTweakRequest,
+) (string, error) {
 	if n.tweaksDemoDBID == "" {
 		return "", fmt.Errorf("tweaks demo DB ID is not set")
 	}
-	r.StatusType = "select"
-	return n.createTweak(n.tweaksDemoDBID, TweakDemoStatusTODO, r)
+	r.StatusType = n.schema.TweaksDemo.Properties.Status.Type
+	return n.createTweak(ctx, n.tweaksDemoDBID, &n.schema.TweaksDemo, r)
 }
 
 func (n *Notion) CreateTweakMix(r *CreateTweakRequest) (string, error) {
+	return n.CreateTweakMixContext(context.Background(), r)
+}
+
+func (n *Notion) CreateTweakMixContext(
+	ctx context.Context, r *CreateTweakRequest,
+) (string, error) {
 	if n.tweaksMixDBID == "" {
 		return "", fmt.Errorf("tweaks mix DB ID is not set")
 	}
-	r.StatusType = "status"
-	return n.createTweak(n.tweaksMixDBID, TweakMixStatusAnalysis, r)
+	r.StatusType = n.schema.TweaksMix.Properties.Status.Type
+	return n.createTweak(ctx, n.tweaksMixDBID, &n.schema.TweaksMix, r)
 }
 
 func (n *Notion) LoadReadyMixTweaksForTrack(trackPageID string) ([]RenderTweak, error) {
-	pages, err := n.loadReadyMixTweakPagesForTrack(trackPageID)
+	return n.LoadReadyMixTweaksForTrackContext(context.Background(), trackPageID)
+}
+
+func (n *Notion) LoadReadyMixTweaksForTrackContext(
+	ctx context.Context, trackPageID string,
+) ([]RenderTweak, error) {
+	pages, err := n.loadReadyMixTweakPagesForTrack(ctx, trackPageID)
 	if err != nil {
 		return nil, err
 	}
 
 	tweaks := make([]RenderTweak, 0, len(pages))
 	for _, page := range pages {
-		tweak := parseRenderTweak(page.Properties)
+		tweak := parseRenderTweak(&n.schema.TweaksMix, page.Properties)
 		tweak.ID = page.ID
 		tweaks = append(tweaks, tweak)
 	}
@@ -332,7 +244,13 @@ func (n *Notion) LoadReadyMixTweaksForTrack(trackPageID string) ([]RenderTweak,
 }
 
 func (n *Notion) CountUnreadyMixTweaksForTrack(trackPageID string) (int, error) {
-	pages, err := n.loadUnreadyMixTweakPagesForTrack(trackPageID)
+	return n.CountUnreadyMixTweaksForTrackContext(context.Background(), trackPageID)
+}
+
+func (n *Notion) CountUnreadyMixTweaksForTrackContext(
+	ctx context.Context, trackPageID string,
+) (int, error) {
+	pages, err := n.loadUnreadyMixTweakPagesForTrack(ctx, trackPageID)
 	if err != nil {
 		return 0, err
 	}
@@ -341,62 +259,81 @@ func (n *Notion) CountUnreadyMixTweaksForTrack(trackPageID string) (int, error)
 }
 
 func (n *Notion) MoveReadyMixTweaksToWorkForTrack(trackPageID string) (int, error) {
-	pages, err := n.loadReadyMixTweakPagesForTrack(trackPageID)
+	moved, err := n.MoveReadyMixTweaksToWorkForTrackContext(context.Background(), trackPageID)
+	return len(moved), err
+}
+
+// MoveReadyMixTweaksToWorkForTrackContext moves ready tweaks of the track to
+// work one by one and returns the IDs of the moved ones. When the context is
+// cancelled in the middle, the tweaks updated so far stay in work and their
+// IDs are returned along with the error.
+func (n *Notion) MoveReadyMixTweaksToWorkForTrackContext(
+	ctx context.Context, trackPageID string,
+) ([]string, error) {
+	pages, err := n.loadReadyMixTweakPagesForTrack(ctx, trackPageID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
+	moved := make([]string, 0, len(pages))
 	for _, page := range pages {
-		if err := n.setMixTweakStatus(page.ID, TweakMixStatusInWork); err != nil {
-			return 0, fmt.Errorf("failed to update tweak %s status: %w", page.ID, err)
+		if err := n.setMixTweakStatus(ctx, page.ID, n.schema.TweaksMix.Statuses.InWork); err != nil {
+			return moved, fmt.Errorf("failed to update tweak %s status: %w", page.ID, err)
 		}
+		moved = append(moved, page.ID)
 	}
 
-	return len(pages), nil
+	return moved, nil
 }
 
-type mixTweakPage struct {
-	ID         string                    `json:"id"`
-	Properties map[string]notionProperty `json:"properties"`
+func (n *Notion) loadReadyMixTweakPagesForTrack(
+	ctx context.Context, trackPageID string,
+) ([]page, error) {
+	return n.loadMixTweakPagesForTrack(ctx, trackPageID, "equals", n.schema.TweaksMix.Statuses.Ready)
 }
 
-func (n *Notion) loadReadyMixTweakPagesForTrack(trackPageID string) ([]mixTweakPage, error) {
-	return n.loadMixTweakPagesForTrack(trackPageID, "equals", TweakMixStatusReadyForWork)
-}
+func (n *Notion) loadUnreadyMixTweakPagesForTrack(
+	ctx context.Context, trackPageID string,
+) ([]page, error) {
+	mix := &n.schema.TweaksMix
+	statusProp := mix.Properties.Status
 
-func (n *Notion) loadUnreadyMixTweakPagesForTrack(trackPageID string) ([]mixTweakPage, error) {
 	statusFilters := make([]map[string]interface{}, 0, 2)
-	for _, status := range []string{TweakMixStatusAnalysis, TweakMixStatusDeferred} {
+	for _, status := range []string{mix.Statuses.New, mix.Statuses.Deferred} {
 		statusFilters = append(statusFilters, map[string]interface{}{
-			"property": "Статус",
-			"status": map[string]string{
+			"property": statusProp.Name,
+			statusProp.Type: map[string]string{
 				"equals": status,
 			},
 		})
 	}
 
-	return n.loadMixTweakPagesForTrackWithStatusFilter(trackPageID, map[string]interface{}{
+	return n.loadMixTweakPagesForTrackWithStatusFilter(ctx, trackPageID, map[string]interface{}{
 		"or": statusFilters,
 	})
 }
 
 func (n *Notion) loadMixTweakPagesForTrack(
+	ctx context.Context,
 	trackPageID string,
 	statusFilterOperator string,
 	status string,
-) ([]mixTweakPage, error) {
-	return n.loadMixTweakPagesForTrackWithStatusFilter(trackPageID, map[string]interface{}{
-		"property": "Статус",
-		"status": map[string]string{
+) ([]page, error) {
+	statusProp := n.schema.TweaksMix.Properties.Status
+
+	return n.loadMixTweakPagesForTrackWithStatusFilter(ctx, trackPageID, map[string]interface{}{
+		"property": statusProp.Name,
+		statusProp.Type: map[string]string{
 			statusFilterOperator: status,
 		},
 	})
 }
 
 func (n *Notion) loadMixTweakPagesForTrackWithStatusFilter(
+	ctx context.Context,
 	trackPageID string,
 	statusFilter map[string]interface{},
-) ([]mixTweakPage, error) {
+) ([]page, error) {
 	if n.tweaksMixDBID == "" {
 		return nil, fmt.Errorf("tweaks mix DB ID is not set")
 	}
@@ -404,148 +341,63 @@ func (n *Notion) loadMixTweakPagesForTrackWithStatusFilter(
 		return nil, fmt.Errorf("track page ID is empty")
 	}
 
-	payload := map[string]interface{}{
-		"filter": map[string]interface{}{
-			"and": []map[string]interface{}{
-				{
-					"property": "Песня",
-					"relation": map[string]string{
-						"contains": trackPageID,
-					},
+	filter := map[string]interface{}{
+		"and": []map[string]interface{}{
+			{
+				"property": n.schema.TweaksMix.Properties.Track.Name,
+				"relation": map[string]string{
+					"contains": trackPageID,
 				},
-				statusFilter,
 			},
+			statusFilter,
 		},
 	}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("could not marshal json payload: %w", err)
-	}
+	return queryAll[page](ctx, n, n.tweaksMixDBID, filter)
+}
 
-	req, err := http.NewRequest(
-		"POST", n.apiBaseURL+path.Join("databases", n.tweaksMixDBID, "query"), nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not create a request: %w", err)
-	}
+// SetMixTweakStatusContext sets the status of the mix tweak, e.g. to move it
+// back from work.
+func (n *Notion) SetMixTweakStatusContext(ctx context.Context, pageID, status string) error {
+	return n.setMixTweakStatus(ctx, pageID, status)
+}
 
-	resp, err := n.doWithRetries(req, body)
+// LoadMixTweakStatusContext loads the status of the mix tweak.
+func (n *Notion) LoadMixTweakStatusContext(ctx context.Context, pageID string) (string, error) {
+	result, err := n.getPage(ctx, pageID)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Results []mixTweakPage `json:"results"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+		return "", fmt.Errorf("could not load tweak %s: %w", pageID, err)
 	}
 
-	return result.Results, nil
+	return result.Properties[n.schema.TweaksMix.Properties.Status.Name].OptionName(), nil
 }
 
-func (n *Notion) setMixTweakStatus(pageID, status string) error {
+func (n *Notion) setMixTweakStatus(ctx context.Context, pageID, status string) error {
 	if strings.TrimSpace(pageID) == "" {
 		return fmt.Errorf("tweak page ID is empty")
 	}
 
-	payload := map[string]interface{}{
-		"properties": map[string]interface{}{
-			"Статус": map[string]interface{}{
-				"status": map[string]string{
-					"name": status,
-				},
-			},
-		},
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("could not marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest("PATCH", n.apiBaseURL+path.Join("pages", pageID), nil)
-	if err != nil {
-		return fmt.Errorf("could not create a request: %w", err)
-	}
-
-	resp, err := n.doWithRetries(req, body)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
-}
+	statusProp := n.schema.TweaksMix.Properties.Status
 
-type notionProperty struct {
-	Title    []plainTextPart `json:"title"`
-	RichText []plainTextPart `json:"rich_text"`
-	Select   *struct {
-		Name string `json:"name"`
-	} `json:"select"`
-	Status *struct {
-		Name string `json:"name"`
-	} `json:"status"`
-	People []struct {
-		Name string `json:"name"`
-		ID   string `json:"id"`
-	} `json:"people"`
+	return n.updatePage(ctx, pageID, property.Properties{
+		statusProp.Name: property.NewOption(property.Type(statusProp.Type), status),
+	})
 }
 
-type plainTextPart struct {
-	PlainText string `json:"plain_text"`
-}
+func parseRenderTweak(s *TweaksSchema, props property.Properties) RenderTweak {
+	names := s.Properties
 
-func parseRenderTweak(props map[string]notionProperty) RenderTweak {
 	return RenderTweak{
-		Summary:     propertyText(props, "Кратко"),
-		TrackPart:   propertyText(props, "Дорожка"),
-		Start:       propertyText(props, "Начало интервала"),
-		End:         propertyText(props, "Конец интервала"),
-		Explanation: propertyText(props, "Пояснение"),
-		Author:      firstNonEmpty(propertyText(props, "Автор"), propertyText(props, "Автор (Manual)")),
-	}
-}
-
-func propertyText(props map[string]notionProperty, name string) string {
-	prop, ok := props[name]
-	if !ok {
-		return ""
-	}
-
-	switch {
-	case len(prop.Title) > 0:
-		return plainText(prop.Title)
-	case len(prop.RichText) > 0:
-		return plainText(prop.RichText)
-	case prop.Select != nil:
-		return prop.Select.Name
-	case prop.Status != nil:
-		return prop.Status.Name
-	case len(prop.People) > 0:
-		names := make([]string, 0, len(prop.People))
-		for _, person := range prop.People {
-			if person.Name != "" {
-				names = append(names, person.Name)
-			} else if person.ID != "" {
-				names = append(names, person.ID)
-			}
-		}
-		return strings.Join(names, ", ")
-	default:
-		return ""
-	}
-}
-
-func plainText(parts []plainTextPart) string {
-	var b strings.Builder
-	for _, part := range parts {
-		b.WriteString(part.PlainText)
+		Summary:     props.PlainText(names.Title.Name),
+		TrackPart:   props.PlainText(names.TrackPart.Name),
+		Start:       props.PlainText(names.Start.Name),
+		End:         props.PlainText(names.End.Name),
+		Explanation: props.PlainText(names.Explanation.Name),
+		Author: firstNonEmpty(
+			props.PlainText(names.AuthorComputed.Name),
+			props.PlainText(names.Author.Name),
+		),
 	}
-	return b.String()
 }
 
 func firstNonEmpty(values ...string) string {
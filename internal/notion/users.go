@@ -0,0 +1,85 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// User is a person of the workspace.
+type User struct {
+	ID    string
+	Name  string
+	Email string
+}
+
+type userResult struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Person struct {
+		Email string `json:"email"`
+	} `json:"person"`
+}
+
+// ListUsersContext returns the people of the workspace, bots are skipped. The
+// integration needs the user information capability to read emails.
+func (n *Notion) ListUsersContext(ctx context.Context) ([]User, error) {
+	var (
+		users  []User
+		cursor string
+	)
+
+	for pages := 0; pages < n.queryMaxPages; pages++ {
+		page, err := n.listUsersPage(ctx, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("could not list Notion users: %w", err)
+		}
+
+		for _, u := range page.Results {
+			if u.Type != "person" {
+				continue
+			}
+			users = append(users, User{ID: u.ID, Name: u.Name, Email: u.Person.Email})
+		}
+
+		if !page.HasMore || page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	return users, nil
+}
+
+func (n *Notion) listUsersPage(
+	ctx context.Context, cursor string,
+) (*queryResponse[userResult], error) {
+	params := url.Values{"page_size": {strconv.Itoa(n.queryPageSize)}}
+	if cursor != "" {
+		params.Set("start_cursor", cursor)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, n.apiBaseURL+"users?"+params.Encode(), nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+
+	resp, err := n.doWithRetries(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result queryResponse[userResult]
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,43 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListUsersSkipsBots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || req.URL.Path != "/users" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+			"results": []map[string]interface{}{
+				{
+					"id": "user-1", "type": "person", "name": "Alice",
+					"person": map[string]string{"email": "alice@example.com"},
+				},
+				{"id": "bot-1", "type": "bot", "name": "Integration"},
+			},
+			"has_more": false,
+		})
+	}))
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	users, err := n.ListUsersContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := User{ID: "user-1", Name: "Alice", Email: "alice@example.com"}
+	if len(users) != 1 || users[0] != want {
+		t.Errorf("expected %+v, got %+v", want, users)
+	}
+}
@@ -0,0 +1,248 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+)
+
+// DatabaseIDs lists the databases checked by ValidateSchemaContext. Empty IDs
+// are skipped.
+type DatabaseIDs struct {
+	Tasks      string
+	TweaksDemo string
+	TweaksMix  string
+	Tracks     string
+}
+
+func (ids DatabaseIDs) byName() []struct{ name, id string } {
+	return []struct{ name, id string }{
+		{"tasks", ids.Tasks},
+		{"tweaks_demo", ids.TweaksDemo},
+		{"tweaks_mix", ids.TweaksMix},
+		{"tracks", ids.Tracks},
+	}
+}
+
+// SchemaIssue is a single mismatch between the schema and a live database.
+// Warnings do not prevent the bot from working.
+type SchemaIssue struct {
+	Field   string
+	Message string
+	Warning bool
+}
+
+func (i SchemaIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+
+	return fmt.Sprintf("%s: %s: %s", level, i.Field, i.Message)
+}
+
+// SchemaReport is the result of checking the schema against live databases.
+type SchemaReport struct {
+	Issues []SchemaIssue
+}
+
+func (r *SchemaReport) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if !issue.Warning {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *SchemaReport) String() string {
+	if len(r.Issues) == 0 {
+		return "schema matches the Notion databases"
+	}
+
+	lines := make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		lines = append(lines, issue.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (r *SchemaReport) add(field string, warning bool, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, SchemaIssue{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+type databaseOptions struct {
+	Options []property.Option `json:"options"`
+}
+
+type databaseProperty struct {
+	Type   string           `json:"type"`
+	Select *databaseOptions `json:"select"`
+	Status *databaseOptions `json:"status"`
+}
+
+func (p databaseProperty) hasOption(name string) bool {
+	options := p.Select
+	if p.Type == PropertyTypeStatus {
+		options = p.Status
+	}
+	if options == nil {
+		return false
+	}
+
+	for _, option := range options.Options {
+		if option.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+type database struct {
+	Properties map[string]databaseProperty `json:"properties"`
+}
+
+func (n *Notion) loadDatabase(ctx context.Context, dbID string) (*database, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, n.apiBaseURL+path.Join("databases", dbID), nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+
+	resp, err := n.doWithRetries(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var db database
+	if err := json.NewDecoder(resp.Body).Decode(&db); err != nil {
+		return nil, fmt.Errorf("could not decode database: %w", err)
+	}
+
+	return &db, nil
+}
+
+func (n *Notion) ValidateSchema(ids DatabaseIDs) *SchemaReport {
+	return n.ValidateSchemaContext(context.Background(), ids)
+}
+
+// ValidateSchemaContext loads the databases and checks that every property the
+// bot reads or writes exists and has the expected type, and that the status
+// values it uses are among the options of status properties.
+//
+// Status properties may be either select or status in Notion, the type found
+// in the database replaces the one from the schema, likewise the pages of a
+// database lacking the idempotency key property are created without keys.
+// That's why it must be called before the client is used concurrently.
+func (n *Notion) ValidateSchemaContext(ctx context.Context, ids DatabaseIDs) *SchemaReport {
+	report := &SchemaReport{}
+	fields := n.schema.fields()
+
+	for _, db := range ids.byName() {
+		if db.id == "" {
+			continue
+		}
+
+		live, err := n.loadDatabase(ctx, db.id)
+		if err != nil {
+			report.add(db.name, false, "could not load database %s: %v", db.id, err)
+			continue
+		}
+
+		for _, f := range fields {
+			if f.db == db.name {
+				validateField(report, f, live)
+			}
+		}
+
+		if n.idempotencyKeyProperty != "" && db.name != "tracks" {
+			n.validateIdempotencyKey(report, db.name, db.id, live)
+		}
+	}
+
+	return report
+}
+
+// validateIdempotencyKey checks the idempotency key property of the database.
+// Without it the pages of the database are created with no key, which is
+// reported as a warning, so that the bot still works with the databases that
+// have not been migrated yet.
+func (n *Notion) validateIdempotencyKey(
+	report *SchemaReport, name, dbID string, live *database,
+) {
+	field := name + ".idempotency_key"
+
+	prop, ok := live.Properties[n.idempotencyKeyProperty]
+	switch {
+	case !ok:
+		report.add(field, true, "property %q does not exist, creating pages without keys",
+			n.idempotencyKeyProperty)
+	case prop.Type != PropertyTypeRichText:
+		report.add(field, true, "property %q has type %s, expected %s, creating pages without keys",
+			n.idempotencyKeyProperty, prop.Type, PropertyTypeRichText)
+	default:
+		return
+	}
+
+	if n.noIdempotencyKey == nil {
+		n.noIdempotencyKey = make(map[string]bool)
+	}
+	n.noIdempotencyKey[dbID] = true
+}
+
+func validateField(report *SchemaReport, f schemaField, live *database) {
+	prop, ok := live.Properties[f.prop.Name]
+	if !ok {
+		// properties that are only rendered may be absent
+		report.add(f.path(), len(f.types) == 0, "property %q does not exist", f.prop.Name)
+		return
+	}
+
+	if len(f.types) == 0 {
+		return
+	}
+
+	if !slices.Contains(f.types, prop.Type) {
+		report.add(
+			f.path(), false, "property %q has type %s, expected %s",
+			f.prop.Name, prop.Type, strings.Join(f.types, " or "),
+		)
+		return
+	}
+
+	if prop.Type != f.prop.Type {
+		report.add(
+			f.path(), true, "property %q has type %s instead of %s, using %s",
+			f.prop.Name, prop.Type, f.prop.Type, prop.Type,
+		)
+		f.prop.Type = prop.Type
+	}
+
+	for _, status := range f.statuses {
+		if prop.hasOption(status) {
+			continue
+		}
+
+		// a missing select option is created by Notion on the first write,
+		// a missing status option can only be added in the UI
+		report.add(
+			f.path(), prop.Type == PropertyTypeSelect, "property %q has no option %q",
+			f.prop.Name, status,
+		)
+	}
+}
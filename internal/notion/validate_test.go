@@ -0,0 +1,210 @@
+package notion
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func options(names ...string) map[string]interface{} {
+	opts := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		opts = append(opts, map[string]string{"name": name})
+	}
+
+	return map[string]interface{}{"options": opts}
+}
+
+func liveTweaksDB(statusType string, statuses ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"Кратко":           map[string]interface{}{"type": "title"},
+		"Статус":           map[string]interface{}{"type": statusType, statusType: options(statuses...)},
+		"Пояснение":        map[string]interface{}{"type": "rich_text"},
+		"Песня":            map[string]interface{}{"type": "relation"},
+		"Дорожка":          map[string]interface{}{"type": "select"},
+		"Начало интервала": map[string]interface{}{"type": "rich_text"},
+		"Конец интервала":  map[string]interface{}{"type": "rich_text"},
+		"Автор (Manual)":   map[string]interface{}{"type": "people"},
+		"Автор":            map[string]interface{}{"type": "created_by"},
+		"_idempotencyKey":  map[string]interface{}{"type": "rich_text"},
+	}
+}
+
+// liveDatabases returns databases matching DefaultSchema.
+func liveDatabases() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
+		"tasks-db": {
+			"Задача":      map[string]interface{}{"type": "title"},
+			"Исполнитель": map[string]interface{}{"type": "people"},
+			"Статус": map[string]interface{}{
+				"type":   "select",
+				"select": options(StatusNew, StatusBacklog, StatusDone, StatusArchived),
+			},
+			"Дедлайн":              map[string]interface{}{"type": "date"},
+			"_timeWhenMovedToWork": map[string]interface{}{"type": "date"},
+			"_idempotencyKey":      map[string]interface{}{"type": "rich_text"},
+		},
+		"demo-db": liveTweaksDB("select", TweakDemoStatusTODO),
+		"mix-db": liveTweaksDB(
+			"status", TweakMixStatusAnalysis, TweakMixStatusDeferred,
+			TweakMixStatusReadyForWork, TweakMixStatusInWork,
+		),
+		"tracks-db": {
+			"Название": map[string]interface{}{"type": "title"},
+			"Статус": map[string]interface{}{
+				"type": "status",
+				"status": options(
+					TrackStatusDemo, TrackStatusRecording, TrackStatusMixing, TrackStatusMixReady,
+				),
+			},
+		},
+	}
+}
+
+func newDatabasesServer(t *testing.T, dbs map[string]map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			t.Errorf("unexpected method %s", req.Method)
+		}
+
+		props, ok := dbs[strings.TrimPrefix(req.URL.Path, "/databases/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"properties": props}) //nolint:errcheck
+	}))
+}
+
+var testDatabaseIDs = DatabaseIDs{
+	Tasks:      "tasks-db",
+	TweaksDemo: "demo-db",
+	TweaksMix:  "mix-db",
+	Tracks:     "tracks-db",
+}
+
+func TestValidateSchema_Matches(t *testing.T) {
+	server := newDatabasesServer(t, liveDatabases())
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	report := n.ValidateSchema(testDatabaseIDs)
+	if len(report.Issues) != 0 {
+		t.Fatalf("expected no issues, got:\n%s", report)
+	}
+}
+
+func TestValidateSchema_Problems(t *testing.T) {
+	dbs := liveDatabases()
+	delete(dbs["tasks-db"], "Дедлайн")
+	dbs["tasks-db"]["Исполнитель"] = map[string]interface{}{"type": "rich_text"}
+	dbs["mix-db"]["Статус"] = map[string]interface{}{
+		"type": "status", "status": options(TweakMixStatusAnalysis),
+	}
+	delete(dbs["demo-db"], "_idempotencyKey")
+	delete(dbs["demo-db"], "Дорожка")
+	delete(dbs, "tracks-db")
+
+	server := newDatabasesServer(t, dbs)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	n.SetIdempotencyKeyProperty("_idempotencyKey")
+
+	report := n.ValidateSchema(testDatabaseIDs)
+	if !report.HasErrors() {
+		t.Fatal("expected errors")
+	}
+
+	want := []string{
+		`error: tasks.assignees: property "Исполнитель" has type rich_text, expected people`,
+		`error: tasks.deadline: property "Дедлайн" does not exist`,
+		`warning: tweaks_demo.track_part: property "Дорожка" does not exist`,
+		`warning: tweaks_demo.idempotency_key: property "_idempotencyKey" does not exist, ` +
+			`creating pages without keys`,
+		`error: tweaks_mix.status: property "Статус" has no option "Отложено"`,
+		`error: tweaks_mix.status: property "Статус" has no option "Готово к работе"`,
+		`error: tweaks_mix.status: property "Статус" has no option "В работе"`,
+		`error: tracks: could not load database tracks-db: request to Notion API failed: ` +
+			`status code is 404`,
+	}
+	if got := report.String(); got != strings.Join(want, "\n") {
+		t.Fatalf("unexpected report:\n%s", got)
+	}
+
+	if got := n.idempotencyKeyPropertyOf("demo-db"); got != "" {
+		t.Errorf("expected no idempotency key for demo-db, got %q", got)
+	}
+	if got := n.idempotencyKeyPropertyOf("tasks-db"); got != "_idempotencyKey" {
+		t.Errorf("expected idempotency key for tasks-db, got %q", got)
+	}
+}
+
+func TestValidateSchema_DetectsStatusType(t *testing.T) {
+	dbs := liveDatabases()
+	dbs["demo-db"]["Статус"] = map[string]interface{}{
+		"type": "status", "status": options(TweakDemoStatusTODO),
+	}
+	dbs["tasks-db"]["Статус"] = map[string]interface{}{
+		"type": "status", "status": options(StatusNew, StatusBacklog, StatusDone),
+	}
+
+	server := newDatabasesServer(t, dbs)
+	defer server.Close()
+
+	n := NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+
+	report := n.ValidateSchema(testDatabaseIDs)
+
+	want := []string{
+		`warning: tasks.status: property "Статус" has type status instead of select, using status`,
+		`error: tasks.status: property "Статус" has no option "архивировано"`,
+		`warning: tweaks_demo.status: property "Статус" has type status instead of select, ` +
+			`using status`,
+	}
+	if got := report.String(); got != strings.Join(want, "\n") {
+		t.Fatalf("unexpected report:\n%s", got)
+	}
+
+	if got := n.Schema().TweaksDemo.Properties.Status.Type; got != PropertyTypeStatus {
+		t.Fatalf("expected detected status type to be used, got %s", got)
+	}
+
+	var created map[string]interface{}
+	createServer := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, req *http.Request,
+	) {
+		if err := json.NewDecoder(req.Body).Decode(&created); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"tweak-1"}`)) //nolint:errcheck
+	}))
+	defer createServer.Close()
+
+	n.SetAPIBaseURL(createServer.URL + "/")
+	n.SetTweaksDBIDs("demo-db", "mix-db")
+
+	r := &CreateTweakRequest{Title: "Tweak"}
+	if _, err := n.CreateTweakDemo(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.StatusType != PropertyTypeStatus {
+		t.Fatalf("expected tweak status type %s, got %s", PropertyTypeStatus, r.StatusType)
+	}
+	status := created["properties"].(map[string]interface{})["Статус"].(map[string]interface{})
+	if _, ok := status["status"]; !ok {
+		t.Fatalf("expected the status to be written as status, got %v", status)
+	}
+}
@@ -1,50 +1,84 @@
 package pinger
 
 import (
+	"context"
 	"fmt"
 	"html"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
 	"github.com/gibsn/telegram_to_notion/internal/notion"
-	"github.com/gibsn/telegram_to_notion/internal/requestprocessor"
+	"github.com/gibsn/telegram_to_notion/internal/users"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var logger = logging.For("pinger")
+
+var (
+	pingsTotal = metrics.NewCounterVec(
+		"pings_total", "Pings about close deadlines by result, sent or error.", "result",
+	)
+	telegramSendErrors = metrics.NewCounterVec(
+		"telegram_send_errors_total",
+		"Failed requests sending messages to Telegram by the component sending them.",
+		"component",
+	)
+)
+
 type clock interface {
 	Now() time.Time
-	Sleep(d time.Duration)
+	// Sleep waits for d to pass or for ctx to be done, whichever comes first.
+	Sleep(ctx context.Context, d time.Duration) error
 	Until(t time.Time) time.Duration
 }
 
 type realClock struct{}
 
 func (realClock) Now() time.Time                  { return time.Now() }
-func (realClock) Sleep(d time.Duration)           { time.Sleep(d) }
 func (realClock) Until(t time.Time) time.Duration { return time.Until(t) }
 
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 type taskCache interface {
 	Tasks() []notion.Task
 }
 
 type sendPingCB func(chatID int64, mention string, task notion.Task, t time.Time) error
 
-type Pinger struct {
-	debug bool
+// schedule holds the settings that may change while the pinger runs, see
+// SetSchedule.
+type schedule struct {
+	chatID   int64
+	pingText string
 
+	startingTime, endTime time.Time
+	threshold, period     time.Duration
+}
+
+type Pinger struct {
 	clock clock
 
 	tasksCache taskCache
 
 	tg            *tgbotapi.BotAPI
-	namesResolver *requestprocessor.UserResolver
-	chatID        int64
-	pingText      string
+	namesResolver *users.UserResolver
 
-	startingTime, endTime time.Time
-	threshold, period     time.Duration
+	mu       sync.Mutex
+	schedule schedule
 
 	sendPingFunc sendPingCB
 }
@@ -56,16 +90,18 @@ func NewPinger(
 	loc := time.Now().Location()
 
 	p := &Pinger{
-		clock:         realClock{},
-		tasksCache:    c,
-		tg:            tg,
-		startingTime:  time.Date(0, 0, 0, 8, 0, 0, 0, loc),
-		endTime:       time.Date(0, 0, 0, 23, 0, 0, 0, loc),
-		threshold:     72 * time.Hour,
-		period:        4 * time.Hour,
-		chatID:        chatID,
-		pingText:      "Hi, what's the estimate?",
-		namesResolver: requestprocessor.NewUserResolver(),
+		clock:      realClock{},
+		tasksCache: c,
+		tg:         tg,
+		schedule: schedule{
+			startingTime: time.Date(0, 0, 0, 8, 0, 0, 0, loc),
+			endTime:      time.Date(0, 0, 0, 23, 0, 0, 0, loc),
+			threshold:    72 * time.Hour,
+			period:       4 * time.Hour,
+			chatID:       chatID,
+			pingText:     "Hi, what's the estimate?",
+		},
+		namesResolver: users.NewUserResolver(),
 	}
 
 	p.sendPingFunc = p.sendPing
@@ -73,19 +109,36 @@ func NewPinger(
 	return p, nil
 }
 
+// currentSchedule returns a copy of the schedule, so that the loops are not
+// affected by changes made in the middle of an iteration.
+func (p *Pinger) currentSchedule() schedule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.schedule
+}
+
+func (p *Pinger) updateSchedule(update func(*schedule)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	update(&p.schedule)
+}
+
 func (p *Pinger) nextTickAfter() time.Time {
 	now := p.clock.Now()
 	loc := now.Location()
+	s := p.currentSchedule()
 
 	firstTick := time.Date(
 		now.Year(), now.Month(), now.Day(),
-		p.startingTime.Hour(), p.startingTime.Minute(), 0, 0, loc,
+		s.startingTime.Hour(), s.startingTime.Minute(), 0, 0, loc,
 	)
 
 	nextTick := firstTick
 
 	for nextTick.Before(now) {
-		nextTick = nextTick.Add(p.period)
+		nextTick = nextTick.Add(s.period)
 	}
 
 	return nextTick
@@ -93,7 +146,7 @@ func (p *Pinger) nextTickAfter() time.Time {
 
 // PingPeriodically runs a daily loop that sends task pings at set times.
 //
-// Pings start from the first tick after p.startingTime and repeat every period,
+// Pings start from the first tick after the starting time and repeat every period,
 // stopping at endTime (e.g., 23:00). After that, the function waits until the
 // next day and restarts the same schedule.
 //
@@ -106,34 +159,45 @@ func (p *Pinger) nextTickAfter() time.Time {
 //	If now = 2025-06-13T07:30 → first ping at 08:00
 //	Pings at 08:00, 12:00, 16:00, 20:00 (if deadline is within 24h)
 //	Then wait until 2025-06-14T08:00 and repeat
-func (p *Pinger) PingPeriodically() {
+//
+// The loop returns once ctx is cancelled.
+func (p *Pinger) PingPeriodically(ctx context.Context) {
 	nextTick := p.nextTickAfter()
 
-	log.Printf("Waiting until %s to send first message", nextTick.Format(time.RFC1123))
-	p.clock.Sleep(p.clock.Until(nextTick))
+	logger.InfoContext(ctx, "Waiting to send the first pings", "until", nextTick)
+	if p.clock.Sleep(ctx, p.clock.Until(nextTick)) != nil {
+		return
+	}
 
 	for {
 		now := p.clock.Now()
 		loc := now.Location()
+		startingTime := p.currentSchedule().startingTime
 
 		firstTick := time.Date(
 			now.Year(), now.Month(), now.Day(),
-			p.startingTime.Hour(), p.startingTime.Minute(), 0, 0, loc,
+			startingTime.Hour(), startingTime.Minute(), 0, 0, loc,
 		)
 
 		if now.Before(firstTick) {
 			wait := p.clock.Until(firstTick)
-			log.Printf("Waiting until %s to start today's cycle", firstTick.Format(time.RFC1123))
+			logger.InfoContext(ctx, "Waiting to start today's cycle", "until", firstTick)
 
-			p.clock.Sleep(wait)
+			if p.clock.Sleep(ctx, wait) != nil {
+				return
+			}
 		}
 
-		p.pingThroughDay()
+		if p.pingThroughDay(ctx) != nil {
+			return
+		}
 
 		next := p.tomorrow(now, loc)
 
-		log.Printf("Waiting until %s", next)
-		p.clock.Sleep(p.clock.Until(next))
+		logger.InfoContext(ctx, "Waiting for the next day", "until", next)
+		if p.clock.Sleep(ctx, p.clock.Until(next)) != nil {
+			return
+		}
 	}
 }
 
@@ -148,26 +212,30 @@ func (p *Pinger) tomorrow(startOfDay time.Time, loc *time.Location) time.Time {
 	return time.Date(now.Year(), now.Month(), now.Day()+dayDelta, 0, 0, 0, 0, loc)
 }
 
-func (p *Pinger) pingThroughDay() {
+func (p *Pinger) pingThroughDay(ctx context.Context) error {
 	now := p.clock.Now()
 	loc := now.Location()
+	endTime := p.currentSchedule().endTime
 
 	nightTime := time.Date(
 		now.Year(), now.Month(), now.Day(),
-		p.endTime.Hour(), p.endTime.Minute(), 0, 0, loc,
+		endTime.Hour(), endTime.Minute(), 0, 0, loc,
 	)
 
 	for {
 		now = p.clock.Now()
+		s := p.currentSchedule()
 
 		if !now.Before(nightTime) {
-			return
+			return nil
 		}
 
-		log.Println("Sending pings now")
+		// every round of pings is logged under its own ID
+		roundCtx := logging.WithRequestID(ctx, logging.NewRequestID())
+		logger.InfoContext(roundCtx, "Sending pings")
 
 		for _, task := range p.tasksCache.Tasks() {
-			if task.Deadline.IsZero() || task.Deadline.Sub(now) > p.threshold {
+			if task.Deadline.IsZero() || task.Deadline.Sub(now) > s.threshold {
 				continue
 			}
 
@@ -176,8 +244,10 @@ func (p *Pinger) pingThroughDay() {
 			for _, a := range task.Assignees {
 				resolved := p.namesResolver.NotionToTg(a.ID)
 				if resolved == "" {
-					log.Printf("Could not resolve user ID '%s' from Notion to telegram name", a.ID)
-					log.Printf("Skipping ping for task '%s'", task.Title)
+					logger.WarnContext(roundCtx,
+						"Could not resolve Notion user to a Telegram name, not pinging them",
+						"notion_user_id", a.ID, "task", task.Link,
+					)
 					continue
 				}
 
@@ -186,24 +256,30 @@ func (p *Pinger) pingThroughDay() {
 
 			mention := strings.Join(resolvedAssignees, ", ")
 
-			log.Printf("Sending ping for task '%s' to '%s'", task.Title, mention)
+			logger.InfoContext(roundCtx, "Sending ping",
+				"task", task.Link, logging.Text("title", task.Title), "mention", mention,
+			)
 
-			if err := p.sendPingFunc(p.chatID, mention, task, now); err != nil {
-				log.Printf(
-					"Could not send ping on task '%s' to user '%s': %v",
-					task.Title, mention, err,
+			if err := p.sendPingFunc(s.chatID, mention, task, now); err != nil {
+				logger.ErrorContext(roundCtx, "Could not send ping",
+					"task", task.Link, "mention", mention, "error", err,
 				)
+				pingsTotal.Inc("error")
+			} else {
+				pingsTotal.Inc("sent")
 			}
 		}
 
-		p.clock.Sleep(p.period)
+		if err := p.clock.Sleep(ctx, s.period); err != nil {
+			return err
+		}
 	}
 }
 
 func (p *Pinger) sendPing(chatID int64, mention string, task notion.Task, t time.Time) error {
 	msgText := fmt.Sprintf(
 		"%s\n\n%s\n\n<a href=\"%s\">%s</a>\nDeadline: %s",
-		p.pingText,
+		p.currentSchedule().pingText,
 		mention,
 		task.Link,
 		html.EscapeString(task.Title),
@@ -215,18 +291,15 @@ func (p *Pinger) sendPing(chatID int64, mention string, task notion.Task, t time
 
 	_, err := p.tg.Send(msg)
 	if err != nil {
+		telegramSendErrors.Inc("pinger")
 		return err
 	}
 
 	return nil
 }
 
-func (p *Pinger) SetDebug(debug bool) {
-	p.debug = debug
-}
-
 func (p *Pinger) SetThreshold(th time.Duration) {
-	p.threshold = th
+	p.updateSchedule(func(s *schedule) { s.threshold = th })
 }
 
 func (p *Pinger) SetStartingTime(t string) error {
@@ -237,7 +310,7 @@ func (p *Pinger) SetStartingTime(t string) error {
 		return fmt.Errorf("invalid start time: %w", err)
 	}
 
-	p.startingTime = startAt
+	p.updateSchedule(func(s *schedule) { s.startingTime = startAt })
 
 	return nil
 }
@@ -250,17 +323,28 @@ func (p *Pinger) SetEndTime(t string) error {
 		return fmt.Errorf("invalid end time: %w", err)
 	}
 
-	p.endTime = endAt
+	p.updateSchedule(func(s *schedule) { s.endTime = endAt })
 
 	return nil
 }
 
+// SetUserResolver sets the links used to mention the assignees, the resolver
+// is shared with the request processor.
+func (p *Pinger) SetUserResolver(resolver *users.UserResolver) {
+	p.namesResolver = resolver
+}
+
 func (p *Pinger) SetPeriod(d time.Duration) {
-	p.period = d
+	p.updateSchedule(func(s *schedule) { s.period = d })
 }
 
 func (p *Pinger) SetPingText(text string) {
-	p.pingText = text
+	p.updateSchedule(func(s *schedule) { s.pingText = text })
+}
+
+// SetChatID sets the chat the pings are sent to.
+func (p *Pinger) SetChatID(chatID int64) {
+	p.updateSchedule(func(s *schedule) { s.chatID = chatID })
 }
 
 func (p *Pinger) setClock(clock clock) {
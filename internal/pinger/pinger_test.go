@@ -1,12 +1,14 @@
 package pinger
 
 import (
+	"context"
 	"log"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/users"
 )
 
 type mockClock struct {
@@ -20,15 +22,18 @@ func (m *mockClock) Now() time.Time {
 	return m.curr
 }
 
-func (m *mockClock) Sleep(d time.Duration) {
+func (m *mockClock) Sleep(ctx context.Context, d time.Duration) error {
 	m.mu.Lock()
 	m.curr = m.curr.Add(d)
 	m.mu.Unlock()
 
 	// if reached an end of the time sequence, hang until test finishes
 	if !m.curr.Before(m.last) {
-		time.Sleep(1 * time.Second)
+		<-ctx.Done()
+		return ctx.Err()
 	}
+
+	return nil
 }
 
 func (m *mockClock) Until(t time.Time) time.Duration {
@@ -142,6 +147,7 @@ func TestPingPeriodically_Scenarios(t *testing.T) {
 
 			var (
 				sentTimes []time.Time
+				mentions  []string
 				sentMU    sync.Mutex
 			)
 
@@ -156,6 +162,15 @@ func TestPingPeriodically_Scenarios(t *testing.T) {
 
 			p.SetPeriod(6 * time.Hour)
 
+			resolver := users.NewUserResolver()
+			err = resolver.Link(users.UserLink{
+				TelegramID: 1, TelegramName: "gibsn", NotionID: "7439e2ca-75f8-4024-b170-620ef7ed08b1",
+			})
+			if err != nil {
+				t.Fatalf("failed to link user: %v", err)
+			}
+			p.SetUserResolver(resolver)
+
 			p.setClock(clock)
 			p.setSendPingFunc(
 				func(
@@ -163,13 +178,17 @@ func TestPingPeriodically_Scenarios(t *testing.T) {
 				) error {
 					sentMU.Lock()
 					sentTimes = append(sentTimes, t)
+					mentions = append(mentions, mention)
 					sentMU.Unlock()
 
 					return nil
 				})
 
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
 			go func() {
-				p.PingPeriodically()
+				p.PingPeriodically(ctx)
 			}()
 
 			time.Sleep(100 * time.Millisecond)
@@ -184,9 +203,34 @@ func TestPingPeriodically_Scenarios(t *testing.T) {
 				if sentTimes[i].String() != want {
 					t.Errorf("Ping %d: expected %s, got %s", i, want, sentTimes[i])
 				}
+				if mentions[i] != "@gibsn" {
+					t.Errorf("Ping %d: expected mention @gibsn, got %q", i, mentions[i])
+				}
 			}
 
 			sentMU.Unlock()
 		})
 	}
 }
+
+func TestPingPeriodically_StopsOnCancel(t *testing.T) {
+	p, err := NewPinger(&mockTaskCache{}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create pinger: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.PingPeriodically(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PingPeriodically did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, time.July, 20, 12, 0, 0, 0, time.UTC)}
+	l := NewLimiter(rate, burst)
+	l.now = clock.Now
+
+	return l, clock
+}
+
+func TestReserveAllowsBurstThenSpacesRequests(t *testing.T) {
+	l, _ := newTestLimiter(3, 3)
+
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, l.reserve(), "request %d should fit into the burst", i)
+	}
+
+	assert.Equal(t, time.Second/3, l.reserve())
+	assert.Equal(t, 2*time.Second/3, l.reserve())
+}
+
+func TestReserveRefillsOverTime(t *testing.T) {
+	l, clock := newTestLimiter(2, 1)
+
+	assert.Zero(t, l.reserve())
+	assert.Equal(t, 500*time.Millisecond, l.reserve())
+
+	clock.now = clock.now.Add(time.Second)
+	assert.Zero(t, l.reserve())
+
+	clock.now = clock.now.Add(time.Hour)
+	assert.Zero(t, l.reserve(), "bucket must refill up to the burst")
+	assert.Equal(t, 500*time.Millisecond, l.reserve(), "bucket must not exceed the burst")
+}
+
+func TestZeroRateDisablesLimiting(t *testing.T) {
+	l, _ := newTestLimiter(0, 1)
+
+	for i := 0; i < 10; i++ {
+		assert.Zero(t, l.reserve())
+	}
+}
+
+func TestWaitReturnsWhenContextIsCancelled(t *testing.T) {
+	l := NewLimiter(0.001, 1)
+	assert.NoError(t, l.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := l.Wait(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.InDelta(t, 0, l.tokens, 0.01, "the cancelled reservation must be returned")
+}
+
+func TestWaitSpacesRealRequests(t *testing.T) {
+	l := NewLimiter(100, 1)
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.Wait(context.Background()))
+	}
+
+	assert.GreaterOrEqual(t, time.Since(started), 15*time.Millisecond)
+}
@@ -1,6 +1,7 @@
 package requestprocessor
 
 import (
+	"context"
 	"testing"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -75,7 +76,7 @@ func TestCommandsWithoutArgumentsStartInputDialog(t *testing.T) {
 			}
 
 			p := NewRequestProcessor(nil, "", nil)
-			response, err := p.processRequest(tgbotapi.Update{Message: message})
+			response, err := p.processRequest(context.Background(), tgbotapi.Update{Message: message})
 
 			require.NoError(t, err)
 			assert.Contains(t, response.text, tt.wantPrompt)
@@ -93,7 +94,7 @@ func TestCommandsWithoutArgumentsStartInputDialog(t *testing.T) {
 func TestDeadlineWithoutTaskReplyStillShowsUsageError(t *testing.T) {
 	text := "/deadline"
 	p := NewRequestProcessor(nil, "", nil)
-	response, err := p.processRequest(tgbotapi.Update{Message: &tgbotapi.Message{
+	response, err := p.processRequest(context.Background(), tgbotapi.Update{Message: &tgbotapi.Message{
 		MessageID: 10,
 		From:      &tgbotapi.User{ID: 20, UserName: "gibsn"},
 		Chat:      &tgbotapi.Chat{ID: 30, Type: "private"},
@@ -138,13 +139,15 @@ func TestPendingPrivateTaskBuildsExplicitAssigneeFormat(t *testing.T) {
 }
 
 func TestPendingCommandReplyIsHandledAsCommandInput(t *testing.T) {
+	ctx := context.Background()
+
 	p := NewRequestProcessor(nil, "", nil)
-	p.setPendingInput(30, 20, pendingInput{
+	p.setPendingInput(ctx, 30, 20, pendingInput{
 		command:         "/task",
 		promptMessageID: 40,
 	})
 
-	response, err := p.processMessage(tgbotapi.Update{Message: &tgbotapi.Message{
+	response, err := p.processMessage(context.Background(), tgbotapi.Update{Message: &tgbotapi.Message{
 		MessageID: 41,
 		From:      &tgbotapi.User{ID: 20, UserName: "gibsn"},
 		Chat:      &tgbotapi.Chat{ID: 30, Type: "group"},
@@ -0,0 +1,386 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const taskReplyNote = "Must be a reply to a message with task link"
+
+// promptFunc starts a dialog for a command sent without arguments, e.g. asks
+// for the arguments in a reply. It returns false if the command has to run
+// without arguments instead.
+type promptFunc func(commandCommon) (commandResponse, bool)
+
+// botCommand declares a command of the bot. Routing, usage hints, prompts and
+// the command list registered in Telegram are all generated from the
+// declarations, see newCommands.
+type botCommand struct {
+	// name is the command without the leading slash. Subcommands are named by
+	// the first word of the arguments.
+	name        string
+	description string
+	// usage is shown along with the error when the arguments are invalid.
+	usage    string
+	examples []string
+	// taskReply tells that the command must be a reply to a message with a
+	// task link.
+	taskReply bool
+	// interactive and privateChat are shown by /help, they describe the
+	// dialog started by the prompt and how the command works in a private
+	// chat with the bot.
+	interactive string
+	privateChat string
+	// role is the minimum role required by the command unless the access
+	// config overrides it. Subcommands without a role require the role of the
+	// command.
+	role access.Role
+	// prompt is used when the command is sent without arguments, the reply to
+	// the prompt is handled as the arguments.
+	prompt promptFunc
+	// run parses the arguments and handles the command, see withArgs.
+	run commandResponseHandler
+
+	subcommands []*botCommand
+}
+
+func (p *RequestProcessor) newCommands() []*botCommand {
+	return []*botCommand{
+		{
+			name:        "task",
+			description: "Create a task",
+			usage:       "/task $task_name\n$assignee1 $assignee2 ...\n$task_description (optional)",
+			examples:    []string{"/task Fix the mixer\n@gibsn @vomadan\nThe left channel hums"},
+			interactive: "Send /task without arguments and reply to the bot's prompt.",
+			privateChat: "In a private chat the assignees line is omitted: the task is " +
+				"assigned to you and the lines after the name are the description.",
+			role: access.Member,
+			prompt: inputPrompt(
+				"Send a reply with:\ntask name\n@assignee1 @assignee2 ...\n[description]",
+				"task, assignees, description",
+			),
+			run: withArgs(parseTaskCommand, p.processTask),
+		},
+		{
+			name:        "agenda",
+			description: "Create an agenda item",
+			usage:       "/agenda $agenda",
+			examples:    []string{"/agenda Discuss the release date"},
+			interactive: "Send /agenda without arguments and reply to the bot's prompt.",
+			role:        access.Admin,
+			prompt:      inputPrompt("Send the agenda as a reply.", "agenda"),
+			run:         withArgs(parseAgendaCommand, p.processAgenda),
+		},
+		{
+			name:        "deadline",
+			description: "Set a task deadline",
+			usage:       "/deadline YYYY-MM-DD",
+			examples:    []string{"/deadline 2026-12-31"},
+			taskReply:   true,
+			interactive: "Reply to a task with /deadline without arguments " +
+				"and reply to the bot's prompt.",
+			role: access.Member,
+			prompt: p.replyToTaskPrompt(inputPrompt(
+				"Send the deadline as a reply in YYYY-MM-DD format.", "YYYY-MM-DD",
+			)),
+			run: withArgs(p.parseSetDeadlineCommand, p.processDeadline),
+		},
+		{
+			name:        "done",
+			description: "Complete a task",
+			usage:       "/done",
+			taskReply:   true,
+			role:        access.Member,
+			run:         withArgs(p.parseDoneCommand, p.processDone),
+		},
+		{
+			name:        "tasks",
+			description: "Show active tasks",
+			usage:       "/tasks",
+			role:        access.Viewer,
+			run:         withArgs(p.parseTasksCommand, p.processTasks),
+		},
+		{
+			name:        "tracks",
+			description: "Show tweak tracks",
+			usage:       "/tracks\n/tracks all",
+			examples:    []string{"/tracks all"},
+			role:        access.Viewer,
+			run:         withArgs(parseTracksCommand, p.processTracks),
+		},
+		{
+			name:        "tweak",
+			description: "Create or process a tweak",
+			usage: "/tweak demo|mix $track\n" +
+				"$edit_name\n" +
+				"[start_time [end_time]] (time format as 0:05 or 01:10)\n" +
+				"[description]\n\n" +
+				"/tweak render $track $iteration_number\n" +
+				"/tweak towork $track",
+			examples: []string{
+				"/tweak mix Song\nLess reverb\n0:05 0:10\nin the chorus",
+				"/tweak render Song 3",
+				"/tweak towork Song",
+			},
+			interactive: "Send /tweak without arguments, choose the action and the track " +
+				"with the buttons and reply to the bot's prompt. " +
+				"Reply with /tweak to a message to quote it in the tweak.",
+			privateChat: "In a private chat the quoted message is not linked.",
+			role:        access.Member,
+			prompt: func(commandCommon) (commandResponse, bool) {
+				return newTweakMenuResponse(), true
+			},
+			run: withArgs(p.parseTweakCommand, p.processTweak),
+			subcommands: []*botCommand{
+				{
+					name:  "render",
+					usage: "/tweak render $track $iteration_number",
+					run:   withArgsResponse(parseTweakRenderCommand, p.processTweakRenderResponse),
+				},
+				{
+					name:  "towork",
+					usage: "/tweak towork $track",
+					role:  access.Admin,
+					run:   withArgs(parseTweakToWorkCommand, p.processTweakToWork),
+				},
+			},
+		},
+		{
+			name:        "cancel",
+			description: "Cancel the current action",
+			role:        access.Viewer,
+			run: func(ctx context.Context, message commandCommon) (commandResponse, error) {
+				return commandResponse{text: p.processCancel(ctx, message)}, nil
+			},
+		},
+		{
+			name:        "link",
+			description: "Link your Telegram account to your Notion user",
+			usage:       "/link",
+			privateChat: "Only works in a private chat with the bot, " +
+				"choose yourself among the Notion users with the buttons. " +
+				"Only an admin may pick a user linked to someone else.",
+			role: access.Viewer,
+			run:  withArgsResponse(parseLinkCommand, p.processLink),
+		},
+		{
+			name:        "grant",
+			description: "Give a role to a user",
+			usage:       "/grant @user viewer|member|admin\n/grant @user none",
+			examples:    []string{"/grant @vomadan member", "/grant @vomadan none"},
+			role:        access.Admin,
+			run:         withArgs(parseGrantCommand, p.processGrant),
+		},
+		{
+			name:        "undo",
+			description: "Undo your last change",
+			usage:       "/undo",
+			role:        access.Member,
+			run:         withArgs(parseUndoCommand, p.processUndo),
+		},
+		{
+			name:        "history",
+			description: "Show the changes made in Notion through the bot",
+			usage:       "/history\n/history @user\n/history $task_link",
+			examples:    []string{"/history @vomadan"},
+			role:        access.Admin,
+			run:         withArgs(parseHistoryCommand, p.processHistory),
+		},
+		{
+			name:        "reload",
+			description: "Reload the config file",
+			usage:       "/reload",
+			role:        access.Admin,
+			run:         withArgs(parseReloadCommand, p.processReload),
+		},
+		{
+			name:        "help",
+			description: "Show how to use the commands",
+			usage:       "/help\n/help $command",
+			examples:    []string{"/help tweak"},
+			role:        access.Anyone,
+			run:         withArgs(parseHelpCommand, p.processHelp),
+		},
+	}
+}
+
+// withArgs makes a command handler that parses the arguments with parse and
+// passes them on to handle. Parse errors are answered with the usage.
+func withArgs[A any](
+	parse func(commandCommon) (A, error),
+	handle func(context.Context, commandCommon, A) (string, error),
+) commandResponseHandler {
+	return withArgsResponse(parse, func(
+		ctx context.Context, message commandCommon, args A,
+	) (commandResponse, error) {
+		text, err := handle(ctx, message, args)
+		return commandResponse{text: text}, err
+	})
+}
+
+// withArgsResponse is withArgs for handlers replying with more than text.
+func withArgsResponse[A any](
+	parse func(commandCommon) (A, error),
+	handle func(context.Context, commandCommon, A) (commandResponse, error),
+) commandResponseHandler {
+	return func(ctx context.Context, message commandCommon) (commandResponse, error) {
+		args, err := parse(message)
+		if err != nil {
+			return commandResponse{}, fmt.Errorf("%w: %w", errInvalidCommand, err)
+		}
+
+		return handle(ctx, message, args)
+	}
+}
+
+// inputPrompt asks the user to send the arguments in a reply.
+func inputPrompt(text, placeholder string) promptFunc {
+	return func(message commandCommon) (commandResponse, bool) {
+		return newCommandInputResponse(message, text, placeholder), true
+	}
+}
+
+// replyToTaskPrompt uses prompt only for a reply to a task, otherwise the
+// command runs and fails with the usage.
+func (p *RequestProcessor) replyToTaskPrompt(prompt promptFunc) promptFunc {
+	return func(message commandCommon) (commandResponse, bool) {
+		if p.extractTaskLink(message) == "" {
+			return commandResponse{}, false
+		}
+
+		return prompt(message)
+	}
+}
+
+// lookupCommand returns the command with the given name, the leading slash
+// is optional.
+func (p *RequestProcessor) lookupCommand(name string) (*botCommand, bool) {
+	name = strings.TrimPrefix(name, "/")
+	for _, cmd := range p.commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return nil, false
+}
+
+// subcommand returns the subcommand named by the first word of the arguments.
+func (c *botCommand) subcommand(message commandCommon) (*botCommand, bool) {
+	parts := strings.Fields(message.restOfMessage)
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	for _, sub := range c.subcommands {
+		if strings.EqualFold(parts[0], sub.name) {
+			return sub, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *botCommand) usageReply(err error) string {
+	if c.usage == "" {
+		return err.Error()
+	}
+
+	var reply strings.Builder
+	reply.WriteString(err.Error() + "\n\n")
+	if c.taskReply {
+		reply.WriteString(taskReplyNote + "\n")
+	}
+	reply.WriteString("Usage:\n" + c.usage)
+
+	return reply.String()
+}
+
+// allowed tells whether the user may run the command, or its subcommand if
+// sub is not nil.
+func (p *RequestProcessor) allowed(userName string, cmd, sub *botCommand) bool {
+	required := p.access.CommandRole(cmd.name, cmd.role)
+	if sub != nil {
+		declared := sub.role
+		if declared == "" {
+			declared = required
+		}
+		required = p.access.CommandRole(cmd.name+" "+sub.name, declared)
+	}
+
+	return p.access.Allows(userName, required)
+}
+
+// runCommand handles the command of the message. The response holds the
+// reply even if an error is returned.
+func (p *RequestProcessor) runCommand(
+	ctx context.Context, message commandCommon,
+) (commandResponse, error) {
+	response, err := p.dispatchCommand(ctx, message)
+
+	name := "unknown"
+	if cmd, ok := p.lookupCommand(message.command); ok {
+		name = cmd.name
+	}
+	commandsTotal.Inc(name, commandOutcome(err))
+
+	return response, err
+}
+
+func (p *RequestProcessor) dispatchCommand(
+	ctx context.Context, message commandCommon,
+) (commandResponse, error) {
+	cmd, ok := p.lookupCommand(message.command)
+	if !ok {
+		if p.access.Role(message.fromUserName) == "" {
+			return commandResponse{}, fmt.Errorf(
+				"user %s is %w to send commands", message.fromUserName, errNotAllowed,
+			)
+		}
+		return commandResponse{text: "🖕🖕🖕"}, errUnknownCommand
+	}
+
+	sub, isSub := cmd.subcommand(message)
+	if !p.allowed(message.fromUserName, cmd, sub) {
+		return commandResponse{text: "You are not allowed to use this command"}, fmt.Errorf(
+			"user %s is %w to send %s", message.fromUserName, errNotAllowed, message.command,
+		)
+	}
+
+	if isSub {
+		cmd = sub
+	} else if cmd.prompt != nil && hasNoCommandArguments(message) {
+		if response, ok := cmd.prompt(message); ok {
+			return response, nil
+		}
+	}
+
+	response, err := cmd.run(ctx, message)
+	switch {
+	case err == nil:
+		return response, nil
+	case errors.Is(err, errInvalidCommand):
+		return commandResponse{text: cmd.usageReply(err)}, err
+	default:
+		return commandResponse{text: err.Error()}, err
+	}
+}
+
+// BotCommands returns the commands to register in Telegram, so that clients
+// suggest them.
+func (p *RequestProcessor) BotCommands() []tgbotapi.BotCommand {
+	commands := make([]tgbotapi.BotCommand, 0, len(p.commands))
+	for _, cmd := range p.commands {
+		commands = append(commands, tgbotapi.BotCommand{
+			Command: cmd.name, Description: cmd.description,
+		})
+	}
+
+	return commands
+}
@@ -0,0 +1,169 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBotCommandsFollowRegistry(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	commands := p.BotCommands()
+	require.Len(t, commands, len(p.commands))
+	for i, cmd := range p.commands {
+		assert.Equal(t, tgbotapi.BotCommand{
+			Command: cmd.name, Description: cmd.description,
+		}, commands[i])
+
+		found, ok := p.lookupCommand("/" + cmd.name)
+		assert.True(t, ok)
+		assert.Same(t, cmd, found)
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	tests := []struct {
+		name  string
+		input string
+		usage string
+	}{
+		{
+			name:  "command",
+			input: "/tracks recent",
+			usage: "Usage:\n/tracks\n/tracks all",
+		},
+		{
+			name:  "subcommand",
+			input: "/tweak render Song",
+			usage: "Usage:\n/tweak render $track $iteration_number",
+		},
+		{
+			name:  "note",
+			input: "/done",
+			usage: "Must be a reply to a message with task link\nUsage:\n/done",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := extractCommand(tt.input, makeBotCommandEntities(tt.input))
+			require.NoError(t, err)
+			message.fromUserName = "gibsn"
+
+			response, err := p.runCommand(context.Background(), message)
+			assert.ErrorIs(t, err, errInvalidCommand)
+			assert.Contains(t, response.text, tt.usage)
+		})
+	}
+}
+
+func TestRunCommandChecksPermission(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	response, err := p.runCommand(context.Background(), commandCommon{
+		command: "/cancel", fromUserName: "stranger",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "You are not allowed to use this command", response.text)
+
+	response, err = p.runCommand(context.Background(), commandCommon{
+		command: "/cancel", fromUserName: "gibsn",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "There is no active action.", response.text)
+
+	response, err = p.runCommand(context.Background(), commandCommon{
+		command: "/unknown", fromUserName: "gibsn",
+	})
+	assert.ErrorIs(t, err, errUnknownCommand)
+	assert.NotEmpty(t, response.text)
+}
+
+func TestCheckAccessConfig(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	assert.NoError(t, p.CheckAccessConfig(&access.Config{
+		Commands: map[string]access.Role{"tweak towork": access.Member, "tasks": access.Anyone},
+	}))
+	assert.Error(t, p.CheckAccessConfig(&access.Config{
+		Commands: map[string]access.Role{"tweak publish": access.Member},
+	}))
+	assert.Error(t, p.SetAccessConfig(&access.Config{
+		Commands: map[string]access.Role{"publish": access.Member},
+	}))
+	assert.True(t, p.access.Allows("gibsn", access.Admin))
+
+	require.NoError(t, p.SetAccessConfig(&access.Config{
+		Users: map[string]access.Role{"vomadan": access.Admin},
+	}))
+	assert.False(t, p.access.Allows("gibsn", access.Viewer))
+	assert.True(t, p.access.Allows("vomadan", access.Admin))
+}
+
+type reloaderFunc func() (string, error)
+
+func (f reloaderFunc) Reload() (string, error) { return f() }
+
+func TestProcessReload(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+	reload := func() string {
+		t.Helper()
+		response, err := p.runCommand(context.Background(), commandCommon{
+			command: "/reload", fromUserName: "gibsn",
+		})
+		require.NoError(t, err)
+		return response.text
+	}
+
+	assert.Contains(t, reload(), "without a config file")
+
+	p.SetReloader(reloaderFunc(func() (string, error) {
+		return "Reloaded: access", nil
+	}))
+	assert.Equal(t, "Reloaded: access", reload())
+
+	p.SetReloader(reloaderFunc(func() (string, error) {
+		return "", errors.New("pinger.start: expected <time>")
+	}))
+	assert.Equal(t,
+		"The config is invalid, the current one is kept:\npinger.start: expected &lt;time&gt;", reload(),
+	)
+}
+
+func TestCommandMetrics(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+	run := func(command, user string) {
+		t.Helper()
+		_, _ = p.runCommand(context.Background(), commandCommon{
+			command: command, fromUserName: user,
+		})
+	}
+
+	before := map[string]float64{}
+	for _, key := range [][2]string{
+		{"help", "ok"}, {"grant", "invalid"}, {"grant", "denied"}, {"unknown", "unknown"},
+	} {
+		before[key[0]+" "+key[1]] = commandsTotal.Value(key[0], key[1])
+	}
+
+	run("/help", "gibsn")
+	run("/grant", "gibsn")
+	run("/grant", "stranger")
+	run("/publish", "gibsn")
+
+	for key, value := range before {
+		name, outcome, _ := strings.Cut(key, " ")
+		assert.Equal(t, value+1, commandsTotal.Value(name, outcome), key)
+	}
+}
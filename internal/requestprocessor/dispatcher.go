@@ -0,0 +1,112 @@
+package requestprocessor
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultWorkers   = 8
+	defaultQueueSize = 100
+)
+
+// dispatcher handles updates of different conversations in parallel and
+// updates of one conversation one by one in the order they were received, so
+// that e.g. a reply to a prompt is never handled before the prompt is
+// registered.
+type dispatcher struct {
+	handle func(tgbotapi.Update)
+
+	// workers limits the number of updates handled at once.
+	workers chan struct{}
+	// slots limits the number of updates queued or being handled, dispatch
+	// blocks when there are no free slots left.
+	slots chan struct{}
+
+	mu sync.Mutex
+	// queues holds the updates waiting to be handled for every conversation
+	// that has a goroutine running for it.
+	queues map[conversationKey][]tgbotapi.Update
+
+	wg sync.WaitGroup
+}
+
+func newDispatcher(workers, queueSize int, handle func(tgbotapi.Update)) *dispatcher {
+	workers = max(workers, 1)
+	queueSize = max(queueSize, workers)
+
+	return &dispatcher{
+		handle:  handle,
+		workers: make(chan struct{}, workers),
+		slots:   make(chan struct{}, queueSize),
+		queues:  make(map[conversationKey][]tgbotapi.Update),
+	}
+}
+
+// dispatch queues the update, blocking while the queue is full.
+func (d *dispatcher) dispatch(update tgbotapi.Update) {
+	d.slots <- struct{}{}
+
+	key := updateConversationKey(update)
+
+	d.mu.Lock()
+	queue, running := d.queues[key]
+	d.queues[key] = append(queue, update)
+	d.mu.Unlock()
+
+	if !running {
+		d.wg.Add(1)
+		go d.run(key)
+	}
+}
+
+// run handles the updates of the conversation until its queue is empty.
+func (d *dispatcher) run(key conversationKey) {
+	defer d.wg.Done()
+
+	d.workers <- struct{}{}
+	defer func() { <-d.workers }()
+
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.queues[key] = queue[1:]
+		d.mu.Unlock()
+
+		d.handle(update)
+		<-d.slots
+	}
+}
+
+// wait blocks until all dispatched updates have been handled.
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// updateConversationKey returns the chat and the user the update comes from,
+// the parts Telegram did not provide are left zero.
+func updateConversationKey(update tgbotapi.Update) conversationKey {
+	var key conversationKey
+	if from := update.SentFrom(); from != nil {
+		key.userID = from.ID
+	}
+
+	var msg *tgbotapi.Message
+	if update.CallbackQuery != nil {
+		msg = update.CallbackQuery.Message
+	} else {
+		msg = update.Message
+	}
+	if msg != nil && msg.Chat != nil {
+		key.chatID = msg.Chat.ID
+	}
+
+	return key
+}
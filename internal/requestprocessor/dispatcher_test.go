@@ -0,0 +1,111 @@
+package requestprocessor
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func messageUpdate(id int, chatID, userID int64) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: id, Message: &tgbotapi.Message{
+		Chat: &tgbotapi.Chat{ID: chatID},
+		From: &tgbotapi.User{ID: userID},
+	}}
+}
+
+func TestDispatcherOrdersUpdatesOfOneConversation(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled = map[conversationKey][]int{}
+	)
+	release := make(chan struct{})
+	otherHandled := make(chan struct{})
+
+	d := newDispatcher(4, 10, func(update tgbotapi.Update) {
+		switch update.UpdateID {
+		case 1:
+			// the first update of the conversation is slow
+			<-release
+		case 4:
+			close(otherHandled)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := updateConversationKey(update)
+		handled[key] = append(handled[key], update.UpdateID)
+	})
+
+	d.dispatch(messageUpdate(1, 1, 1))
+	d.dispatch(messageUpdate(2, 1, 1))
+	d.dispatch(messageUpdate(3, 1, 1))
+	d.dispatch(messageUpdate(4, 1, 2))
+
+	select {
+	case <-otherHandled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("an update of another user waits for the slow update")
+	}
+
+	close(release)
+	d.wait()
+
+	assert.Equal(t, map[conversationKey][]int{
+		{chatID: 1, userID: 1}: {1, 2, 3},
+		{chatID: 1, userID: 2}: {4},
+	}, handled)
+	assert.Empty(t, d.queues)
+}
+
+func TestDispatcherBlocksWhenQueueIsFull(t *testing.T) {
+	release := make(chan struct{})
+	d := newDispatcher(1, 2, func(tgbotapi.Update) { <-release })
+
+	d.dispatch(messageUpdate(1, 1, 1))
+	d.dispatch(messageUpdate(2, 2, 1))
+
+	dispatched := make(chan struct{})
+	go func() {
+		d.dispatch(messageUpdate(3, 3, 1))
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+		t.Fatal("dispatch does not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch is still blocked after an update has been handled")
+	}
+
+	close(release)
+	d.wait()
+}
+
+func TestUpdateConversationKey(t *testing.T) {
+	callback := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		From:    &tgbotapi.User{ID: 2},
+		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}},
+	}}
+	require.Equal(t, conversationKey{chatID: 1, userID: 2}, updateConversationKey(callback))
+
+	inline := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		From: &tgbotapi.User{ID: 2},
+	}}
+	require.Equal(t, conversationKey{userID: 2}, updateConversationKey(inline))
+
+	require.Equal(t, conversationKey{chatID: 1, userID: 2}, updateConversationKey(
+		messageUpdate(1, 1, 2),
+	))
+	require.Equal(t, conversationKey{}, updateConversationKey(tgbotapi.Update{}))
+}
@@ -0,0 +1,313 @@
+package requestprocessor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+	"github.com/gibsn/telegram_to_notion/internal/taskscache"
+	"github.com/gibsn/telegram_to_notion/internal/trackscache"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	e2eTasksDB      = "tasks-db"
+	e2eTweaksDemoDB = "tweaks-demo-db"
+	e2eTweaksMixDB  = "tweaks-mix-db"
+	e2eTracksDB     = "tracks-db"
+	e2eChatID       = -1001234567890
+)
+
+// testUserLinks are the links of the team used across the tests.
+func testUserLinks() []users.UserLink {
+	return []users.UserLink{
+		{TelegramID: 1, TelegramName: "gibsn", NotionID: "7439e2ca-75f8-4024-b170-620ef7ed08b1"},
+		{TelegramID: 2, TelegramName: "vomadan", NotionID: "0724b18e-320d-4fce-87f6-95d69b51c2c0"},
+		{
+			TelegramID: 3, TelegramName: "alexander_zh",
+			NotionID: "9e8f4963-fd1c-4bb5-bdd2-7f29a9a8698a",
+		},
+	}
+}
+
+func newTestUserResolver(t *testing.T) *users.UserResolver {
+	t.Helper()
+
+	r := users.NewUserResolver()
+	for _, link := range testUserLinks() {
+		require.NoError(t, r.Link(link))
+	}
+
+	return r
+}
+
+// e2eEnv is a request processor backed by the Notion emulator with databases
+// matching the default schema.
+type e2eEnv struct {
+	server    *notiontest.Server
+	processor *RequestProcessor
+	tracks    *trackscache.Cache
+}
+
+func column(p notion.Property, options ...string) notiontest.Column {
+	return notiontest.Column{Type: property.Type(p.Type), Options: options}
+}
+
+func tweaksColumns(s *notion.TweaksSchema) map[string]notiontest.Column {
+	props := s.Properties
+	statuses := s.Statuses
+
+	return map[string]notiontest.Column{
+		props.Title.Name: column(props.Title),
+		props.Status.Name: column(
+			props.Status, statuses.New, statuses.Deferred, statuses.Ready, statuses.InWork,
+		),
+		props.Explanation.Name:    column(props.Explanation),
+		props.Track.Name:          column(props.Track),
+		props.TrackPart.Name:      {Type: property.TypeSelect},
+		props.Start.Name:          column(props.Start),
+		props.End.Name:            column(props.End),
+		props.Author.Name:         column(props.Author),
+		props.AuthorComputed.Name: {Type: property.TypeFormula},
+		"_idempotencyKey":         {Type: property.TypeRichText},
+	}
+}
+
+func newE2EEnv(t *testing.T) *e2eEnv {
+	t.Helper()
+
+	s := notiontest.NewServer()
+	t.Cleanup(s.Close)
+
+	schema := notion.DefaultSchema()
+
+	tasks := &schema.Tasks.Properties
+	taskStatuses := schema.Tasks.Statuses
+	s.AddDatabase(e2eTasksDB, map[string]notiontest.Column{
+		tasks.Title.Name:     column(tasks.Title),
+		tasks.Assignees.Name: column(tasks.Assignees),
+		tasks.Status.Name: column(
+			tasks.Status,
+			taskStatuses.New, taskStatuses.Backlog, taskStatuses.Done, taskStatuses.Archived,
+		),
+		tasks.Deadline.Name:    column(tasks.Deadline),
+		tasks.MovedToWork.Name: column(tasks.MovedToWork),
+		"_idempotencyKey":      {Type: property.TypeRichText},
+	})
+	s.AddDatabase(e2eTweaksDemoDB, tweaksColumns(&schema.TweaksDemo))
+	s.AddDatabase(e2eTweaksMixDB, tweaksColumns(&schema.TweaksMix))
+
+	tracks := &schema.Tracks.Properties
+	s.AddDatabase(e2eTracksDB, map[string]notiontest.Column{
+		tracks.Title.Name:  column(tracks.Title),
+		tracks.Status.Name: column(tracks.Status, append(schema.Tracks.ActiveStatuses, "Выпущен")...),
+	})
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(s.URL())
+	n.SetTweaksDBIDs(e2eTweaksDemoDB, e2eTweaksMixDB)
+	n.SetIdempotencyKeyProperty("_idempotencyKey")
+	n.SetRetryPolicy(notion.RetryPolicy{MaxAttempts: 3})
+
+	p := NewRequestProcessor(n, e2eTasksDB, nil)
+	p.SetUserResolver(newTestUserResolver(t))
+	for _, link := range testUserLinks() {
+		s.AddUser(link.NotionID, "@"+link.TelegramName)
+	}
+
+	tracksCache := trackscache.NewTracksCache(n, e2eTracksDB, time.Minute)
+	p.SetTasksCache(taskscache.NewTasksCache(n, e2eTasksDB, time.Minute))
+	p.SetTracksCache(tracksCache)
+	p.SetTracksDBID(e2eTracksDB)
+
+	return &e2eEnv{server: s, processor: p, tracks: tracksCache}
+}
+
+// command sends a command from an allowed user to a group chat, replyTo is the
+// text of the message the command replies to.
+func (e *e2eEnv) command(t *testing.T, text, replyTo string) commandResponse {
+	t.Helper()
+
+	message := &tgbotapi.Message{
+		MessageID: 100,
+		From:      &tgbotapi.User{ID: 1, UserName: "gibsn"},
+		Chat:      &tgbotapi.Chat{ID: e2eChatID, Type: "supergroup"},
+		Text:      text,
+		Entities:  makeBotCommandEntities(text),
+	}
+	if replyTo != "" {
+		message.ReplyToMessage = &tgbotapi.Message{MessageID: 99, Text: replyTo}
+	}
+
+	response, err := e.processor.processRequest(
+		context.Background(), tgbotapi.Update{Message: message},
+	)
+	if err != nil {
+		t.Logf("%s: %v", text, err)
+	}
+
+	return response
+}
+
+func (e *e2eEnv) addTrack(t *testing.T, title, status string) string {
+	t.Helper()
+
+	id := e.server.AddPage(e2eTracksDB, property.Properties{
+		"Название": property.NewTitle(title),
+		"Статус":   property.NewStatus(status),
+	})
+	require.NoError(t, e.tracks.RefreshCache(context.Background()))
+
+	return id
+}
+
+func TestE2ETaskLifecycle(t *testing.T) {
+	e := newE2EEnv(t)
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn @vomadan\nIt hums", "")
+	require.Contains(t, created.text, "Task has been successfully created")
+
+	pages := e.server.Pages(e2eTasksDB)
+	require.Len(t, pages, 1)
+	task := pages[0]
+	assert.Equal(t, "Fix the mixer", task.Properties.PlainText("Задача"))
+	assert.Equal(t, "@gibsn, @vomadan", task.Properties.PlainText("Исполнитель"))
+	assert.Equal(t, notion.StatusNew, task.Properties.PlainText("Статус"))
+	assert.NotNil(t, task.Properties["_timeWhenMovedToWork"].Date)
+
+	reply := e.command(t, "/deadline 2030-01-02", created.text)
+	assert.Equal(t, "Deadline has been successfully set to 2030-01-02", reply.text)
+	task, _ = e.server.Page(task.ID)
+	assert.Equal(t, "2030-01-02", task.Properties.PlainText("Дедлайн"))
+
+	reply = e.command(t, "/tasks", "")
+	assert.Contains(t, reply.text, "Fix the mixer</a> (Status: "+notion.StatusNew+")")
+	assert.Contains(t, reply.text, "(Deadline: 2030-01-02)")
+
+	reply = e.command(t, "/done", created.text)
+	assert.Equal(t, "Task has been successfully marked as Done", reply.text)
+	task, _ = e.server.Page(task.ID)
+	assert.Equal(t, notion.StatusDone, task.Properties.PlainText("Статус"))
+
+	reply = e.command(t, "/tasks", "")
+	assert.Equal(t, "No tasks found for you", reply.text)
+}
+
+func TestE2EAgenda(t *testing.T) {
+	e := newE2EEnv(t)
+
+	reply := e.command(t, "/agenda Weekly sync", "")
+	assert.Contains(t, reply.text, "Agenda created:\nhttps://www.notion.so/")
+
+	pages := e.server.Pages(e2eTasksDB)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "Agenda: Weekly sync", pages[0].Properties.PlainText("Задача"))
+	assert.Len(t, pages[0].Properties["Исполнитель"].People, len(testUserLinks()))
+}
+
+func TestE2ETracks(t *testing.T) {
+	e := newE2EEnv(t)
+	e.addTrack(t, "Beta", notion.TrackStatusMixing)
+	e.addTrack(t, "Alpha", notion.TrackStatusDemo)
+	e.addTrack(t, "Old", "Выпущен")
+
+	reply := e.command(t, "/tracks", "")
+	assert.Contains(t, reply.text, "Tracks in progress:")
+	assert.Contains(t, reply.text, ">Alpha</a>\n2. <a")
+	assert.NotContains(t, reply.text, "Old")
+
+	reply = e.command(t, "/tracks all", "")
+	assert.Contains(t, reply.text, "All tracks:")
+	assert.Contains(t, reply.text, ">Old</a>")
+}
+
+func TestE2ETweaks(t *testing.T) {
+	e := newE2EEnv(t)
+	trackID := e.addTrack(t, "Song", notion.TrackStatusDemo)
+
+	reply := e.command(t, "/tweak demo Song\nLouder vocals\n0:05 0:10\nin the chorus", "")
+	require.Contains(t, reply.text, "Tweak has been created")
+
+	reply = e.command(t, "/tweak mix song\nLess reverb", "Too wet")
+	require.Contains(t, reply.text, "Tweak has been created")
+
+	demo := e.server.Pages(e2eTweaksDemoDB)
+	require.Len(t, demo, 1)
+	props := demo[0].Properties
+	assert.Equal(t, "Louder vocals", props.PlainText("Кратко"))
+	assert.Equal(t, notion.TweakDemoStatusTODO, props.PlainText("Статус"))
+	assert.Equal(t, []string{trackID}, props["Песня"].RelationIDs())
+	assert.Equal(t, "0:05", props.PlainText("Начало интервала"))
+	assert.Equal(t, "0:10", props.PlainText("Конец интервала"))
+	assert.Equal(t, "in the chorus", props.PlainText("Пояснение"))
+	assert.Equal(t, "@gibsn", props.PlainText("Автор (Manual)"))
+
+	mix := e.server.Pages(e2eTweaksMixDB)
+	require.Len(t, mix, 1)
+	assert.Equal(t, notion.TweakMixStatusAnalysis, mix[0].Properties.PlainText("Статус"))
+	assert.Equal(
+		t,
+		"Ответ на сообщение: Too wet\nСсылка на сообщение: https://t.me/c/1234567890/99",
+		mix[0].Properties.PlainText("Пояснение"),
+	)
+}
+
+func TestE2ETweakRenderAndToWork(t *testing.T) {
+	e := newE2EEnv(t)
+	trackID := e.addTrack(t, "Song", notion.TrackStatusMixing)
+	otherID := e.addTrack(t, "Other", notion.TrackStatusMixing)
+
+	addTweak := func(title, status, track string) string {
+		return e.server.AddPage(e2eTweaksMixDB, property.Properties{
+			"Кратко":  property.NewTitle(title),
+			"Статус":  property.NewStatus(status),
+			"Песня":   property.NewRelation(track),
+			"Дорожка": property.NewSelect("Vocals"),
+		})
+	}
+	ready := []string{
+		addTweak("First", notion.TweakMixStatusReadyForWork, trackID),
+		addTweak("Second", notion.TweakMixStatusReadyForWork, trackID),
+	}
+	addTweak("Unready", notion.TweakMixStatusAnalysis, trackID)
+	addTweak("Other track", notion.TweakMixStatusReadyForWork, otherID)
+
+	rendered := e.command(t, "/tweak render Song 3", "")
+	require.NotNil(t, rendered.document)
+	assert.Contains(t, rendered.text, "Generated 2 tweaks for")
+	assert.Contains(t, rendered.text, "Unready tweaks left: 1")
+
+	reply := e.command(t, "/tweak towork Song", "")
+	assert.Contains(t, reply.text, "Moved 2 tweaks for")
+	for _, id := range ready {
+		page, _ := e.server.Page(id)
+		assert.Equal(t, notion.TweakMixStatusInWork, page.Properties.PlainText("Статус"))
+	}
+
+	reply = e.command(t, "/tweak towork Song", "")
+	assert.Equal(t, "No ready tweaks found for track \"Song\"", reply.text)
+}
+
+func TestE2ENotionFaults(t *testing.T) {
+	e := newE2EEnv(t)
+
+	e.server.InjectFault(notiontest.Fault{
+		Method: http.MethodPost, Path: "pages", Times: 1,
+		Status: http.StatusTooManyRequests, RetryAfter: time.Millisecond,
+	})
+	reply := e.command(t, "/task Survive rate limits\n@gibsn", "")
+	assert.Contains(t, reply.text, "Task has been successfully created")
+	assert.Len(t, e.server.Pages(e2eTasksDB), 1)
+
+	e.server.InjectFault(notiontest.Fault{Status: http.StatusInternalServerError})
+	reply = e.command(t, "/done", "Task: https://www.notion.so/00000000000040008000000000000001")
+	assert.Contains(t, reply.text, "could not set status to done")
+}
@@ -0,0 +1,66 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+)
+
+var userNameRe = regexp.MustCompile(`^@?\w+$`)
+
+type grantRequest struct {
+	userName string
+	role     access.Role
+}
+
+func parseGrantCommand(message commandCommon) (grantRequest, error) {
+	parts := strings.Fields(message.restOfMessage)
+	if len(parts) != 2 {
+		return grantRequest{}, errors.New("expected a username and a role")
+	}
+	if !userNameRe.MatchString(parts[0]) {
+		return grantRequest{}, fmt.Errorf("invalid username %q", parts[0])
+	}
+
+	req := grantRequest{userName: access.NormalizeUser(parts[0]), role: access.None}
+	if !strings.EqualFold(parts[1], string(access.None)) {
+		role, err := access.ParseRole(parts[1])
+		if err != nil {
+			return grantRequest{}, err
+		}
+		req.role = role
+	}
+
+	return req, nil
+}
+
+func (p *RequestProcessor) processGrant(
+	_ context.Context, message commandCommon, req grantRequest,
+) (string, error) {
+	// an admin demoting themselves could leave the bot without admins
+	if req.userName == access.NormalizeUser(message.fromUserName) {
+		return "You cannot change your own role", nil
+	}
+
+	if err := p.access.Grant(req.userName, req.role); err != nil {
+		return "", fmt.Errorf("could not grant the role: %w", err)
+	}
+
+	if req.role == access.None {
+		return fmt.Sprintf("@%s no longer has access", req.userName), nil
+	}
+
+	return fmt.Sprintf("@%s is now %s %s", req.userName, article(req.role), req.role), nil
+}
+
+func article(role access.Role) string {
+	if role == access.Admin {
+		return "an"
+	}
+
+	return "a"
+}
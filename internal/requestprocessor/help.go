@@ -0,0 +1,86 @@
+package requestprocessor
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// parseHelpCommand returns the command to show the help for, empty for the
+// list of all commands.
+func parseHelpCommand(message commandCommon) (string, error) {
+	parts := strings.Fields(message.restOfMessage)
+	switch len(parts) {
+	case 0:
+		return "", nil
+	case 1:
+		return strings.ToLower(strings.TrimPrefix(parts[0], "/")), nil
+	default:
+		return "", fmt.Errorf("expected a single command, got %q", message.restOfMessage)
+	}
+}
+
+func (p *RequestProcessor) processHelp(
+	_ context.Context, message commandCommon, topic string,
+) (string, error) {
+	if topic == "" {
+		return p.commandsHelp(message), nil
+	}
+
+	cmd, ok := p.lookupCommand(topic)
+	if !ok {
+		return fmt.Sprintf(
+			"Unknown command /%s. Send /help to see all commands.", html.EscapeString(topic),
+		), nil
+	}
+
+	return cmd.help(), nil
+}
+
+// commandsHelp lists the commands the sender may use.
+func (p *RequestProcessor) commandsHelp(message commandCommon) string {
+	var reply strings.Builder
+	reply.WriteString("<b>Commands:</b>\n")
+	for _, cmd := range p.commands {
+		if !p.allowed(message.fromUserName, cmd, nil) {
+			continue
+		}
+
+		reply.WriteString(fmt.Sprintf("/%s — %s", cmd.name, html.EscapeString(cmd.description)))
+		if cmd.taskReply {
+			reply.WriteString(" (reply to a task)")
+		}
+		reply.WriteString("\n")
+	}
+	reply.WriteString("\nSend /help $command for the syntax and examples, e.g. /help tweak.")
+
+	return reply.String()
+}
+
+// help describes the command in detail.
+func (c *botCommand) help() string {
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("/%s — %s\n", c.name, html.EscapeString(c.description)))
+
+	if c.taskReply {
+		reply.WriteString("\n" + taskReplyNote + ".\n")
+	}
+	if c.usage != "" {
+		reply.WriteString("\n<b>Usage:</b>\n" + html.EscapeString(c.usage) + "\n")
+	}
+	if len(c.examples) > 0 {
+		reply.WriteString("\n<b>Examples:</b>\n")
+		for _, example := range c.examples {
+			reply.WriteString("<code>" + html.EscapeString(example) + "</code>\n")
+		}
+	}
+	if c.interactive != "" {
+		reply.WriteString("\n<b>Interactive:</b> " + html.EscapeString(c.interactive) + "\n")
+	}
+	if c.privateChat != "" {
+		reply.WriteString("\n<b>Private chat:</b> " + html.EscapeString(c.privateChat) + "\n")
+	}
+
+	return strings.TrimSuffix(reply.String(), "\n")
+}
@@ -0,0 +1,54 @@
+package requestprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func help(t *testing.T, p *RequestProcessor, input, from string) string {
+	t.Helper()
+
+	message, err := extractCommand(input, makeBotCommandEntities(input))
+	require.NoError(t, err)
+	message.fromUserName = from
+
+	response, err := p.runCommand(context.Background(), message)
+	require.NoError(t, err)
+
+	return response.text
+}
+
+func TestHelpListsCommands(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	reply := help(t, p, "/help", "gibsn")
+	for _, cmd := range p.commands {
+		assert.Contains(t, reply, "/"+cmd.name+" — "+cmd.description)
+	}
+	assert.Contains(t, reply, "/done — Complete a task (reply to a task)")
+
+	reply = help(t, p, "/help", "stranger")
+	assert.Contains(t, reply, "/help — ")
+	assert.NotContains(t, reply, "/task — ")
+}
+
+func TestHelpForCommand(t *testing.T) {
+	p := NewRequestProcessor(nil, "", nil)
+
+	reply := help(t, p, "/help tweak", "gibsn")
+	assert.Contains(t, reply, "/tweak render $track $iteration_number")
+	assert.Contains(t, reply, "<code>/tweak render Song 3</code>")
+	assert.Contains(t, reply, "<b>Interactive:</b> Send /tweak without arguments")
+
+	reply = help(t, p, "/help /task", "gibsn")
+	assert.Contains(t, reply, "<b>Private chat:</b> In a private chat the assignees line is omitted")
+
+	reply = help(t, p, "/help deadline", "gibsn")
+	assert.Contains(t, reply, "Must be a reply to a message with task link.")
+
+	reply = help(t, p, "/help <b>", "gibsn")
+	assert.Equal(t, "Unknown command /&lt;b&gt;. Send /help to see all commands.", reply)
+}
@@ -0,0 +1,241 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+)
+
+// historyLimit is the number of entries shown by /history.
+const historyLimit = 20
+
+// tweakToWorkCommand is the command recorded for /tweak towork, its changes
+// are of tweaks rather than tasks.
+const tweakToWorkCommand = "/tweak towork"
+
+// recordChange writes the command and its outcome to the audit log if there
+// is one. The command is done by then, so a failure to write is only logged.
+func (p *RequestProcessor) recordChange(
+	ctx context.Context, message commandCommon, command string, changes []audit.Change, err error,
+) {
+	if p.auditLog == nil {
+		return
+	}
+
+	p.appendEntry(ctx, newEntry(ctx, message, command, changes, err))
+}
+
+func newEntry(
+	ctx context.Context, message commandCommon, command string, changes []audit.Change, err error,
+) audit.Entry {
+	entry := audit.Entry{
+		RequestID: logging.RequestID(ctx),
+		UserID:    message.fromUserID,
+		UserName:  message.fromUserName,
+		ChatID:    message.chatID,
+		Command:   command,
+		Changes:   changes,
+		Outcome:   audit.OutcomeOK,
+	}
+	if err != nil {
+		entry.Outcome, entry.Error = audit.OutcomeError, err.Error()
+	}
+
+	return entry
+}
+
+func (p *RequestProcessor) appendEntry(ctx context.Context, entry audit.Entry) {
+	entry.Time = p.now()
+	if err := p.auditLog.Append(entry); err != nil {
+		logger.ErrorContext(ctx, "Could not write audit log", "command", entry.Command, "error", err)
+	}
+}
+
+// taskChange describes setting the field of the task to value. The current
+// value is loaded to be recorded as the old one, unless there is no audit log.
+// If Notion is unavailable the error is returned, the write would fail as
+// well. On other failures the old value is left unknown.
+func (p *RequestProcessor) taskChange(
+	ctx context.Context, taskLink, field, value string,
+) (audit.Change, error) {
+	change := audit.Change{PageID: notion.PageIDFromLink(taskLink), Field: field, New: value}
+	if p.auditLog == nil {
+		return change, nil
+	}
+
+	state, err := p.notion.LoadTaskStateContext(ctx, taskLink)
+	if errors.Is(err, notion.ErrUnavailable) {
+		return change, err
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Could not load the task to record its old value",
+			"task", taskLink, "error", err,
+		)
+		return change, nil
+	}
+
+	change.OldKnown = true
+	switch field {
+	case audit.FieldStatus:
+		change.Old = state.Status
+	case audit.FieldDeadline:
+		change.Old, change.OldDate = state.Deadline.String(), state.Deadline
+	}
+
+	return change, nil
+}
+
+// createdPage describes creating the page with the link, none if it has not
+// been created.
+func createdPage(link string) []audit.Change {
+	if link == "" {
+		return nil
+	}
+
+	return []audit.Change{{PageID: notion.PageIDFromLink(link), Field: audit.FieldPage, New: link}}
+}
+
+// historyRequest filters the history by the user or by the page, the whole
+// history is shown if both are empty.
+type historyRequest struct {
+	userName string
+	pageID   string
+}
+
+func parseHistoryCommand(message commandCommon) (historyRequest, error) {
+	parts := strings.Fields(message.restOfMessage)
+	switch {
+	case len(parts) == 0:
+		return historyRequest{}, nil
+	case len(parts) > 1:
+		return historyRequest{}, errors.New("expected a single @user or task link")
+	case strings.HasPrefix(parts[0], "@") && userNameRe.MatchString(parts[0]):
+		return historyRequest{userName: access.NormalizeUser(parts[0])}, nil
+	}
+
+	pageID := notion.PageIDFromLink(parts[0])
+	if pageID == "" {
+		return historyRequest{}, fmt.Errorf("%q is neither a @user nor a task link", parts[0])
+	}
+
+	return historyRequest{pageID: pageID}, nil
+}
+
+func (r historyRequest) matches(e audit.Entry) bool {
+	if r.userName != "" && e.UserName != r.userName {
+		return false
+	}
+	if r.pageID == "" {
+		return true
+	}
+	for _, change := range e.Changes {
+		if change.PageID == r.pageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RequestProcessor) processHistory(
+	_ context.Context, _ commandCommon, req historyRequest,
+) (string, error) {
+	if p.auditLog == nil {
+		return "The audit log is not configured.", nil
+	}
+
+	entries, err := p.auditLog.Query(req.matches, historyLimit)
+	if err != nil {
+		return "", fmt.Errorf("could not read the audit log: %w", err)
+	}
+	if len(entries) == 0 {
+		return "No changes found.", nil
+	}
+
+	var reply strings.Builder
+	reply.WriteString("Latest changes:\n")
+	for _, e := range entries {
+		reply.WriteString("\n" + formatHistoryEntry(e))
+	}
+
+	return reply.String(), nil
+}
+
+func formatHistoryEntry(e audit.Entry) string {
+	user := "@" + e.UserName
+	if e.UserName == "" {
+		user = fmt.Sprintf("user %d", e.UserID)
+	}
+
+	line := fmt.Sprintf("%s %s %s",
+		e.Time.Local().Format("2006-01-02 15:04"), html.EscapeString(user), e.Command,
+	)
+	if changes := formatChanges(e.Changes); changes != "" {
+		line += ": " + changes
+	}
+	switch e.Outcome {
+	case audit.OutcomeOK:
+	case audit.OutcomeQueued:
+		line += " — queued: " + html.EscapeString(e.Error)
+	default:
+		line += " — failed: " + html.EscapeString(e.Error)
+	}
+
+	return line
+}
+
+// formatChanges describes the changes, the same change of several pages, e.g.
+// of tweaks moved to work, is described once.
+func formatChanges(changes []audit.Change) string {
+	if len(changes) == 0 {
+		return ""
+	}
+
+	first := changes[0]
+	same := true
+	for _, change := range changes[1:] {
+		if change.Field != first.Field || change.Old != first.Old || change.New != first.New {
+			same = false
+			break
+		}
+	}
+	if same && len(changes) > 1 {
+		return fmt.Sprintf("%d pages %s", len(changes), formatValues(first))
+	}
+
+	described := make([]string, 0, len(changes))
+	for _, change := range changes {
+		var text string
+		switch {
+		case change.Field == audit.FieldPage && change.New == "":
+			text = fmt.Sprintf("archived <a href=\"%s\">page</a>", html.EscapeString(change.Old))
+		case change.Field == audit.FieldPage:
+			text = fmt.Sprintf("created <a href=\"%s\">page</a>", html.EscapeString(change.New))
+		default:
+			text = fmt.Sprintf(
+				"<a href=\"%s\">page</a> %s", pageLinkFromID(change.PageID), formatValues(change),
+			)
+		}
+		described = append(described, text)
+	}
+
+	return strings.Join(described, ", ")
+}
+
+func formatValues(change audit.Change) string {
+	old := change.Old
+	if old == "" {
+		old = "none"
+	}
+
+	return fmt.Sprintf("%s %s → %s",
+		change.Field, html.EscapeString(old), html.EscapeString(change.New),
+	)
+}
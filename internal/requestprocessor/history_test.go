@@ -0,0 +1,122 @@
+package requestprocessor
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuditLog(t *testing.T) *audit.Log {
+	t.Helper()
+
+	l, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"), 1<<20, 2)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+func TestAuditLogRecordsChanges(t *testing.T) {
+	e := newE2EEnv(t)
+	auditLog := newTestAuditLog(t)
+	e.processor.SetAuditLog(auditLog)
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn\nIt hums", "")
+	require.Contains(t, created.text, "Task has been successfully created")
+	pageID := e.server.Pages(e2eTasksDB)[0].ID
+
+	e.command(t, "/deadline 2030-01-02", created.text)
+	e.command(t, "/deadline 2030-02-03", created.text)
+	e.command(t, "/done", created.text)
+
+	entries, err := auditLog.Query(func(audit.Entry) bool { return true }, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	done := entries[0]
+	assert.Equal(t, "/done", done.Command)
+	assert.Equal(t, "gibsn", done.UserName)
+	assert.Equal(t, int64(1), done.UserID)
+	assert.Equal(t, int64(e2eChatID), done.ChatID)
+	assert.Equal(t, audit.OutcomeOK, done.Outcome)
+	assert.Equal(t, []audit.Change{{
+		PageID: pageID, Field: audit.FieldStatus, Old: notion.StatusNew, New: notion.StatusDone,
+		OldKnown: true,
+	}}, done.Changes)
+
+	assert.Equal(t, []audit.Change{{
+		PageID: pageID, Field: audit.FieldDeadline, Old: "2030-01-02", New: "2030-02-03",
+		OldKnown: true, OldDate: &property.Date{Start: "2030-01-02"},
+	}}, entries[1].Changes)
+	assert.Equal(t, "", entries[2].Changes[0].Old)
+	assert.True(t, entries[2].Changes[0].OldKnown)
+	assert.Equal(t, audit.FieldPage, entries[3].Changes[0].Field)
+	assert.Equal(t, pageID, entries[3].Changes[0].PageID)
+
+	trackID := e.addTrack(t, "Song", notion.TrackStatusMixing)
+	tweakID := e.server.AddPage(e2eTweaksMixDB, property.Properties{
+		"Кратко": property.NewTitle("Less reverb"),
+		"Статус": property.NewStatus(notion.TweakMixStatusReadyForWork),
+		"Песня":  property.NewRelation(trackID),
+	})
+	e.command(t, "/tweak towork Song", "")
+	entries, err = auditLog.Query(func(audit.Entry) bool { return true }, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "/tweak towork", entries[0].Command)
+	assert.Equal(t, []audit.Change{{
+		PageID: tweakID, Field: audit.FieldStatus,
+		Old: notion.TweakMixStatusReadyForWork, New: notion.TweakMixStatusInWork, OldKnown: true,
+	}}, entries[0].Changes)
+
+	e.server.InjectFault(notiontest.Fault{
+		Method: http.MethodPost, Path: "pages", Status: http.StatusInternalServerError,
+	})
+	e.command(t, "/agenda Weekly sync", "")
+	entries, err = auditLog.Query(func(audit.Entry) bool { return true }, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "/agenda", entries[0].Command)
+	assert.Equal(t, audit.OutcomeError, entries[0].Outcome)
+	assert.NotEmpty(t, entries[0].Error)
+}
+
+func TestHistoryCommand(t *testing.T) {
+	e := newE2EEnv(t)
+
+	reply := e.command(t, "/history", "")
+	assert.Equal(t, "The audit log is not configured.", reply.text)
+
+	e.processor.SetAuditLog(newTestAuditLog(t))
+	reply = e.command(t, "/history", "")
+	assert.Equal(t, "No changes found.", reply.text)
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn\nIt hums", "")
+	e.command(t, "/done", created.text)
+	other := e.command(t, "/task Buy strings\n@vomadan", "")
+	link := e.processor.taskLinkParser.FindString(created.text)
+	require.NotEmpty(t, link)
+
+	reply = e.command(t, "/history", "")
+	assert.Contains(t, reply.text, "Latest changes:")
+	assert.Contains(t, reply.text, "@gibsn /done: <a href=\""+link+"\">page</a> status "+
+		notion.StatusNew+" → "+notion.StatusDone)
+	assert.Equal(t, 3, strings.Count(reply.text, "\n")-1)
+
+	reply = e.command(t, "/history "+link, "")
+	assert.Equal(t, 2, strings.Count(reply.text, "\n")-1)
+	assert.NotContains(t, reply.text, e.processor.taskLinkParser.FindString(other.text))
+
+	reply = e.command(t, "/history @Vomadan", "")
+	assert.Equal(t, "No changes found.", reply.text)
+
+	reply = e.command(t, "/history nonsense", "")
+	assert.Contains(t, reply.text, "is neither a @user nor a task link")
+}
@@ -0,0 +1,130 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/users"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const linkCallbackPrefix = "link:"
+
+func parseLinkCommand(message commandCommon) (struct{}, error) {
+	if strings.TrimSpace(message.restOfMessage) != "" {
+		return struct{}{}, errors.New("the command takes no arguments")
+	}
+
+	return struct{}{}, nil
+}
+
+// processLink lists the people of the Notion workspace for the sender to pick
+// themselves. The list is only shown in a private chat with the bot.
+func (p *RequestProcessor) processLink(
+	ctx context.Context, message commandCommon, _ struct{},
+) (commandResponse, error) {
+	if !message.isPrivate {
+		return commandResponse{text: "Send /link to me in a private chat."}, nil
+	}
+
+	people, err := p.notion.ListUsersContext(ctx)
+	if err != nil {
+		return commandResponse{}, fmt.Errorf("could not load Notion users: %w", err)
+	}
+	if len(people) == 0 {
+		return commandResponse{text: "There are no people in the Notion workspace."}, nil
+	}
+
+	current, linked := p.nameResolver.LinkOf(message.fromUserID)
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(people))
+	for _, user := range people {
+		label := user.Name
+		if linked && strings.EqualFold(user.ID, current.NotionID) {
+			label += " ✓"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, linkCallbackPrefix+user.ID),
+		))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	text := "Who are you in Notion?"
+	if linked {
+		text = fmt.Sprintf(
+			"You are linked to <b>%s</b>. Choose another Notion user to relink.",
+			html.EscapeString(current.NotionName),
+		)
+	}
+
+	return commandResponse{text: text, replyMarkup: &markup}, nil
+}
+
+// processLinkCallback links the sender to the Notion user picked. Only an
+// admin may take over a Notion user linked to another account or declared for
+// another username.
+func (p *RequestProcessor) processLinkCallback(
+	ctx context.Context, callback *tgbotapi.CallbackQuery, notionID string,
+) {
+	cmd, ok := p.lookupCommand("link")
+	if !ok || !p.allowed(strings.ToLower(callback.From.UserName), cmd, nil) {
+		p.answerCallback(ctx, callback.ID, "You are not allowed to use this command")
+		return
+	}
+	if callback.Message == nil || callback.Message.Chat == nil || !callback.Message.Chat.IsPrivate() {
+		p.answerCallback(ctx, callback.ID, "Send /link to me in a private chat")
+		return
+	}
+
+	people, err := p.notion.ListUsersContext(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not load Notion users", "error", err)
+		p.answerCallback(ctx, callback.ID, "Could not load Notion users, try again later")
+		return
+	}
+
+	for _, user := range people {
+		if !strings.EqualFold(user.ID, notionID) {
+			continue
+		}
+
+		link := users.UserLink{
+			TelegramID:   callback.From.ID,
+			TelegramName: callback.From.UserName,
+			NotionID:     user.ID,
+			NotionName:   user.Name,
+		}
+		if p.access.Allows(callback.From.UserName, access.Admin) {
+			err = p.nameResolver.TakeOver(link)
+		} else {
+			err = p.nameResolver.Link(link)
+		}
+		switch {
+		case errors.Is(err, users.ErrNotionUserLinked), errors.Is(err, users.ErrNotionUserDeclared):
+			p.answerCallback(ctx, callback.ID, fmt.Sprintf(
+				"%s is already linked to another account, an admin may relink it", user.Name,
+			))
+		case err != nil:
+			logger.ErrorContext(ctx, "Could not link Telegram user",
+				"telegram_user_id", callback.From.ID, "error", err,
+			)
+			p.answerCallback(ctx, callback.ID, "Could not save the link, try again later")
+		default:
+			logger.InfoContext(ctx, "Telegram user is linked to Notion user",
+				"telegram_user_id", callback.From.ID, "notion_user_id", user.ID,
+			)
+			p.answerCallback(ctx, callback.ID, "")
+			p.sendCallbackResponse(ctx, callback, commandResponse{
+				text: fmt.Sprintf("You are linked to <b>%s</b>.", html.EscapeString(user.Name)),
+			})
+		}
+		return
+	}
+
+	p.answerCallback(ctx, callback.ID, "This Notion user is no longer available")
+}
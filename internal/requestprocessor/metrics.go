@@ -0,0 +1,60 @@
+package requestprocessor
+
+import (
+	"errors"
+
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	commandsTotal = metrics.NewCounterVec(
+		"commands_total",
+		"Commands handled by name and outcome: ok, invalid, denied, unknown or error.",
+		"command", "outcome",
+	)
+	telegramSendErrors = metrics.NewCounterVec(
+		"telegram_send_errors_total",
+		"Failed requests sending messages to Telegram by the component sending them.",
+		"component",
+	)
+)
+
+func commandOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errInvalidCommand):
+		return "invalid"
+	case errors.Is(err, errNotAllowed):
+		return "denied"
+	case errors.Is(err, errUnknownCommand):
+		return "unknown"
+	default:
+		return "error"
+	}
+}
+
+// instrumentedBot counts the failed requests to Telegram.
+type instrumentedBot struct {
+	TelegramBot
+}
+
+func (b instrumentedBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg, err := b.TelegramBot.Send(c)
+	if err != nil {
+		telegramSendErrors.Inc("processor")
+	}
+
+	return msg, err
+}
+
+func (b instrumentedBot) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	resp, err := b.TelegramBot.Request(c)
+	if err != nil {
+		telegramSendErrors.Inc("processor")
+	}
+
+	return resp, err
+}
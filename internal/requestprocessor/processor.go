@@ -1,53 +1,94 @@
 package requestprocessor
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html"
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/audit"
 	"github.com/gibsn/telegram_to_notion/internal/fixespdf"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
 	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/store"
+	"github.com/gibsn/telegram_to_notion/internal/supervisor"
 	"github.com/gibsn/telegram_to_notion/internal/taskscache"
 	"github.com/gibsn/telegram_to_notion/internal/trackscache"
+	"github.com/gibsn/telegram_to_notion/internal/users"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var logger = logging.For("processor")
+
 var (
 	errInvalidCommand = errors.New("invalid command")
 	errUnknownCommand = errors.New("unknown command")
 	errNotACommand    = errors.New("not a command")
+	errNotAllowed     = errors.New("not allowed")
 )
 
 type RequestProcessor struct {
 	notion     *notion.Notion
 	notionDBID string
 
-	bot          *tgbotapi.BotAPI
-	nameResolver *UserResolver
+	bot          TelegramBot
+	nameResolver *users.UserResolver
 	tasksCache   *taskscache.Cache
 	tracksCache  tracksCache
 
-	allowedToCreate map[string]bool
+	access   *access.Control
+	commands []*botCommand
+	reloader Reloader
+	auditLog *audit.Log
+	// undoWindow is how long after a change /undo may revert it.
+	undoWindow time.Duration
 
 	taskLinkParser   *regexp.Regexp
 	timePatternRe    *regexp.Regexp
 	timeValidationRe *regexp.Regexp
 
-	debug bool
-
 	tracksDBID string
 	timeRe     *regexp.Regexp
 
 	pendingInputsMu sync.Mutex
-	pendingInputs   map[conversationKey]pendingInput
+	pendingInputs   *store.Bucket[pendingInput]
 	now             func() time.Time
+
+	// writeQueue is nil unless the writes failing while Notion is unavailable
+	// are queued. nextReplay and replayFailures are used by the replay loop
+	// only.
+	writeQueue     *WriteQueueConfig
+	queuedWrites   *store.Bucket[notionWrite]
+	nextReplay     time.Time
+	replayFailures int
+
+	requestTimeout time.Duration
+	workers        int
+	queueSize      int
+}
+
+// pendingInputsBucket is the bucket of the store holding the dialogs waiting
+// for a reply.
+const pendingInputsBucket = "pending_inputs"
+
+// defaultRequestTimeout bounds handling of a single update, including all
+// Notion requests it makes and their retries.
+const defaultRequestTimeout = time.Minute
+
+// TelegramBot is the part of the Telegram Bot API client used by the
+// processor, it is implemented by *tgbotapi.BotAPI.
+type TelegramBot interface {
+	GetUpdatesChan(tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	StopReceivingUpdates()
+	Send(tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
 }
 
 type tracksCache interface {
@@ -57,14 +98,21 @@ type tracksCache interface {
 }
 
 func NewRequestProcessor(
-	notion *notion.Notion, dbid string, bot *tgbotapi.BotAPI,
+	notion *notion.Notion, dbid string, bot TelegramBot,
 ) *RequestProcessor {
+	memoryStore := store.NewMemoryStore()
 	p := &RequestProcessor{
 		notion:        notion,
 		notionDBID:    dbid,
-		bot:           bot,
-		pendingInputs: make(map[conversationKey]pendingInput),
+		bot:           instrumentedBot{bot},
+		pendingInputs: store.NewBucket[pendingInput](memoryStore, pendingInputsBucket),
+		queuedWrites:  store.NewBucket[notionWrite](memoryStore, queuedWritesBucket),
 		now:           time.Now,
+		undoWindow:    defaultUndoWindow,
+
+		requestTimeout: defaultRequestTimeout,
+		workers:        defaultWorkers,
+		queueSize:      defaultQueueSize,
 	}
 
 	p.taskLinkParser = regexp.MustCompile(`https://www.notion.so/[\w\d\-]+`)
@@ -72,27 +120,99 @@ func NewRequestProcessor(
 	p.timePatternRe = regexp.MustCompile(`^\s*(\d{1,2}:\d{2})(?:\s+(\d{1,2}:\d{2}))?\s*$`)
 	p.timeValidationRe = regexp.MustCompile(`^\d{1,2}:\d{2}$`)
 
-	p.nameResolver = NewUserResolver()
-	p.allowedToCreate = map[string]bool{
-		"alexander_zh": true,
-		"vomadan":      true,
-		"fenyakolles":  true,
-		"nikitacmc":    true,
-		"homesick94":   true,
-		"gibsn":        true,
-	}
+	p.nameResolver = users.NewUserResolver()
+	p.access = access.NewControl(access.DefaultConfig())
+	p.commands = p.newCommands()
 
 	return p
 }
 
-func (p *RequestProcessor) SetDebug(debug bool) {
-	p.debug = debug
+// CheckAccessConfig makes sure the access config overrides only the commands
+// the bot has.
+func (p *RequestProcessor) CheckAccessConfig(config *access.Config) error {
+	for _, command := range config.OverriddenCommands() {
+		name, subName, _ := strings.Cut(command, " ")
+		cmd, ok := p.lookupCommand(name)
+		if ok && subName != "" {
+			_, ok = cmd.subcommand(commandCommon{restOfMessage: subName})
+		}
+		if !ok {
+			return fmt.Errorf("access config overrides unknown command %q", command)
+		}
+	}
+
+	return nil
+}
+
+// SetAccessConfig sets the roles of users and the roles required by commands,
+// it may be called while updates are handled. The roles granted with /grant
+// are kept.
+func (p *RequestProcessor) SetAccessConfig(config *access.Config) error {
+	if err := p.CheckAccessConfig(config); err != nil {
+		return err
+	}
+	p.access.SetConfig(config)
+
+	return nil
+}
+
+// SetReloader enables /reload.
+func (p *RequestProcessor) SetReloader(reloader Reloader) {
+	p.reloader = reloader
+}
+
+// SetAuditLog makes the changes made in Notion recorded in the log, and
+// enables /history and /undo.
+func (p *RequestProcessor) SetAuditLog(l *audit.Log) {
+	p.auditLog = l
+}
+
+// SetUndoWindow sets how long after a change /undo may revert it.
+func (p *RequestProcessor) SetUndoWindow(window time.Duration) {
+	p.undoWindow = window
+}
+
+// SetUserResolver sets the links between Telegram and Notion users, the
+// resolver is shared with the pinger.
+func (p *RequestProcessor) SetUserResolver(resolver *users.UserResolver) {
+	p.nameResolver = resolver
+}
+
+// SetStore makes the processor keep its state in s, so that dialogs waiting
+// for a reply and the roles granted with /grant survive a restart.
+func (p *RequestProcessor) SetStore(s *store.Store) {
+	p.pendingInputs = store.NewBucket[pendingInput](s, pendingInputsBucket)
+	p.queuedWrites = store.NewBucket[notionWrite](s, queuedWritesBucket)
+	p.access.SetStore(s)
 }
 
 func (p *RequestProcessor) SetTasksCache(cache *taskscache.Cache) {
 	p.tasksCache = cache
 }
 
+// tasksChanging keeps the pages the bot is about to change out of the
+// announced changes of the tasks, see taskscache.Cache.MarkChanged.
+func (p *RequestProcessor) tasksChanging(pageIDs ...string) {
+	if p.tasksCache != nil {
+		p.tasksCache.MarkChanged(pageIDs...)
+	}
+}
+
+// tasksChanged makes /tasks reload the edited tasks after a change made by
+// the bot, the changed pages are not announced as changes of the tasks. The
+// pages created are only known then, it is called right after the response.
+func (p *RequestProcessor) tasksChanged(changes []audit.Change) {
+	if p.tasksCache == nil {
+		return
+	}
+
+	pageIDs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		pageIDs = append(pageIDs, change.PageID)
+	}
+	p.tasksCache.MarkChanged(pageIDs...)
+}
+
 func (p *RequestProcessor) SetTracksCache(cache *trackscache.Cache) {
 	p.tracksCache = cache
 }
@@ -101,35 +221,43 @@ func (p *RequestProcessor) SetTracksDBID(tracksDBID string) {
 	p.tracksDBID = tracksDBID
 }
 
+// SetRequestTimeout sets the deadline for handling a single update.
+func (p *RequestProcessor) SetRequestTimeout(timeout time.Duration) {
+	p.requestTimeout = timeout
+}
+
+// SetWorkers sets the number of updates handled in parallel.
+func (p *RequestProcessor) SetWorkers(workers int) {
+	p.workers = workers
+}
+
+// SetQueueSize sets the number of received updates that may wait for a worker
+// or be handled at once, see ProcessUpdates.
+func (p *RequestProcessor) SetQueueSize(size int) {
+	p.queueSize = size
+}
+
 type commandCommon struct {
-	command            string
-	restOfMessage      string
-	repliedToText      string
-	repliedToEntities  []tgbotapi.MessageEntity
-	fromUserName       string
-	isPrivate          bool
-	explicitAssignees  bool
-	chatID             int64
-	fromUserID         int64
+	command           string
+	restOfMessage     string
+	repliedToText     string
+	repliedToEntities []tgbotapi.MessageEntity
+	fromUserName      string
+	isPrivate         bool
+	explicitAssignees bool
+	chatID            int64
+	fromUserID        int64
+	// messageID is the message with the command, zero if it was not sent as
+	// a message, e.g. chosen with a button.
+	messageID          int
 	repliedToMessageID int
 }
 
-func (p *RequestProcessor) parseAndValidateTelegramRequest(update tgbotapi.Update) (
-	commandCommon, error,
-) {
+func (p *RequestProcessor) parseAndValidateTelegramRequest(
+	ctx context.Context, update tgbotapi.Update,
+) (commandCommon, error) {
 	fromUserName := strings.ToLower(update.Message.From.UserName)
 
-	if p.debug {
-		log.Printf(
-			"parseAndValidateTelegramRequest: fromUserName=%s, allowed=%v",
-			fromUserName, p.allowedToCreate[fromUserName],
-		)
-	}
-
-	if !p.allowedToCreate[fromUserName] {
-		return commandCommon{}, fmt.Errorf("user %s is not allowed to send commands", fromUserName)
-	}
-
 	command, cmdErr := extractCommand(update.Message.Text, update.Message.Entities)
 	if cmdErr != nil {
 		return commandCommon{}, cmdErr
@@ -139,43 +267,32 @@ func (p *RequestProcessor) parseAndValidateTelegramRequest(update tgbotapi.Updat
 		command.repliedToText = update.Message.ReplyToMessage.Text
 		command.repliedToEntities = update.Message.ReplyToMessage.Entities
 		command.repliedToMessageID = update.Message.ReplyToMessage.MessageID
-
-		if p.debug {
-			log.Printf(
-				"Reply detected: repliedToText=%s, repliedToMessageID=%d",
-				command.repliedToText, command.repliedToMessageID,
-			)
-		}
 	}
 	command.isPrivate = update.Message.Chat.IsPrivate()
 	command.fromUserName = fromUserName
 	command.fromUserID = update.Message.From.ID
 	command.chatID = update.Message.Chat.ID
-
-	if p.debug {
-		log.Printf(
-			"Parsed command: command=%s, isPrivate=%v, chatID=%d",
-			command.command, command.isPrivate, command.chatID,
-		)
-	}
+	command.messageID = update.Message.MessageID
+
+	logger.DebugContext(ctx, "Parsed command",
+		"command", command.command,
+		"private", command.isPrivate,
+		"chat_id", command.chatID,
+		"replied_to_message_id", command.repliedToMessageID,
+		logging.Text("replied_to_text", command.repliedToText),
+	)
 
 	return command, nil
 }
 
 func (p *RequestProcessor) createMessageLink(chatID int64, messageID int, isPrivate bool) string {
 	if messageID == 0 {
-		if p.debug {
-			log.Printf("createMessageLink: messageID is 0, returning empty string")
-		}
 		return ""
 	}
 
 	if isPrivate {
 		// For private chats, we can't create direct message links without username
 		// Return empty string as private message links require username or special handling
-		if p.debug {
-			log.Printf("createMessageLink: private chat, returning empty string")
-		}
 		return ""
 	}
 
@@ -189,14 +306,7 @@ func (p *RequestProcessor) createMessageLink(chatID int64, messageID int, isPriv
 		groupChatID -= 1000000000000
 	}
 
-	link := fmt.Sprintf("https://t.me/c/%d/%d", groupChatID, messageID)
-
-	if p.debug {
-		log.Printf("createMessageLink: chatID=%d, messageID=%d, isPrivate=%v, groupChatID=%d, link=%s",
-			chatID, messageID, isPrivate, groupChatID, link)
-	}
-
-	return link
+	return fmt.Sprintf("https://t.me/c/%d/%d", groupChatID, messageID)
 }
 
 func extractCommand(text string, entities []tgbotapi.MessageEntity) (commandCommon, error) {
@@ -323,17 +433,30 @@ func (p *RequestProcessor) parseDoneCommand(message commandCommon) (
 	}
 
 	req.TaskLink = taskLink
-	req.Status = notion.StatusDone
+	req.Status = p.taskDoneStatus()
 
 	return req, nil
 }
 
+// taskDoneStatus returns the status set by /done according to the Notion
+// schema.
+func (p *RequestProcessor) taskDoneStatus() string {
+	if p.notion == nil {
+		return notion.DefaultSchema().Tasks.Statuses.Done
+	}
+
+	return p.notion.Schema().Tasks.Statuses.Done
+}
+
 func (p *RequestProcessor) parseTasksCommand(message commandCommon) (
 	string, error,
 ) {
-	userID := p.nameResolver.TgToNotion("@" + message.fromUserName)
+	userID := p.nameResolver.TgIDToNotion(message.fromUserID)
 	if userID == "" {
-		return "", fmt.Errorf("user %s is not found in the system", message.fromUserName)
+		return "", fmt.Errorf(
+			"user %s is not linked to Notion, send /link to me in a private chat",
+			message.fromUserName,
+		)
 	}
 
 	return userID, nil
@@ -352,8 +475,7 @@ func parseTracksCommand(message commandCommon) (bool, error) {
 	return false, fmt.Errorf("unknown argument %q", arg)
 }
 
-type commandHandler func(commandCommon) (string, error)
-type commandResponseHandler func(commandCommon) (commandResponse, error)
+type commandResponseHandler func(context.Context, commandCommon) (commandResponse, error)
 
 type commandResponse struct {
 	text        string
@@ -363,230 +485,163 @@ type commandResponse struct {
 	pending     *pendingInput
 }
 
-func (p *RequestProcessor) ProcessRequests() {
+// ProcessRequests receives updates from Telegram by long polling and handles
+// them until ctx is cancelled.
+func (p *RequestProcessor) ProcessRequests(ctx context.Context) {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	for update := range p.bot.GetUpdatesChan(u) {
-		if update.CallbackQuery != nil {
-			p.processCallbackQuery(update.CallbackQuery)
-			continue
-		}
+	updates := p.bot.GetUpdatesChan(u)
+	defer p.bot.StopReceivingUpdates()
 
-		if update.Message == nil {
-			continue
-		}
-
-		if p.debug {
-			log.Printf("Received message: ChatID=%d, MessageID=%d, From=%s, Text=%s",
-				update.Message.Chat.ID,
-				update.Message.MessageID,
-				update.Message.From.UserName,
-				update.Message.Text,
-			)
-		}
+	p.ProcessUpdates(ctx, updates)
+}
 
-		response, err := p.processMessage(update)
-		if err != nil {
-			if errors.Is(err, errNotACommand) {
-				// Ignore non-commands silently
-				continue
+// ProcessUpdates handles updates from the channel until ctx is cancelled or
+// the channel is closed. Both long polling and the webhook deliver updates
+// here.
+//
+// Updates of different conversations are handled in parallel by a pool of
+// workers, updates of one user in one chat are handled in order. While the
+// queue of received updates is full, no more updates are taken from the
+// channel.
+//
+// Cancelling ctx stops taking new updates, and ProcessUpdates returns once
+// the updates already taken have been handled. They are not aborted, so that
+// a Notion write is not cut in the middle on shutdown, and each of them is
+// still bounded by its own deadline. A panic while handling an update is
+// logged and the update is dropped.
+func (p *RequestProcessor) ProcessUpdates(ctx context.Context, updates <-chan tgbotapi.Update) {
+	handleCtx := context.WithoutCancel(ctx)
+	d := newDispatcher(p.workers, p.queueSize, func(update tgbotapi.Update) {
+		p.handleUpdate(handleCtx, update)
+	})
+	defer d.wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
 			}
-			log.Printf("Got an invalid message from %s: %v", update.Message.From.UserName, err)
-		}
 
-		if response.document != nil {
-			doc := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
-				Name:  response.document.FileName,
-				Bytes: response.document.Bytes,
-			})
-			doc.Caption = response.text
-			doc.ParseMode = "HTML"
-			doc.ReplyToMessageID = update.Message.MessageID
-			if _, err := p.bot.Send(doc); err != nil {
-				log.Printf("Could not send document to Telegram: %v", err)
-			}
-			continue
+			d.dispatch(update)
 		}
+	}
+}
 
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, response.text)
-		msg.ParseMode = "HTML"
-		if response.replyMarkup != nil {
-			msg.ReplyMarkup = *response.replyMarkup
-		} else if response.forceReply != nil {
-			msg.ReplyMarkup = *response.forceReply
-		}
-		// Reply to the command (and into the same forum topic/thread if present)
-		msg.ReplyToMessageID = update.Message.MessageID
+func (p *RequestProcessor) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	// the lines logged while handling the update carry its ID
+	ctx = logging.WithRequestID(ctx, fmt.Sprintf("u%d", update.UpdateID))
+	defer supervisor.LogPanic(ctx, fmt.Sprintf("update %d", update.UpdateID))
 
-		sent, err := p.bot.Send(msg)
-		if err != nil {
-			log.Printf("Could not send message to Telegram: %v", err)
-			continue
-		}
-		if response.pending != nil {
-			pending := *response.pending
-			pending.promptMessageID = sent.MessageID
-			p.setPendingInput(update.Message.Chat.ID, update.Message.From.ID, pending)
+	ctx, cancel := context.WithTimeout(ctx, p.requestTimeout)
+	defer cancel()
+
+	p.processUpdate(ctx, update)
+}
+
+func (p *RequestProcessor) processUpdate(ctx context.Context, update tgbotapi.Update) {
+	if from := update.SentFrom(); from != nil {
+		if err := p.nameResolver.Seen(from.ID, from.UserName); err != nil {
+			logger.ErrorContext(ctx, "Could not save the username of the user", "error", err)
 		}
 	}
-}
 
-func (p *RequestProcessor) processMessage(update tgbotapi.Update) (commandResponse, error) {
-	response, err := p.processRequest(update)
-	if !errors.Is(err, errNotACommand) {
-		return response, err
+	if update.CallbackQuery != nil {
+		p.processCallbackQuery(ctx, update.CallbackQuery)
+		return
 	}
 
-	if p.hasPendingInputReply(update.Message) {
-		return p.processPendingInputReply(update.Message)
+	if update.Message == nil {
+		return
 	}
 
-	return commandResponse{}, errNotACommand
-}
+	logger.DebugContext(ctx, "Received message",
+		"chat_id", update.Message.Chat.ID,
+		"message_id", update.Message.MessageID,
+		"from", update.Message.From.UserName,
+		logging.Text("text", update.Message.Text),
+	)
 
-func (p *RequestProcessor) processRequest(update tgbotapi.Update) (commandResponse, error) {
-	message, err := p.parseAndValidateTelegramRequest(update)
+	response, err := p.processMessage(ctx, update)
 	if err != nil {
-		return commandResponse{}, err
+		if errors.Is(err, errNotACommand) {
+			// Ignore non-commands silently
+			return
+		}
+		logger.WarnContext(ctx, "Could not process message",
+			"from", update.Message.From.UserName, "error", err,
+		)
 	}
 
-	var response commandResponse
-
-	switch message.command {
-	case "/task":
-		if hasNoCommandArguments(message) {
-			response = newCommandInputResponse(message)
-		} else {
-			response.text, err = withErrorReply(message, p.processTask)
-		}
-	case "/agenda":
-		if hasNoCommandArguments(message) {
-			response = newCommandInputResponse(message)
-		} else {
-			response.text, err = withErrorReply(message, p.processAgenda)
-		}
-	case "/deadline":
-		if hasNoCommandArguments(message) && p.extractTaskLink(message) != "" {
-			response = newCommandInputResponse(message)
-		} else {
-			response.text, err = withErrorReply(message, p.processDeadline)
-		}
-	case "/done":
-		response.text, err = withErrorReply(message, p.processDone)
-	case "/tasks":
-		response.text, err = withErrorReply(message, p.processTasks)
-	case "/tracks":
-		response.text, err = withErrorReply(message, p.processTracks)
-	case "/cancel":
-		response.text = p.processCancel(message)
-	case "/tweak":
-		switch {
-		case isTweakMenuCommand(message):
-			response = newTweakMenuResponse()
-		case isTweakRenderCommand(message):
-			response, err = withUsageErrorReply(
-				message,
-				"/tweak render $track $iteration_number",
-				p.processTweakRenderResponse,
-			)
-		case isTweakToWorkCommand(message):
-			response, err = withUsageErrorReply(
-				message,
-				"/tweak towork $track",
-				p.processTweakToWorkResponse,
-			)
-		default:
-			response.text, err = withErrorReply(message, p.processTweak)
+	if response.document != nil {
+		doc := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+			Name:  response.document.FileName,
+			Bytes: response.document.Bytes,
+		})
+		doc.Caption = response.text
+		doc.ParseMode = "HTML"
+		doc.ReplyToMessageID = update.Message.MessageID
+		if _, err := p.bot.Send(doc); err != nil {
+			logger.ErrorContext(ctx, "Could not send document to Telegram", "error", err)
 		}
-	default:
-		err = errUnknownCommand
-		response.text = "🖕🖕🖕"
+		return
 	}
 
-	return response, err
-}
-
-func withErrorReply(message commandCommon, cb commandHandler) (string, error) {
-	reply, err := cb(message)
-	if err == nil {
-		return reply, nil
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, response.text)
+	msg.ParseMode = "HTML"
+	if response.replyMarkup != nil {
+		msg.ReplyMarkup = *response.replyMarkup
+	} else if response.forceReply != nil {
+		msg.ReplyMarkup = *response.forceReply
 	}
+	// Reply to the command (and into the same forum topic/thread if present)
+	msg.ReplyToMessageID = update.Message.MessageID
 
-	if !errors.Is(err, errInvalidCommand) {
-		return err.Error(), err
+	sent, err := p.bot.Send(msg)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not send message to Telegram", "error", err)
+		return
 	}
-
-	switch message.command {
-	case "/task":
-		reply = fmt.Sprintf(
-			"%s\n\nUsage:\n/task $task_name\n$assignee1 $assignee2 ...\n$task_description (optional)",
-			err.Error(),
-		)
-	case "/agenda":
-		reply = fmt.Sprintf(
-			"%s\n\nUsage:\n/agenda $agenda",
-			err.Error(),
-		)
-	case "/deadline":
-		reply = fmt.Sprintf(
-			"%s\n\nMust be a reply to a message with task link \nUsage:\n/deadline YYYY-MM-DD",
-			err.Error(),
-		)
-	case "/done":
-		reply = fmt.Sprintf(
-			"%s\n\nMust be a reply to a message with task link \nUsage:\n/done",
-			err.Error(),
-		)
-	case "/tasks":
-		reply = fmt.Sprintf(
-			"%s\n\nUsage:\n/tasks",
-			err.Error(),
-		)
-	case "/tracks":
-		reply = fmt.Sprintf(
-			"%s\n\nUsage:\n/tracks\n/tracks all",
-			err.Error(),
-		)
-	case "/tweak":
-		reply = fmt.Sprintf(
-			"%s\n\nUsage:\n"+
-				"/tweak demo|mix $track\n"+
-				"$edit_name\n"+
-				"[start_time [end_time]] (time format as 0:05 or 01:10)\n"+
-				"[description]\n\n"+
-				"/tweak render $track $iteration_number\n"+
-				"/tweak towork $track\n",
-			err.Error(),
-		)
+	if response.pending != nil {
+		pending := *response.pending
+		pending.promptMessageID = sent.MessageID
+		p.setPendingInput(ctx, update.Message.Chat.ID, update.Message.From.ID, pending)
 	}
-
-	return reply, err
 }
 
-func withUsageErrorReply(
-	message commandCommon,
-	usage string,
-	cb commandResponseHandler,
+func (p *RequestProcessor) processMessage(
+	ctx context.Context, update tgbotapi.Update,
 ) (commandResponse, error) {
-	response, err := cb(message)
-	if err == nil {
-		return response, nil
+	response, err := p.processRequest(ctx, update)
+	if !errors.Is(err, errNotACommand) {
+		return response, err
 	}
 
-	if !errors.Is(err, errInvalidCommand) {
-		return commandResponse{text: err.Error()}, err
+	if p.hasPendingInputReply(ctx, update.Message) {
+		return p.processPendingInputReply(ctx, update.Message)
 	}
 
-	return commandResponse{text: fmt.Sprintf("%s\n\nUsage:\n%s", err.Error(), usage)}, err
+	return commandResponse{}, errNotACommand
 }
 
-func (p *RequestProcessor) processTask(message commandCommon) (string, error) {
-	req, err := parseTaskCommand(message)
+func (p *RequestProcessor) processRequest(
+	ctx context.Context, update tgbotapi.Update,
+) (commandResponse, error) {
+	message, err := p.parseAndValidateTelegramRequest(ctx, update)
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
+		return commandResponse{}, err
 	}
 
+	return p.runCommand(ctx, message)
+}
+
+func (p *RequestProcessor) processTask(
+	ctx context.Context, message commandCommon, req *notion.CreateTaskRequest,
+) (string, error) {
 	req.NotionDBID = p.notionDBID
 
 	assigneesResolved, err := p.nameResolver.ResolveArr(req.Assignees)
@@ -597,97 +652,77 @@ func (p *RequestProcessor) processTask(message commandCommon) (string, error) {
 	reqCopy := *req
 	reqCopy.Assignees = assigneesResolved
 
-	if p.debug {
-		req.Debug = true
-	}
-
-	url, err := p.notion.CreateNotionTask(&reqCopy)
+	reply, err := p.applyOrQueue(ctx, message, &notionWrite{
+		Command: "/task",
+		Task:    &reqCopy,
+		Reply: fmt.Sprintf(
+			"Task has been successfully created and assigned to %s:\n",
+			strings.Join(req.Assignees, ", "),
+		),
+	})
 	if err != nil {
 		return "", fmt.Errorf("error creating a task in Notion: %w", err)
 	}
 
-	reply := fmt.Sprintf(
-		"Task has been successfully created and assigned to %s:\n%s",
-		strings.Join(req.Assignees, ", "),
-		url,
-	)
-
 	return reply, nil
 }
 
-func (p *RequestProcessor) processAgenda(message commandCommon) (string, error) {
-	req, err := parseAgendaCommand(message)
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
+func (p *RequestProcessor) processAgenda(
+	ctx context.Context, message commandCommon, req *notion.CreateTaskRequest,
+) (string, error) {
 	req.NotionDBID = p.notionDBID
 	req.Assignees = p.nameResolver.AllNotionUserIDs()
 	req.TaskName = "Agenda: " + req.TaskName
 
-	if p.debug {
-		req.Debug = true
-	}
-
-	url, err := p.notion.CreateNotionTask(req)
+	reply, err := p.applyOrQueue(ctx, message, &notionWrite{
+		Command: "/agenda", Task: req, Reply: "Agenda created:\n",
+	})
 	if err != nil {
 		return "", fmt.Errorf("error creating a task in Notion: %w", err)
 	}
 
-	return "Agenda created:\n" + url, nil
+	return reply, nil
 }
 
-func (p *RequestProcessor) processDeadline(message commandCommon) (string, error) {
-	req, err := p.parseSetDeadlineCommand(message)
+func (p *RequestProcessor) processDeadline(
+	ctx context.Context, message commandCommon, req *notion.SetDeadlineRequest,
+) (string, error) {
+	reply, err := p.applyOrQueue(ctx, message, &notionWrite{
+		Command:  "/deadline",
+		Deadline: req,
+		Reply: fmt.Sprintf(
+			"Deadline has been successfully set to %s",
+			req.Deadline.Format("2006-01-02"),
+		),
+	})
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
-	if p.debug {
-		req.Debug = true
-	}
-
-	if err := p.notion.SetDeadline(req); err != nil {
 		return "", fmt.Errorf("could not set deadline to %s: %w", req.Deadline.Format("2006-01-02"), err)
 	}
 
-	reply := fmt.Sprintf(
-		"Deadline has been successfully set to %s",
-		req.Deadline.Format("2006-01-02"),
-	)
-
 	return reply, nil
 }
 
-func (p *RequestProcessor) processDone(message commandCommon) (string, error) {
-	req, err := p.parseDoneCommand(message)
+func (p *RequestProcessor) processDone(
+	ctx context.Context, message commandCommon, req *notion.SetStatusRequest,
+) (string, error) {
+	reply, err := p.applyOrQueue(ctx, message, &notionWrite{
+		Command: "/done", Status: req, Reply: "Task has been successfully marked as Done",
+	})
 	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
-	if p.debug {
-		req.Debug = true
-	}
-
-	if err := p.notion.SetStatus(req); err != nil {
 		return "", fmt.Errorf("could not set status to done: %w", err)
 	}
 
-	reply := "Task has been successfully marked as Done"
-
 	return reply, nil
 }
 
-func (p *RequestProcessor) processTasks(message commandCommon) (string, error) {
-	userID, err := p.parseTasksCommand(message)
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
+func (p *RequestProcessor) processTasks(
+	ctx context.Context, _ commandCommon, userID string,
+) (string, error) {
 	if p.tasksCache == nil {
 		return "", fmt.Errorf("tasks cache is not initialized")
 	}
 
-	tasks, err := p.tasksCache.GetTasksForUser(userID)
+	tasks, err := p.tasksCache.GetTasksForUser(ctx, userID)
 	if err != nil {
 		return "", fmt.Errorf("error loading tasks: %w", err)
 	}
@@ -716,21 +751,21 @@ func (p *RequestProcessor) processTasks(message commandCommon) (string, error) {
 	return reply.String(), nil
 }
 
-func (p *RequestProcessor) processTracks(message commandCommon) (string, error) {
-	loadAll, err := parseTracksCommand(message)
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
+func (p *RequestProcessor) processTracks(
+	ctx context.Context, _ commandCommon, loadAll bool,
+) (string, error) {
 	if p.tracksDBID == "" {
 		return "", fmt.Errorf("tracks database is not configured")
 	}
 
-	var tracks []notion.TrackPage
+	var (
+		tracks []notion.TrackPage
+		err    error
+	)
 	if loadAll {
-		tracks, err = p.notion.LoadAllTrackPages(p.tracksDBID)
+		tracks, err = p.notion.LoadAllTrackPagesContext(ctx, p.tracksDBID)
 	} else {
-		tracks, err = p.notion.LoadTrackPages(p.tracksDBID)
+		tracks, err = p.notion.LoadTrackPagesContext(ctx, p.tracksDBID)
 	}
 	if err != nil {
 		return "", fmt.Errorf("error loading tracks: %w", err)
@@ -850,16 +885,6 @@ func (p *RequestProcessor) parseTweakCommand(message commandCommon) (*TweakReque
 	return req, nil
 }
 
-func isTweakRenderCommand(message commandCommon) bool {
-	parts := strings.Fields(message.restOfMessage)
-	return len(parts) > 0 && strings.EqualFold(parts[0], "render")
-}
-
-func isTweakToWorkCommand(message commandCommon) bool {
-	parts := strings.Fields(message.restOfMessage)
-	return len(parts) > 0 && strings.EqualFold(parts[0], "towork")
-}
-
 func parseTweakRenderCommand(message commandCommon) (*TweakRenderRequest, error) {
 	parts := strings.Fields(strings.TrimSpace(message.restOfMessage))
 	if len(parts) < 3 || !strings.EqualFold(parts[0], "render") {
@@ -894,13 +919,8 @@ func parseTweakToWorkCommand(message commandCommon) (*TweakToWorkRequest, error)
 }
 
 func (p *RequestProcessor) processTweakRender(
-	message commandCommon,
+	ctx context.Context, _ commandCommon, req *TweakRenderRequest,
 ) (string, *fixespdf.Document, error) {
-	req, err := parseTweakRenderCommand(message)
-	if err != nil {
-		return "", nil, fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
 	if p.tracksCache == nil {
 		return "", nil, fmt.Errorf("tracks cache is not initialized")
 	}
@@ -914,7 +934,7 @@ func (p *RequestProcessor) processTweakRender(
 		), nil, nil
 	}
 
-	tweaks, err := p.notion.LoadReadyMixTweaksForTrack(trackPageID)
+	tweaks, err := p.notion.LoadReadyMixTweaksForTrackContext(ctx, trackPageID)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to load ready tweaks: %w", err)
 	}
@@ -922,7 +942,7 @@ func (p *RequestProcessor) processTweakRender(
 		return fmt.Sprintf("No tweaks found for track \"%s\"", req.TrackName), nil, nil
 	}
 
-	unreadyTweaksCount, err := p.notion.CountUnreadyMixTweaksForTrack(trackPageID)
+	unreadyTweaksCount, err := p.notion.CountUnreadyMixTweaksForTrackContext(ctx, trackPageID)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to count unready tweaks: %w", err)
 	}
@@ -948,18 +968,15 @@ func (p *RequestProcessor) processTweakRender(
 }
 
 func (p *RequestProcessor) processTweakRenderResponse(
-	message commandCommon,
+	ctx context.Context, message commandCommon, req *TweakRenderRequest,
 ) (commandResponse, error) {
-	reply, doc, err := p.processTweakRender(message)
+	reply, doc, err := p.processTweakRender(ctx, message, req)
 	return commandResponse{text: reply, document: doc}, err
 }
 
-func (p *RequestProcessor) processTweakToWork(message commandCommon) (string, error) {
-	req, err := parseTweakToWorkCommand(message)
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
+func (p *RequestProcessor) processTweakToWork(
+	ctx context.Context, message commandCommon, req *TweakToWorkRequest,
+) (string, error) {
 	if p.tracksCache == nil {
 		return "", fmt.Errorf("tracks cache is not initialized")
 	}
@@ -973,10 +990,22 @@ func (p *RequestProcessor) processTweakToWork(message commandCommon) (string, er
 		), nil
 	}
 
-	updated, err := p.notion.MoveReadyMixTweaksToWorkForTrack(trackPageID)
+	moved, err := p.notion.MoveReadyMixTweaksToWorkForTrackContext(ctx, trackPageID)
+	if len(moved) > 0 || err != nil {
+		statuses := p.notion.Schema().TweaksMix.Statuses
+		changes := make([]audit.Change, 0, len(moved))
+		for _, pageID := range moved {
+			changes = append(changes, audit.Change{
+				PageID: pageID, Field: audit.FieldStatus, Old: statuses.Ready, New: statuses.InWork,
+				OldKnown: true,
+			})
+		}
+		p.recordChange(ctx, message, tweakToWorkCommand, changes, err)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to move ready tweaks to work: %w", err)
 	}
+	updated := len(moved)
 	if updated == 0 {
 		return fmt.Sprintf("No ready tweaks found for track \"%s\"", req.TrackName), nil
 	}
@@ -985,24 +1014,17 @@ func (p *RequestProcessor) processTweakToWork(message commandCommon) (string, er
 		"Moved %d %s for <a href=\"%s\">%s</a> to work",
 		updated,
 		tweaksWord(updated),
-		trackLinkFromPageID(trackPageID),
+		pageLinkFromID(trackPageID),
 		html.EscapeString(req.TrackName),
 	), nil
 }
 
-func (p *RequestProcessor) processTweakToWorkResponse(
-	message commandCommon,
-) (commandResponse, error) {
-	reply, err := p.processTweakToWork(message)
-	return commandResponse{text: reply}, err
-}
-
 func tweakRenderCaption(trackName, trackPageID string, tweaksCount, unreadyTweaksCount int) string {
 	return fmt.Sprintf(
 		"Generated %d %s for <a href=\"%s\">%s</a>\nUnready tweaks left: %d",
 		tweaksCount,
 		tweaksWord(tweaksCount),
-		trackLinkFromPageID(trackPageID),
+		pageLinkFromID(trackPageID),
 		html.EscapeString(trackName),
 		unreadyTweaksCount,
 	)
@@ -1015,16 +1037,13 @@ func tweaksWord(count int) string {
 	return "tweaks"
 }
 
-func trackLinkFromPageID(pageID string) string {
+func pageLinkFromID(pageID string) string {
 	return "https://www.notion.so/" + strings.ReplaceAll(pageID, "-", "")
 }
 
-func (p *RequestProcessor) processTweak(message commandCommon) (string, error) {
-	req, err := p.parseTweakCommand(message)
-	if err != nil {
-		return "", fmt.Errorf("%w: %w", errInvalidCommand, err)
-	}
-
+func (p *RequestProcessor) processTweak(
+	ctx context.Context, message commandCommon, req *TweakRequest,
+) (string, error) {
 	if p.tracksCache == nil {
 		return "", fmt.Errorf("tracks cache is not initialized")
 	}
@@ -1038,11 +1057,10 @@ func (p *RequestProcessor) processTweak(message commandCommon) (string, error) {
 		), nil
 	}
 
-	authorID := p.nameResolver.TgToNotion("@" + message.fromUserName)
+	authorID := p.nameResolver.TgIDToNotion(message.fromUserID)
 	if authorID == "" {
-		log.Printf(
-			"warning: could not resolve telegram username @%s to Notion user id",
-			message.fromUserName,
+		logger.WarnContext(ctx, "Telegram user is not linked to a Notion user",
+			"user", message.fromUserName,
 		)
 	}
 
@@ -1073,15 +1091,12 @@ func (p *RequestProcessor) processTweak(message commandCommon) (string, error) {
 		AuthorNotionUser: authorID,
 	}
 
-	var url string
-	if req.Mode == tweakModeMix {
-		url, err = p.notion.CreateTweakMix(r)
-	} else {
-		url, err = p.notion.CreateTweakDemo(r)
-	}
+	reply, err := p.applyOrQueue(ctx, message, &notionWrite{
+		Command: "/tweak " + string(req.Mode), Tweak: r, Reply: "Tweak has been created:\n",
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create tweak: %w", err)
 	}
 
-	return "Tweak has been created:\n" + url, nil
+	return reply, nil
 }
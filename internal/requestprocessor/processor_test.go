@@ -1,6 +1,7 @@
 package requestprocessor
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -387,45 +388,46 @@ func TestParseDoneCommand(t *testing.T) {
 
 func TestParseTasksCommand(t *testing.T) {
 	tests := []struct {
-		name         string
-		fromUserName string
-		expectErr    bool
-		want         string
+		name       string
+		fromUserID int64
+		expectErr  bool
+		want       string
 	}{
 		{
-			name:         "valid user",
-			fromUserName: "gibsn",
-			expectErr:    false,
-			want:         "7439e2ca-75f8-4024-b170-620ef7ed08b1",
+			name:       "valid user",
+			fromUserID: 1,
+			expectErr:  false,
+			want:       "7439e2ca-75f8-4024-b170-620ef7ed08b1",
 		},
 		{
-			name:         "another valid user",
-			fromUserName: "alexander_zh",
-			expectErr:    false,
-			want:         "9e8f4963-fd1c-4bb5-bdd2-7f29a9a8698a",
+			name:       "another valid user",
+			fromUserID: 3,
+			expectErr:  false,
+			want:       "9e8f4963-fd1c-4bb5-bdd2-7f29a9a8698a",
 		},
 		{
-			name:         "unknown user",
-			fromUserName: "unknown_user",
-			expectErr:    true,
-			want:         "",
+			name:       "unlinked user",
+			fromUserID: 100,
+			expectErr:  true,
+			want:       "",
 		},
 		{
-			name:         "empty username",
-			fromUserName: "",
-			expectErr:    true,
-			want:         "",
+			name:       "no user",
+			fromUserID: 0,
+			expectErr:  true,
+			want:       "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			command := commandCommon{
-				fromUserName: tt.fromUserName,
-				chatID:       -123456789, // Mock chat ID
+				fromUserID: tt.fromUserID,
+				chatID:     -123456789, // Mock chat ID
 			}
 
 			processor := NewRequestProcessor(nil, "", nil)
+			processor.SetUserResolver(newTestUserResolver(t))
 			got, err := processor.parseTasksCommand(command)
 
 			if tt.expectErr {
@@ -452,7 +454,7 @@ func TestProcessTasksIncludesStatus(t *testing.T) {
 							},
 						},
 						"Исполнитель": map[string]interface{}{
-							"People": []map[string]interface{}{
+							"people": []map[string]interface{}{
 								{"name": "Kirill", "id": "7439e2ca-75f8-4024-b170-620ef7ed08b1"},
 							},
 						},
@@ -462,7 +464,7 @@ func TestProcessTasksIncludesStatus(t *testing.T) {
 							},
 						},
 						"Дедлайн": map[string]interface{}{
-							"Date": map[string]interface{}{
+							"date": map[string]interface{}{
 								"start": "2026-07-12",
 							},
 						},
@@ -478,9 +480,12 @@ func TestProcessTasksIncludesStatus(t *testing.T) {
 	n.SetAPIBaseURL(server.URL + "/")
 
 	p := NewRequestProcessor(n, "", nil)
+	p.SetUserResolver(newTestUserResolver(t))
 	p.SetTasksCache(taskscache.NewTasksCache(n, "tasks-db-id", time.Minute))
 
-	reply, err := p.processTasks(commandCommon{fromUserName: "gibsn"})
+	userID, err := p.parseTasksCommand(commandCommon{fromUserID: 1})
+	assert.NoError(t, err)
+	reply, err := p.processTasks(context.Background(), commandCommon{}, userID)
 
 	assert.NoError(t, err)
 	assert.Contains(
@@ -614,7 +619,9 @@ func TestProcessTracks(t *testing.T) {
 			cmd, err := extractCommand(tt.input, makeBotCommandEntities(tt.input))
 			assert.NoError(t, err)
 
-			reply, err := p.processTracks(cmd)
+			loadAll, err := parseTracksCommand(cmd)
+			assert.NoError(t, err)
+			reply, err := p.processTracks(context.Background(), cmd, loadAll)
 			assert.NoError(t, err)
 			assert.True(t, strings.HasPrefix(reply, tt.wantPrefix))
 			expectedAlpha := "1. <a href=\"https://www.notion.so/" +
@@ -992,7 +999,7 @@ func TestProcessTweakRender(t *testing.T) {
 	n.SetAPIBaseURL(server.URL + "/v1/")
 	n.SetTweaksDBIDs("demo-db-id", tweaksDBID)
 	tracksCache := trackscache.NewTracksCache(n, tracksDBID, time.Minute)
-	assert.NoError(t, tracksCache.RefreshCache())
+	assert.NoError(t, tracksCache.RefreshCache(context.Background()))
 
 	p := NewRequestProcessor(n, "", nil)
 	p.SetTracksCache(tracksCache)
@@ -1000,7 +1007,10 @@ func TestProcessTweakRender(t *testing.T) {
 	cmd, err := extractCommand(input, makeBotCommandEntities(input))
 	assert.NoError(t, err)
 
-	reply, doc, err := p.processTweakRender(cmd)
+	req, err := parseTweakRenderCommand(cmd)
+	assert.NoError(t, err)
+
+	reply, doc, err := p.processTweakRender(context.Background(), cmd, req)
 
 	assert.NoError(t, err)
 	assert.Equal(
@@ -1105,7 +1115,7 @@ func TestProcessTweakRenderNoReadyTweaks(t *testing.T) {
 	n.SetAPIBaseURL(server.URL + "/v1/")
 	n.SetTweaksDBIDs("demo-db-id", tweaksDBID)
 	tracksCache := trackscache.NewTracksCache(n, tracksDBID, time.Minute)
-	assert.NoError(t, tracksCache.RefreshCache())
+	assert.NoError(t, tracksCache.RefreshCache(context.Background()))
 
 	p := NewRequestProcessor(n, "", nil)
 	p.SetTracksCache(tracksCache)
@@ -1113,7 +1123,10 @@ func TestProcessTweakRenderNoReadyTweaks(t *testing.T) {
 	cmd, err := extractCommand(input, makeBotCommandEntities(input))
 	assert.NoError(t, err)
 
-	reply, doc, err := p.processTweakRender(cmd)
+	req, err := parseTweakRenderCommand(cmd)
+	assert.NoError(t, err)
+
+	reply, doc, err := p.processTweakRender(context.Background(), cmd, req)
 
 	assert.NoError(t, err)
 	assert.Nil(t, doc)
@@ -1187,7 +1200,7 @@ func TestProcessTweakToWork(t *testing.T) {
 	n.SetAPIBaseURL(server.URL + "/v1/")
 	n.SetTweaksDBIDs("demo-db-id", tweaksDBID)
 	tracksCache := trackscache.NewTracksCache(n, tracksDBID, time.Minute)
-	assert.NoError(t, tracksCache.RefreshCache())
+	assert.NoError(t, tracksCache.RefreshCache(context.Background()))
 
 	p := NewRequestProcessor(n, "", nil)
 	p.SetTracksCache(tracksCache)
@@ -1195,7 +1208,10 @@ func TestProcessTweakToWork(t *testing.T) {
 	cmd, err := extractCommand(input, makeBotCommandEntities(input))
 	assert.NoError(t, err)
 
-	reply, err := p.processTweakToWork(cmd)
+	req, err := parseTweakToWorkCommand(cmd)
+	assert.NoError(t, err)
+
+	reply, err := p.processTweakToWork(context.Background(), cmd, req)
 
 	assert.NoError(t, err)
 	assert.Equal(
@@ -1244,7 +1260,7 @@ func TestProcessTweakToWorkNoReadyTweaks(t *testing.T) {
 	n.SetAPIBaseURL(server.URL + "/v1/")
 	n.SetTweaksDBIDs("demo-db-id", tweaksDBID)
 	tracksCache := trackscache.NewTracksCache(n, tracksDBID, time.Minute)
-	assert.NoError(t, tracksCache.RefreshCache())
+	assert.NoError(t, tracksCache.RefreshCache(context.Background()))
 
 	p := NewRequestProcessor(n, "", nil)
 	p.SetTracksCache(tracksCache)
@@ -1252,7 +1268,10 @@ func TestProcessTweakToWorkNoReadyTweaks(t *testing.T) {
 	cmd, err := extractCommand(input, makeBotCommandEntities(input))
 	assert.NoError(t, err)
 
-	reply, err := p.processTweakToWork(cmd)
+	req, err := parseTweakToWorkCommand(cmd)
+	assert.NoError(t, err)
+
+	reply, err := p.processTweakToWork(context.Background(), cmd, req)
 
 	assert.NoError(t, err)
 	assert.Equal(t, "No ready tweaks found for track \"Track One\"", reply)
@@ -1392,18 +1411,6 @@ func TestParseAgendaCommand(t *testing.T) {
 	}
 }
 
-func TestAllNotionUserIDs(t *testing.T) {
-	r := NewUserResolver()
-	ids := r.AllNotionUserIDs()
-	// UserResolver has 7 known users (see userresolver.go)
-	assert.Len(t, ids, 7)
-	// All IDs should be non-empty UUIDs
-	for _, id := range ids {
-		assert.NotEmpty(t, id)
-		assert.Contains(t, id, "-")
-	}
-}
-
 func TestProcessAgenda(t *testing.T) {
 	const testDBID = "test-db-id"
 	const testPageID = "12345678-1234-1234-1234-123456789abc"
@@ -1432,7 +1439,7 @@ func TestProcessAgenda(t *testing.T) {
 		}
 		assigneeField, _ := payload.Properties["Исполнитель"].(map[string]interface{})
 		people, _ := assigneeField["people"].([]interface{})
-		expectedCount := len(NewUserResolver().AllNotionUserIDs())
+		expectedCount := len(testUserLinks())
 		if len(people) != expectedCount {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -1445,14 +1452,82 @@ func TestProcessAgenda(t *testing.T) {
 	n := notion.NewNotion("test-token")
 	n.SetAPIBaseURL(server.URL + "/v1/")
 	p := NewRequestProcessor(n, testDBID, nil)
+	p.SetUserResolver(newTestUserResolver(t))
 
 	cmd, err := extractCommand("/agenda Weekly sync", makeBotCommandEntities("/agenda Weekly sync"))
 	assert.NoError(t, err)
-	message := cmd
+	req, err := parseAgendaCommand(cmd)
+	assert.NoError(t, err)
 
-	reply, err := p.processAgenda(message)
+	reply, err := p.processAgenda(context.Background(), cmd, req)
 	assert.NoError(t, err)
 	assert.Contains(t, reply, "Agenda created:")
 	assert.Contains(t, reply, "https://www.notion.so/")
 	assert.Contains(t, reply, "12345678123412341234123456789abc")
 }
+
+func TestProcessDoneStopsAtDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/v1/")
+	p := NewRequestProcessor(n, "", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	cmd := commandCommon{
+		command:       "/done",
+		repliedToText: "https://www.notion.so/Task-1234567890abcdef1234567890abcdef",
+	}
+	req, err := p.parseDoneCommand(cmd)
+	assert.NoError(t, err)
+
+	started := time.Now()
+	_, err = p.processDone(ctx, cmd, req)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+// panickingBot panics on the first message sent and records the rest.
+type panickingBot struct {
+	TelegramBot
+	panicked bool
+	sent     []string
+}
+
+func (b *panickingBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if !b.panicked {
+		b.panicked = true
+		panic("send failed")
+	}
+	b.sent = append(b.sent, c.(tgbotapi.MessageConfig).Text)
+
+	return tgbotapi.Message{}, nil
+}
+
+func TestProcessUpdatesRecoversFromPanic(t *testing.T) {
+	bot := &panickingBot{}
+	p := NewRequestProcessor(nil, "", bot)
+
+	updates := make(chan tgbotapi.Update, 2)
+	for i := 1; i <= 2; i++ {
+		updates <- tgbotapi.Update{UpdateID: i, Message: &tgbotapi.Message{
+			MessageID: i,
+			From:      &tgbotapi.User{ID: 1, UserName: "gibsn"},
+			Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+			Text:      "/cancel",
+			Entities:  makeBotCommandEntities("/cancel"),
+		}}
+	}
+	close(updates)
+
+	assert.NotPanics(t, func() { p.ProcessUpdates(context.Background(), updates) })
+	assert.Equal(t, []string{"There is no active action."}, bot.sent)
+}
@@ -0,0 +1,40 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+)
+
+// Reloader reloads the config of the bot, it is used by /reload.
+type Reloader interface {
+	// Reload applies the config file if it is valid and describes what has
+	// changed, the current config is kept otherwise.
+	Reload() (string, error)
+}
+
+func parseReloadCommand(message commandCommon) (struct{}, error) {
+	if strings.TrimSpace(message.restOfMessage) != "" {
+		return struct{}{}, errors.New("the command takes no arguments")
+	}
+
+	return struct{}{}, nil
+}
+
+func (p *RequestProcessor) processReload(
+	_ context.Context, _ commandCommon, _ struct{},
+) (string, error) {
+	if p.reloader == nil {
+		return "The bot was started without a config file, there is nothing to reload.", nil
+	}
+
+	summary, err := p.reloader.Reload()
+	if err != nil {
+		// the error describes the invalid config, it is meant for the admin
+		return "The config is invalid, the current one is kept:\n" +
+			html.EscapeString(err.Error()), nil
+	}
+
+	return html.EscapeString(summary), nil
+}
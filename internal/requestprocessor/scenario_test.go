@@ -0,0 +1,399 @@
+package requestprocessor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/access"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+	"github.com/gibsn/telegram_to_notion/internal/telegramtest"
+	"github.com/gibsn/telegram_to_notion/internal/webhook"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const scenarioTimeout = 5 * time.Second
+
+// scenario runs the processor against the fake Bot API and the Notion
+// emulator, and plays a user talking to the bot in a group chat.
+type scenario struct {
+	*e2eEnv
+	t    *testing.T
+	tg   *telegramtest.Server
+	user *tgbotapi.User
+	chat *tgbotapi.Chat
+	// seen is the number of messages of the bot already checked by expect.
+	seen int
+	// stop stops receiving updates and waits for the processor to return.
+	stop func()
+}
+
+// receiveFunc receives updates for the processor until ctx is cancelled.
+type receiveFunc func(ctx context.Context, t *testing.T, s *scenario)
+
+// polling receives updates by long polling.
+func polling(ctx context.Context, _ *testing.T, s *scenario) {
+	s.processor.ProcessRequests(ctx)
+}
+
+// viaWebhook sets a webhook and passes the updates it receives on.
+func viaWebhook(ctx context.Context, t *testing.T, s *scenario) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bot, err := s.tg.NewBotAPI()
+	if !assert.NoError(t, err) {
+		return
+	}
+	receiver, err := webhook.NewReceiver(bot, webhook.Config{
+		URL:         "http://" + ln.Addr().String() + "/telegram",
+		SecretToken: "secret",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		s.processor.ProcessUpdates(ctx, receiver.Updates())
+	}()
+
+	assert.NoError(t, receiver.Serve(ctx, ln))
+	<-processed
+}
+
+// forEachMode runs the test with updates received by polling and by webhook.
+func forEachMode(t *testing.T, test func(t *testing.T, s *scenario)) {
+	modes := []struct {
+		name    string
+		receive receiveFunc
+	}{
+		{"polling", polling},
+		{"webhook", viaWebhook},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			test(t, newScenario(t, mode.receive))
+		})
+	}
+}
+
+func newScenario(t *testing.T, receive receiveFunc) *scenario {
+	t.Helper()
+
+	e := newE2EEnv(t)
+
+	tg := telegramtest.NewServer()
+	t.Cleanup(tg.Close)
+
+	bot, err := tg.NewBotAPI()
+	require.NoError(t, err)
+	e.processor.bot = instrumentedBot{bot}
+
+	s := &scenario{
+		e2eEnv: e,
+		t:      t,
+		tg:     tg,
+		user:   &tgbotapi.User{ID: 1, UserName: "gibsn"},
+		chat:   &tgbotapi.Chat{ID: e2eChatID, Type: "supergroup"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		receive(ctx, t, s)
+	}()
+	var once sync.Once
+	s.stop = func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(s.stop)
+
+	return s
+}
+
+// say sends a message to the chat.
+func (s *scenario) say(text string) tgbotapi.Message {
+	s.t.Helper()
+
+	return s.send(tgbotapi.Message{From: s.user, Chat: s.chat, Text: text})
+}
+
+// reply sends a reply to a message of the chat.
+func (s *scenario) reply(to tgbotapi.Message, text string) tgbotapi.Message {
+	s.t.Helper()
+
+	return s.send(tgbotapi.Message{
+		From: s.user, Chat: s.chat, Text: text,
+		ReplyToMessage: &tgbotapi.Message{MessageID: to.MessageID},
+	})
+}
+
+func (s *scenario) send(msg tgbotapi.Message) tgbotapi.Message {
+	s.t.Helper()
+
+	sent, err := s.tg.SendMessage(msg)
+	require.NoError(s.t, err)
+
+	return sent
+}
+
+// press presses a button under a message of the bot and waits for the bot
+// to answer the callback query.
+func (s *scenario) press(msg tgbotapi.Message, button string) telegramtest.CallbackAnswer {
+	s.t.Helper()
+
+	id, err := s.tg.PressButton(s.user, msg, button)
+	require.NoError(s.t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
+	defer cancel()
+
+	answer, err := s.tg.WaitAnswered(ctx, id)
+	require.NoError(s.t, err)
+
+	return answer
+}
+
+// expect waits for the next message of the bot and checks that it contains
+// text.
+func (s *scenario) expect(text string) tgbotapi.Message {
+	s.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
+	defer cancel()
+
+	sent, err := s.tg.WaitSent(ctx, s.seen+1)
+	require.NoError(s.t, err, "waiting for a message containing %q", text)
+
+	msg := sent[s.seen]
+	s.seen++
+	require.Contains(s.t, msg.Text+msg.Caption, text)
+
+	return msg
+}
+
+func TestScenarioTaskPrompt(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		command := s.say("/task")
+		prompt := s.expect("@gibsn, Send a reply with:\ntask name")
+		assert.Equal(t, command.MessageID, prompt.ReplyToMessage.MessageID)
+
+		s.reply(prompt, "Fix the mixer\n@gibsn\nIt hums")
+		created := s.expect("Task has been successfully created")
+
+		pages := s.server.Pages(e2eTasksDB)
+		require.Len(t, pages, 1)
+		assert.Equal(t, "Fix the mixer", pages[0].Properties.PlainText("Задача"))
+
+		s.reply(created, "/done")
+		s.expect("Task has been successfully marked as Done")
+
+		page, _ := s.server.Page(pages[0].ID)
+		assert.Equal(t, notion.StatusDone, page.Properties.PlainText("Статус"))
+	})
+}
+
+func TestScenarioTweakMixDialog(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		trackID := s.addTrack(t, "Song", notion.TrackStatusMixing)
+		s.addTrack(t, "Other", notion.TrackStatusDemo)
+
+		command := s.reply(s.say("Too wet"), "/tweak")
+		actions := s.expect("Choose an action for /tweak:")
+		assert.Equal(t, command.MessageID, actions.ReplyToMessage.MessageID)
+		assert.Equal(t, []string{"Demo", "Mix", "Render", "To work"}, telegramtest.Buttons(actions))
+
+		assert.Empty(t, s.press(actions, "Mix").Text)
+		tracks := s.expect("Choose a track for Mix:")
+		assert.Equal(t, []string{"Other", "Song"}, telegramtest.Buttons(tracks))
+
+		assert.Empty(t, s.press(tracks, "Song").Text)
+		prompt := s.expect("@gibsn, Send a reply with:\nedit name")
+
+		s.reply(prompt, "Less reverb\n0:05 0:10\nin the chorus")
+		s.expect("Tweak has been created")
+
+		mix := s.server.Pages(e2eTweaksMixDB)
+		require.Len(t, mix, 1)
+		props := mix[0].Properties
+		assert.Equal(t, "Less reverb", props.PlainText("Кратко"))
+		assert.Equal(t, []string{trackID}, props["Песня"].RelationIDs())
+		assert.Equal(t, "0:05", props.PlainText("Начало интервала"))
+		assert.Equal(t, "in the chorus", props.PlainText("Пояснение"))
+
+		// the prompt is answered only once, the second reply is ignored
+		s.reply(prompt, "Too late")
+		s.say("/tracks")
+		s.expect("Tracks in progress:")
+		assert.Len(t, s.server.Pages(e2eTweaksMixDB), 1)
+	})
+}
+
+func TestScenarioTweakRender(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		trackID := s.addTrack(t, "Song", notion.TrackStatusMixing)
+		s.server.AddPage(e2eTweaksMixDB, property.Properties{
+			"Кратко":  property.NewTitle("Less reverb"),
+			"Статус":  property.NewStatus(notion.TweakMixStatusReadyForWork),
+			"Песня":   property.NewRelation(trackID),
+			"Дорожка": property.NewSelect("Vocals"),
+		})
+
+		command := s.say("/tweak render Song 3")
+		rendered := s.expect("Generated 1 tweak for")
+		assert.Equal(t, command.MessageID, rendered.ReplyToMessage.MessageID)
+		require.NotNil(t, rendered.Document)
+
+		data, ok := s.tg.File(rendered.Document.FileID)
+		require.True(t, ok)
+		assert.True(t, strings.HasPrefix(string(data), "%PDF"))
+	})
+}
+
+func TestScenarioCallbackFromStranger(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.say("/tweak")
+		actions := s.expect("Choose an action for /tweak:")
+
+		s.user = &tgbotapi.User{ID: 2, UserName: "stranger"}
+		answer := s.press(actions, "Demo")
+		assert.Equal(t, "You are not allowed to use this command", answer.Text)
+	})
+}
+
+func TestScenarioGrantedRole(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.addTrack(t, "Song", notion.TrackStatusMixing)
+
+		s.say("/grant @VomaDan member")
+		s.expect("@vomadan is now a member")
+
+		s.say("/tweak")
+		actions := s.expect("Choose an action for /tweak:")
+
+		s.user = &tgbotapi.User{ID: 2, UserName: "vomadan"}
+		answer := s.press(actions, "To work")
+		assert.Equal(t, "You are not allowed to use this command", answer.Text)
+		assert.Empty(t, s.press(actions, "Mix").Text)
+		s.expect("Choose a track for Mix:")
+
+		s.say("/agenda Discuss the release date")
+		s.expect("You are not allowed to use this command")
+		s.say("/grant @gibsn viewer")
+		s.expect("You are not allowed to use this command")
+	})
+}
+
+func TestScenarioLink(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.server.AddUser("fenya-notion-id", "Fenya")
+
+		s.say("/link")
+		s.expect("Send /link to me in a private chat.")
+
+		s.user = &tgbotapi.User{ID: 5, UserName: "fenyakolles"}
+		s.chat = &tgbotapi.Chat{ID: 5, Type: "private"}
+		s.say("/tasks")
+		s.expect("user fenyakolles is not linked to Notion")
+
+		s.say("/link")
+		users := s.expect("Who are you in Notion?")
+		assert.ElementsMatch(t, []string{
+			"@gibsn", "@vomadan", "@alexander_zh", "Fenya",
+		}, telegramtest.Buttons(users))
+
+		require.NoError(t, s.processor.SetAccessConfig(&access.Config{
+			Users: map[string]access.Role{"gibsn": access.Admin, "fenyakolles": access.Viewer},
+		}))
+		answer := s.press(users, "@gibsn")
+		assert.Equal(t, "@gibsn is already linked to another account, an admin may relink it",
+			answer.Text)
+
+		assert.Empty(t, s.press(users, "Fenya").Text)
+		s.expect("You are linked to <b>Fenya</b>.")
+		assert.Equal(t, "fenya-notion-id", s.processor.nameResolver.TgToNotion("@fenyakolles"))
+
+		s.say("/link")
+		users = s.expect("You are linked to <b>Fenya</b>. Choose another Notion user to relink.")
+		assert.Contains(t, telegramtest.Buttons(users), "Fenya ✓")
+
+		// an admin takes over a linked Notion user
+		require.NoError(t, s.processor.SetAccessConfig(&access.Config{
+			Users: map[string]access.Role{"fenyakolles": access.Admin},
+		}))
+		assert.Empty(t, s.press(users, "@gibsn").Text)
+		s.expect("You are linked to <b>@gibsn</b>.")
+		gibsnID := testUserLinks()[0].NotionID
+		assert.Equal(t, gibsnID, s.processor.nameResolver.TgToNotion("@fenyakolles"))
+		assert.Equal(t, "@fenyakolles", s.processor.nameResolver.NotionToTg(gibsnID))
+	})
+}
+
+func TestScenarioShutdownFinishesInFlightUpdate(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.server.InjectFault(notiontest.Fault{
+			Method: http.MethodPost, Path: "pages", Delay: 200 * time.Millisecond,
+		})
+
+		s.say("/task Fix the mixer\n@gibsn")
+		require.Eventually(t, func() bool {
+			for _, req := range s.server.Requests() {
+				if req.Method == http.MethodPost && req.Path == "pages" {
+					return true
+				}
+			}
+			return false
+		}, scenarioTimeout, time.Millisecond, "the page is being created")
+
+		s.stop()
+
+		assert.Len(t, s.server.Pages(e2eTasksDB), 1)
+		sent := s.tg.Sent()
+		require.Len(t, sent, 1)
+		assert.Contains(t, sent[0].Text, "Task has been successfully created")
+	})
+}
+
+func TestScenarioSlowCommandDoesNotBlockOthers(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.addTrack(t, "Song", notion.TrackStatusMixing)
+		s.server.InjectFault(notiontest.Fault{
+			Method: http.MethodPost, Path: "pages", Delay: time.Second,
+		})
+
+		s.say("/task Fix the mixer\n@gibsn")
+		s.user = &tgbotapi.User{ID: 2, UserName: "vomadan"}
+		s.say("/tracks")
+
+		s.expect("Tracks in progress:")
+		s.expect("Task has been successfully created")
+	})
+}
+
+func TestScenarioHelp(t *testing.T) {
+	forEachMode(t, func(t *testing.T, s *scenario) {
+		s.say("/help")
+		s.expect("/tweak — Create or process a tweak")
+
+		s.say("/help task")
+		s.expect("Private chat:")
+	})
+}
@@ -1,9 +1,10 @@
 package requestprocessor
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -31,6 +32,11 @@ type conversationKey struct {
 	userID int64
 }
 
+// String is the key of the conversation in the store.
+func (k conversationKey) String() string {
+	return fmt.Sprintf("%d:%d", k.chatID, k.userID)
+}
+
 type pendingInput struct {
 	command            string
 	action             tweakAction
@@ -42,12 +48,59 @@ type pendingInput struct {
 	repliedToMessageID int
 }
 
+// pendingInputJSON is the form pending inputs are saved in, so that the
+// dialogs survive a restart.
+type pendingInputJSON struct {
+	Command            string                   `json:"command,omitempty"`
+	Action             tweakAction              `json:"action,omitempty"`
+	TrackName          string                   `json:"track_name,omitempty"`
+	PromptMessageID    int                      `json:"prompt_message_id"`
+	ExpiresAt          time.Time                `json:"expires_at"`
+	RepliedToText      string                   `json:"replied_to_text,omitempty"`
+	RepliedToEntities  []tgbotapi.MessageEntity `json:"replied_to_entities,omitempty"`
+	RepliedToMessageID int                      `json:"replied_to_message_id,omitempty"`
+}
+
+func (p pendingInput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pendingInputJSON{
+		Command:            p.command,
+		Action:             p.action,
+		TrackName:          p.trackName,
+		PromptMessageID:    p.promptMessageID,
+		ExpiresAt:          p.expiresAt,
+		RepliedToText:      p.repliedToText,
+		RepliedToEntities:  p.repliedToEntities,
+		RepliedToMessageID: p.repliedToMessageID,
+	})
+}
+
+func (p *pendingInput) UnmarshalJSON(data []byte) error {
+	var v pendingInputJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*p = pendingInput{
+		command:            v.Command,
+		action:             v.Action,
+		trackName:          v.TrackName,
+		promptMessageID:    v.PromptMessageID,
+		expiresAt:          v.ExpiresAt,
+		repliedToText:      v.RepliedToText,
+		repliedToEntities:  v.RepliedToEntities,
+		repliedToMessageID: v.RepliedToMessageID,
+	}
+
+	return nil
+}
+
 func hasNoCommandArguments(message commandCommon) bool {
 	return strings.TrimSpace(message.restOfMessage) == ""
 }
 
-func newCommandInputResponse(message commandCommon) commandResponse {
-	prompt, placeholder := commandInputPrompt(message)
+// newCommandInputResponse asks the sender to reply with the arguments of the
+// command.
+func newCommandInputResponse(message commandCommon, prompt, placeholder string) commandResponse {
 	if !message.isPrivate && message.fromUserName != "" {
 		prompt = fmt.Sprintf("@%s, %s", message.fromUserName, prompt)
 	}
@@ -71,20 +124,6 @@ func newCommandInputResponse(message commandCommon) commandResponse {
 	}
 }
 
-func commandInputPrompt(message commandCommon) (text, placeholder string) {
-	switch message.command {
-	case "/task":
-		return "Send a reply with:\ntask name\n@assignee1 @assignee2 ...\n[description]",
-			"task, assignees, description"
-	case "/agenda":
-		return "Send the agenda as a reply.", "agenda"
-	case "/deadline":
-		return "Send the deadline as a reply in YYYY-MM-DD format.", "YYYY-MM-DD"
-	default:
-		return "Send the command parameters as a reply.", "parameters"
-	}
-}
-
 func newTweakMenuResponse() commandResponse {
 	markup := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -103,10 +142,6 @@ func newTweakMenuResponse() commandResponse {
 	}
 }
 
-func isTweakMenuCommand(message commandCommon) bool {
-	return strings.TrimSpace(message.restOfMessage) == ""
-}
-
 func newTweakTrackMenuResponse(action tweakAction, cache tracksCache) (commandResponse, error) {
 	if cache == nil {
 		return commandResponse{}, errors.New("tracks cache is not initialized")
@@ -203,65 +238,90 @@ func tweakActionPrompt(action tweakAction) (text, placeholder string) {
 	}
 }
 
-func (p *RequestProcessor) processCallbackQuery(callback *tgbotapi.CallbackQuery) {
+func (p *RequestProcessor) processCallbackQuery(
+	ctx context.Context, callback *tgbotapi.CallbackQuery,
+) {
 	if callback == nil || callback.From == nil {
 		return
 	}
 
+	if notionID, ok := strings.CutPrefix(callback.Data, linkCallbackPrefix); ok {
+		p.processLinkCallback(ctx, callback, notionID)
+		return
+	}
+
+	action, trackID, isTrack := parseTweakTrackCallback(callback.Data)
+	if !isTrack {
+		var ok bool
+		if action, ok = parseTweakCallback(callback.Data); !ok {
+			p.answerCallback(ctx, callback.ID, "Unknown action")
+			return
+		}
+	}
+
 	fromUserName := strings.ToLower(callback.From.UserName)
-	if !p.allowedToCreate[fromUserName] {
-		p.answerCallback(callback.ID, "You are not allowed to use this command")
+	if !p.allowedTweakAction(fromUserName, action) {
+		p.answerCallback(ctx, callback.ID, "You are not allowed to use this command")
 		return
 	}
 
 	if callback.Message == nil || callback.Message.Chat == nil {
-		p.answerCallback(callback.ID, "The original message is unavailable")
+		p.answerCallback(ctx, callback.ID, "The original message is unavailable")
 		return
 	}
 
-	if action, ok := parseTweakCallback(callback.Data); ok {
-		p.processTweakActionCallback(callback, action)
+	if isTrack {
+		p.processTweakTrackCallback(ctx, callback, action, trackID)
 		return
 	}
-	if action, trackID, ok := parseTweakTrackCallback(callback.Data); ok {
-		p.processTweakTrackCallback(callback, action, trackID)
-		return
+	p.processTweakActionCallback(ctx, callback, action)
+}
+
+// allowedTweakAction checks the buttons of the tweak dialog the same way as
+// the commands they stand for, e.g. "To work" as /tweak towork.
+func (p *RequestProcessor) allowedTweakAction(userName string, action tweakAction) bool {
+	tweak, ok := p.lookupCommand("tweak")
+	if !ok {
+		return false
 	}
+	sub, _ := tweak.subcommand(commandCommon{restOfMessage: string(action)})
 
-	p.answerCallback(callback.ID, "Unknown action")
+	return p.allowed(userName, tweak, sub)
 }
 
 func (p *RequestProcessor) processTweakActionCallback(
+	ctx context.Context,
 	callback *tgbotapi.CallbackQuery,
 	action tweakAction,
 ) {
 	response, err := newTweakTrackMenuResponse(action, p.tracksCache)
 	if err != nil {
-		p.answerCallback(callback.ID, err.Error())
+		p.answerCallback(ctx, callback.ID, err.Error())
 		return
 	}
 
-	p.answerCallback(callback.ID, "")
-	p.sendCallbackResponse(callback, response)
+	p.answerCallback(ctx, callback.ID, "")
+	p.sendCallbackResponse(ctx, callback, response)
 }
 
 func (p *RequestProcessor) processTweakTrackCallback(
+	ctx context.Context,
 	callback *tgbotapi.CallbackQuery,
 	action tweakAction,
 	trackID string,
 ) {
 	if p.tracksCache == nil {
-		p.answerCallback(callback.ID, "Tracks cache is not initialized")
+		p.answerCallback(ctx, callback.ID, "Tracks cache is not initialized")
 		return
 	}
 
 	trackName, ok := p.tracksCache.GetTrackName(trackID)
 	if !ok {
-		p.answerCallback(callback.ID, "This track is no longer available")
+		p.answerCallback(ctx, callback.ID, "This track is no longer available")
 		return
 	}
 
-	p.answerCallback(callback.ID, "")
+	p.answerCallback(ctx, callback.ID, "")
 	if action == tweakActionToWork {
 		command := commandCommon{
 			command:       "/tweak",
@@ -271,11 +331,11 @@ func (p *RequestProcessor) processTweakTrackCallback(
 			isPrivate:     callback.Message.Chat.IsPrivate(),
 			chatID:        callback.Message.Chat.ID,
 		}
-		response, err := withUsageErrorReply(command, "$track", p.processTweakToWorkResponse)
+		response, err := p.runCommand(ctx, command)
 		if err != nil {
-			log.Printf("Could not process interactive tweak towork: %v", err)
+			logger.WarnContext(ctx, "Could not process interactive tweak towork", "error", err)
 		}
-		p.sendCallbackResponse(callback, response)
+		p.sendCallbackResponse(ctx, callback, response)
 		return
 	}
 
@@ -295,7 +355,7 @@ func (p *RequestProcessor) processTweakTrackCallback(
 
 	sent, err := p.bot.Send(msg)
 	if err != nil {
-		log.Printf("Could not send tweak prompt to Telegram: %v", err)
+		logger.ErrorContext(ctx, "Could not send tweak prompt to Telegram", "error", err)
 		return
 	}
 
@@ -312,10 +372,11 @@ func (p *RequestProcessor) processTweakTrackCallback(
 		pending.repliedToMessageID = originalMessage.MessageID
 	}
 
-	p.setPendingInput(callback.Message.Chat.ID, callback.From.ID, pending)
+	p.setPendingInput(ctx, callback.Message.Chat.ID, callback.From.ID, pending)
 }
 
 func (p *RequestProcessor) sendCallbackResponse(
+	ctx context.Context,
 	callback *tgbotapi.CallbackQuery,
 	response commandResponse,
 ) {
@@ -327,47 +388,78 @@ func (p *RequestProcessor) sendCallbackResponse(
 	}
 
 	if _, err := p.bot.Send(msg); err != nil {
-		log.Printf("Could not send callback response to Telegram: %v", err)
+		logger.ErrorContext(ctx, "Could not send callback response to Telegram", "error", err)
 	}
 }
 
-func (p *RequestProcessor) answerCallback(callbackID, text string) {
+func (p *RequestProcessor) answerCallback(ctx context.Context, callbackID, text string) {
 	if _, err := p.bot.Request(tgbotapi.NewCallback(callbackID, text)); err != nil {
-		log.Printf("Could not answer Telegram callback: %v", err)
+		logger.ErrorContext(ctx, "Could not answer Telegram callback", "error", err)
 	}
 }
 
-func (p *RequestProcessor) setPendingInput(chatID, userID int64, pending pendingInput) {
+func (p *RequestProcessor) setPendingInput(
+	ctx context.Context, chatID, userID int64, pending pendingInput,
+) {
 	p.pendingInputsMu.Lock()
 	defer p.pendingInputsMu.Unlock()
 
 	now := p.now()
-	for key, current := range p.pendingInputs {
+	all, err := p.pendingInputs.All()
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not load pending inputs", "error", err)
+	}
+	var expired []string
+	for key, current := range all {
 		if !current.expiresAt.After(now) {
-			delete(p.pendingInputs, key)
+			expired = append(expired, key)
 		}
 	}
+	if err := p.pendingInputs.Delete(expired...); err != nil {
+		logger.ErrorContext(ctx, "Could not delete expired pending inputs", "error", err)
+	}
 
 	pending.expiresAt = now.Add(conversationTTL)
-	p.pendingInputs[conversationKey{chatID: chatID, userID: userID}] = pending
+	key := conversationKey{chatID: chatID, userID: userID}
+	if err := p.pendingInputs.Put(key.String(), pending); err != nil {
+		logger.ErrorContext(ctx, "Could not save pending input", "error", err)
+	}
 }
 
-func (p *RequestProcessor) takePendingInput(message *tgbotapi.Message) (pendingInput, bool, bool) {
+// pendingInputFor returns the pending input the message replies to.
+func (p *RequestProcessor) pendingInputFor(
+	ctx context.Context, message *tgbotapi.Message,
+) (pendingInput, string, bool) {
 	if message == nil || message.Chat == nil || message.From == nil || message.ReplyToMessage == nil {
-		return pendingInput{}, false, false
+		return pendingInput{}, "", false
 	}
 
-	key := conversationKey{chatID: message.Chat.ID, userID: message.From.ID}
+	key := conversationKey{chatID: message.Chat.ID, userID: message.From.ID}.String()
+	pending, ok, err := p.pendingInputs.Get(key)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not load pending input", "error", err)
+	}
+	if !ok || pending.promptMessageID != message.ReplyToMessage.MessageID {
+		return pendingInput{}, "", false
+	}
+
+	return pending, key, true
+}
 
+func (p *RequestProcessor) takePendingInput(
+	ctx context.Context, message *tgbotapi.Message,
+) (pendingInput, bool, bool) {
 	p.pendingInputsMu.Lock()
 	defer p.pendingInputsMu.Unlock()
 
-	pending, ok := p.pendingInputs[key]
-	if !ok || pending.promptMessageID != message.ReplyToMessage.MessageID {
+	pending, key, ok := p.pendingInputFor(ctx, message)
+	if !ok {
 		return pendingInput{}, false, false
 	}
 
-	delete(p.pendingInputs, key)
+	if err := p.pendingInputs.Delete(key); err != nil {
+		logger.ErrorContext(ctx, "Could not delete pending input", "error", err)
+	}
 	if !pending.expiresAt.After(p.now()) {
 		return pendingInput{}, true, true
 	}
@@ -375,24 +467,21 @@ func (p *RequestProcessor) takePendingInput(message *tgbotapi.Message) (pendingI
 	return pending, true, false
 }
 
-func (p *RequestProcessor) hasPendingInputReply(message *tgbotapi.Message) bool {
-	if message == nil || message.Chat == nil || message.From == nil || message.ReplyToMessage == nil {
-		return false
-	}
-
-	key := conversationKey{chatID: message.Chat.ID, userID: message.From.ID}
-
+func (p *RequestProcessor) hasPendingInputReply(
+	ctx context.Context, message *tgbotapi.Message,
+) bool {
 	p.pendingInputsMu.Lock()
 	defer p.pendingInputsMu.Unlock()
 
-	pending, ok := p.pendingInputs[key]
-	return ok && pending.promptMessageID == message.ReplyToMessage.MessageID
+	_, _, ok := p.pendingInputFor(ctx, message)
+	return ok
 }
 
 func (p *RequestProcessor) processPendingInputReply(
+	ctx context.Context,
 	message *tgbotapi.Message,
 ) (commandResponse, error) {
-	pending, found, expired := p.takePendingInput(message)
+	pending, found, expired := p.takePendingInput(ctx, message)
 	if !found {
 		return commandResponse{}, errNotACommand
 	}
@@ -404,38 +493,7 @@ func (p *RequestProcessor) processPendingInputReply(
 		return commandResponse{text: "This action has expired. Send " + command + " again."}, nil
 	}
 
-	command := pendingInputCommand(pending, message)
-	if pending.command != "" {
-		var text string
-		var err error
-		switch pending.command {
-		case "/task":
-			text, err = withErrorReply(command, p.processTask)
-		case "/agenda":
-			text, err = withErrorReply(command, p.processAgenda)
-		case "/deadline":
-			text, err = withErrorReply(command, p.processDeadline)
-		default:
-			return commandResponse{}, errors.New("unknown pending command")
-		}
-		return commandResponse{text: text}, err
-	}
-
-	switch pending.action {
-	case tweakActionDemo, tweakActionMix:
-		text, err := withErrorReply(command, p.processTweak)
-		return commandResponse{text: text}, err
-	case tweakActionRender:
-		return withUsageErrorReply(
-			command,
-			"$track $iteration_number",
-			p.processTweakRenderResponse,
-		)
-	case tweakActionToWork:
-		return withUsageErrorReply(command, "$track", p.processTweakToWorkResponse)
-	default:
-		return commandResponse{}, errors.New("unknown pending tweak action")
-	}
+	return p.runCommand(ctx, pendingInputCommand(pending, message))
 }
 
 func pendingInputCommand(pending pendingInput, message *tgbotapi.Message) commandCommon {
@@ -450,6 +508,7 @@ func pendingInputCommand(pending pendingInput, message *tgbotapi.Message) comman
 			isPrivate:          message.Chat.IsPrivate(),
 			explicitAssignees:  pending.command == "/task",
 			chatID:             message.Chat.ID,
+			messageID:          message.MessageID,
 			repliedToMessageID: pending.repliedToMessageID,
 		}
 	}
@@ -470,20 +529,23 @@ func pendingInputCommand(pending pendingInput, message *tgbotapi.Message) comman
 		fromUserID:         message.From.ID,
 		isPrivate:          message.Chat.IsPrivate(),
 		chatID:             message.Chat.ID,
+		messageID:          message.MessageID,
 		repliedToMessageID: pending.repliedToMessageID,
 	}
 }
 
-func (p *RequestProcessor) processCancel(message commandCommon) string {
-	key := conversationKey{chatID: message.chatID, userID: message.fromUserID}
+func (p *RequestProcessor) processCancel(ctx context.Context, message commandCommon) string {
+	key := conversationKey{chatID: message.chatID, userID: message.fromUserID}.String()
 
 	p.pendingInputsMu.Lock()
 	defer p.pendingInputsMu.Unlock()
 
-	if _, ok := p.pendingInputs[key]; !ok {
+	if _, ok, _ := p.pendingInputs.Get(key); !ok {
 		return "There is no active action."
 	}
 
-	delete(p.pendingInputs, key)
+	if err := p.pendingInputs.Delete(key); err != nil {
+		logger.ErrorContext(ctx, "Could not delete pending input", "error", err)
+	}
 	return "Action cancelled."
 }
@@ -1,14 +1,17 @@
 package requestprocessor
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/store"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,6 +45,8 @@ func (f *fakeTracksCache) GetTrackNames() []string {
 }
 
 func TestProcessTweakCallbackPromptsAndStoresConversation(t *testing.T) {
+	ctx := context.Background()
+
 	var requests []telegramRequest
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
@@ -78,7 +83,7 @@ func TestProcessTweakCallbackPromptsAndStoresConversation(t *testing.T) {
 	require.NoError(t, err)
 	p := NewRequestProcessor(nil, "", bot)
 	p.tracksCache = &fakeTracksCache{tracks: map[string]string{"Track One": "track-id"}}
-	p.processCallbackQuery(&tgbotapi.CallbackQuery{
+	p.processCallbackQuery(context.Background(), &tgbotapi.CallbackQuery{
 		ID:   "callback-id",
 		From: &tgbotapi.User{ID: 20, UserName: "gibsn"},
 		Data: "tweak:render",
@@ -95,7 +100,7 @@ func TestProcessTweakCallbackPromptsAndStoresConversation(t *testing.T) {
 	assert.Contains(t, requests[2].form.Get("text"), "Choose a track")
 	assert.Contains(t, requests[2].form.Get("reply_markup"), "Track One")
 
-	p.processCallbackQuery(&tgbotapi.CallbackQuery{
+	p.processCallbackQuery(context.Background(), &tgbotapi.CallbackQuery{
 		ID:   "track-callback-id",
 		From: &tgbotapi.User{ID: 20, UserName: "gibsn"},
 		Data: "twtrk:render:track-id",
@@ -116,7 +121,7 @@ func TestProcessTweakCallbackPromptsAndStoresConversation(t *testing.T) {
 		Chat:           &tgbotapi.Chat{ID: 30},
 		ReplyToMessage: &tgbotapi.Message{MessageID: 99},
 	}
-	pending, found, expired := p.takePendingInput(reply)
+	pending, found, expired := p.takePendingInput(ctx, reply)
 	assert.True(t, found)
 	assert.False(t, expired)
 	assert.Equal(t, tweakActionRender, pending.action)
@@ -134,7 +139,7 @@ func TestProcessTweakWithoutArgumentsShowsMenu(t *testing.T) {
 		Entities:  makeBotCommandEntities(text),
 	}}
 
-	response, err := p.processRequest(update)
+	response, err := p.processRequest(context.Background(), update)
 
 	require.NoError(t, err)
 	assert.Equal(t, "Choose an action for /tweak:", response.text)
@@ -151,13 +156,15 @@ func TestProcessTweakWithoutArgumentsShowsMenu(t *testing.T) {
 	assert.Equal(t, []string{"tweak:demo", "tweak:mix", "tweak:render", "tweak:towork"}, callbackData)
 }
 
-func TestIsTweakMenuCommand(t *testing.T) {
-	assert.True(t, isTweakMenuCommand(commandCommon{}))
-	assert.True(t, isTweakMenuCommand(commandCommon{restOfMessage: "  \n\t"}))
-	assert.False(t, isTweakMenuCommand(commandCommon{restOfMessage: "render Track 1"}))
+func TestHasNoCommandArguments(t *testing.T) {
+	assert.True(t, hasNoCommandArguments(commandCommon{}))
+	assert.True(t, hasNoCommandArguments(commandCommon{restOfMessage: "  \n\t"}))
+	assert.False(t, hasNoCommandArguments(commandCommon{restOfMessage: "render Track 1"}))
 }
 
 func TestHasPendingTweakReply(t *testing.T) {
+	ctx := context.Background()
+
 	p := NewRequestProcessor(nil, "", nil)
 	message := &tgbotapi.Message{
 		From:           &tgbotapi.User{ID: 20, UserName: "gibsn"},
@@ -166,13 +173,13 @@ func TestHasPendingTweakReply(t *testing.T) {
 		ReplyToMessage: &tgbotapi.Message{MessageID: 40},
 	}
 
-	assert.False(t, p.hasPendingInputReply(message))
+	assert.False(t, p.hasPendingInputReply(ctx, message))
 
-	p.setPendingInput(30, 20, pendingInput{action: tweakActionRender, promptMessageID: 40})
-	assert.True(t, p.hasPendingInputReply(message))
+	p.setPendingInput(ctx, 30, 20, pendingInput{action: tweakActionRender, promptMessageID: 40})
+	assert.True(t, p.hasPendingInputReply(ctx, message))
 
 	message.ReplyToMessage.MessageID = 41
-	assert.False(t, p.hasPendingInputReply(message))
+	assert.False(t, p.hasPendingInputReply(ctx, message))
 }
 
 func TestManualTweakCommandStillUsesExistingParser(t *testing.T) {
@@ -186,7 +193,7 @@ func TestManualTweakCommandStillUsesExistingParser(t *testing.T) {
 		Entities:  makeBotCommandEntities(text),
 	}}
 
-	response, err := p.processRequest(update)
+	response, err := p.processRequest(context.Background(), update)
 
 	require.Error(t, err)
 	assert.Nil(t, response.replyMarkup)
@@ -220,17 +227,19 @@ func TestPendingTweakCommandBuildsExistingCommandFormat(t *testing.T) {
 }
 
 func TestPendingTweakLifecycle(t *testing.T) {
+	ctx := context.Background()
+
 	p := NewRequestProcessor(nil, "", nil)
 	now := time.Date(2026, time.July, 20, 12, 0, 0, 0, time.UTC)
 	p.now = func() time.Time { return now }
-	p.setPendingInput(30, 20, pendingInput{action: tweakActionRender, promptMessageID: 40})
+	p.setPendingInput(ctx, 30, 20, pendingInput{action: tweakActionRender, promptMessageID: 40})
 
 	wrongReply := &tgbotapi.Message{
 		From:           &tgbotapi.User{ID: 20},
 		Chat:           &tgbotapi.Chat{ID: 30},
 		ReplyToMessage: &tgbotapi.Message{MessageID: 41},
 	}
-	_, found, expired := p.takePendingInput(wrongReply)
+	_, found, expired := p.takePendingInput(ctx, wrongReply)
 	assert.False(t, found)
 	assert.False(t, expired)
 
@@ -239,29 +248,33 @@ func TestPendingTweakLifecycle(t *testing.T) {
 		Chat:           &tgbotapi.Chat{ID: 30},
 		ReplyToMessage: &tgbotapi.Message{MessageID: 40},
 	}
-	pending, found, expired := p.takePendingInput(correctReply)
+	pending, found, expired := p.takePendingInput(ctx, correctReply)
 	assert.True(t, found)
 	assert.False(t, expired)
 	assert.Equal(t, tweakActionRender, pending.action)
 
-	_, found, _ = p.takePendingInput(correctReply)
+	_, found, _ = p.takePendingInput(ctx, correctReply)
 	assert.False(t, found)
 }
 
 func TestPendingTweakExpiresAndCanBeCancelled(t *testing.T) {
+	ctx := context.Background()
+
 	p := NewRequestProcessor(nil, "", nil)
 	now := time.Date(2026, time.July, 20, 12, 0, 0, 0, time.UTC)
 	p.now = func() time.Time { return now }
-	p.setPendingInput(30, 20, pendingInput{action: tweakActionDemo, promptMessageID: 40})
+	p.setPendingInput(ctx, 30, 20, pendingInput{action: tweakActionDemo, promptMessageID: 40})
 
-	assert.Equal(t, "Action cancelled.", p.processCancel(commandCommon{chatID: 30, fromUserID: 20}))
+	assert.Equal(t,
+		"Action cancelled.", p.processCancel(ctx, commandCommon{chatID: 30, fromUserID: 20}),
+	)
 	assert.Equal(
 		t,
 		"There is no active action.",
-		p.processCancel(commandCommon{chatID: 30, fromUserID: 20}),
+		p.processCancel(ctx, commandCommon{chatID: 30, fromUserID: 20}),
 	)
 
-	p.setPendingInput(30, 20, pendingInput{action: tweakActionDemo, promptMessageID: 40})
+	p.setPendingInput(ctx, 30, 20, pendingInput{action: tweakActionDemo, promptMessageID: 40})
 	now = now.Add(conversationTTL)
 	reply := &tgbotapi.Message{
 		From:           &tgbotapi.User{ID: 20},
@@ -269,7 +282,7 @@ func TestPendingTweakExpiresAndCanBeCancelled(t *testing.T) {
 		ReplyToMessage: &tgbotapi.Message{MessageID: 40},
 	}
 
-	_, found, expired := p.takePendingInput(reply)
+	_, found, expired := p.takePendingInput(ctx, reply)
 	assert.True(t, found)
 	assert.True(t, expired)
 }
@@ -298,3 +311,55 @@ func TestParseTweakCallback(t *testing.T) {
 	_, _, ok = parseTweakTrackCallback("twtrk:unknown:track-id")
 	assert.False(t, ok)
 }
+
+func TestPendingInputSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, time.July, 20, 12, 0, 0, 0, time.UTC)
+
+	restart := func() *RequestProcessor {
+		s, err := store.Open(path)
+		require.NoError(t, err)
+
+		p := NewRequestProcessor(nil, "", nil)
+		p.SetStore(s)
+		p.now = func() time.Time { return now }
+		return p
+	}
+
+	entities := []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 3}}
+	restart().setPendingInput(ctx, 30, 20, pendingInput{
+		action: tweakActionMix, trackName: "Song", promptMessageID: 40,
+		repliedToText: "Too wet", repliedToEntities: entities, repliedToMessageID: 39,
+	})
+	restart().setPendingInput(ctx, 31, 20, pendingInput{command: "/agenda", promptMessageID: 41})
+
+	reply := &tgbotapi.Message{
+		From:           &tgbotapi.User{ID: 20},
+		Chat:           &tgbotapi.Chat{ID: 30},
+		ReplyToMessage: &tgbotapi.Message{MessageID: 40},
+	}
+	pending, found, expired := restart().takePendingInput(ctx, reply)
+	require.True(t, found)
+	assert.False(t, expired)
+	assert.Equal(t, pendingInput{
+		action: tweakActionMix, trackName: "Song", promptMessageID: 40,
+		expiresAt:     now.Add(conversationTTL),
+		repliedToText: "Too wet", repliedToEntities: entities, repliedToMessageID: 39,
+	}, pending)
+
+	_, found, _ = restart().takePendingInput(ctx, reply)
+	assert.False(t, found, "a taken input is removed from the store")
+
+	// the expiration time is kept across restarts
+	now = now.Add(conversationTTL)
+	pending, found, expired = restart().takePendingInput(ctx, &tgbotapi.Message{
+		From:           &tgbotapi.User{ID: 20},
+		Chat:           &tgbotapi.Chat{ID: 31},
+		ReplyToMessage: &tgbotapi.Message{MessageID: 41},
+	})
+	assert.True(t, found)
+	assert.True(t, expired)
+	assert.Empty(t, pending.command)
+}
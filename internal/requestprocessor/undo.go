@@ -0,0 +1,205 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+)
+
+// defaultUndoWindow is how long after a change /undo may revert it.
+const defaultUndoWindow = 15 * time.Minute
+
+// undoSearchLimit bounds the entries of the caller searched for a change to
+// undo.
+const undoSearchLimit = 100
+
+const undoCommand = "/undo"
+
+func parseUndoCommand(message commandCommon) (struct{}, error) {
+	if strings.TrimSpace(message.restOfMessage) != "" {
+		return struct{}{}, errors.New("the command takes no arguments")
+	}
+
+	return struct{}{}, nil
+}
+
+// changeKey identifies a change of an entry.
+type changeKey struct {
+	pageID string
+	field  string
+}
+
+// lastUndoable returns the latest change of the user within the undo window
+// that has not been undone yet, with the changes left to revert. Failed
+// commands are skipped, so are the undoes themselves. Only the changes an undo
+// has reverted count as undone, which makes repeated /undo go further back
+// once a change is fully reverted, and retry the same one otherwise.
+func (p *RequestProcessor) lastUndoable(userID int64) (audit.Entry, bool, error) {
+	since := p.now().Add(-p.undoWindow)
+	entries, err := p.auditLog.Query(func(e audit.Entry) bool {
+		return e.UserID == userID && e.Time.After(since)
+	}, undoSearchLimit)
+	if err != nil {
+		return audit.Entry{}, false, fmt.Errorf("could not read the audit log: %w", err)
+	}
+
+	undone := make(map[string]map[changeKey]bool)
+	for _, e := range entries {
+		if e.Undoes != "" {
+			if undone[e.Undoes] == nil {
+				undone[e.Undoes] = make(map[changeKey]bool)
+			}
+			for _, change := range e.Changes {
+				undone[e.Undoes][changeKey{change.PageID, change.Field}] = true
+			}
+			continue
+		}
+		if e.Outcome != audit.OutcomeOK {
+			continue
+		}
+
+		left := make([]audit.Change, 0, len(e.Changes))
+		for _, change := range e.Changes {
+			if !undone[e.ID][changeKey{change.PageID, change.Field}] {
+				left = append(left, change)
+			}
+		}
+		if len(left) > 0 {
+			e.Changes = left
+			return e, true, nil
+		}
+	}
+
+	return audit.Entry{}, false, nil
+}
+
+func (p *RequestProcessor) processUndo(
+	ctx context.Context, message commandCommon, _ struct{},
+) (string, error) {
+	if p.auditLog == nil {
+		return "Undo needs the audit log, it is not configured.", nil
+	}
+
+	entry, ok, err := p.lastUndoable(message.fromUserID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return fmt.Sprintf("You have made no changes to undo in the last %s.", p.undoWindow), nil
+	}
+
+	pageIDs := make([]string, 0, len(entry.Changes))
+	for _, change := range entry.Changes {
+		pageIDs = append(pageIDs, change.PageID)
+	}
+	p.tasksChanging(pageIDs...)
+	reverted, err := p.revert(ctx, entry)
+	if len(reverted) > 0 {
+		p.tasksChanged(reverted)
+	}
+
+	undo := newEntry(ctx, message, undoCommand, reverted, err)
+	undo.Undoes = entry.ID
+	p.appendEntry(ctx, undo)
+
+	if err != nil {
+		return "", fmt.Errorf("could not undo %s: %w", entry.Command, err)
+	}
+
+	return fmt.Sprintf("Undone %s: %s", entry.Command, formatChanges(reverted)), nil
+}
+
+// revert reverts the changes of the entry, the latest first, and returns the
+// changes made. It stops at the first failure, the changes left are reverted
+// by the next /undo.
+func (p *RequestProcessor) revert(ctx context.Context, entry audit.Entry) ([]audit.Change, error) {
+	reverted := make([]audit.Change, 0, len(entry.Changes))
+	for i := len(entry.Changes) - 1; i >= 0; i-- {
+		change := entry.Changes[i]
+		if err := p.revertChange(ctx, entry.Command, change); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, audit.Change{
+			PageID: change.PageID, Field: change.Field, Old: change.New, New: change.Old,
+		})
+	}
+
+	return reverted, nil
+}
+
+func (p *RequestProcessor) revertChange(
+	ctx context.Context, command string, change audit.Change,
+) error {
+	pageLink := pageLinkFromID(change.PageID)
+	if change.Field != audit.FieldPage && !change.OldKnown {
+		return fmt.Errorf("the previous %s of %s is unknown", change.Field, pageLink)
+	}
+	if err := p.checkUnchanged(ctx, command, change); err != nil {
+		return err
+	}
+
+	switch change.Field {
+	case audit.FieldPage:
+		return p.notion.ArchivePageContext(ctx, change.PageID)
+	case audit.FieldDeadline:
+		return p.notion.RestoreDeadlineContext(ctx, pageLink, change.OldDate)
+	case audit.FieldStatus:
+		if change.Old == "" {
+			return fmt.Errorf("the previous status of %s is unknown", pageLink)
+		}
+		if command == tweakToWorkCommand {
+			return p.notion.SetMixTweakStatusContext(ctx, change.PageID, change.Old)
+		}
+		return p.notion.SetStatusContext(ctx, &notion.SetStatusRequest{
+			TaskLink: pageLink, Status: change.Old,
+		})
+	default:
+		return fmt.Errorf("cannot undo a change of %s", change.Field)
+	}
+}
+
+// checkUnchanged refuses to revert the change if the field no longer holds the
+// value set by it, someone has changed it since. Created pages are archived
+// regardless.
+func (p *RequestProcessor) checkUnchanged(
+	ctx context.Context, command string, change audit.Change,
+) error {
+	pageLink := pageLinkFromID(change.PageID)
+
+	var current string
+	switch {
+	case change.Field == audit.FieldPage:
+		return nil
+	case command == tweakToWorkCommand:
+		status, err := p.notion.LoadMixTweakStatusContext(ctx, change.PageID)
+		if err != nil {
+			return fmt.Errorf("could not check the current status: %w", err)
+		}
+		current = status
+	default:
+		state, err := p.notion.LoadTaskStateContext(ctx, pageLink)
+		if err != nil {
+			return fmt.Errorf("could not check the current %s: %w", change.Field, err)
+		}
+		current = state.Status
+		if change.Field == audit.FieldDeadline {
+			current = state.Deadline.String()
+		}
+	}
+
+	if current != change.New {
+		if current == "" {
+			current = "none"
+		}
+		return fmt.Errorf("the %s of %s has been changed since, it is %s now",
+			change.Field, pageLink, current,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package requestprocessor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoTaskChanges(t *testing.T) {
+	e := newE2EEnv(t)
+
+	reply := e.command(t, "/undo", "")
+	assert.Equal(t, "Undo needs the audit log, it is not configured.", reply.text)
+
+	auditLog := newTestAuditLog(t)
+	e.processor.SetAuditLog(auditLog)
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn", "")
+	require.Contains(t, created.text, "Task has been successfully created")
+	taskID := e.server.Pages(e2eTasksDB)[0].ID
+	e.command(t, "/deadline 2030-01-02", created.text)
+	e.command(t, "/deadline 2030-02-03", created.text)
+	e.command(t, "/done", created.text)
+
+	task := func() notiontest.Page {
+		page, ok := e.server.Page(taskID)
+		require.True(t, ok)
+		return page
+	}
+
+	reply = e.command(t, "/undo", "")
+	assert.Contains(t, reply.text, "Undone /done: <a href=")
+	assert.Equal(t, notion.StatusNew, task().Properties.PlainText("Статус"))
+
+	reply = e.command(t, "/undo", "")
+	assert.Contains(t, reply.text, "Undone /deadline")
+	assert.Equal(t, "2030-01-02", task().Properties.PlainText("Дедлайн"))
+
+	e.command(t, "/undo", "")
+	assert.Empty(t, task().Properties.PlainText("Дедлайн"))
+
+	reply = e.command(t, "/undo", "")
+	assert.Contains(t, reply.text, "Undone /task: archived <a href=")
+	assert.True(t, task().Archived)
+
+	reply = e.command(t, "/undo", "")
+	assert.Equal(t, "You have made no changes to undo in the last 15m0s.", reply.text)
+
+	entries, err := auditLog.Query(func(e audit.Entry) bool { return e.Command == "/undo" }, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, []audit.Change{{
+		PageID: taskID, Field: audit.FieldStatus, Old: notion.StatusDone, New: notion.StatusNew,
+	}}, entries[3].Changes)
+	assert.NotEmpty(t, entries[3].Undoes)
+}
+
+func TestUndoTweaksToWork(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetAuditLog(newTestAuditLog(t))
+
+	trackID := e.addTrack(t, "Song", notion.TrackStatusMixing)
+	ids := make([]string, 0, 2)
+	for _, title := range []string{"Less reverb", "Louder vocals"} {
+		ids = append(ids, e.server.AddPage(e2eTweaksMixDB, property.Properties{
+			"Кратко": property.NewTitle(title),
+			"Статус": property.NewStatus(notion.TweakMixStatusReadyForWork),
+			"Песня":  property.NewRelation(trackID),
+		}))
+	}
+
+	reply := e.command(t, "/tweak towork Song", "")
+	require.Contains(t, reply.text, "Moved 2 tweaks for")
+
+	reply = e.command(t, "/undo", "")
+	assert.Equal(t, "Undone /tweak towork: 2 pages status "+
+		notion.TweakMixStatusInWork+" → "+notion.TweakMixStatusReadyForWork, reply.text)
+	for _, id := range ids {
+		page, _ := e.server.Page(id)
+		assert.Equal(t, notion.TweakMixStatusReadyForWork, page.Properties.PlainText("Статус"))
+	}
+}
+
+func TestUndoWindow(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetAuditLog(newTestAuditLog(t))
+	e.processor.SetUndoWindow(time.Minute)
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn", "")
+	e.command(t, "/done", created.text)
+
+	now := time.Now().Add(2 * time.Minute)
+	e.processor.now = func() time.Time { return now }
+
+	reply := e.command(t, "/undo", "")
+	assert.Equal(t, "You have made no changes to undo in the last 1m0s.", reply.text)
+	assert.Equal(t, notion.StatusDone, e.server.Pages(e2eTasksDB)[0].Properties.PlainText("Статус"))
+}
+
+func TestUndoRefusesUnknownOldValue(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetAuditLog(newTestAuditLog(t))
+
+	created := e.command(t, "/task Fix the mixer\n@gibsn", "")
+	e.command(t, "/deadline 2030-01-02", created.text)
+
+	// the task cannot be loaded to record the deadline it had
+	e.server.InjectFault(notiontest.Fault{
+		Method: http.MethodGet, Path: "pages", Times: 1, Status: http.StatusBadRequest,
+	})
+	e.command(t, "/deadline 2030-02-03", created.text)
+
+	// a refused change is not skipped, the earlier one is left alone
+	for i := 0; i < 2; i++ {
+		reply := e.command(t, "/undo", "")
+		assert.Contains(t, reply.text, "the previous deadline of")
+		assert.Contains(t, reply.text, "is unknown")
+		page := e.server.Pages(e2eTasksDB)[0]
+		assert.Equal(t, "2030-02-03", page.Properties.PlainText("Дедлайн"))
+	}
+}
+
+func TestUndoRefusesChangedSince(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetAuditLog(newTestAuditLog(t))
+	ctx := context.Background()
+
+	trackID := e.addTrack(t, "Song", notion.TrackStatusMixing)
+	ids := make([]string, 0, 2)
+	for _, title := range []string{"Less reverb", "Louder vocals"} {
+		ids = append(ids, e.server.AddPage(e2eTweaksMixDB, property.Properties{
+			"Кратко": property.NewTitle(title),
+			"Статус": property.NewStatus(notion.TweakMixStatusReadyForWork),
+			"Песня":  property.NewRelation(trackID),
+		}))
+	}
+	e.command(t, "/tweak towork Song", "")
+
+	status := func(id string) string {
+		page, _ := e.server.Page(id)
+		return page.Properties.PlainText("Статус")
+	}
+
+	// a teammate moves the first tweak on, it is kept while the other is
+	// reverted
+	require.NoError(t, e.processor.notion.SetMixTweakStatusContext(
+		ctx, ids[0], notion.TweakMixStatusReadyForWork,
+	))
+	reply := e.command(t, "/undo", "")
+	assert.Contains(t, reply.text, "has been changed since")
+	assert.Equal(t, notion.TweakMixStatusReadyForWork, status(ids[1]))
+
+	require.NoError(t, e.processor.notion.SetMixTweakStatusContext(
+		ctx, ids[0], notion.TweakMixStatusInWork,
+	))
+	reply = e.command(t, "/undo", "")
+	assert.Contains(t, reply.text, "Undone /tweak towork: <a href=")
+	assert.Equal(t, notion.TweakMixStatusReadyForWork, status(ids[0]))
+
+	reply = e.command(t, "/undo", "")
+	assert.Equal(t, "You have made no changes to undo in the last 15m0s.", reply.text)
+}
@@ -0,0 +1,352 @@
+package requestprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// queuedWritesBucket is the bucket of the store holding the writes waiting
+// for Notion to become available.
+const queuedWritesBucket = "queued_writes"
+
+// writeQueuePollInterval is how often the queue is checked for writes to
+// replay.
+const writeQueuePollInterval = 5 * time.Second
+
+const (
+	queuedReply = "Notion is unavailable right now. The change has been queued and " +
+		"will be applied once Notion is back, the result will be sent here."
+	queuedBehindReply = "Earlier changes are still waiting for Notion. The change has been " +
+		"queued after them, the result will be sent here."
+)
+
+// errWritesQueued is the reason a write is queued without trying it, the
+// writes queued before have not been applied yet.
+var errWritesQueued = errors.New("earlier writes are still queued")
+
+// WriteQueueConfig sets how the writes that failed while Notion was
+// unavailable are replayed.
+type WriteQueueConfig struct {
+	// BaseDelay is the delay before replaying after a failure, it doubles
+	// with every following failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAge is how long a write is replayed before it is given up.
+	MaxAge time.Duration
+}
+
+func DefaultWriteQueueConfig() WriteQueueConfig {
+	return WriteQueueConfig{
+		BaseDelay: 30 * time.Second,
+		MaxDelay:  10 * time.Minute,
+		MaxAge:    24 * time.Hour,
+	}
+}
+
+// backoff returns the delay before replaying after the given number of
+// failures in a row.
+func (c WriteQueueConfig) backoff(failures int) time.Duration {
+	delay := c.BaseDelay
+	for i := 1; i < failures && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, c.MaxDelay)
+}
+
+// notionWrite is a change of Notion made by a command, one of the requests is
+// set. It is saved to be replayed if Notion is unavailable.
+type notionWrite struct {
+	// ID is also the request ID of the replays.
+	ID        string `json:"id"`
+	Command   string `json:"command"`
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id,omitempty"`
+	UserID    int64  `json:"user_id"`
+	UserName  string `json:"user_name,omitempty"`
+
+	Task     *notion.CreateTaskRequest  `json:"task,omitempty"`
+	Tweak    *notion.CreateTweakRequest `json:"tweak,omitempty"`
+	Deadline *notion.SetDeadlineRequest `json:"deadline,omitempty"`
+	Status   *notion.SetStatusRequest   `json:"status,omitempty"`
+
+	// Reply is the reply to the command once the write is applied, the link
+	// of the created page is appended to it.
+	Reply    string    `json:"reply"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// changes describes the write before it is applied, the old values are
+// unknown.
+func (w *notionWrite) changes() []audit.Change {
+	switch {
+	case w.Deadline != nil:
+		return []audit.Change{{
+			PageID: notion.PageIDFromLink(w.Deadline.TaskLink), Field: audit.FieldDeadline,
+			New: w.Deadline.Deadline.Format("2006-01-02"),
+		}}
+	case w.Status != nil:
+		return []audit.Change{{
+			PageID: notion.PageIDFromLink(w.Status.TaskLink), Field: audit.FieldStatus,
+			New: w.Status.Status,
+		}}
+	default:
+		return nil
+	}
+}
+
+// replayable tells whether the write may be sent again after it has failed.
+// A create may have been applied by Notion despite the failure, it is only
+// sent again with an idempotency key, which lets the bot find the page.
+func (w *notionWrite) replayable() bool {
+	switch {
+	case w.Task != nil:
+		return w.Task.IdempotencyKey != ""
+	case w.Tweak != nil:
+		return w.Tweak.IdempotencyKey != ""
+	default:
+		return true
+	}
+}
+
+// message returns the command as far as the audit log is concerned.
+func (w *notionWrite) message() commandCommon {
+	return commandCommon{
+		chatID:       w.ChatID,
+		messageID:    w.MessageID,
+		fromUserID:   w.UserID,
+		fromUserName: w.UserName,
+	}
+}
+
+// SetWriteQueue makes the writes failing while Notion is unavailable queued
+// in the store and replayed by ReplayQueuedWrites.
+func (p *RequestProcessor) SetWriteQueue(config WriteQueueConfig) {
+	p.writeQueue = &config
+}
+
+// apply makes the change in Notion. It returns the link of the created page,
+// empty for updates, and the changes to record. The old values of the changes
+// are loaded right before the write, if Notion is unavailable the write is not
+// tried.
+func (p *RequestProcessor) apply(
+	ctx context.Context, w *notionWrite,
+) (string, []audit.Change, error) {
+	switch w.Command {
+	case "/task", "/agenda":
+		url, err := p.notion.CreateNotionTaskContext(ctx, w.Task)
+		return url, createdPage(url), err
+	case "/tweak " + string(tweakModeMix):
+		url, err := p.notion.CreateTweakMixContext(ctx, w.Tweak)
+		return url, createdPage(url), err
+	case "/tweak " + string(tweakModeDemo):
+		url, err := p.notion.CreateTweakDemoContext(ctx, w.Tweak)
+		return url, createdPage(url), err
+	case "/deadline":
+		change, err := p.taskChange(
+			ctx, w.Deadline.TaskLink, audit.FieldDeadline, w.Deadline.Deadline.Format("2006-01-02"),
+		)
+		if err != nil {
+			return "", []audit.Change{change}, err
+		}
+		p.tasksChanging(change.PageID)
+		return "", []audit.Change{change}, p.notion.SetDeadlineContext(ctx, w.Deadline)
+	case "/done":
+		change, err := p.taskChange(ctx, w.Status.TaskLink, audit.FieldStatus, w.Status.Status)
+		if err != nil {
+			return "", []audit.Change{change}, err
+		}
+		p.tasksChanging(change.PageID)
+		return "", []audit.Change{change}, p.notion.SetStatusContext(ctx, w.Status)
+	default:
+		return "", nil, fmt.Errorf("unknown write %s", w.Command)
+	}
+}
+
+// applyOrQueue applies the write of the command and returns the reply. If
+// Notion is unavailable and the write queue is enabled, the write is queued
+// instead and the result is sent in a follow-up message, see replayable. While earlier writes
+// are queued, the write is queued right away to be applied after them.
+func (p *RequestProcessor) applyOrQueue(
+	ctx context.Context, message commandCommon, w *notionWrite,
+) (string, error) {
+	if p.writeQueue != nil && p.writesQueued(ctx) {
+		return p.queue(ctx, message, w, w.changes(), errWritesQueued, queuedBehindReply)
+	}
+
+	link, changes, err := p.apply(ctx, w)
+	if err == nil {
+		p.tasksChanged(changes)
+	}
+	if err == nil || p.writeQueue == nil || !errors.Is(err, notion.ErrUnavailable) ||
+		!w.replayable() {
+		p.recordChange(ctx, message, w.Command, changes, err)
+		if err != nil {
+			return "", err
+		}
+		return w.Reply + link, nil
+	}
+
+	return p.queue(ctx, message, w, changes, err, queuedReply)
+}
+
+// queue queues the write that could not be applied because of err and
+// returns the reply. If the write cannot be queued, err is returned.
+func (p *RequestProcessor) queue(
+	ctx context.Context, message commandCommon, w *notionWrite,
+	changes []audit.Change, err error, reply string,
+) (string, error) {
+	if qErr := p.queueWrite(message, w); qErr != nil {
+		logger.ErrorContext(ctx, "Could not queue the write", "command", w.Command, "error", qErr)
+		p.recordChange(ctx, message, w.Command, changes, err)
+		return "", err
+	}
+
+	logger.WarnContext(ctx, "Queued the write",
+		"command", w.Command, "write_id", w.ID, "error", err,
+	)
+	if p.auditLog != nil {
+		entry := newEntry(ctx, message, w.Command, changes, err)
+		entry.Outcome = audit.OutcomeQueued
+		p.appendEntry(ctx, entry)
+	}
+
+	return reply, nil
+}
+
+// writesQueued tells whether there are writes waiting to be replayed. The
+// writes that cannot be loaded are never replayed, so they do not count.
+func (p *RequestProcessor) writesQueued(ctx context.Context) bool {
+	writes, err := p.queuedWrites.All()
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not load some queued writes", "error", err)
+	}
+
+	return len(writes) > 0
+}
+
+func (p *RequestProcessor) queueWrite(message commandCommon, w *notionWrite) error {
+	w.ID = logging.NewRequestID()
+	w.ChatID = message.chatID
+	w.MessageID = message.messageID
+	w.UserID = message.fromUserID
+	w.UserName = message.fromUserName
+	w.QueuedAt = p.now()
+
+	return p.queuedWrites.Put(w.ID, *w)
+}
+
+// ReplayQueuedWrites replays the queued writes until ctx is cancelled.
+func (p *RequestProcessor) ReplayQueuedWrites(ctx context.Context) {
+	ticker := time.NewTicker(writeQueuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.replayQueuedWrites(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replayQueuedWrites replays the writes in the order they were queued, unless
+// it is too early after a failure. It stops at the first write failing because
+// Notion is still unavailable, so that the order of the writes is kept.
+func (p *RequestProcessor) replayQueuedWrites(ctx context.Context) {
+	if p.writeQueue == nil || p.now().Before(p.nextReplay) {
+		return
+	}
+
+	all, err := p.queuedWrites.All()
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not load some queued writes", "error", err)
+	}
+
+	writes := make([]notionWrite, 0, len(all))
+	for _, w := range all {
+		writes = append(writes, w)
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].QueuedAt.Before(writes[j].QueuedAt) })
+
+	for i := range writes {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !p.replayWrite(ctx, &writes[i]) {
+			p.replayFailures++
+			p.nextReplay = p.now().Add(p.writeQueue.backoff(p.replayFailures))
+			logger.InfoContext(ctx, "Notion is still unavailable, will replay queued writes later",
+				"queued", len(writes)-i, "next_replay", p.nextReplay,
+			)
+			return
+		}
+	}
+
+	p.replayFailures = 0
+}
+
+// replayWrite applies the write and sends the result to the chat of the
+// command. It reports false if Notion is still unavailable, the write is kept
+// then unless it is too old or may not be sent again.
+func (p *RequestProcessor) replayWrite(ctx context.Context, w *notionWrite) bool {
+	ctx = logging.WithRequestID(ctx, w.ID)
+	ctx, cancel := context.WithTimeout(ctx, p.requestTimeout)
+	defer cancel()
+
+	link, changes, err := p.apply(ctx, w)
+	unavailable := errors.Is(err, notion.ErrUnavailable)
+	if unavailable && p.now().Sub(w.QueuedAt) < p.writeQueue.MaxAge && w.replayable() {
+		// the idempotency key generated by the attempt is kept for the next
+		if err := p.queuedWrites.Put(w.ID, *w); err != nil {
+			logger.ErrorContext(ctx, "Could not update the queued write", "error", err)
+		}
+		return false
+	}
+
+	p.recordChange(ctx, w.message(), w.Command, changes, err)
+	if err == nil {
+		p.tasksChanged(changes)
+	}
+
+	text := fmt.Sprintf("Queued %s has been applied. %s", w.Command, w.Reply+link)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not replay queued write", "command", w.Command, "error", err)
+		text = fmt.Sprintf("Could not apply queued %s: %s", w.Command, html.EscapeString(err.Error()))
+	} else {
+		logger.InfoContext(ctx, "Replayed queued write", "command", w.Command)
+	}
+	p.sendFollowUp(ctx, w, text)
+
+	if err := p.queuedWrites.Delete(w.ID); err != nil {
+		logger.ErrorContext(ctx, "Could not remove replayed write from the queue", "error", err)
+	}
+
+	return !unavailable
+}
+
+func (p *RequestProcessor) sendFollowUp(ctx context.Context, w *notionWrite, text string) {
+	msg := tgbotapi.NewMessage(w.ChatID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyToMessageID = w.MessageID
+	msg.AllowSendingWithoutReply = true
+
+	if _, err := p.bot.Send(msg); err != nil {
+		logger.ErrorContext(ctx, "Could not send the result of queued write to Telegram",
+			"error", err,
+		)
+	}
+}
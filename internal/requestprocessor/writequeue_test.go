@@ -0,0 +1,165 @@
+package requestprocessor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/audit"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func notionDown(path string) notiontest.Fault {
+	return notiontest.Fault{Path: path, Status: http.StatusServiceUnavailable}
+}
+
+func TestWriteQueueReplaysWhenNotionIsBack(t *testing.T) {
+	s := newScenario(t, polling)
+	auditLog := newTestAuditLog(t)
+	s.processor.SetAuditLog(auditLog)
+	s.processor.SetWriteQueue(WriteQueueConfig{
+		BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAge: time.Hour,
+	})
+	ctx := context.Background()
+
+	s.server.InjectFault(notionDown("pages"))
+	command := s.say("/task Fix the mixer\n@gibsn")
+	s.expect("The change has been queued")
+	assert.Empty(t, s.server.Pages(e2eTasksDB))
+
+	// Notion is still down, the write is kept and retried after a delay
+	s.processor.replayQueuedWrites(ctx)
+	writes, err := s.processor.queuedWrites.All()
+	require.NoError(t, err)
+	require.Len(t, writes, 1)
+	assert.False(t, s.processor.nextReplay.IsZero())
+
+	s.server.ClearFaults()
+	s.processor.replayQueuedWrites(ctx)
+	assert.Empty(t, s.server.Pages(e2eTasksDB), "replayed before the delay")
+
+	s.processor.nextReplay = time.Time{}
+	s.processor.replayQueuedWrites(ctx)
+	created := s.expect("Queued /task has been applied. Task has been successfully created")
+	assert.Equal(t, command.MessageID, created.ReplyToMessage.MessageID)
+	assert.Len(t, s.server.Pages(e2eTasksDB), 1)
+
+	writes, err = s.processor.queuedWrites.All()
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+
+	// the reply to the follow-up works like a reply to the original answer
+	s.reply(created, "/done")
+	s.expect("Task has been successfully marked as Done")
+
+	entries, err := auditLog.Query(func(e audit.Entry) bool { return e.Command == "/task" }, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, audit.OutcomeOK, entries[0].Outcome)
+	assert.Equal(t, audit.OutcomeQueued, entries[1].Outcome)
+}
+
+func TestWriteQueueGivesUp(t *testing.T) {
+	s := newScenario(t, polling)
+	s.processor.SetWriteQueue(WriteQueueConfig{MaxAge: time.Hour})
+
+	taskID := s.server.AddPage(e2eTasksDB, property.Properties{
+		"Задача": property.NewTitle("Fix the mixer"),
+	})
+	task := s.say("Task: " + pageLinkFromID(taskID))
+
+	s.server.InjectFault(notionDown(""))
+	s.reply(task, "/deadline 2030-01-02")
+	s.expect("The change has been queued")
+
+	s.processor.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	s.processor.replayQueuedWrites(context.Background())
+	s.expect("Could not apply queued /deadline: request to Notion API failed")
+
+	writes, err := s.processor.queuedWrites.All()
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+}
+
+func TestWriteQueueKeepsRejectedWritesOut(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetWriteQueue(DefaultWriteQueueConfig())
+
+	e.server.InjectFault(notiontest.Fault{Path: "pages", Status: http.StatusBadRequest})
+	reply := e.command(t, "/done", "Task: https://www.notion.so/00000000000040008000000000000001")
+	assert.Contains(t, reply.text, "could not set status to done")
+
+	writes, err := e.processor.queuedWrites.All()
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+}
+
+func TestWriteQueueKeepsCreatesWithoutKeyOut(t *testing.T) {
+	e := newE2EEnv(t)
+	e.processor.SetWriteQueue(DefaultWriteQueueConfig())
+	e.processor.notion.SetIdempotencyKeyProperty("")
+
+	// the page may have been created, sending it again could duplicate it
+	e.server.InjectFault(notiontest.Fault{
+		Method: http.MethodPost, Path: "pages", Status: http.StatusBadGateway,
+	})
+	reply := e.command(t, "/task Fix the mixer\n@gibsn", "")
+	assert.Contains(t, reply.text, "error creating a task in Notion")
+
+	writes, err := e.processor.queuedWrites.All()
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+}
+
+func TestWriteQueueKeepsOrder(t *testing.T) {
+	s := newScenario(t, polling)
+	auditLog := newTestAuditLog(t)
+	s.processor.SetAuditLog(auditLog)
+	s.processor.SetWriteQueue(WriteQueueConfig{
+		BaseDelay: time.Minute, MaxDelay: time.Hour, MaxAge: time.Hour,
+	})
+
+	taskID := s.server.AddPage(e2eTasksDB, property.Properties{
+		"Задача": property.NewTitle("Fix the mixer"),
+	})
+	task := s.say("Task: " + pageLinkFromID(taskID))
+
+	// the old deadline cannot be loaded, the write is not tried
+	s.server.InjectFault(notionDown(""))
+	s.reply(task, "/deadline 2030-01-02")
+	s.expect("Notion is unavailable right now")
+	for _, req := range s.server.Requests() {
+		assert.NotEqual(t, http.MethodPatch, req.Method)
+	}
+
+	// Notion is back, yet the later write waits for the queued one
+	s.server.ClearFaults()
+	s.reply(task, "/done")
+	s.expect("Earlier changes are still waiting for Notion")
+	page, _ := s.server.Page(taskID)
+	assert.Empty(t, page.Properties.PlainText("Статус"))
+
+	s.processor.replayQueuedWrites(context.Background())
+	s.expect("Queued /deadline has been applied")
+	s.expect("Queued /done has been applied")
+
+	entries, err := auditLog.Query(func(e audit.Entry) bool {
+		return e.Command == "/deadline" && e.Outcome == audit.OutcomeOK
+	}, 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].Changes[0].OldKnown, "the old value is loaded when replayed")
+}
+
+func TestWriteQueueBackoff(t *testing.T) {
+	c := WriteQueueConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	assert.Equal(t, time.Second, c.backoff(1))
+	assert.Equal(t, 4*time.Second, c.backoff(3))
+	assert.Equal(t, 5*time.Second, c.backoff(10))
+}
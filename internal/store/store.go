@@ -0,0 +1,210 @@
+// Package store keeps the state of the bot that has to survive restarts, e.g.
+// dialogs waiting for a reply. The state is small, so it is held in memory and
+// the whole of it is written to a single file on every change.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store is a key/value store of JSON values grouped in named buckets. It is
+// safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]json.RawMessage
+	// path is the file the state is saved to, empty keeps it in memory only.
+	path string
+}
+
+// NewMemoryStore returns a store that is not saved anywhere.
+func NewMemoryStore() *Store {
+	return &Store{buckets: make(map[string]map[string]json.RawMessage)}
+}
+
+// Open loads the store saved to path, a missing file is created by the first
+// change.
+func Open(path string) (*Store, error) {
+	s := NewMemoryStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.buckets); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[string]map[string]json.RawMessage)
+	}
+
+	return s, nil
+}
+
+func (s *Store) get(bucket, key string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.buckets[bucket][key]
+	return value, ok
+}
+
+func (s *Store) all(bucket string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string]json.RawMessage, len(s.buckets[bucket]))
+	for key, value := range s.buckets[bucket] {
+		values[key] = value
+	}
+
+	return values
+}
+
+// update applies the change and saves the store if change reports that
+// anything has changed. The change is kept in memory even if saving fails, so
+// the process goes on with the latest state.
+func (s *Store) update(bucket string, change func(values map[string]json.RawMessage) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values, ok := s.buckets[bucket]
+	if !ok {
+		values = make(map[string]json.RawMessage)
+		s.buckets[bucket] = values
+	}
+	changed := change(values)
+	if len(values) == 0 {
+		delete(s.buckets, bucket)
+	}
+
+	if !changed || s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.buckets)
+	if err != nil {
+		return fmt.Errorf("could not encode state: %w", err)
+	}
+	if err := WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("could not save state: %w", err)
+	}
+
+	return nil
+}
+
+// WriteFileAtomic replaces the file with data. The data is written to a
+// temporary file in the same directory first, so that after a crash the file
+// holds either the old or the new contents and never a part of them.
+func WriteFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // it is gone after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // the write error is reported
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // the sync error is reported
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Bucket is a typed view of a bucket of the store, values are kept as JSON.
+type Bucket[T any] struct {
+	store *Store
+	name  string
+}
+
+func NewBucket[T any](s *Store, name string) *Bucket[T] {
+	return &Bucket[T]{store: s, name: name}
+}
+
+// Get returns the value of the key, false if there is none.
+func (b *Bucket[T]) Get(key string) (T, bool, error) {
+	var value T
+
+	data, ok := b.store.get(b.name, key)
+	if !ok {
+		return value, false, nil
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, false, fmt.Errorf("invalid value of %s/%s: %w", b.name, key, err)
+	}
+
+	return value, true, nil
+}
+
+// All returns all values of the bucket. Values that can not be decoded, e.g.
+// saved by an older version, are skipped and reported in the error.
+func (b *Bucket[T]) All() (map[string]T, error) {
+	var errs []error
+
+	values := make(map[string]T)
+	for key, data := range b.store.all(b.name) {
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value of %s/%s: %w", b.name, key, err))
+			continue
+		}
+		values[key] = value
+	}
+
+	return values, errors.Join(errs...)
+}
+
+// Put sets the value of the key and saves the store.
+func (b *Bucket[T]) Put(key string, value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("could not encode value of %s/%s: %w", b.name, key, err)
+	}
+
+	return b.store.update(b.name, func(values map[string]json.RawMessage) bool {
+		values[key] = data
+		return true
+	})
+}
+
+// Delete removes the keys and saves the store.
+func (b *Bucket[T]) Delete(keys ...string) error {
+	return b.store.update(b.name, func(values map[string]json.RawMessage) bool {
+		changed := false
+		for _, key := range keys {
+			if _, ok := values[key]; ok {
+				delete(values, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type record struct {
+	Name      string    `json:"name"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func TestBucketSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	require.NoError(t, err)
+	records := NewBucket[record](s, "records")
+
+	expiresAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, records.Put("a", record{Name: "A", ExpiresAt: expiresAt}))
+	require.NoError(t, records.Put("b", record{Name: "B"}))
+	require.NoError(t, records.Delete("b", "missing"))
+	require.NoError(t, NewBucket[int](s, "counters").Put("n", 1))
+
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	records = NewBucket[record](reopened, "records")
+
+	got, ok, err := records.Get("a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "A", got.Name)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+
+	_, ok, err = records.Get("b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	all, err := NewBucket[int](reopened, "counters").All()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"n": 1}, all)
+}
+
+func TestBucketSkipsInvalidValues(t *testing.T) {
+	s := NewMemoryStore()
+	require.NoError(t, NewBucket[string](s, "mixed").Put("text", "hello"))
+	require.NoError(t, NewBucket[int](s, "mixed").Put("number", 1))
+
+	all, err := NewBucket[int](s, "mixed").All()
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"number": 1}, all)
+
+	_, _, err = NewBucket[int](s, "mixed").Get("text")
+	assert.Error(t, err)
+}
+
+func TestOpenRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+	_, err := Open(path)
+	assert.Error(t, err)
+}
+
+func TestFailedSaveKeepsChange(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(filepath.Join(dir, "missing", "state.json"))
+	require.NoError(t, err)
+	b := NewBucket[string](s, "b")
+
+	assert.Error(t, b.Put("k", "v"))
+
+	got, ok, err := b.Get("k")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v", got)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+
+	require.NoError(t, WriteFileAtomic(path, []byte("old")))
+	require.NoError(t, WriteFileAtomic(path, []byte("new")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are left behind")
+}
@@ -0,0 +1,121 @@
+// Package supervisor keeps the background loops of the bot running. A loop
+// that panics or returns before it is told to stop is restarted after a
+// backoff, so that a bug in one loop does not take the whole bot down.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+)
+
+var logger = logging.For("supervisor")
+
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Supervisor runs loops in goroutines and restarts them until their context
+// is cancelled.
+type Supervisor struct {
+	minBackoff, maxBackoff time.Duration
+
+	wg sync.WaitGroup
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+}
+
+// SetBackoff sets the delay before the first restart of a loop. The delay
+// doubles with every restart up to maxBackoff and is reset once the loop has
+// been running for longer than maxBackoff.
+func (s *Supervisor) SetBackoff(minBackoff, maxBackoff time.Duration) {
+	s.minBackoff = minBackoff
+	s.maxBackoff = maxBackoff
+}
+
+// Go runs loop in a goroutine until ctx is cancelled, restarting it whenever
+// it panics or returns early. The name is used in logs.
+func (s *Supervisor) Go(ctx context.Context, name string, loop func(context.Context)) {
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		s.supervise(ctx, name, loop)
+	}()
+}
+
+// Wait blocks until all loops have returned after their contexts were
+// cancelled.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Supervisor) supervise(ctx context.Context, name string, loop func(context.Context)) {
+	backoff := s.minBackoff
+
+	for {
+		started := time.Now()
+		panicked := runProtected(ctx, name, loop)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > s.maxBackoff {
+			backoff = s.minBackoff
+		}
+		if panicked {
+			logger.ErrorContext(ctx, "Loop crashed, restarting it", "loop", name, "backoff", backoff)
+		} else {
+			logger.ErrorContext(ctx, "Loop stopped unexpectedly, restarting it",
+				"loop", name, "backoff", backoff,
+			)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backoff = min(2*backoff, s.maxBackoff)
+	}
+}
+
+func runProtected(ctx context.Context, name string, loop func(context.Context)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(ctx, name, r)
+			panicked = true
+		}
+	}()
+
+	loop(ctx)
+
+	return false
+}
+
+// LogPanic recovers from a panic and logs it along with the stack trace. It
+// has to be deferred directly, e.g. defer supervisor.LogPanic(ctx, "update 42").
+func LogPanic(ctx context.Context, what string) {
+	if r := recover(); r != nil {
+		logPanic(ctx, what, r)
+	}
+}
+
+func logPanic(ctx context.Context, what string, r any) {
+	logger.ErrorContext(ctx, "Recovered from a panic",
+		"in", what, "panic", fmt.Sprint(r), "stack", string(debug.Stack()),
+	)
+}
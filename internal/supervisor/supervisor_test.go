@@ -0,0 +1,78 @@
+package supervisor
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSupervisor() *Supervisor {
+	s := NewSupervisor()
+	s.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	return s
+}
+
+func TestRestartsCrashedLoop(t *testing.T) {
+	s := newTestSupervisor()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var runs atomic.Int32
+	s.Go(ctx, "test loop", func(ctx context.Context) {
+		switch runs.Add(1) {
+		case 1:
+			panic("boom")
+		case 2:
+			return
+		default:
+			<-ctx.Done()
+		}
+	})
+
+	assert.Eventually(t, func() bool { return runs.Load() == 3 }, time.Second, time.Millisecond)
+
+	cancel()
+	s.Wait()
+	assert.EqualValues(t, 3, runs.Load(), "the loop is not restarted after cancel")
+}
+
+func TestStopsDuringBackoff(t *testing.T) {
+	s := NewSupervisor()
+	s.SetBackoff(time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	crashed := make(chan struct{})
+	s.Go(ctx, "test loop", func(context.Context) {
+		close(crashed)
+		panic("boom")
+	})
+
+	<-crashed
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the supervisor waits for the backoff after cancel")
+	}
+}
+
+func TestLogPanic(t *testing.T) {
+	recovered := func() (ok bool) {
+		defer LogPanic(context.Background(), "test")
+		defer func() { ok = true }()
+
+		panic("boom")
+	}
+
+	assert.NotPanics(t, func() { assert.True(t, recovered()) })
+}
@@ -0,0 +1,123 @@
+package taskscache
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+)
+
+// EventType is the kind of a change of a task noticed between two loads.
+type EventType string
+
+const (
+	// EventCreated is a task appearing among the active ones, it is either
+	// created or moved out of the backlog.
+	EventCreated       EventType = "created"
+	EventAssigneeAdded EventType = "assignee_added"
+	EventStatusChanged EventType = "status_changed"
+	// EventDeadline is a deadline set or moved.
+	EventDeadline EventType = "deadline"
+	EventDone     EventType = "done"
+)
+
+// EventTypes lists all event types.
+var EventTypes = []EventType{
+	EventCreated, EventAssigneeAdded, EventStatusChanged, EventDeadline, EventDone,
+}
+
+// Event is a change of a task noticed between two loads of the cache.
+type Event struct {
+	Type EventType
+	// Task is the task after the change, for EventDone it is the last one
+	// known while the task was active.
+	Task notion.Task
+	// Assignees are the assignees added by EventAssigneeAdded.
+	Assignees []notion.Assignee
+	// OldStatus and OldDeadline are the values before EventStatusChanged and
+	// EventDeadline, OldDeadline is zero if the deadline has been set.
+	OldStatus   string
+	OldDeadline time.Time
+}
+
+// EventHandler is called with the events noticed by a load, it should not
+// block the load for long.
+type EventHandler func(ctx context.Context, events []Event)
+
+// SetEventHandler makes the cache diff the consecutive loads and pass the
+// changes to h. The first load is not diffed. A task is known to be done
+// only from the loads of the edited tasks, the full ones see it vanish.
+func (c *Cache) SetEventHandler(h EventHandler) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.onEvents = h
+}
+
+// diff returns the events turning the old tasks into the updated ones, done
+// are the links of the tasks marked done in between.
+func diff(old, updated []notion.Task, done []string) []Event {
+	byLink := make(map[string]notion.Task, len(old))
+	for _, task := range old {
+		byLink[task.Link] = task
+	}
+
+	var events []Event
+	for _, task := range updated {
+		before, ok := byLink[task.Link]
+		if !ok {
+			events = append(events, Event{Type: EventCreated, Task: task})
+			continue
+		}
+
+		var added []notion.Assignee
+		for _, a := range task.Assignees {
+			if !slices.ContainsFunc(before.Assignees, func(b notion.Assignee) bool {
+				return b.ID == a.ID
+			}) {
+				added = append(added, a)
+			}
+		}
+		if len(added) > 0 {
+			events = append(events, Event{Type: EventAssigneeAdded, Task: task, Assignees: added})
+		}
+
+		if task.Status != before.Status {
+			events = append(events, Event{
+				Type: EventStatusChanged, Task: task, OldStatus: before.Status,
+			})
+		}
+
+		if !task.Deadline.IsZero() && !task.Deadline.Equal(before.Deadline) {
+			events = append(events, Event{
+				Type: EventDeadline, Task: task, OldDeadline: before.Deadline,
+			})
+		}
+	}
+
+	for _, link := range done {
+		if task, ok := byLink[link]; ok {
+			events = append(events, Event{Type: EventDone, Task: task})
+		}
+	}
+
+	return events
+}
+
+// withoutPages drops the events of the tasks whose page IDs are among the
+// keys of pageIDs.
+func withoutPages(events []Event, pageIDs map[string]time.Time) []Event {
+	if len(pageIDs) == 0 {
+		return events
+	}
+
+	kept := events[:0]
+	for _, e := range events {
+		if _, ok := pageIDs[notion.PageIDFromLink(e.Task.Link)]; !ok {
+			kept = append(kept, e)
+		}
+	}
+
+	return kept
+}
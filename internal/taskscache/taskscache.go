@@ -1,24 +1,78 @@
 package taskscache
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math"
 	"sync"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
 	"github.com/gibsn/telegram_to_notion/internal/notion"
 )
 
-type Cache struct {
-	debug bool
+var logger = logging.For("cache").With("cache", "tasks")
+
+var (
+	cacheRefreshes = metrics.NewCounterVec(
+		"cache_refreshes_total", "Loads of the caches by result, ok or error.", "cache", "result",
+	)
+	cacheAge = metrics.NewGaugeVec(
+		"cache_age_seconds",
+		"Time since the last successful load of the cache, +Inf before the first one.",
+		"cache",
+	)
+)
+
+const (
+	// DefaultResyncPeriod is how often all active tasks are loaded, in between
+	// only the edited ones are. A full load is what notices the removed tasks.
+	DefaultResyncPeriod = 10 * time.Minute
+
+	// editedMargin widens the window of the edited tasks, Notion rounds
+	// last_edited_time down to the minute.
+	editedMargin = 2 * time.Minute
+
+	// freshFor is how long after a load a read does not revalidate the cache.
+	freshFor = 10 * time.Second
+)
 
+type Cache struct {
 	notion *notion.Notion
 	dbID   string
 
-	period time.Duration
+	period       time.Duration
+	resyncPeriod time.Duration
+
+	// refreshMu guards refreshing, the load in progress if any, and the
+	// settings of the loads.
+	refreshMu  sync.Mutex
+	refreshing *refreshCall
+	onEvents   EventHandler
 
 	cacheLock sync.RWMutex
 	cache     []notion.Task
+	// refreshed is the time of the last successful load, zero until the
+	// first one.
+	refreshed time.Time
+	// synced is the time the last successful load started at, the next one
+	// loads the tasks edited since. fullSynced is the same for full loads.
+	synced     time.Time
+	fullSynced time.Time
+	// staleGen is bumped by MarkStale, loadedGen is its value at the start of
+	// the last successful load.
+	staleGen  uint64
+	loadedGen uint64
+	// botChanges maps the IDs of the pages changed by the bot to the time of
+	// the change, see MarkChanged.
+	botChanges map[string]time.Time
+}
+
+// refreshCall is a load shared by the concurrent refreshes.
+type refreshCall struct {
+	done chan struct{}
+	err  error
 }
 
 func NewTasksCache(
@@ -26,35 +80,47 @@ func NewTasksCache(
 	period time.Duration,
 ) *Cache {
 	c := &Cache{
-		notion: notion,
-		dbID:   dbID,
-		period: period,
+		notion:       notion,
+		dbID:         dbID,
+		period:       period,
+		resyncPeriod: DefaultResyncPeriod,
+		botChanges:   make(map[string]time.Time),
 	}
 
+	cacheAge.SetFunc(c.ageSeconds, "tasks")
+
 	return c
 }
 
-func (c *Cache) RefreshPeriodically() {
+// SetResyncPeriod sets how often all active tasks are loaded instead of the
+// edited ones only.
+func (c *Cache) SetResyncPeriod(period time.Duration) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.resyncPeriod = period
+}
+
+// RefreshPeriodically refreshes the cache every period until the context is
+// cancelled. A single load may take no longer than the period itself.
+func (c *Cache) RefreshPeriodically(ctx context.Context) {
 	ticker := time.NewTicker(c.period)
 	defer ticker.Stop()
 
 	for {
-		log.Printf("Will load tasks now")
+		loadCtx, cancel := context.WithTimeout(ctx, c.period)
+		loadCtx = logging.WithRequestID(loadCtx, logging.NewRequestID())
 
-		tasks, err := c.notion.LoadTasks(c.dbID)
-		if err != nil {
-			log.Printf("Could not load tasks: %v", err)
+		if err := c.refresh(loadCtx); err != nil {
+			logger.ErrorContext(loadCtx, "Could not load tasks", "error", err)
 		}
+		cancel()
 
-		log.Printf("%d tasks loaded, next refresh in %s", len(tasks), c.period)
-
-		if tasks != nil {
-			c.cacheLock.Lock()
-			c.cache = tasks
-			c.cacheLock.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-
-		<-ticker.C
 	}
 }
 
@@ -68,32 +134,238 @@ func (c *Cache) Tasks() []notion.Task {
 	return tasks
 }
 
-func (c *Cache) RefreshCache() error {
-	log.Printf("Refreshing tasks cache")
+// RefreshCache brings the cache up to date, see refresh.
+func (c *Cache) RefreshCache(ctx context.Context) error {
+	return c.refresh(ctx)
+}
+
+// MarkStale makes the next read refresh the cache before answering, it is
+// called after the bot changes a task.
+func (c *Cache) MarkStale() {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	c.staleGen++
+}
+
+// MarkChanged marks the cache stale as MarkStale does and keeps the pages with
+// the IDs out of the events until a load started after the call, so that the
+// changes made by the bot itself are not announced. It is called both before
+// a change, a load may notice it before the bot gets the response, and after.
+// A change made by someone else to such a page in between is not announced
+// either.
+func (c *Cache) MarkChanged(pageIDs ...string) {
+	now := time.Now()
+
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	c.staleGen++
+	for _, id := range pageIDs {
+		c.botChanges[id] = now
+	}
+}
+
+// forgetBotChanges forgets the changes made by the bot before the start of a
+// successful load, the cache holds them since. cacheLock must be held.
+func (c *Cache) forgetBotChanges(start time.Time) {
+	for id, changed := range c.botChanges {
+		if changed.Before(start) {
+			delete(c.botChanges, id)
+		}
+	}
+}
+
+// refresh loads the tasks edited since the last load, or all active tasks once
+// in resyncPeriod. Concurrent refreshes share a single load, the context of
+// the one that started it is used.
+func (c *Cache) refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	if call := c.refreshing; call != nil {
+		c.refreshMu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	c.refreshing = call
+	resyncPeriod, onEvents := c.resyncPeriod, c.onEvents
+	c.refreshMu.Unlock()
+
+	call.err = c.load(ctx, resyncPeriod, onEvents)
+
+	c.refreshMu.Lock()
+	c.refreshing = nil
+	c.refreshMu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+func (c *Cache) load(
+	ctx context.Context, resyncPeriod time.Duration, onEvents EventHandler,
+) error {
+	start := time.Now()
+
+	c.cacheLock.RLock()
+	synced, fullSynced, gen := c.synced, c.fullSynced, c.staleGen
+	old, first := c.cache, c.refreshed.IsZero()
+	c.cacheLock.RUnlock()
 
-	tasks, err := c.notion.LoadTasks(c.dbID)
+	// only the loads run under refreshing, the cache is not changed in
+	// between. The changes of the bot are the ones known by the end of the
+	// load, taken under cacheLock before they are forgotten.
+	eventsOf := func(updated []notion.Task, done []string) []Event {
+		if onEvents == nil || first {
+			return nil
+		}
+		return withoutPages(diff(old, updated, done), c.botChanges)
+	}
+	notify := func(events []Event) {
+		if len(events) > 0 {
+			onEvents(ctx, events)
+		}
+	}
+
+	if fullSynced.IsZero() || start.Sub(fullSynced) >= resyncPeriod {
+		logger.InfoContext(ctx, "Loading tasks")
+
+		tasks, err := c.notion.LoadTasksContext(ctx, c.dbID)
+		if err != nil {
+			cacheRefreshes.Inc("tasks", "error")
+			return fmt.Errorf("could not load tasks: %w", err)
+		}
+		cacheRefreshes.Inc("tasks", "ok")
+
+		c.cacheLock.Lock()
+		events := eventsOf(tasks, nil)
+		c.cache = tasks
+		c.refreshed = time.Now()
+		c.synced, c.fullSynced, c.loadedGen = start, start, gen
+		c.forgetBotChanges(start)
+		c.cacheLock.Unlock()
+
+		logger.InfoContext(ctx, "Tasks loaded", "count", len(tasks))
+		notify(events)
+
+		return nil
+	}
+
+	changes, err := c.notion.LoadTasksEditedSinceContext(ctx, c.dbID, synced.Add(-editedMargin))
 	if err != nil {
-		return fmt.Errorf("could not load tasks: %w", err)
+		cacheRefreshes.Inc("tasks", "error")
+		return fmt.Errorf("could not load edited tasks: %w", err)
 	}
+	cacheRefreshes.Inc("tasks", "ok")
 
-	log.Printf("%d tasks loaded", len(tasks))
+	tasks := merge(old, changes)
 
 	c.cacheLock.Lock()
+	events := eventsOf(tasks, changes.Done)
 	c.cache = tasks
+	c.refreshed = time.Now()
+	c.synced, c.loadedGen = start, gen
+	c.forgetBotChanges(start)
 	c.cacheLock.Unlock()
 
+	logger.InfoContext(ctx, "Edited tasks loaded",
+		"active", len(changes.Active), "inactive", len(changes.Inactive), "count", len(tasks),
+	)
+	notify(events)
+
 	return nil
 }
 
-func (c *Cache) GetTasksForUser(userID string) ([]notion.Task, error) {
-	if err := c.RefreshCache(); err != nil {
-		log.Printf("Could not refresh cache: %v, using existing cache", err)
+// merge returns the tasks with the changes applied. The tasks are not
+// modified, readers may still hold them.
+func merge(tasks []notion.Task, changes notion.TaskChanges) []notion.Task {
+	edited := make(map[string]*notion.Task, len(changes.Active))
+	for i := range changes.Active {
+		edited[changes.Active[i].Link] = &changes.Active[i]
+	}
+	removed := make(map[string]bool, len(changes.Inactive))
+	for _, link := range changes.Inactive {
+		removed[link] = true
+	}
+
+	merged := make([]notion.Task, 0, len(tasks)+len(changes.Active))
+	for _, task := range tasks {
+		if removed[task.Link] {
+			continue
+		}
+		if e, ok := edited[task.Link]; ok {
+			merged = append(merged, *e)
+			delete(edited, task.Link)
+			continue
+		}
+		merged = append(merged, task)
+	}
+	for _, task := range changes.Active {
+		if _, ok := edited[task.Link]; ok {
+			merged = append(merged, task)
+		}
+	}
+
+	return merged
+}
+
+// Ready tells whether the tasks have been loaded at least once.
+func (c *Cache) Ready() bool {
+	c.cacheLock.RLock()
+	defer c.cacheLock.RUnlock()
+
+	return !c.refreshed.IsZero()
+}
+
+// ageSeconds returns the time since the last successful load, infinite before
+// the first one.
+func (c *Cache) ageSeconds() float64 {
+	c.cacheLock.RLock()
+	defer c.cacheLock.RUnlock()
+
+	if c.refreshed.IsZero() {
+		return math.Inf(1)
+	}
+
+	return time.Since(c.refreshed).Seconds()
+}
+
+// stale tells whether the cache must be refreshed before a read: it has never
+// been loaded or a task has been changed since the last load started.
+func (c *Cache) stale() bool {
+	c.cacheLock.RLock()
+	defer c.cacheLock.RUnlock()
+
+	return c.refreshed.IsZero() || c.staleGen != c.loadedGen
+}
+
+// GetTasksForUser returns the active tasks of the user. A stale cache is
+// refreshed first, otherwise the cached tasks are returned right away and the
+// cache is revalidated in background.
+func (c *Cache) GetTasksForUser(ctx context.Context, userID string) ([]notion.Task, error) {
+	// a load already in progress may have started before the change, then
+	// one more is needed
+	for i := 0; i < 2 && c.stale(); i++ {
+		if err := c.refresh(ctx); err != nil {
+			logger.WarnContext(ctx, "Could not refresh cache, using the existing one", "error", err)
+			break
+		}
 	}
 
 	c.cacheLock.RLock()
 	cachedTasks := c.cache
+	revalidate := !c.refreshed.IsZero() && time.Since(c.refreshed) >= freshFor
 	c.cacheLock.RUnlock()
 
+	if revalidate {
+		c.revalidate(ctx)
+	}
+
 	// Filter tasks for the user
 	userTasks := make([]notion.Task, 0)
 	for _, task := range cachedTasks {
@@ -108,6 +380,15 @@ func (c *Cache) GetTasksForUser(userID string) ([]notion.Task, error) {
 	return userTasks, nil
 }
 
-func (c *Cache) SetDebug(debug bool) {
-	c.debug = debug
+// revalidate refreshes the cache in background, it outlives the request.
+func (c *Cache) revalidate(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.period)
+
+	go func() {
+		defer cancel()
+
+		if err := c.refresh(ctx); err != nil {
+			logger.WarnContext(ctx, "Could not revalidate cache", "error", err)
+		}
+	}()
 }
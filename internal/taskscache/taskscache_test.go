@@ -0,0 +1,276 @@
+package taskscache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+	"github.com/gibsn/telegram_to_notion/internal/notion/notiontest"
+	"github.com/gibsn/telegram_to_notion/internal/notion/property"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tasksDB = "tasks-db"
+
+func newTestCache(t *testing.T) (*Cache, *notiontest.Server, *notion.Notion) {
+	t.Helper()
+
+	s := notiontest.NewServer()
+	t.Cleanup(s.Close)
+
+	schema := notion.DefaultSchema()
+	props := &schema.Tasks.Properties
+	statuses := schema.Tasks.Statuses
+	s.AddDatabase(tasksDB, map[string]notiontest.Column{
+		props.Title.Name:     {Type: property.Type(props.Title.Type)},
+		props.Assignees.Name: {Type: property.Type(props.Assignees.Type)},
+		props.Status.Name: {
+			Type: property.Type(props.Status.Type),
+			Options: []string{
+				statuses.New, statuses.Backlog, statuses.Done, statuses.Archived,
+			},
+		},
+		props.Deadline.Name: {Type: property.Type(props.Deadline.Type)},
+	})
+	s.AddUser("user-1", "Alice")
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(s.URL())
+
+	return NewTasksCache(n, tasksDB, time.Minute), s, n
+}
+
+func addTask(s *notiontest.Server, title string) string {
+	id := s.AddPage(tasksDB, property.Properties{
+		"Задача":      property.NewTitle(title),
+		"Исполнитель": property.NewPeople("user-1"),
+		"Статус":      property.NewOption(property.TypeSelect, notion.StatusNew),
+	})
+
+	return "https://www.notion.so/" + strings.ReplaceAll(id, "-", "")
+}
+
+func titles(tasks []notion.Task) []string {
+	result := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		result = append(result, task.Title)
+	}
+
+	return result
+}
+
+// queries returns the bodies of the queries of the tasks database.
+func queries(s *notiontest.Server) []string {
+	var bodies []string
+	for _, req := range s.Requests() {
+		if req.Path == "databases/"+tasksDB+"/query" {
+			bodies = append(bodies, string(req.Body))
+		}
+	}
+
+	return bodies
+}
+
+func TestIncrementalRefresh(t *testing.T) {
+	cache, s, n := newTestCache(t)
+	ctx := context.Background()
+
+	first := addTask(s, "First")
+	second := addTask(s, "Second")
+	require.NoError(t, cache.RefreshCache(ctx))
+	assert.Equal(t, []string{"First", "Second"}, titles(cache.Tasks()))
+
+	require.NoError(t, n.SetStatusContext(ctx, &notion.SetStatusRequest{
+		TaskLink: second, Status: notion.StatusDone,
+	}))
+	addTask(s, "Third")
+	require.NoError(t, n.ArchivePageContext(ctx, notion.PageIDFromLink(first)))
+
+	require.NoError(t, cache.RefreshCache(ctx))
+	bodies := queries(s)
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[1], `"timestamp":"last_edited_time"`)
+	// an archived page is not returned by queries, it stays until a full load
+	assert.Equal(t, []string{"First", "Third"}, titles(cache.Tasks()))
+
+	cache.SetResyncPeriod(0)
+	require.NoError(t, cache.RefreshCache(ctx))
+	assert.NotContains(t, queries(s)[2], "last_edited_time")
+	assert.Equal(t, []string{"Third"}, titles(cache.Tasks()))
+}
+
+func TestGetTasksForUserRefreshesStaleCache(t *testing.T) {
+	cache, s, n := newTestCache(t)
+	ctx := context.Background()
+
+	link := addTask(s, "First")
+	tasks, err := cache.GetTasksForUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"First"}, titles(tasks))
+
+	// a fresh cache is served as is
+	addTask(s, "Second")
+	tasks, err = cache.GetTasksForUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"First"}, titles(tasks))
+	assert.Len(t, queries(s), 1)
+
+	require.NoError(t, n.SetStatusContext(ctx, &notion.SetStatusRequest{
+		TaskLink: link, Status: notion.StatusDone,
+	}))
+	cache.MarkStale()
+	tasks, err = cache.GetTasksForUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Second"}, titles(tasks))
+}
+
+func TestConcurrentRefreshesShareLoad(t *testing.T) {
+	cache, s, _ := newTestCache(t)
+	addTask(s, "First")
+	s.InjectFault(notiontest.Fault{Path: "databases", Delay: 100 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, cache.RefreshCache(context.Background()))
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, queries(s), 1)
+	assert.Len(t, cache.Tasks(), 1)
+}
+
+func TestDiff(t *testing.T) {
+	alice := notion.Assignee{Name: "Alice", ID: "user-1"}
+	bob := notion.Assignee{Name: "Bob", ID: "user-2"}
+	day := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	old := []notion.Task{
+		{Link: "a", Title: "A", Assignees: []notion.Assignee{alice}, Status: "New"},
+		{Link: "b", Title: "B", Assignees: []notion.Assignee{alice}, Status: "New", Deadline: day},
+		{Link: "c", Title: "C", Assignees: []notion.Assignee{alice}, Status: "New"},
+		{Link: "d", Title: "D", Assignees: []notion.Assignee{alice}, Status: "New"},
+	}
+	updated := []notion.Task{
+		{Link: "a", Title: "A", Assignees: []notion.Assignee{alice, bob}, Status: "In work"},
+		{Link: "b", Title: "B", Assignees: []notion.Assignee{alice}, Status: "New", Deadline: day},
+		{Link: "c", Title: "C", Assignees: []notion.Assignee{alice}, Status: "New", Deadline: day},
+		{Link: "e", Title: "E", Assignees: []notion.Assignee{bob}, Status: "New"},
+	}
+
+	events := diff(old, updated, []string{"d", "unknown"})
+
+	assert.Equal(t, []Event{
+		{Type: EventAssigneeAdded, Task: updated[0], Assignees: []notion.Assignee{bob}},
+		{Type: EventStatusChanged, Task: updated[0], OldStatus: "New"},
+		{Type: EventDeadline, Task: updated[2]},
+		{Type: EventCreated, Task: updated[3]},
+		{Type: EventDone, Task: old[3]},
+	}, events)
+}
+
+func TestEventsBetweenLoads(t *testing.T) {
+	cache, s, n := newTestCache(t)
+	ctx := context.Background()
+
+	var events []Event
+	cache.SetEventHandler(func(_ context.Context, e []Event) { events = append(events, e...) })
+
+	first := addTask(s, "First")
+	second := addTask(s, "Second")
+	require.NoError(t, cache.RefreshCache(ctx))
+	assert.Empty(t, events, "the first load is not diffed")
+
+	deadline := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, n.SetDeadlineContext(ctx, &notion.SetDeadlineRequest{
+		TaskLink: first, Deadline: deadline,
+	}))
+	require.NoError(t, n.SetStatusContext(ctx, &notion.SetStatusRequest{
+		TaskLink: second, Status: notion.StatusDone,
+	}))
+	addTask(s, "Third")
+
+	require.NoError(t, cache.RefreshCache(ctx))
+	require.Len(t, events, 3)
+	assert.Equal(t, EventDeadline, events[0].Type)
+	assert.Equal(t, "First", events[0].Task.Title)
+	assert.True(t, events[0].OldDeadline.IsZero())
+	assert.Equal(t, EventCreated, events[1].Type)
+	assert.Equal(t, "Third", events[1].Task.Title)
+	assert.Equal(t, EventDone, events[2].Type)
+	assert.Equal(t, "Second", events[2].Task.Title)
+
+	// nothing has changed since
+	events = nil
+	require.NoError(t, cache.RefreshCache(ctx))
+	assert.Empty(t, events)
+}
+
+func TestEventsSkipBotChanges(t *testing.T) {
+	cache, s, n := newTestCache(t)
+	ctx := context.Background()
+
+	var events []Event
+	cache.SetEventHandler(func(_ context.Context, e []Event) { events = append(events, e...) })
+
+	first := addTask(s, "First")
+	require.NoError(t, cache.RefreshCache(ctx))
+
+	// the bot creates a task and marks another one done
+	created := addTask(s, "Created by the bot")
+	require.NoError(t, n.SetStatusContext(ctx, &notion.SetStatusRequest{
+		TaskLink: first, Status: notion.StatusDone,
+	}))
+	cache.MarkChanged(notion.PageIDFromLink(created), notion.PageIDFromLink(first))
+	addTask(s, "Created by someone")
+
+	require.NoError(t, cache.RefreshCache(ctx))
+	require.Len(t, events, 1)
+	assert.Equal(t, EventCreated, events[0].Type)
+	assert.Equal(t, "Created by someone", events[0].Task.Title)
+
+	// the pages are announced again once the load has seen the changes
+	events = nil
+	deadline := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, n.SetDeadlineContext(ctx, &notion.SetDeadlineRequest{
+		TaskLink: created, Deadline: deadline,
+	}))
+	require.NoError(t, cache.RefreshCache(ctx))
+	require.Len(t, events, 1)
+	assert.Equal(t, EventDeadline, events[0].Type)
+	assert.Equal(t, "Created by the bot", events[0].Task.Title)
+}
+
+func TestEventsSkipBotChangesMarkedDuringLoad(t *testing.T) {
+	cache, s, n := newTestCache(t)
+	ctx := context.Background()
+
+	var events []Event
+	cache.SetEventHandler(func(_ context.Context, e []Event) { events = append(events, e...) })
+
+	link := addTask(s, "First")
+	require.NoError(t, cache.RefreshCache(ctx))
+
+	// the load starts once Notion has applied the change, before the bot has
+	// got the response and marked the page
+	require.NoError(t, n.SetStatusContext(ctx, &notion.SetStatusRequest{
+		TaskLink: link, Status: "In work",
+	}))
+	s.InjectFault(notiontest.Fault{Path: "databases", Times: 1, Delay: 100 * time.Millisecond})
+	loaded := make(chan error, 1)
+	go func() { loaded <- cache.RefreshCache(ctx) }()
+
+	time.Sleep(20 * time.Millisecond)
+	cache.MarkChanged(notion.PageIDFromLink(link))
+
+	require.NoError(t, <-loaded)
+	assert.Empty(t, events)
+}
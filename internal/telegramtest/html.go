@@ -0,0 +1,82 @@
+package telegramtest
+
+import (
+	"strings"
+)
+
+// htmlTags are the tags supported by the HTML parse mode.
+var htmlTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "span": true, "tg-spoiler": true,
+	"a": true, "tg-emoji": true, "code": true, "pre": true, "blockquote": true,
+}
+
+func checkParseMode(mode, text string) *apiError {
+	switch mode {
+	case "":
+		return nil
+	case "HTML":
+		return checkHTML(text)
+	case "Markdown", "MarkdownV2":
+		// the bot does not use Markdown, so it is not validated
+		return nil
+	default:
+		return badRequest("unsupported parse_mode")
+	}
+}
+
+// checkHTML checks that the text has only supported and properly nested tags,
+// so that a "<" not escaped in user input is caught the way Telegram does.
+func checkHTML(text string) *apiError {
+	var open []string
+
+	for offset := 0; ; {
+		i := strings.IndexByte(text[offset:], '<')
+		if i < 0 {
+			break
+		}
+		start := offset + i
+
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			return parseError("Unclosed start tag at byte offset %d", start)
+		}
+		tag := text[start+1 : start+end]
+		offset = start + end + 1
+
+		name, closing := strings.CutPrefix(tag, "/")
+		name, _, _ = strings.Cut(name, " ")
+		name = strings.ToLower(name)
+		if !htmlTags[name] {
+			return parseError("Unsupported start tag \"%s\" at byte offset %d", name, start)
+		}
+
+		if !closing {
+			open = append(open, name)
+			continue
+		}
+
+		if len(open) == 0 {
+			return parseError("Unexpected end tag at byte offset %d", start)
+		}
+		if last := open[len(open)-1]; last != name {
+			return parseError(
+				"Unmatched end tag at byte offset %d, expected \"</%s>\", found \"</%s>\"",
+				start, last, name,
+			)
+		}
+		open = open[:len(open)-1]
+	}
+
+	if len(open) > 0 {
+		return parseError(
+			"Can't find end tag corresponding to start tag \"%s\"", open[len(open)-1],
+		)
+	}
+
+	return nil
+}
+
+func parseError(format string, args ...interface{}) *apiError {
+	return badRequest("can't parse entities: "+format, args...)
+}
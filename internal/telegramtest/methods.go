@@ -0,0 +1,297 @@
+package telegramtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxTextLength         = 4096
+	maxCaptionLength      = 1024
+	maxCallbackDataLength = 64
+	maxCommands           = 100
+	maxPollTimeout        = 50 * time.Second
+)
+
+var commandRe = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+func (s *Server) handleGetMe(*http.Request) (interface{}, *apiError) {
+	return s.bot, nil
+}
+
+// handleGetUpdates confirms the updates before offset and returns the rest,
+// waiting for new ones up to timeout seconds if there are none.
+func (s *Server) handleGetUpdates(r *http.Request) (interface{}, *apiError) {
+	var offset, timeout, limit int
+	for name, v := range map[string]*int{"offset": &offset, "timeout": &timeout, "limit": &limit} {
+		if value := r.FormValue(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, badRequest("invalid %s", name)
+			}
+			*v = n
+		}
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+
+	poll := min(time.Duration(timeout)*time.Second, maxPollTimeout)
+	timer := time.NewTimer(poll)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if s.webhook != nil {
+			s.mu.Unlock()
+			return nil, &apiError{http.StatusConflict, "Conflict: can't use getUpdates method " +
+				"while webhook is active; use deleteWebhook to delete the webhook first"}
+		}
+		for len(s.updates) > 0 && s.updates[0].UpdateID < offset {
+			s.updates = s.updates[1:]
+		}
+		updates := s.updates[:min(limit, len(s.updates))]
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(updates) > 0 || poll <= 0 {
+			return append([]tgbotapi.Update{}, updates...), nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return []tgbotapi.Update{}, nil
+		case <-r.Context().Done():
+			return []tgbotapi.Update{}, nil
+		case <-s.closed:
+			return []tgbotapi.Update{}, nil
+		}
+	}
+}
+
+// outgoing is the part of a message common to sendMessage and sendDocument.
+type outgoing struct {
+	chatID  int64
+	replyTo int
+	// withoutReply sends the message even if replyTo is not found.
+	withoutReply bool
+	parseMode    string
+	markup       *tgbotapi.InlineKeyboardMarkup
+}
+
+func parseOutgoing(r *http.Request) (outgoing, *apiError) {
+	var out outgoing
+
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		return outgoing{}, badRequest("chat_id is empty")
+	}
+	out.chatID = chatID
+	out.parseMode = r.FormValue("parse_mode")
+
+	if v := r.FormValue("reply_to_message_id"); v != "" {
+		if out.replyTo, err = strconv.Atoi(v); err != nil {
+			return outgoing{}, badRequest("invalid reply_to_message_id")
+		}
+	}
+	out.withoutReply = r.FormValue("allow_sending_without_reply") == "true"
+
+	if v := r.FormValue("reply_markup"); v != "" {
+		var markup struct {
+			InlineKeyboard [][]tgbotapi.InlineKeyboardButton `json:"inline_keyboard"`
+		}
+		if err := json.Unmarshal([]byte(v), &markup); err != nil {
+			return outgoing{}, badRequest("can't parse reply keyboard markup JSON object")
+		}
+		if markup.InlineKeyboard != nil {
+			if apiErr := validateKeyboard(markup.InlineKeyboard); apiErr != nil {
+				return outgoing{}, apiErr
+			}
+			out.markup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: markup.InlineKeyboard}
+		}
+	}
+
+	return out, nil
+}
+
+func validateKeyboard(rows [][]tgbotapi.InlineKeyboardButton) *apiError {
+	for _, row := range rows {
+		for _, button := range row {
+			if button.Text == "" {
+				return badRequest("text buttons are unallowed in the inline keyboard")
+			}
+			if button.CallbackData != nil && len(*button.CallbackData) > maxCallbackDataLength {
+				return badRequest("BUTTON_DATA_INVALID")
+			}
+		}
+	}
+
+	return nil
+}
+
+// send stores a message sent by the bot, it must be called with s.mu held.
+func (s *Server) send(out outgoing, msg tgbotapi.Message) (tgbotapi.Message, *apiError) {
+	c, ok := s.chats[out.chatID]
+	if !ok {
+		return tgbotapi.Message{}, badRequest("chat not found")
+	}
+
+	if out.replyTo != 0 {
+		replyTo, ok := c.replyTo(out.replyTo)
+		switch {
+		case ok:
+			msg.ReplyToMessage = replyTo
+		case !out.withoutReply:
+			return tgbotapi.Message{}, badRequest("message to be replied not found")
+		}
+	}
+
+	bot := s.bot
+	msg.From = &bot
+	msg.ReplyMarkup = out.markup
+	msg = s.store(c, msg)
+	s.sent = append(s.sent, msg)
+	s.notify()
+
+	return msg, nil
+}
+
+func (s *Server) handleSendMessage(r *http.Request) (interface{}, *apiError) {
+	out, apiErr := parseOutgoing(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	text := r.FormValue("text")
+	switch {
+	case text == "":
+		return nil, badRequest("message text is empty")
+	case utf8.RuneCountInString(text) > maxTextLength:
+		return nil, badRequest("message is too long")
+	}
+	if apiErr := checkParseMode(out.parseMode, text); apiErr != nil {
+		return nil, apiErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, apiErr := s.send(out, tgbotapi.Message{Text: text})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	return msg, nil
+}
+
+func (s *Server) handleSendDocument(r *http.Request) (interface{}, *apiError) {
+	out, apiErr := parseOutgoing(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	caption := r.FormValue("caption")
+	if utf8.RuneCountInString(caption) > maxCaptionLength {
+		return nil, badRequest("message caption is too long")
+	}
+	if apiErr := checkParseMode(out.parseMode, caption); apiErr != nil {
+		return nil, apiErr
+	}
+
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		return nil, badRequest("there is no document in the request")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, badRequest("could not read the document: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, badRequest("file must be non-empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fileID := "file-" + strconv.Itoa(len(s.files)+1)
+	msg, apiErr := s.send(out, tgbotapi.Message{
+		Caption: caption,
+		Document: &tgbotapi.Document{
+			FileID:       fileID,
+			FileUniqueID: fileID,
+			FileName:     header.Filename,
+			FileSize:     len(data),
+		},
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	s.files[fileID] = data
+
+	return msg, nil
+}
+
+func (s *Server) handleAnswerCallbackQuery(r *http.Request) (interface{}, *apiError) {
+	answer := CallbackAnswer{
+		CallbackQueryID: r.FormValue("callback_query_id"),
+		Text:            r.FormValue("text"),
+		ShowAlert:       r.FormValue("show_alert") == "true",
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.callbacks[answer.CallbackQueryID] {
+		return nil, badRequest(
+			"query is too old and response timeout expired or query ID is invalid",
+		)
+	}
+	delete(s.callbacks, answer.CallbackQueryID)
+	s.answers = append(s.answers, answer)
+	s.notify()
+
+	return true, nil
+}
+
+func (s *Server) handleSetMyCommands(r *http.Request) (interface{}, *apiError) {
+	var commands []tgbotapi.BotCommand
+	if err := json.Unmarshal([]byte(r.FormValue("commands")), &commands); err != nil {
+		return nil, badRequest("can't parse commands JSON object")
+	}
+	if len(commands) > maxCommands {
+		return nil, badRequest("too many commands specified")
+	}
+	for _, c := range commands {
+		if !commandRe.MatchString(c.Command) {
+			return nil, badRequest("BOT_COMMAND_INVALID")
+		}
+		if n := utf8.RuneCountInString(c.Description); n == 0 || n > 256 {
+			return nil, badRequest("command description is empty or too long")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = commands
+	s.notify()
+
+	return true, nil
+}
+
+func (s *Server) handleGetMyCommands(*http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]tgbotapi.BotCommand{}, s.commands...), nil
+}
@@ -0,0 +1,322 @@
+// Package telegramtest provides a fake Telegram Bot API server to test the bot
+// end to end with the real Bot API client.
+//
+// The server keeps the chats in memory. Users act through the server: their
+// messages and button presses are queued as updates and served by getUpdates,
+// while the messages sent by the bot are stored in the chats and recorded, so
+// that tests can wait for them and reply to them. When the bot sets a
+// webhook, the updates are posted to it instead. The methods the bot relies
+// on are validated the way Telegram does: messages to unknown chats, replies
+// to missing messages, malformed HTML and stale callback queries are rejected.
+package telegramtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Token is the only bot token accepted by the server.
+const Token = "123456:test-token"
+
+// Request is a Bot API request received by the server.
+type Request struct {
+	Method string
+	Params url.Values
+	// Files are the names of the uploaded files by the form field.
+	Files map[string]string
+}
+
+// CallbackAnswer is an answer to a callback query.
+type CallbackAnswer struct {
+	CallbackQueryID string
+	Text            string
+	ShowAlert       bool
+}
+
+type chat struct {
+	info          tgbotapi.Chat
+	messages      []tgbotapi.Message
+	lastMessageID int
+}
+
+// Server is a fake Telegram Bot API server.
+type Server struct {
+	server  *httptest.Server
+	closed  chan struct{}
+	bot     tgbotapi.User
+	methods map[string]method
+	// ctx is cancelled on Close to stop the delivery to the webhook.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// changed is closed and replaced whenever the state changes.
+	changed      chan struct{}
+	chats        map[int64]*chat
+	updates      []tgbotapi.Update
+	lastUpdateID int
+	sent         []tgbotapi.Message
+	files        map[string][]byte
+	callbacks    map[string]bool
+	lastCallback int
+	answers      []CallbackAnswer
+	commands     []tgbotapi.BotCommand
+	webhook      *webhook
+	requests     []Request
+	now          func() time.Time
+}
+
+// NewServer starts a server without chats. It must be closed by Close.
+func NewServer() *Server {
+	s := &Server{
+		closed:    make(chan struct{}),
+		bot:       tgbotapi.User{ID: 1000, IsBot: true, FirstName: "Test", UserName: "test_bot"},
+		changed:   make(chan struct{}),
+		chats:     make(map[int64]*chat),
+		files:     make(map[string][]byte),
+		callbacks: make(map[string]bool),
+		now:       time.Now,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.methods = map[string]method{
+		"getMe":               s.handleGetMe,
+		"getUpdates":          s.handleGetUpdates,
+		"sendMessage":         s.handleSendMessage,
+		"sendDocument":        s.handleSendDocument,
+		"answerCallbackQuery": s.handleAnswerCallbackQuery,
+		"setMyCommands":       s.handleSetMyCommands,
+		"getMyCommands":       s.handleGetMyCommands,
+		"setWebhook":          s.handleSetWebhook,
+		"deleteWebhook":       s.handleDeleteWebhook,
+		"getWebhookInfo":      s.handleGetWebhookInfo,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Endpoint returns the API endpoint to be passed to
+// tgbotapi.NewBotAPIWithAPIEndpoint.
+func (s *Server) Endpoint() string {
+	return s.server.URL + "/bot%s/%s"
+}
+
+// NewBotAPI creates a Bot API client talking to the server.
+func (s *Server) NewBotAPI() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(Token, s.Endpoint())
+}
+
+// Close aborts pending getUpdates requests, stops posting updates to the
+// webhook and shuts the server down.
+func (s *Server) Close() {
+	s.cancel()
+	close(s.closed)
+	s.server.Close()
+}
+
+// Bot returns the user of the bot.
+func (s *Server) Bot() tgbotapi.User {
+	return s.bot
+}
+
+// SetNow replaces the clock used for message dates.
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// Messages returns the messages of the chat sent by both users and the bot.
+func (s *Server) Messages(chatID int64) []tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.chats[chatID]; ok {
+		return slices.Clone(c.messages)
+	}
+
+	return nil
+}
+
+// Sent returns the messages sent by the bot to all chats.
+func (s *Server) Sent() []tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.sent)
+}
+
+// File returns the content of a file uploaded by the bot.
+func (s *Server) File(fileID string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[fileID]
+
+	return data, ok
+}
+
+// CallbackAnswers returns the answers to callback queries.
+func (s *Server) CallbackAnswers() []CallbackAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.answers)
+}
+
+// Commands returns the commands set by setMyCommands.
+func (s *Server) Commands() []tgbotapi.BotCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.commands)
+}
+
+// Requests returns the requests received so far, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// WaitSent waits until the bot has sent at least n messages and returns all of
+// them.
+func (s *Server) WaitSent(ctx context.Context, n int) ([]tgbotapi.Message, error) {
+	err := s.wait(ctx, func() bool { return len(s.sent) >= n })
+	if err != nil {
+		return nil, fmt.Errorf("wait for %d messages, got %d: %w", n, len(s.Sent()), err)
+	}
+
+	return s.Sent(), nil
+}
+
+// WaitAnswered waits for the answer to the callback query.
+func (s *Server) WaitAnswered(ctx context.Context, callbackID string) (CallbackAnswer, error) {
+	var answer CallbackAnswer
+
+	err := s.wait(ctx, func() bool {
+		for _, a := range s.answers {
+			if a.CallbackQueryID == callbackID {
+				answer = a
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return CallbackAnswer{}, fmt.Errorf("wait for answer to %s: %w", callbackID, err)
+	}
+
+	return answer, nil
+}
+
+// wait waits until cond holds. cond is called with s.mu held.
+func (s *Server) wait(ctx context.Context, cond func() bool) error {
+	for {
+		s.mu.Lock()
+		ok, changed := cond(), s.changed
+		s.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.closed:
+			return errServerClosed
+		}
+	}
+}
+
+// notify wakes up the waiters, it must be called with s.mu held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeError(w, &apiError{http.StatusUnauthorized, "Unauthorized"})
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil &&
+		err != http.ErrNotMultipart {
+		writeError(w, badRequest("invalid form: %v", err))
+		return
+	}
+
+	req := Request{Method: name, Params: r.Form}
+	if r.MultipartForm != nil {
+		req.Files = make(map[string]string)
+		for field, headers := range r.MultipartForm.File {
+			req.Files[field] = headers[0].Filename
+		}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	handle, ok := s.methods[name]
+	if !ok {
+		writeError(w, &apiError{http.StatusNotFound, "Not Found: method not found"})
+		return
+	}
+
+	result, apiErr := handle(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "result": result})
+}
+
+const maxUploadSize = 50 << 20
+
+// method handles a Bot API method and returns its result.
+type method func(*http.Request) (interface{}, *apiError)
+
+type apiError struct {
+	Code        int
+	Description string
+}
+
+var errServerClosed = errors.New("server is closed")
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "Bad Request: " + fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck,gosec // the client is gone
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.Code, map[string]interface{}{
+		"ok":          false,
+		"error_code":  err.Code,
+		"description": err.Description,
+	})
+}
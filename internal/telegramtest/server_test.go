@@ -0,0 +1,333 @@
+package telegramtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/telegramtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	testChat = &tgbotapi.Chat{ID: -1001234567890, Type: "supergroup"}
+	testUser = &tgbotapi.User{ID: 1, UserName: "gibsn"}
+)
+
+func newTestServer(t *testing.T) (*telegramtest.Server, *tgbotapi.BotAPI) {
+	t.Helper()
+
+	s := telegramtest.NewServer()
+	t.Cleanup(s.Close)
+
+	bot, err := s.NewBotAPI()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return s, bot
+}
+
+func sendMessage(t *testing.T, s *telegramtest.Server, text string, replyTo int) tgbotapi.Message {
+	t.Helper()
+
+	msg := tgbotapi.Message{From: testUser, Chat: testChat, Text: text}
+	if replyTo != 0 {
+		msg.ReplyToMessage = &tgbotapi.Message{MessageID: replyTo}
+	}
+
+	sent, err := s.SendMessage(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return sent
+}
+
+func getUpdates(t *testing.T, bot *tgbotapi.BotAPI, offset int) []tgbotapi.Update {
+	t.Helper()
+
+	updates, err := bot.GetUpdates(tgbotapi.UpdateConfig{Offset: offset})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return updates
+}
+
+func TestConversation(t *testing.T) {
+	s, bot := newTestServer(t)
+
+	if bot.Self.UserName != "test_bot" {
+		t.Errorf("unexpected bot %+v", bot.Self)
+	}
+
+	original := sendMessage(t, s, "Too wet", 0)
+	command := sendMessage(t, s, "/tweak@test_bot", original.MessageID)
+
+	updates := getUpdates(t, bot, 0)
+	if len(updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(updates))
+	}
+	received := updates[1].Message
+	if received.Command() != "tweak" || received.ReplyToMessage.Text != "Too wet" {
+		t.Errorf("unexpected message %+v", received)
+	}
+
+	msg := tgbotapi.NewMessage(testChat.ID, "<b>Choose</b> an action:")
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = command.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Mix", "tweak:mix"),
+	))
+	menu, err := bot.Send(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := telegramtest.Buttons(menu); !slices.Equal(got, []string{"Mix"}) {
+		t.Errorf("unexpected buttons %v", got)
+	}
+
+	if _, err := s.PressButton(testUser, menu, "Demo"); err == nil {
+		t.Error("expected error pressing a missing button")
+	}
+	callbackID, err := s.PressButton(testUser, menu, "Mix")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updates = getUpdates(t, bot, updates[1].UpdateID+1)
+	if len(updates) != 1 || updates[0].CallbackQuery == nil {
+		t.Fatalf("unexpected updates %+v", updates)
+	}
+	callback := updates[0].CallbackQuery
+	if callback.Data != "tweak:mix" || callback.Message.ReplyToMessage.MessageID != command.MessageID {
+		t.Errorf("unexpected callback %+v", callback)
+	}
+	if callback.Message.ReplyToMessage.ReplyToMessage != nil {
+		t.Error("replied message should not contain further replies")
+	}
+
+	if _, err := bot.Request(tgbotapi.NewCallback(callback.ID, "")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := bot.Request(tgbotapi.NewCallback(callback.ID, "")); err == nil {
+		t.Error("expected error answering a callback twice")
+	}
+	answer, err := s.WaitAnswered(context.Background(), callbackID)
+	if err != nil || answer.CallbackQueryID != callback.ID {
+		t.Errorf("unexpected answer %+v, %v", answer, err)
+	}
+
+	if got := len(s.Messages(testChat.ID)); got != 3 {
+		t.Errorf("expected 3 messages in the chat, got %d", got)
+	}
+	if updates = getUpdates(t, bot, updates[0].UpdateID+1); len(updates) != 0 {
+		t.Errorf("unexpected updates %+v", updates)
+	}
+}
+
+func TestRejectsInvalidRequests(t *testing.T) {
+	s, bot := newTestServer(t)
+	command := sendMessage(t, s, "/tasks", 0)
+
+	tests := []struct {
+		name string
+		msg  tgbotapi.MessageConfig
+		err  string
+	}{
+		{
+			name: "unknown chat",
+			msg:  tgbotapi.NewMessage(42, "hi"),
+			err:  "chat not found",
+		},
+		{
+			name: "missing reply",
+			msg: func() tgbotapi.MessageConfig {
+				msg := tgbotapi.NewMessage(testChat.ID, "hi")
+				msg.ReplyToMessageID = command.MessageID + 10
+				return msg
+			}(),
+			err: "message to be replied not found",
+		},
+		{
+			name: "unescaped html",
+			msg: func() tgbotapi.MessageConfig {
+				msg := tgbotapi.NewMessage(testChat.ID, "a <b> b")
+				msg.ParseMode = tgbotapi.ModeHTML
+				return msg
+			}(),
+			err: "can't parse entities",
+		},
+		{
+			name: "long callback data",
+			msg: func() tgbotapi.MessageConfig {
+				msg := tgbotapi.NewMessage(testChat.ID, "hi")
+				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("x", strings.Repeat("x", 65)),
+				))
+				return msg
+			}(),
+			err: "BUTTON_DATA_INVALID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bot.Send(tt.msg)
+			var tgErr *tgbotapi.Error
+			if !errors.As(err, &tgErr) || !strings.Contains(tgErr.Message, tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if got := len(s.Sent()); got != 0 {
+		t.Errorf("expected nothing to be sent, got %d messages", got)
+	}
+
+	msg := tgbotapi.NewMessage(testChat.ID, "hi")
+	msg.ReplyToMessageID = command.MessageID + 10
+	msg.AllowSendingWithoutReply = true
+	sent, err := bot.Send(msg)
+	if err != nil || sent.ReplyToMessage != nil {
+		t.Errorf("expected the message to be sent without reply, got %+v, %v", sent, err)
+	}
+}
+
+func TestSendDocument(t *testing.T) {
+	s, bot := newTestServer(t)
+	command := sendMessage(t, s, "/tweak render Song 3", 0)
+
+	doc := tgbotapi.NewDocument(testChat.ID, tgbotapi.FileBytes{
+		Name: "fixes.pdf", Bytes: []byte("%PDF-1.4"),
+	})
+	doc.Caption = "Generated <b>2</b> tweaks"
+	doc.ParseMode = tgbotapi.ModeHTML
+	doc.ReplyToMessageID = command.MessageID
+	if _, err := bot.Send(doc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sent := s.Sent()
+	if len(sent) != 1 || sent[0].Document == nil {
+		t.Fatalf("unexpected messages %+v", sent)
+	}
+	if sent[0].Document.FileName != "fixes.pdf" || sent[0].Caption != doc.Caption {
+		t.Errorf("unexpected document %+v", sent[0])
+	}
+	if data, _ := s.File(sent[0].Document.FileID); string(data) != "%PDF-1.4" {
+		t.Errorf("unexpected file content %q", data)
+	}
+}
+
+func TestSetMyCommands(t *testing.T) {
+	s, bot := newTestServer(t)
+
+	commands := []tgbotapi.BotCommand{{Command: "task", Description: "Create a task"}}
+	if _, err := bot.Request(tgbotapi.NewSetMyCommands(commands...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.Commands(); !slices.Equal(got, commands) {
+		t.Errorf("unexpected commands %+v", got)
+	}
+
+	invalid := tgbotapi.BotCommand{Command: "Task", Description: "Create a task"}
+	if _, err := bot.Request(tgbotapi.NewSetMyCommands(invalid)); err == nil {
+		t.Error("expected error for an invalid command")
+	}
+}
+
+func TestLongPolling(t *testing.T) {
+	s, bot := newTestServer(t)
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates := bot.GetUpdatesChan(u)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, text := range []string{"first", "second"} {
+		sendMessage(t, s, text, 0)
+
+		select {
+		case update := <-updates:
+			if update.Message.Text != text {
+				t.Errorf("expected %q, got %q", text, update.Message.Text)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", text)
+		}
+	}
+
+	bot.StopReceivingUpdates()
+}
+
+func TestWebhook(t *testing.T) {
+	s, bot := newTestServer(t)
+
+	received := make(chan tgbotapi.Update, 1)
+	failures := 1
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- update
+	}))
+	defer receiver.Close()
+
+	_, err := bot.MakeRequest("setWebhook", tgbotapi.Params{
+		"url": receiver.URL, "secret_token": "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := bot.GetUpdates(tgbotapi.UpdateConfig{}); err == nil {
+		t.Error("expected getUpdates to conflict with the webhook")
+	}
+
+	sendMessage(t, s, "/tasks", 0)
+	select {
+	case update := <-received:
+		if update.Message.Text != "/tasks" {
+			t.Errorf("unexpected update %+v", update)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the update to be posted")
+	}
+
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.URL != receiver.URL || !strings.Contains(info.LastErrorMessage, "503") {
+		t.Errorf("unexpected webhook info %+v", info)
+	}
+
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sendMessage(t, s, "/tracks", 0)
+	if updates := getUpdates(t, bot, 0); len(updates) != 1 || updates[0].Message.Text != "/tracks" {
+		t.Errorf("unexpected updates %+v", updates)
+	}
+}
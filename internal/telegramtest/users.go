@@ -0,0 +1,183 @@
+package telegramtest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SendMessage sends a message from a user to the bot. From, Chat and Text or
+// Caption must be set. If ReplyToMessage is set, only its MessageID is used
+// to find the message in the chat. The message ID, the date and the entities
+// of a leading bot command are filled in. The returned message is the one
+// the bot will receive.
+func (s *Server) SendMessage(msg tgbotapi.Message) (tgbotapi.Message, error) {
+	if msg.From == nil || msg.Chat == nil {
+		return tgbotapi.Message{}, errors.New("message has no sender or chat")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.chats[msg.Chat.ID]
+	if !ok {
+		c = &chat{info: *msg.Chat}
+		s.chats[msg.Chat.ID] = c
+	}
+
+	if msg.ReplyToMessage != nil {
+		replyTo, ok := c.replyTo(msg.ReplyToMessage.MessageID)
+		if !ok {
+			return tgbotapi.Message{}, fmt.Errorf(
+				"message %d is not found in chat %d", msg.ReplyToMessage.MessageID, msg.Chat.ID,
+			)
+		}
+		msg.ReplyToMessage = replyTo
+	}
+	if msg.Entities == nil {
+		msg.Entities = commandEntities(msg.Text)
+	}
+
+	msg = s.store(c, msg)
+	s.push(tgbotapi.Update{Message: &msg})
+
+	return msg, nil
+}
+
+// PressButton presses the inline keyboard button with the given text under a
+// message of the bot and returns the ID of the callback query.
+func (s *Server) PressButton(
+	from *tgbotapi.User, msg tgbotapi.Message, text string,
+) (string, error) {
+	if msg.Chat == nil {
+		return "", errors.New("message has no chat")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.chats[msg.Chat.ID]
+	if !ok {
+		return "", fmt.Errorf("chat %d is not found", msg.Chat.ID)
+	}
+
+	stored, ok := c.message(msg.MessageID)
+	if !ok {
+		return "", fmt.Errorf("message %d is not found in chat %d", msg.MessageID, msg.Chat.ID)
+	}
+
+	data, ok := buttonData(stored.ReplyMarkup, text)
+	if !ok {
+		return "", fmt.Errorf("message %d has no button %q", msg.MessageID, text)
+	}
+
+	s.lastCallback++
+	id := strconv.Itoa(s.lastCallback)
+	s.callbacks[id] = true
+	s.push(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:           id,
+		From:         from,
+		Message:      &stored,
+		ChatInstance: strconv.FormatInt(msg.Chat.ID, 10),
+		Data:         data,
+	}})
+
+	return id, nil
+}
+
+// Buttons returns the texts of the inline keyboard buttons of a message.
+func Buttons(msg tgbotapi.Message) []string {
+	if msg.ReplyMarkup == nil {
+		return nil
+	}
+
+	var texts []string
+	for _, row := range msg.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			texts = append(texts, button.Text)
+		}
+	}
+
+	return texts
+}
+
+func buttonData(markup *tgbotapi.InlineKeyboardMarkup, text string) (string, bool) {
+	if markup == nil {
+		return "", false
+	}
+
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == text && button.CallbackData != nil {
+				return *button.CallbackData, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// commandEntities returns the bot_command entity of a message starting with a
+// command. Offsets are in UTF-16 code units as in Telegram.
+func commandEntities(text string) []tgbotapi.MessageEntity {
+	if !strings.HasPrefix(text, "/") {
+		return nil
+	}
+
+	command, _, _ := strings.Cut(text, " ")
+	command, _, _ = strings.Cut(command, "\n")
+
+	return []tgbotapi.MessageEntity{{
+		Type:   "bot_command",
+		Offset: 0,
+		Length: len(utf16.Encode([]rune(command))),
+	}}
+}
+
+// push queues an update, it must be called with s.mu held.
+func (s *Server) push(update tgbotapi.Update) {
+	s.lastUpdateID++
+	update.UpdateID = s.lastUpdateID
+	s.updates = append(s.updates, update)
+	s.notify()
+}
+
+// store assigns the message an ID and adds it to the chat, it must be called
+// with s.mu held.
+func (s *Server) store(c *chat, msg tgbotapi.Message) tgbotapi.Message {
+	c.lastMessageID++
+	info := c.info
+	msg.MessageID = c.lastMessageID
+	msg.Chat = &info
+	msg.Date = int(s.now().Unix())
+	c.messages = append(c.messages, msg)
+
+	return msg
+}
+
+// message returns a copy of the message with the given ID.
+func (c *chat) message(id int) (tgbotapi.Message, bool) {
+	for _, msg := range c.messages {
+		if msg.MessageID == id {
+			return msg, true
+		}
+	}
+
+	return tgbotapi.Message{}, false
+}
+
+// replyTo returns the message to be set as the ReplyToMessage of a reply. Like
+// in Telegram, it does not contain further replies.
+func (c *chat) replyTo(id int) (*tgbotapi.Message, bool) {
+	msg, ok := c.message(id)
+	if !ok {
+		return nil, false
+	}
+	msg.ReplyToMessage = nil
+
+	return &msg, true
+}
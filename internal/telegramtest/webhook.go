@@ -0,0 +1,209 @@
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	secretTokenHeader  = "X-Telegram-Bot-Api-Secret-Token"
+	webhookTimeout     = 10 * time.Second
+	webhookRetryDelay  = 10 * time.Millisecond
+	maxCertificateSize = 1 << 20
+)
+
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhook is a webhook set by the bot. While it is set, updates are posted to
+// it one by one in order instead of being served by getUpdates. Unlike
+// Telegram, the server accepts plain HTTP URLs, so that tests do not need
+// certificates.
+type webhook struct {
+	url         string
+	secretToken string
+	client      *http.Client
+	customCert  bool
+	cancel      context.CancelFunc
+
+	lastErrorDate    int
+	lastErrorMessage string
+}
+
+func (s *Server) handleSetWebhook(r *http.Request) (interface{}, *apiError) {
+	rawURL := r.FormValue("url")
+	if rawURL == "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.deleteWebhook()
+		return true, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, badRequest("bad webhook: invalid webhook URL specified")
+	}
+
+	secretToken := r.FormValue("secret_token")
+	if secretToken != "" && !secretTokenRe.MatchString(secretToken) {
+		return nil, badRequest("secret token contains unallowed characters")
+	}
+
+	wh := &webhook{url: rawURL, secretToken: secretToken}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if file, _, fileErr := r.FormFile("certificate"); fileErr == nil {
+		defer file.Close()
+
+		pem, readErr := io.ReadAll(io.LimitReader(file, maxCertificateSize))
+		pool := x509.NewCertPool()
+		if readErr != nil || !pool.AppendCertsFromPEM(pem) {
+			return nil, badRequest("bad webhook: Failed to set custom certificate file")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		wh.customCert = true
+	}
+	wh.client = &http.Client{Timeout: webhookTimeout, Transport: transport}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteWebhook()
+
+	var ctx context.Context
+	ctx, wh.cancel = context.WithCancel(s.ctx)
+	s.webhook = wh
+	go s.deliver(ctx, wh)
+
+	return true, nil
+}
+
+func (s *Server) handleDeleteWebhook(r *http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteWebhook()
+	if r.FormValue("drop_pending_updates") == "true" {
+		s.updates = nil
+	}
+
+	return true, nil
+}
+
+func (s *Server) handleGetWebhookInfo(*http.Request) (interface{}, *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := tgbotapi.WebhookInfo{PendingUpdateCount: len(s.updates)}
+	if wh := s.webhook; wh != nil {
+		info.URL = wh.url
+		info.HasCustomCertificate = wh.customCert
+		info.LastErrorDate = wh.lastErrorDate
+		info.LastErrorMessage = wh.lastErrorMessage
+	}
+
+	return info, nil
+}
+
+// deleteWebhook stops the delivery to the current webhook, it must be called
+// with s.mu held.
+func (s *Server) deleteWebhook() {
+	if s.webhook == nil {
+		return
+	}
+
+	s.webhook.cancel()
+	s.webhook = nil
+	s.notify()
+}
+
+// deliver posts the queued updates to the webhook until it is deleted. An
+// update is removed from the queue once the webhook responds with 2xx,
+// otherwise it is posted again.
+func (s *Server) deliver(ctx context.Context, wh *webhook) {
+	for {
+		s.mu.Lock()
+		var (
+			update  tgbotapi.Update
+			pending = len(s.updates) > 0
+			changed = s.changed
+		)
+		if pending {
+			update = s.updates[0]
+		}
+		s.mu.Unlock()
+
+		if !pending {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err := s.post(ctx, wh, update)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if err == nil {
+			if len(s.updates) > 0 && s.updates[0].UpdateID == update.UpdateID {
+				s.updates = s.updates[1:]
+			}
+		} else {
+			wh.lastErrorDate = int(s.now().Unix())
+			wh.lastErrorMessage = err.Error()
+		}
+		s.notify()
+		s.mu.Unlock()
+
+		if err != nil {
+			select {
+			case <-time.After(webhookRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) post(ctx context.Context, wh *webhook, update tgbotapi.Update) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("encode update: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.secretToken != "" {
+		req.Header.Set(secretTokenHeader, wh.secretToken)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck,gosec // only the status matters
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("wrong response from the webhook: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -1,19 +1,33 @@
 package trackscache
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+	"github.com/gibsn/telegram_to_notion/internal/metrics"
 	"github.com/gibsn/telegram_to_notion/internal/notion"
 )
 
-type Cache struct {
-	debug bool
+var logger = logging.For("cache").With("cache", "tracks")
+
+var (
+	cacheRefreshes = metrics.NewCounterVec(
+		"cache_refreshes_total", "Loads of the caches by result, ok or error.", "cache", "result",
+	)
+	cacheAge = metrics.NewGaugeVec(
+		"cache_age_seconds",
+		"Time since the last successful load of the cache, +Inf before the first one.",
+		"cache",
+	)
+)
 
+type Cache struct {
 	notion *notion.Notion
 	dbID   string
 
@@ -21,6 +35,9 @@ type Cache struct {
 
 	cacheLock sync.RWMutex
 	cache     map[string]string // track title -> track ID
+	// refreshed is the time of the last successful load, zero until the
+	// first one.
+	refreshed time.Time
 }
 
 func NewTracksCache(
@@ -33,30 +50,37 @@ func NewTracksCache(
 		period: period,
 	}
 
+	cacheAge.SetFunc(c.ageSeconds, "tracks")
+
 	return c
 }
 
-func (c *Cache) RefreshPeriodically() {
+// RefreshPeriodically reloads the cache every period until the context is
+// cancelled. A single load may take no longer than the period itself.
+func (c *Cache) RefreshPeriodically(ctx context.Context) {
 	ticker := time.NewTicker(c.period)
 	defer ticker.Stop()
 
 	for {
-		log.Printf("Will load tracks now")
+		loadCtx, cancel := context.WithTimeout(ctx, c.period)
+		loadCtx = logging.WithRequestID(loadCtx, logging.NewRequestID())
+		logger.InfoContext(loadCtx, "Loading tracks")
 
-		tracks, err := c.notion.LoadTracks(c.dbID)
+		tracks, err := c.notion.LoadTracksContext(loadCtx, c.dbID)
+		cancel()
 		if err != nil {
-			log.Printf("Could not load tracks: %v", err)
+			logger.ErrorContext(loadCtx, "Could not load tracks", "error", err)
+		} else {
+			logger.InfoContext(loadCtx, "Tracks loaded", "count", len(tracks), "next_refresh", c.period)
 		}
 
-		log.Printf("%d tracks loaded, next refresh in %s", len(tracks), c.period)
+		c.update(tracks, err)
 
-		if tracks != nil {
-			c.cacheLock.Lock()
-			c.cache = tracks
-			c.cacheLock.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-
-		<-ticker.C
 	}
 }
 
@@ -114,23 +138,60 @@ func (c *Cache) GetTrackNames() []string {
 	return names
 }
 
-func (c *Cache) RefreshCache() error {
-	log.Printf("Refreshing tracks cache")
+func (c *Cache) RefreshCache(ctx context.Context) error {
+	logger.InfoContext(ctx, "Refreshing cache")
 
-	tracks, err := c.notion.LoadTracks(c.dbID)
+	tracks, err := c.notion.LoadTracksContext(ctx, c.dbID)
 	if err != nil {
+		c.update(nil, err)
 		return fmt.Errorf("could not load tracks: %w", err)
 	}
+	c.update(tracks, nil)
+
+	logger.InfoContext(ctx, "Tracks loaded", "count", len(tracks))
 
-	log.Printf("%d tracks loaded", len(tracks))
+	return nil
+}
+
+// update stores the tracks unless nil, the ones loaded before an error are kept
+// as well, but only a load without errors makes the cache ready.
+func (c *Cache) update(tracks map[string]string, err error) {
+	if err != nil {
+		cacheRefreshes.Inc("tracks", "error")
+	} else {
+		cacheRefreshes.Inc("tracks", "ok")
+	}
+
+	if tracks == nil && err != nil {
+		return
+	}
 
 	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
 	c.cache = tracks
-	c.cacheLock.Unlock()
+	if err == nil {
+		c.refreshed = time.Now()
+	}
+}
 
-	return nil
+// Ready tells whether the tracks have been loaded at least once.
+func (c *Cache) Ready() bool {
+	c.cacheLock.RLock()
+	defer c.cacheLock.RUnlock()
+
+	return !c.refreshed.IsZero()
 }
 
-func (c *Cache) SetDebug(debug bool) {
-	c.debug = debug
+// ageSeconds returns the time since the last successful load, infinite before
+// the first one.
+func (c *Cache) ageSeconds() float64 {
+	c.cacheLock.RLock()
+	defer c.cacheLock.RUnlock()
+
+	if c.refreshed.IsZero() {
+		return math.Inf(1)
+	}
+
+	return time.Since(c.refreshed).Seconds()
 }
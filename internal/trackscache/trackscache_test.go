@@ -1,10 +1,17 @@
 package trackscache
 
 import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gibsn/telegram_to_notion/internal/notion"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetTrackID(t *testing.T) {
@@ -156,3 +163,65 @@ func TestNewTracksCache(t *testing.T) {
 	assert.Equal(t, 5*time.Minute, cache.period)
 	assert.Nil(t, cache.notion)
 }
+
+func TestRefreshPeriodicallyStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{"id":"id-1","properties":{` + //nolint:errcheck
+			`"Название":{"title":[{"plain_text":"Song One"}]}}}]}`))
+	}))
+	defer server.Close()
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	cache := NewTracksCache(n, "tracks-db", time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.RefreshPeriodically(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, ok := cache.GetTrackID("Song One")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RefreshPeriodically did not return after the context was cancelled")
+	}
+}
+
+func TestReadyAfterFirstLoad(t *testing.T) {
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[]}`)) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	n := notion.NewNotion("test-token")
+	n.SetAPIBaseURL(server.URL + "/")
+	cache := NewTracksCache(n, "tracks-db", time.Hour)
+	failed, succeeded := cacheRefreshes.Value("tracks", "error"), cacheRefreshes.Value("tracks", "ok")
+
+	require.Error(t, cache.RefreshCache(context.Background()))
+	assert.False(t, cache.Ready())
+	assert.True(t, math.IsInf(cacheAge.Value("tracks"), 1))
+
+	failing = false
+	require.NoError(t, cache.RefreshCache(context.Background()))
+	assert.True(t, cache.Ready())
+	assert.Less(t, cacheAge.Value("tracks"), 1.0)
+	assert.Equal(t, failed+1, cacheRefreshes.Value("tracks", "error"))
+	assert.Equal(t, succeeded+1, cacheRefreshes.Value("tracks", "ok"))
+}
@@ -0,0 +1,345 @@
+// Package users links Telegram accounts to Notion users.
+package users
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gibsn/telegram_to_notion/internal/store"
+)
+
+// UserLink links a Telegram account to a Notion user.
+type UserLink struct {
+	// TelegramID is zero for links declared in the config, it is filled in
+	// when the user with TelegramName writes to the bot for the first time.
+	TelegramID int64 `json:"telegram_id,omitempty"`
+	// TelegramName is the last known username without @, it is kept up to
+	// date since the user may change it.
+	TelegramName string `json:"telegram_name,omitempty"`
+	NotionID     string `json:"notion_id"`
+	NotionName   string `json:"notion_name,omitempty"`
+}
+
+// userLinksBucket is the bucket of the store holding the links under
+// userLinksKey.
+const (
+	userLinksBucket = "user_links"
+	userLinksKey    = "links"
+)
+
+// ErrNotionUserLinked and ErrNotionUserDeclared are returned by Link for a
+// Notion user linked to another account or declared for another username.
+var (
+	ErrNotionUserLinked   = errors.New("notion user is already linked")
+	ErrNotionUserDeclared = errors.New("notion user is declared for another username")
+)
+
+// UserResolver maps Telegram accounts to Notion users, the links are made by
+// the users themselves with /link or declared in the config. It is safe for
+// concurrent use.
+type UserResolver struct {
+	mu sync.RWMutex
+	// links holds the declared links not claimed yet after the ones of known
+	// accounts, only the latter are saved.
+	links []UserLink
+	saved *store.Bucket[[]UserLink]
+}
+
+func NewUserResolver() *UserResolver {
+	return &UserResolver{
+		saved: store.NewBucket[[]UserLink](store.NewMemoryStore(), userLinksBucket),
+	}
+}
+
+// SetStore loads the links saved to s and makes the resolver save the links
+// to it. The declared links are kept.
+func (r *UserResolver) SetStore(s *store.Store) error {
+	saved := store.NewBucket[[]UserLink](s, userLinksBucket)
+
+	links, _, err := saved.Get(userLinksKey)
+	if err != nil {
+		return fmt.Errorf("could not load user links: %w", err)
+	}
+	for i, link := range links {
+		if link.NotionID == "" || link.TelegramID == 0 {
+			return errors.New("invalid user links: a link needs a Notion ID and a Telegram ID")
+		}
+		links[i].TelegramName = normalizeTgName(link.TelegramName)
+		links[i].NotionID = strings.ToLower(link.NotionID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.saved = saved
+	r.links = mergeDeclared(links, declaredLinks(r.links))
+
+	return nil
+}
+
+// SetDeclaredLinks replaces the links declared in the config. A declared link
+// is claimed by the account with the username on its first message, it is
+// skipped while the Notion user or the username is linked to a known account.
+func (r *UserResolver) SetDeclaredLinks(declared []UserLink) {
+	normalized := make([]UserLink, 0, len(declared))
+	for _, link := range declared {
+		normalized = append(normalized, UserLink{
+			TelegramName: normalizeTgName(link.TelegramName),
+			NotionID:     strings.ToLower(strings.TrimSpace(link.NotionID)),
+			NotionName:   link.NotionName,
+		})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.links = mergeDeclared(knownLinks(r.links), normalized)
+}
+
+func knownLinks(links []UserLink) []UserLink {
+	known := make([]UserLink, 0, len(links))
+	for _, l := range links {
+		if l.TelegramID != 0 {
+			known = append(known, l)
+		}
+	}
+
+	return known
+}
+
+func declaredLinks(links []UserLink) []UserLink {
+	var declared []UserLink
+	for _, l := range links {
+		if l.TelegramID == 0 {
+			declared = append(declared, l)
+		}
+	}
+
+	return declared
+}
+
+func mergeDeclared(known, declared []UserLink) []UserLink {
+	links := append([]UserLink{}, known...)
+	for _, d := range declared {
+		taken := false
+		for _, l := range links {
+			if l.NotionID == d.NotionID || l.TelegramName == d.TelegramName {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			links = append(links, d)
+		}
+	}
+
+	return links
+}
+
+func normalizeTgName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+}
+
+// Link links the Telegram account to the Notion user replacing the previous
+// link of the account. A Notion user can be linked to a single account only,
+// the one linked to another account or declared in the config for another
+// username is refused, see TakeOver.
+func (r *UserResolver) Link(link UserLink) error {
+	return r.link(link, false)
+}
+
+// TakeOver is Link taking the Notion user over from the account or the
+// declared username it is linked to, it is meant for admins.
+func (r *UserResolver) TakeOver(link UserLink) error {
+	return r.link(link, true)
+}
+
+func (r *UserResolver) link(link UserLink, takeOver bool) error {
+	link.TelegramName = normalizeTgName(link.TelegramName)
+	link.NotionID = strings.ToLower(link.NotionID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	links := make([]UserLink, 0, len(r.links)+1)
+	for _, l := range r.links {
+		switch {
+		case l.NotionID == link.NotionID && !takeOver && l.TelegramID != 0 &&
+			l.TelegramID != link.TelegramID:
+			return fmt.Errorf("%w to @%s", ErrNotionUserLinked, l.TelegramName)
+		case l.NotionID == link.NotionID && !takeOver && l.TelegramID == 0 &&
+			l.TelegramName != link.TelegramName:
+			return fmt.Errorf("%w @%s", ErrNotionUserDeclared, l.TelegramName)
+		case l.NotionID == link.NotionID, l.TelegramID == link.TelegramID:
+			continue
+		case link.TelegramName != "" && l.TelegramName == link.TelegramName:
+			// the username has moved to another account
+			l.TelegramName = ""
+		}
+		links = append(links, l)
+	}
+	links = append(links, link)
+
+	return r.update(links)
+}
+
+// Seen is called for every update, it keeps the username of the account up to
+// date and claims the link declared for the username. An error means the
+// change could not be saved and has not been applied.
+func (r *UserResolver) Seen(telegramID int64, telegramName string) error {
+	telegramName = normalizeTgName(telegramName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	own, claimed := -1, -1
+	for i, l := range r.links {
+		switch {
+		case l.TelegramID == telegramID:
+			own = i
+		case l.TelegramID == 0 && telegramName != "" && l.TelegramName == telegramName:
+			claimed = i
+		}
+	}
+
+	var link UserLink
+	switch {
+	case own >= 0 && r.links[own].TelegramName != telegramName:
+		link = r.links[own]
+	case own < 0 && claimed >= 0:
+		link = r.links[claimed]
+	default:
+		return nil
+	}
+
+	links := make([]UserLink, 0, len(r.links))
+	for i, l := range r.links {
+		if i == own || i == claimed {
+			continue
+		}
+		if telegramName != "" && l.TelegramName == telegramName {
+			l.TelegramName = ""
+		}
+		links = append(links, l)
+	}
+	link.TelegramID, link.TelegramName = telegramID, telegramName
+	links = append(links, link)
+
+	if err := r.update(links); err != nil {
+		return fmt.Errorf("could not update the link of Telegram user %d: %w", telegramID, err)
+	}
+
+	return nil
+}
+
+// update saves the links of known accounts, and replaces the current links if
+// saving succeeded. It must be called with mu locked.
+func (r *UserResolver) update(links []UserLink) error {
+	if err := r.saved.Put(userLinksKey, knownLinks(links)); err != nil {
+		return fmt.Errorf("could not save user links: %w", err)
+	}
+	r.links = links
+
+	return nil
+}
+
+// LinkOf returns the link of the Telegram account.
+func (r *UserResolver) LinkOf(telegramID int64) (UserLink, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, l := range r.links {
+		if l.TelegramID == telegramID {
+			return l, true
+		}
+	}
+
+	return UserLink{}, false
+}
+
+// LinkedTo returns the link of the Notion user.
+func (r *UserResolver) LinkedTo(notionID string) (UserLink, bool) {
+	notionID = strings.ToLower(strings.TrimSpace(notionID))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, l := range r.links {
+		if l.NotionID == notionID {
+			return l, true
+		}
+	}
+
+	return UserLink{}, false
+}
+
+// TgIDToNotion returns the Notion user ID of the Telegram account, empty if
+// the account is not linked.
+func (r *UserResolver) TgIDToNotion(telegramID int64) string {
+	link, _ := r.LinkOf(telegramID)
+	return link.NotionID
+}
+
+// TgToNotion resolves a username given as @name, it is used for the assignees
+// mentioned in commands.
+func (r *UserResolver) TgToNotion(tgName string) string {
+	tgName = strings.ToLower(strings.TrimSpace(tgName))
+	if !strings.HasPrefix(tgName, "@") {
+		return ""
+	}
+	tgName = strings.TrimPrefix(tgName, "@")
+	if tgName == "" {
+		return ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, l := range r.links {
+		if l.TelegramName == tgName {
+			return l.NotionID
+		}
+	}
+
+	return ""
+}
+
+// NotionToTg returns the username of the Notion user as @name, empty if the
+// user is not linked or the username is unknown.
+func (r *UserResolver) NotionToTg(notionID string) string {
+	link, ok := r.LinkedTo(notionID)
+	if !ok || link.TelegramName == "" {
+		return ""
+	}
+
+	return "@" + link.TelegramName
+}
+
+func (r *UserResolver) ResolveArr(tgNames []string) ([]string, error) {
+	resolved := make([]string, 0, len(tgNames))
+
+	for _, tgName := range tgNames {
+		resolvedName := r.TgToNotion(tgName)
+		if resolvedName == "" {
+			return nil, fmt.Errorf("login unknown: %s", tgName)
+		}
+
+		resolved = append(resolved, resolvedName)
+	}
+
+	return resolved, nil
+}
+
+// AllNotionUserIDs returns all Notion user IDs known to the resolver.
+func (r *UserResolver) AllNotionUserIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.links))
+	for _, l := range r.links {
+		ids = append(ids, l.NotionID)
+	}
+	return ids
+}
@@ -0,0 +1,146 @@
+package users
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gibsn/telegram_to_notion/internal/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testUserLinks are the links of the team used across the tests.
+func testUserLinks() []UserLink {
+	return []UserLink{
+		{TelegramID: 1, TelegramName: "gibsn", NotionID: "7439e2ca-75f8-4024-b170-620ef7ed08b1"},
+		{TelegramID: 2, TelegramName: "vomadan", NotionID: "0724b18e-320d-4fce-87f6-95d69b51c2c0"},
+		{
+			TelegramID: 3, TelegramName: "alexander_zh",
+			NotionID: "9e8f4963-fd1c-4bb5-bdd2-7f29a9a8698a",
+		},
+	}
+}
+
+func newTestUserResolver(t *testing.T) *UserResolver {
+	t.Helper()
+
+	r := NewUserResolver()
+	for _, link := range testUserLinks() {
+		require.NoError(t, r.Link(link))
+	}
+
+	return r
+}
+
+func TestUserResolverLink(t *testing.T) {
+	r := newTestUserResolver(t)
+
+	assert.Equal(t, "7439e2ca-75f8-4024-b170-620ef7ed08b1", r.TgIDToNotion(1))
+	assert.Equal(t, "7439e2ca-75f8-4024-b170-620ef7ed08b1", r.TgToNotion("@GibSN"))
+	assert.Empty(t, r.TgToNotion("gibsn"))
+	assert.Equal(t, "@vomadan", r.NotionToTg("0724B18E-320D-4FCE-87F6-95D69B51C2C0"))
+
+	// a Notion user belongs to a single account
+	err := r.Link(UserLink{
+		TelegramID: 4, TelegramName: "other", NotionID: testUserLinks()[0].NotionID,
+	})
+	assert.ErrorIs(t, err, ErrNotionUserLinked)
+
+	// relinking replaces the link of the account
+	require.NoError(t, r.Link(UserLink{TelegramID: 1, TelegramName: "gibsn", NotionID: "new-id"}))
+	assert.Equal(t, "new-id", r.TgIDToNotion(1))
+	assert.Empty(t, r.NotionToTg(testUserLinks()[0].NotionID))
+	assert.Len(t, r.AllNotionUserIDs(), 3)
+}
+
+func TestUserResolverFollowsUsername(t *testing.T) {
+	r := newTestUserResolver(t)
+
+	require.NoError(t, r.Seen(1, "Kirill"))
+	assert.Equal(t, "@kirill", r.NotionToTg(testUserLinks()[0].NotionID))
+	assert.Empty(t, r.TgToNotion("@gibsn"))
+
+	// the old username is taken by another account
+	require.NoError(t, r.Seen(2, "kirill"))
+	assert.Equal(t, testUserLinks()[1].NotionID, r.TgToNotion("@kirill"))
+	assert.Empty(t, r.NotionToTg(testUserLinks()[0].NotionID))
+	assert.Equal(t, testUserLinks()[0].NotionID, r.TgIDToNotion(1))
+}
+
+func TestUserResolverStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := store.Open(path)
+	require.NoError(t, err)
+
+	r := NewUserResolver()
+	r.SetDeclaredLinks([]UserLink{{TelegramName: "@Gibsn", NotionID: "notion-1"}})
+	require.NoError(t, r.SetStore(s))
+	assert.Equal(t, "notion-1", r.TgToNotion("@gibsn"))
+	assert.Empty(t, r.TgIDToNotion(10))
+
+	// a declared link is claimed by the username
+	require.NoError(t, r.Seen(10, "gibsn"))
+	assert.Equal(t, "notion-1", r.TgIDToNotion(10))
+	require.NoError(t, r.Link(UserLink{TelegramID: 11, TelegramName: "vomadan", NotionID: "notion-2"}))
+
+	// the claimed links survive a restart without the declaration
+	s, err = store.Open(path)
+	require.NoError(t, err)
+	saved := NewUserResolver()
+	require.NoError(t, saved.SetStore(s))
+	link, ok := saved.LinkOf(10)
+	require.True(t, ok)
+	assert.Equal(t, UserLink{TelegramID: 10, TelegramName: "gibsn", NotionID: "notion-1"}, link)
+	assert.Equal(t, "notion-2", saved.TgIDToNotion(11))
+}
+
+func TestUserResolverDeclaredLinks(t *testing.T) {
+	r := newTestUserResolver(t)
+
+	r.SetDeclaredLinks([]UserLink{
+		{TelegramName: "fenyakolles", NotionID: "notion-1"},
+		// taken by the links of known accounts
+		{TelegramName: "gibsn", NotionID: "notion-2"},
+		{TelegramName: "other", NotionID: testUserLinks()[1].NotionID},
+	})
+	assert.Equal(t, "notion-1", r.TgToNotion("@fenyakolles"))
+	assert.Equal(t, testUserLinks()[0].NotionID, r.TgToNotion("@gibsn"))
+	assert.Empty(t, r.TgToNotion("@other"))
+
+	// a reload replaces the declared links
+	r.SetDeclaredLinks([]UserLink{{TelegramName: "nikitacmc", NotionID: "notion-3"}})
+	assert.Empty(t, r.TgToNotion("@fenyakolles"))
+	assert.Equal(t, "@nikitacmc", r.NotionToTg("notion-3"))
+	assert.Len(t, r.AllNotionUserIDs(), 4)
+}
+
+func TestUserResolverTakeOver(t *testing.T) {
+	r := newTestUserResolver(t)
+	r.SetDeclaredLinks([]UserLink{{TelegramName: "nikitacmc", NotionID: "notion-3"}})
+
+	// the declared Notion user is left for its username
+	err := r.Link(UserLink{TelegramID: 4, TelegramName: "other", NotionID: "notion-3"})
+	assert.ErrorIs(t, err, ErrNotionUserDeclared)
+	require.NoError(t, r.Link(UserLink{
+		TelegramID: 5, TelegramName: "nikitacmc", NotionID: "notion-3",
+	}))
+	assert.Equal(t, "notion-3", r.TgIDToNotion(5))
+
+	require.NoError(t, r.TakeOver(UserLink{
+		TelegramID: 4, TelegramName: "other", NotionID: testUserLinks()[0].NotionID,
+	}))
+	assert.Equal(t, testUserLinks()[0].NotionID, r.TgIDToNotion(4))
+	assert.Empty(t, r.TgIDToNotion(1))
+}
+
+func TestAllNotionUserIDs(t *testing.T) {
+	r := newTestUserResolver(t)
+	ids := r.AllNotionUserIDs()
+	assert.Len(t, ids, len(testUserLinks()))
+	// All IDs should be non-empty UUIDs
+	for _, id := range ids {
+		assert.NotEmpty(t, id)
+		assert.Contains(t, id, "-")
+	}
+}
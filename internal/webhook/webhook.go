@@ -0,0 +1,243 @@
+// Package webhook receives Telegram updates through a webhook, an HTTP
+// endpoint Telegram pushes updates to, as an alternative to long polling.
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/logging"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SecretTokenHeader is the header Telegram puts the secret token in.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+var logger = logging.For("webhook")
+
+const (
+	maxUpdateSize     = 1 << 20
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
+var secretTokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Config describes the webhook.
+type Config struct {
+	// URL is the public URL Telegram sends updates to. The server handles
+	// requests to its path only.
+	URL string
+	// ListenAddr is the address the server listens on, e.g. ":8443".
+	ListenAddr string
+	// SecretToken is sent by Telegram with every update, requests without it
+	// are rejected.
+	SecretToken string
+	// CertFile and KeyFile make the server use TLS. Without them TLS has to be
+	// terminated by a proxy, since Telegram only sends updates over HTTPS.
+	CertFile string
+	KeyFile  string
+	// UploadCertificate sends CertFile to Telegram when the webhook is set,
+	// which is required for a self-signed certificate.
+	UploadCertificate bool
+}
+
+type telegramClient interface {
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+	UploadFiles(
+		endpoint string, params tgbotapi.Params, files []tgbotapi.RequestFile,
+	) (*tgbotapi.APIResponse, error)
+}
+
+// Receiver serves the webhook and passes the received updates on.
+type Receiver struct {
+	bot    telegramClient
+	config Config
+	path   string
+
+	updates chan tgbotapi.Update
+	// stopping is closed when the receiver starts shutting down, so that
+	// requests waiting for the updates to be taken are turned away.
+	stopping chan struct{}
+}
+
+func NewReceiver(bot telegramClient, config Config) (*Receiver, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q", config.URL)
+	}
+	if !secretTokenRe.MatchString(config.SecretToken) {
+		return nil, errors.New(
+			"webhook secret token must be 1-256 characters of A-Z, a-z, 0-9, _ and -",
+		)
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("both certificate and key files are required for tls")
+	}
+	if config.UploadCertificate && config.CertFile == "" {
+		return nil, errors.New("certificate file is required to upload the certificate")
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return &Receiver{
+		bot:      bot,
+		config:   config,
+		path:     path,
+		updates:  make(chan tgbotapi.Update),
+		stopping: make(chan struct{}),
+	}, nil
+}
+
+// Updates returns the channel of received updates, it is closed when Serve
+// returns. Telegram gets a response only after the update has been taken
+// from the channel, so that the update is sent again if the bot stops before
+// taking it.
+func (r *Receiver) Updates() <-chan tgbotapi.Update {
+	return r.updates
+}
+
+// Run listens on the configured address and serves the webhook, see Serve.
+func (r *Receiver) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", r.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", r.config.ListenAddr, err)
+	}
+
+	return r.Serve(ctx, ln)
+}
+
+// Serve registers the webhook and serves it on ln until ctx is cancelled.
+// The webhook is deleted on return, so that the updates sent in the meantime
+// are kept by Telegram until the next start. A receiver can only be served
+// once.
+func (r *Receiver) Serve(ctx context.Context, ln net.Listener) error {
+	server := &http.Server{Handler: r, ReadHeaderTimeout: readHeaderTimeout}
+	served := make(chan error, 1)
+	go func() {
+		if r.config.CertFile != "" {
+			served <- server.ServeTLS(ln, r.config.CertFile, r.config.KeyFile)
+		} else {
+			served <- server.Serve(ln)
+		}
+	}()
+
+	// the server is already listening when Telegram learns about it
+	if err := r.setWebhook(); err != nil {
+		r.shutdown(server)
+		return err
+	}
+	logger.InfoContext(ctx, "Receiving Telegram updates", "listen", r.config.ListenAddr)
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-served:
+		err = fmt.Errorf("serve webhook: %w", err)
+	}
+
+	deleteErr := r.deleteWebhook()
+	r.shutdown(server)
+
+	return errors.Join(err, deleteErr)
+}
+
+// shutdown stops the server and closes the updates channel once no request
+// is in flight.
+func (r *Receiver) shutdown(server *http.Server) {
+	close(r.stopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		// requests may still be in flight, so the channel is left open
+		logger.ErrorContext(ctx, "Could not shut down webhook server", "error", err)
+		return
+	}
+
+	close(r.updates)
+}
+
+func (r *Receiver) setWebhook() error {
+	params := tgbotapi.Params{
+		"url":          r.config.URL,
+		"secret_token": r.config.SecretToken,
+	}
+
+	var err error
+	if r.config.UploadCertificate {
+		_, err = r.bot.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{{
+			Name: "certificate",
+			Data: tgbotapi.FilePath(r.config.CertFile),
+		}})
+	} else {
+		_, err = r.bot.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		return fmt.Errorf("set Telegram webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Receiver) deleteWebhook() error {
+	if _, err := r.bot.MakeRequest("deleteWebhook", tgbotapi.Params{}); err != nil {
+		return fmt.Errorf("delete Telegram webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != r.path {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := req.Header.Get(SecretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.config.SecretToken)) != 1 {
+		logger.WarnContext(req.Context(), "Rejected webhook request with a wrong secret token",
+			"remote_addr", req.RemoteAddr,
+		)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	body := io.LimitReader(req.Body, maxUpdateSize)
+	if err := json.NewDecoder(body).Decode(&update); err != nil {
+		logger.WarnContext(req.Context(), "Got an invalid update from the webhook", "error", err)
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	ctx := logging.WithRequestID(req.Context(), fmt.Sprintf("u%d", update.UpdateID))
+	logger.DebugContext(ctx, "Received update from the webhook")
+
+	select {
+	case r.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-r.stopping:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case <-req.Context().Done():
+	}
+}
@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gibsn/telegram_to_notion/internal/telegramtest"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "s3cret_token"
+
+func TestNewReceiverValidatesConfig(t *testing.T) {
+	valid := Config{URL: "https://bot.example.com/telegram", SecretToken: testSecret}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{
+			name:   "no url",
+			modify: func(c *Config) { c.URL = "" },
+			err:    "invalid webhook url",
+		},
+		{
+			name:   "no secret",
+			modify: func(c *Config) { c.SecretToken = "" },
+			err:    "secret token",
+		},
+		{
+			name:   "invalid secret",
+			modify: func(c *Config) { c.SecretToken = "not secret!" },
+			err:    "secret token",
+		},
+		{
+			name:   "cert without key",
+			modify: func(c *Config) { c.CertFile = "cert.pem" },
+			err:    "both certificate and key",
+		},
+		{
+			name:   "upload without cert",
+			modify: func(c *Config) { c.UploadCertificate = true },
+			err:    "certificate file is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+
+			_, err := NewReceiver(nil, config)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r, err := NewReceiver(nil, Config{
+		URL: "https://bot.example.com/telegram", SecretToken: testSecret,
+	})
+	require.NoError(t, err)
+
+	request := func(method, path, secret, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set(SecretTokenHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	update := `{"update_id": 7, "message": {"message_id": 1, "text": "/tasks"}}`
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/other", testSecret, update))
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/telegram", testSecret, ""))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/telegram", "", update))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/telegram", "wrong", update))
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/telegram", testSecret, "{"))
+
+	received := make(chan tgbotapi.Update, 1)
+	go func() { received <- <-r.Updates() }()
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/telegram", testSecret, update))
+
+	got := <-received
+	assert.Equal(t, 7, got.UpdateID)
+	assert.Equal(t, "/tasks", got.Message.Text)
+}
+
+// serve runs the receiver against the fake Bot API until the returned
+// function is called, which returns the error of Serve.
+func serve(t *testing.T, tg *telegramtest.Server, config Config) (*Receiver, func() error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	scheme := "http"
+	if config.CertFile != "" {
+		scheme = "https"
+	}
+	config.URL = scheme + "://" + ln.Addr().String() + "/telegram"
+	config.SecretToken = testSecret
+
+	bot, err := tg.NewBotAPI()
+	require.NoError(t, err)
+	r, err := NewReceiver(bot, config)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- r.Serve(ctx, ln) }()
+
+	require.Eventually(t, func() bool {
+		info, err := bot.GetWebhookInfo()
+		return err == nil && info.URL == config.URL
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return r, func() error {
+		cancel()
+		return <-served
+	}
+}
+
+func receive(t *testing.T, r *Receiver) tgbotapi.Update {
+	t.Helper()
+
+	select {
+	case update := <-r.Updates():
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an update")
+		return tgbotapi.Update{}
+	}
+}
+
+func TestServe(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	r, stop := serve(t, tg, Config{})
+
+	_, err := tg.SendMessage(tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1, UserName: "gibsn"},
+		Chat: &tgbotapi.Chat{ID: 1, Type: "private"},
+		Text: "/tasks",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/tasks", receive(t, r).Message.Text)
+
+	require.NoError(t, stop())
+
+	bot, err := tg.NewBotAPI()
+	require.NoError(t, err)
+	info, err := bot.GetWebhookInfo()
+	require.NoError(t, err)
+	assert.Empty(t, info.URL, "the webhook is deleted")
+
+	_, ok := <-r.Updates()
+	assert.False(t, ok, "the updates channel is closed")
+}
+
+func TestServeTLSWithUploadedCertificate(t *testing.T) {
+	tg := telegramtest.NewServer()
+	defer tg.Close()
+
+	certFile, keyFile := writeSelfSignedCert(t)
+	r, stop := serve(t, tg, Config{
+		CertFile: certFile, KeyFile: keyFile, UploadCertificate: true,
+	})
+
+	_, err := tg.SendMessage(tgbotapi.Message{
+		From: &tgbotapi.User{ID: 1, UserName: "gibsn"},
+		Chat: &tgbotapi.Chat{ID: 1, Type: "private"},
+		Text: "/tracks",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/tracks", receive(t, r).Message.Text)
+
+	require.NoError(t, stop())
+}
+
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(
+		certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600,
+	))
+	require.NoError(t, os.WriteFile(
+		keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600,
+	))
+
+	return certFile, keyFile
+}
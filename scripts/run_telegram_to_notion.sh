@@ -2,20 +2,18 @@
 set -euo pipefail
 
 app_dir="${APP_DIR:-/home/telegram_to_notion/telegram_to_notion}"
+config="${CONFIG:-$app_dir/config.yaml}"
 
-exec "$app_dir/bin/telegram_to_notion" \
-	-telegram_token="${TELEGRAM_TOKEN:?TELEGRAM_TOKEN is required}" \
-	-notion_token="${NOTION_TOKEN:?NOTION_TOKEN is required}" \
-	-tasks_db="${TASKS_DB:?TASKS_DB is required}" \
-	-tweaks_db="${TWEAKS_DB:?TWEAKS_DB is required}" \
-	-tweaks_mix_db="${TWEAKS_MIX_DB:?TWEAKS_MIX_DB is required}" \
-	-tracks_db="${TRACKS_DB:?TRACKS_DB is required}" \
-	-ping_chat_id="${PING_CHAT_ID:-0}" \
-	-ping_threshold="${PING_THRESHOLD:-72h}" \
-	-ping_st_time="${PING_STARTING_TIME:-09:00}" \
-	-ping_end_time="${PING_END_TIME:-23:00}" \
-	-ping_period_time="${PING_PERIOD:-6h}" \
-	-ping_text="${PING_TEXT:-Hi, what's the estimate?}" \
-	-tasks_cache_period="${TASKS_CACHE_PERIOD:-1m}" \
-	-tracks_cache_period="${TRACKS_CACHE_PERIOD:-1m}" \
-	-debug="${DEBUG:-false}"
+# the settings used to be passed in environment variables such as
+# TELEGRAM_TOKEN, they are ignored now
+if [[ ! -e "$config" ]]; then
+	echo "config $config not found, the settings are no longer read from" \
+		"environment variables: copy $app_dir/scripts/config.example.yaml" \
+		"to $config and fill it in, see \"Migrating to config.yaml\" in" \
+		"README.md" >&2
+	exit 1
+fi
+
+# tokens live in the config or in the files it points to, so that they do not
+# show up on the command line; send SIGHUP to reload the config
+exec "$app_dir/bin/telegram_to_notion" -config="$config"